@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"example.com/api-gateway/middleware"
 	"example.com/api-gateway/proxy"
 	"github.com/gin-gonic/gin"
 )
@@ -12,21 +13,101 @@ func SetupRoutes(router *gin.Engine) {
 	router.GET("/swagger/doc.json", SwaggerJSON)
 
 	api := router.Group("/api/v1")
+	api.Use(middleware.OAuthScopeMiddleware(routeScopes))
 	{
 		// Users service routes
 		api.POST("/register", proxy.ProxyToUsersService)
 		api.POST("/login", proxy.ProxyToUsersService)
 		api.POST("/verify-otp", proxy.ProxyToUsersService)
+		api.POST("/verify-otp/resend", proxy.ProxyToUsersService)
+		api.POST("/verify-push", proxy.ProxyToUsersService)
 		api.GET("/verify-email", proxy.ProxyToUsersService)
+		api.GET("/verify-email-change", proxy.ProxyToUsersService)
+		api.GET("/revert-email-change", proxy.ProxyToUsersService)
 		api.POST("/magic-link", proxy.ProxyToUsersService)
 		api.GET("/magic-login", proxy.ProxyToUsersService)
 		api.POST("/reset-password", proxy.ProxyToUsersService)
 		api.POST("/reset-password/confirm", proxy.ProxyToUsersService)
+		api.GET("/captcha/config", proxy.ProxyToUsersService)
 		api.POST("/change-password", proxy.ProxyToUsersService)
 		api.GET("/profile", proxy.ProxyToUsersService)
 		api.PUT("/profile", proxy.ProxyToUsersService)
 		api.DELETE("/profile", proxy.ProxyToUsersService)
+		api.POST("/profile/email", proxy.ProxyToUsersService)
+		api.DELETE("/profile/email", proxy.ProxyToUsersService)
+		api.GET("/profile/deletion", proxy.ProxyToUsersService)
+		api.POST("/profile/deletion/cancel", proxy.ProxyToUsersService)
+		api.POST("/profile/export", proxy.ProxyToUsersService)
+		api.GET("/profile/export", proxy.ProxyToUsersService)
+		api.GET("/profile/export/download", proxy.ProxyToUsersService)
 		api.POST("/logout", proxy.ProxyToUsersService)
+		api.POST("/token/refresh", proxy.ProxyToUsersService)
+		api.GET("/sessions", proxy.ProxyToUsersService)
+		api.GET("/profile/devices", proxy.ProxyToUsersService)
+		api.DELETE("/profile/devices/:id", proxy.ProxyToUsersService)
+		api.GET("/profile/security-activity", proxy.ProxyToUsersService)
+		api.DELETE("/sessions", proxy.ProxyToUsersService)
+		api.DELETE("/sessions/:id", proxy.ProxyToUsersService)
+		api.GET("/admin/roles", proxy.ProxyToUsersService)
+		api.GET("/admin/roles/audit", proxy.ProxyToUsersService)
+		api.GET("/admin/users/:id/roles", proxy.ProxyToUsersService)
+		api.POST("/admin/users/:id/roles", proxy.ProxyToUsersService)
+		api.DELETE("/admin/users/:id/roles/:role", proxy.ProxyToUsersService)
+		api.GET("/admin/deletions", proxy.ProxyToUsersService)
+		api.GET("/admin/login-throttle", proxy.ProxyToUsersService)
+		api.POST("/admin/users/:id/unlock", proxy.ProxyToUsersService)
+		api.POST("/admin/ips/:ip/unlock", proxy.ProxyToUsersService)
+		api.GET("/admin/emails", proxy.ProxyToUsersService)
+		api.GET("/admin/emails/:id", proxy.ProxyToUsersService)
+		api.POST("/admin/emails/:id/retry", proxy.ProxyToUsersService)
+		api.GET("/admin/security-events", proxy.ProxyToUsersService)
+
+		// OAuth2 for third-party apps
+		api.GET("/oauth/authorize", proxy.ProxyToUsersService)
+		api.POST("/oauth/authorize", proxy.ProxyToUsersService)
+		api.POST("/oauth/token", proxy.ProxyToUsersService)
+		api.POST("/oauth/introspect", proxy.ProxyToUsersService)
+		api.POST("/oauth/revoke", proxy.ProxyToUsersService)
+		api.GET("/oauth/userinfo", proxy.ProxyToUsersService)
+		api.GET("/oauth/consents", proxy.ProxyToUsersService)
+		api.DELETE("/oauth/consents/:client_id", proxy.ProxyToUsersService)
+		api.POST("/oauth/clients", proxy.ProxyToUsersService)
+		api.GET("/oauth/clients", proxy.ProxyToUsersService)
+		api.DELETE("/oauth/clients/:client_id", proxy.ProxyToUsersService)
+		api.GET("/2fa", proxy.ProxyToUsersService)
+		api.PUT("/2fa/method", proxy.ProxyToUsersService)
+		api.POST("/2fa/totp/setup", proxy.ProxyToUsersService)
+		api.GET("/2fa/totp/qr", proxy.ProxyToUsersService)
+		api.POST("/2fa/totp/confirm", proxy.ProxyToUsersService)
+		api.POST("/2fa/totp/disable", proxy.ProxyToUsersService)
+		api.POST("/2fa/recovery-codes", proxy.ProxyToUsersService)
+		api.POST("/profile/phone", proxy.ProxyToUsersService)
+		api.POST("/profile/phone/verify", proxy.ProxyToUsersService)
+		api.DELETE("/profile/phone", proxy.ProxyToUsersService)
+		api.GET("/profile/login-approvals", proxy.ProxyToUsersService)
+		api.POST("/profile/login-approvals/:id", proxy.ProxyToUsersService)
+		api.PUT("/profile/social", proxy.ProxyToUsersService)
+		api.GET("/profile/follow-requests", proxy.ProxyToUsersService)
+		api.POST("/profile/follow-requests/:username", proxy.ProxyToUsersService)
+		api.DELETE("/profile/follow-requests/:username", proxy.ProxyToUsersService)
+		api.DELETE("/profile/followers/:username", proxy.ProxyToUsersService)
+		api.GET("/profile/blocks", proxy.ProxyToUsersService)
+		api.GET("/users/:username", proxy.ProxyToUsersService)
+		api.GET("/users/:username/followers", proxy.ProxyToUsersService)
+		api.GET("/users/:username/following", proxy.ProxyToUsersService)
+		api.POST("/users/:username/follow", proxy.ProxyToUsersService)
+		api.DELETE("/users/:username/follow", proxy.ProxyToUsersService)
+		api.POST("/users/:username/block", proxy.ProxyToUsersService)
+		api.DELETE("/users/:username/block", proxy.ProxyToUsersService)
+		api.GET("/feed", proxy.ProxyToUsersService)
+		api.GET("/profile/activity-sharing", proxy.ProxyToUsersService)
+		api.PUT("/profile/activity-sharing", proxy.ProxyToUsersService)
+		api.POST("/webauthn/login/begin", proxy.ProxyToUsersService)
+		api.POST("/webauthn/login/finish", proxy.ProxyToUsersService)
+		api.POST("/webauthn/register/begin", proxy.ProxyToUsersService)
+		api.POST("/webauthn/register/finish", proxy.ProxyToUsersService)
+		api.GET("/webauthn/credentials", proxy.ProxyToUsersService)
+		api.DELETE("/webauthn/credentials/:id", proxy.ProxyToUsersService)
 
 		// Legacy auth routes (keeping for compatibility)
 		api.POST("/auth/register", proxy.ProxyToUsersService)
@@ -43,14 +124,35 @@ func SetupRoutes(router *gin.Engine) {
 		api.GET("/albums", proxy.ProxyToContentService)
 		api.GET("/albums/:id", proxy.ProxyToContentService)
 		api.GET("/songs", proxy.ProxyToContentService)
+		api.GET("/songs/:id/stream", proxy.ProxyToContentService)
 		api.GET("/search", proxy.ProxyToContentService)
 
+		// Podcasts
+		api.GET("/podcasts", proxy.ProxyToContentService)
+		api.POST("/podcasts", proxy.ProxyToContentService)
+		api.POST("/podcasts/import", proxy.ProxyToContentService)
+		api.GET("/podcasts/:id", proxy.ProxyToContentService)
+		api.PUT("/podcasts/:id", proxy.ProxyToContentService)
+		api.DELETE("/podcasts/:id", proxy.ProxyToContentService)
+		api.GET("/podcasts/:id/episodes", proxy.ProxyToContentService)
+		api.POST("/podcasts/:id/episodes", proxy.ProxyToContentService)
+		api.GET("/podcasts/:id/progress", proxy.ProxyToContentService)
+		api.GET("/episodes/:id", proxy.ProxyToContentService)
+		api.PUT("/episodes/:id", proxy.ProxyToContentService)
+		api.DELETE("/episodes/:id", proxy.ProxyToContentService)
+		api.GET("/episodes/:id/progress", proxy.ProxyToContentService)
+		api.PUT("/episodes/:id/progress", proxy.ProxyToContentService)
+
 		// Admin content routes
 		api.POST("/artists", proxy.ProxyToContentService)
 		api.PUT("/artists/:id", proxy.ProxyToContentService)
 		api.POST("/albums", proxy.ProxyToContentService)
 		api.POST("/songs", proxy.ProxyToContentService)
 		api.DELETE("/songs/:id", proxy.DeleteSongCascade)
+		api.POST("/ddex/ingest", proxy.ProxyToContentService)
+		api.GET("/ddex/reports", proxy.ProxyToContentService)
+		api.GET("/ddex/reports/:messageId", proxy.ProxyToContentService)
+		api.GET("/media/*filepath", proxy.ProxyToContentService)
 
 		// Ratings service routes
 		api.POST("/ratings", proxy.ProxyToRatingsService)
@@ -58,6 +160,36 @@ func SetupRoutes(router *gin.Engine) {
 		api.GET("/ratings/:songId", proxy.ProxyToRatingsService)
 		api.DELETE("/ratings/:songId", proxy.ProxyToRatingsService)
 
+		// Playback service routes
+		api.GET("/playback", proxy.ProxyToPlaybackService)
+		api.PUT("/playback/play", proxy.ProxyToPlaybackService)
+		api.PUT("/playback/pause", proxy.ProxyToPlaybackService)
+		api.PUT("/playback/seek", proxy.ProxyToPlaybackService)
+		api.POST("/playback/next", proxy.ProxyToPlaybackService)
+		api.POST("/playback/previous", proxy.ProxyToPlaybackService)
+		api.PUT("/playback/shuffle", proxy.ProxyToPlaybackService)
+		api.PUT("/playback/repeat", proxy.ProxyToPlaybackService)
+		api.PUT("/playback/transfer", proxy.ProxyToPlaybackService)
+		api.GET("/playback/queue", proxy.ProxyToPlaybackService)
+		api.POST("/playback/queue", proxy.ProxyToPlaybackService)
+		api.DELETE("/playback/queue", proxy.ProxyToPlaybackService)
+		api.GET("/playback/devices", proxy.ProxyToPlaybackService)
+		api.GET("/playback/ws", proxy.ProxyWebSocketToPlaybackService)
+		api.POST("/parties", proxy.ProxyToPlaybackService)
+		api.POST("/parties/join", proxy.ProxyToPlaybackService)
+		api.GET("/parties/:id", proxy.ProxyToPlaybackService)
+		api.DELETE("/parties/:id", proxy.ProxyToPlaybackService)
+		api.POST("/parties/:id/leave", proxy.ProxyToPlaybackService)
+		api.POST("/parties/:id/command", proxy.ProxyToPlaybackService)
+		api.GET("/parties/:id/chat", proxy.ProxyToPlaybackService)
+		api.GET("/parties/:id/ws", proxy.ProxyWebSocketToPlaybackService)
+
+		// Library routes (ratings service)
+		api.GET("/library/:type", proxy.ProxyToRatingsService)
+		api.PUT("/library/:type", proxy.ProxyToRatingsService)
+		api.DELETE("/library/:type", proxy.ProxyToRatingsService)
+		api.GET("/library/:type/contains", proxy.ProxyToRatingsService)
+
 		// Subscriptions routes
 		api.POST("/subscriptions", proxy.ProxyToSubscriptionsService)
 		api.GET("/subscriptions", proxy.ProxyToSubscriptionsService)
@@ -75,6 +207,9 @@ func SetupRoutes(router *gin.Engine) {
 		api.GET("/recommendations", proxy.ProxyToRecommendationService)
 	}
 
+	// Public keys for verifying access tokens
+	router.GET("/.well-known/jwks.json", proxy.ProxyToUsersService)
+
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok", "service": "api-gateway"})
 	})
@@ -0,0 +1,50 @@
+package handlers
+
+// routeScopes lists the routes third-party OAuth apps may call and the scope each
+// needs. Anything not listed is first-party only.
+var routeScopes = map[string]string{
+	"GET /api/v1/oauth/userinfo": "profile:read",
+
+	// Catalog
+	"GET /api/v1/genres":                "catalog:read",
+	"GET /api/v1/artists":               "catalog:read",
+	"GET /api/v1/artists/:id":           "catalog:read",
+	"GET /api/v1/albums":                "catalog:read",
+	"GET /api/v1/albums/:id":            "catalog:read",
+	"GET /api/v1/songs":                 "catalog:read",
+	"GET /api/v1/search":                "catalog:read",
+	"GET /api/v1/podcasts":              "catalog:read",
+	"GET /api/v1/podcasts/:id":          "catalog:read",
+	"GET /api/v1/podcasts/:id/episodes": "catalog:read",
+	"GET /api/v1/episodes/:id":          "catalog:read",
+	"GET /api/v1/ratings/:songId":       "catalog:read",
+
+	// Library
+	"GET /api/v1/library/:type":          "library:read",
+	"GET /api/v1/library/:type/contains": "library:read",
+	"PUT /api/v1/library/:type":          "library:write",
+	"DELETE /api/v1/library/:type":       "library:write",
+
+	// Playback
+	"GET /api/v1/playback":           "playback:read",
+	"GET /api/v1/playback/queue":     "playback:read",
+	"GET /api/v1/playback/devices":   "playback:read",
+	"PUT /api/v1/playback/play":      "playback:control",
+	"PUT /api/v1/playback/pause":     "playback:control",
+	"PUT /api/v1/playback/seek":      "playback:control",
+	"POST /api/v1/playback/next":     "playback:control",
+	"POST /api/v1/playback/previous": "playback:control",
+	"PUT /api/v1/playback/shuffle":   "playback:control",
+	"PUT /api/v1/playback/repeat":    "playback:control",
+	"PUT /api/v1/playback/transfer":  "playback:control",
+	"POST /api/v1/playback/queue":    "playback:control",
+	"DELETE /api/v1/playback/queue":  "playback:control",
+
+	// Ratings
+	"GET /api/v1/ratings":            "ratings:read",
+	"POST /api/v1/ratings":           "ratings:write",
+	"DELETE /api/v1/ratings/:songId": "ratings:write",
+
+	// Recommendations
+	"GET /api/v1/recommendations": "recommendations:read",
+}
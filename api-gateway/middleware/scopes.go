@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/api-gateway/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Revocation answers are cached briefly so every OAuth request doesn't hit users-service
+const revocationCacheTTL = 30 * time.Second
+
+type revocationEntry struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+var (
+	// jti -> revocationEntry; expired entries are dropped on lookup and by the sweeper
+	revocationCache   sync.Map
+	revocationSweeper sync.Once
+	revocationURL     = usersServiceURL() + "/api/v1/oauth/revoked/"
+	revocationHTTP    = &http.Client{
+		Timeout:   3 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+)
+
+func usersServiceURL() string {
+	if u := os.Getenv("USERS_SERVICE_URL"); u != "" {
+		return u
+	}
+	return "https://localhost:8001"
+}
+
+func isRevoked(jti string) (bool, error) {
+	if v, ok := revocationCache.Load(jti); ok {
+		entry := v.(revocationEntry)
+		if time.Since(entry.checkedAt) < revocationCacheTTL {
+			return entry.revoked, nil
+		}
+		revocationCache.CompareAndDelete(jti, entry)
+	}
+
+	resp, err := revocationHTTP.Get(revocationURL + jti)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Revoked bool `json:"revoked"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("revocation check returned %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, err
+	}
+
+	revocationCache.Store(jti, revocationEntry{revoked: body.Revoked, checkedAt: time.Now()})
+	return body.Revoked, nil
+}
+
+// sweepRevocationCache drops expired answers for tokens that aren't used again, which
+// would otherwise stay in the cache forever
+func sweepRevocationCache() {
+	ticker := time.NewTicker(revocationCacheTTL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		revocationCache.Range(func(key, value any) bool {
+			if time.Since(value.(revocationEntry).checkedAt) >= revocationCacheTTL {
+				revocationCache.CompareAndDelete(key, value)
+			}
+			return true
+		})
+	}
+}
+
+func rejectBearer(c *gin.Context, status int, code, description, scope string) {
+	challenge := `Bearer error="` + code + `"`
+	if scope != "" {
+		challenge += `, scope="` + scope + `"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+	c.Abort()
+}
+
+// OAuthScopeMiddleware limits tokens issued to third-party apps to the routes listed in
+// routeScopes ("METHOD /path" -> scope) and to the scopes the user granted. First-party
+// tokens are left to the services.
+func OAuthScopeMiddleware(routeScopes map[string]string) gin.HandlerFunc {
+	revocationSweeper.Do(func() { go sweepRevocationCache() })
+
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			// WebSocket clients pass the token in the query string
+			token = c.Query("token")
+		}
+		if token == "" || !utils.IsOAuthToken(token) {
+			c.Next()
+			return
+		}
+
+		claims, err := utils.ValidateJWT(token)
+		if err != nil {
+			rejectBearer(c, http.StatusUnauthorized, "invalid_token", "Invalid or expired access token", "")
+			return
+		}
+
+		revoked, err := isRevoked(claims.ID)
+		if err != nil {
+			// Fail closed, a revoked token must not slip through while users-service is down
+			rejectBearer(c, http.StatusServiceUnavailable, "temporarily_unavailable", "Token revocation can't be checked", "")
+			return
+		}
+		if revoked {
+			rejectBearer(c, http.StatusUnauthorized, "invalid_token", "Access token was revoked", "")
+			return
+		}
+
+		required, ok := routeScopes[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			rejectBearer(c, http.StatusForbidden, "insufficient_scope", "This endpoint is not available to third-party apps", "")
+			return
+		}
+
+		for _, s := range strings.Fields(claims.Scope) {
+			if s == required {
+				c.Next()
+				return
+			}
+		}
+		rejectBearer(c, http.StatusForbidden, "insufficient_scope", "Token lacks the required scope", required)
+	}
+}
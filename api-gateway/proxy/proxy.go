@@ -4,6 +4,8 @@ import (
 	"crypto/tls"
 	"io"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"time"
 
@@ -22,6 +24,7 @@ var (
 	subscriptionsServiceURL  = getEnv("SUBSCRIPTIONS_SERVICE_URL", "https://localhost:8004")
 	notificationsServiceURL  = getEnv("NOTIFICATIONS_SERVICE_URL", "https://localhost:8005")
 	recommendationServiceURL = getEnv("RECOMMENDATION_SERVICE_URL", "https://localhost:8006")
+	playbackServiceURL       = getEnv("PLAYBACK_SERVICE_URL", "https://localhost:8007")
 )
 
 func getEnv(key, defaultValue string) string {
@@ -72,6 +75,10 @@ func proxyRequest(c *gin.Context, baseURL string) {
 		}
 	}
 
+	// Replace whatever the client sent so services that trust the gateway see the real peer address
+	req.Header.Set("X-Forwarded-For", c.ClientIP())
+	req.Header.Del("X-Real-IP")
+
 	// Inject trace context into outgoing request headers
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
@@ -110,6 +117,36 @@ func ProxyToRatingsService(c *gin.Context)        { proxyRequest(c, ratingsServi
 func ProxyToSubscriptionsService(c *gin.Context)  { proxyRequest(c, subscriptionsServiceURL) }
 func ProxyToNotificationsService(c *gin.Context)  { proxyRequest(c, notificationsServiceURL) }
 func ProxyToRecommendationService(c *gin.Context) { proxyRequest(c, recommendationServiceURL) }
+func ProxyToPlaybackService(c *gin.Context)       { proxyRequest(c, playbackServiceURL) }
+
+// ProxyWebSocketToPlaybackService tunnels WebSocket upgrades, which the buffered
+// http.Client proxy above can't do
+func ProxyWebSocketToPlaybackService(c *gin.Context) { proxyWebSocket(c, playbackServiceURL) }
+
+func proxyWebSocket(c *gin.Context, baseURL string) {
+	target, err := url.Parse(baseURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid service URL"})
+		return
+	}
+
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to service"})
+	}
+
+	// Drop client-sent forwarding headers; the reverse proxy adds the peer address itself
+	c.Request.Header.Del("X-Forwarded-For")
+	c.Request.Header.Del("X-Real-IP")
+
+	// Inject trace context into the upgrade request
+	otel.GetTextMapPropagator().Inject(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+	rp.ServeHTTP(c.Writer, c.Request)
+}
 
 // DeleteSongCascade handles cascade deletion of a song across all services
 func DeleteSongCascade(c *gin.Context) {
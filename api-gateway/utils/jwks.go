@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Public keys published by users-service, which is the only service that signs tokens
+var (
+	jwksURL             = "http://users-service:8001/.well-known/jwks.json"
+	jwksRefreshInterval = 10 * time.Minute
+	// An unknown kid triggers a refresh, but never more often than this
+	jwksMinRefreshGap = 30 * time.Second
+)
+
+type publicKey struct {
+	alg string
+	key interface{}
+}
+
+var (
+	jwksMu        sync.RWMutex
+	jwksKeys      map[string]publicKey
+	jwksFetchedAt time.Time
+
+	jwksRefreshMu   sync.Mutex
+	jwksLastAttempt time.Time
+
+	jwksClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+func init() {
+	if u := os.Getenv("JWKS_URL"); u != "" {
+		jwksURL = u
+	}
+	if d, err := time.ParseDuration(os.Getenv("JWKS_REFRESH_INTERVAL")); err == nil && d > 0 {
+		jwksRefreshInterval = d
+	}
+}
+
+// lookupKey returns the verification key for kid, refreshing the cached JWKS when
+// it is stale or doesn't know the kid yet (a freshly rotated key)
+func lookupKey(kid string) (publicKey, error) {
+	jwksMu.RLock()
+	key, ok := jwksKeys[kid]
+	fresh := time.Since(jwksFetchedAt) < jwksRefreshInterval
+	jwksMu.RUnlock()
+
+	if ok && fresh {
+		return key, nil
+	}
+
+	refreshJWKS()
+
+	jwksMu.RLock()
+	key, ok = jwksKeys[kid]
+	jwksMu.RUnlock()
+	if !ok {
+		return publicKey{}, errors.New("unknown signing key")
+	}
+	return key, nil
+}
+
+// refreshJWKS fetches the key set; on failure the previous keys stay in use
+func refreshJWKS() {
+	jwksRefreshMu.Lock()
+	defer jwksRefreshMu.Unlock()
+
+	if time.Since(jwksLastAttempt) < jwksMinRefreshGap {
+		return
+	}
+	jwksLastAttempt = time.Now()
+
+	keys, err := fetchJWKS()
+	if err != nil {
+		log.Printf("Failed to refresh JWKS from %s: %v", jwksURL, err)
+		return
+	}
+
+	jwksMu.Lock()
+	jwksKeys = keys
+	jwksFetchedAt = time.Now()
+	jwksMu.Unlock()
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func fetchJWKS() (map[string]publicKey, error) {
+	resp, err := jwksClient.Get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+			keys[k.Kid] = publicKey{alg: "EdDSA", key: ed25519.PublicKey(x)}
+		case k.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = publicKey{alg: "RS256", key: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}}
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,255 @@
+package ddex
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Root element names of the ERN messages we accept
+const (
+	NewReleaseMessage   = "NewReleaseMessage"
+	PurgeReleaseMessage = "PurgeReleaseMessage"
+)
+
+// MessageKind describes how a delivery should be applied to the catalog
+type MessageKind string
+
+const (
+	KindNew      MessageKind = "new"
+	KindUpdate   MessageKind = "update"
+	KindTakedown MessageKind = "takedown"
+)
+
+// Message is the subset of a DDEX ERN message that we map onto the catalog.
+// Elements we don't use are ignored by the decoder.
+type Message struct {
+	XMLName         xml.Name
+	MessageHeader   MessageHeader    `xml:"MessageHeader"`
+	UpdateIndicator string           `xml:"UpdateIndicator"`
+	PartyList       []Party          `xml:"PartyList>Party"`
+	SoundRecordings []SoundRecording `xml:"ResourceList>SoundRecording"`
+	Releases        []Release        `xml:"ReleaseList>Release"`
+	ReleaseDeals    []ReleaseDeal    `xml:"DealList>ReleaseDeal"`
+	PurgedReleases  []PurgedRelease  `xml:"PurgedRelease"`
+}
+
+type MessageHeader struct {
+	MessageID              string    `xml:"MessageId"`
+	MessageSender          PartyInfo `xml:"MessageSender"`
+	MessageRecipient       PartyInfo `xml:"MessageRecipient"`
+	MessageCreatedDateTime string    `xml:"MessageCreatedDateTime"`
+}
+
+type PartyInfo struct {
+	PartyID  string `xml:"PartyId"`
+	FullName string `xml:"PartyName>FullName"`
+}
+
+type Party struct {
+	PartyReference string `xml:"PartyReference"`
+	FullName       string `xml:"PartyName>FullName"`
+	ISNI           string `xml:"PartyId>ISNI"`
+}
+
+type DisplayArtist struct {
+	ArtistPartyReference string `xml:"ArtistPartyReference"`
+	FullName             string `xml:"PartyName>FullName"`
+	Role                 string `xml:"DisplayArtistRole"`
+}
+
+type SoundRecording struct {
+	ResourceReference string          `xml:"ResourceReference"`
+	ISRC              string          `xml:"ResourceId>ISRC"`
+	Title             string          `xml:"DisplayTitleText"`
+	ReferenceTitle    string          `xml:"ReferenceTitle>TitleText"`
+	Duration          string          `xml:"Duration"`
+	DisplayArtists    []DisplayArtist `xml:"DisplayArtist"`
+	Files             []string        `xml:"TechnicalDetails>DeliveryFile>File>URI"`
+}
+
+// DisplayTitle returns the title to show, falling back to the ERN 3 reference title
+func (s SoundRecording) DisplayTitle() string {
+	if s.Title != "" {
+		return s.Title
+	}
+	return s.ReferenceTitle
+}
+
+type Release struct {
+	ReleaseReference    string          `xml:"ReleaseReference"`
+	ReleaseType         string          `xml:"ReleaseType"`
+	ICPN                string          `xml:"ReleaseId>ICPN"`
+	GRid                string          `xml:"ReleaseId>GRid"`
+	Title               string          `xml:"DisplayTitleText"`
+	ReferenceTitle      string          `xml:"ReferenceTitle>TitleText"`
+	DisplayArtists      []DisplayArtist `xml:"DisplayArtist"`
+	Genres              []string        `xml:"Genre>GenreText"`
+	OriginalReleaseDate string          `xml:"OriginalReleaseDate"`
+	ReleaseDate         string          `xml:"ReleaseDate"`
+	ResourceReferences  []string        `xml:"ResourceGroup>ResourceGroupContentItem>ReleaseResourceReference"`
+	ResourceList        []string        `xml:"ReleaseResourceReferenceList>ReleaseResourceReference"` // ERN 3
+}
+
+// Resources returns the resource references of the release in track order
+func (r Release) Resources() []string {
+	if len(r.ResourceReferences) > 0 {
+		return r.ResourceReferences
+	}
+	return r.ResourceList
+}
+
+// IsTrackRelease reports whether this is an ERN 3 track-level release that
+// only repeats a single resource of the main release
+func (r Release) IsTrackRelease() bool {
+	return r.ReleaseType == "TrackRelease"
+}
+
+// DisplayTitle returns the title to show, falling back to the ERN 3 reference title
+func (r Release) DisplayTitle() string {
+	if r.Title != "" {
+		return r.Title
+	}
+	return r.ReferenceTitle
+}
+
+// ID returns the identifier used to match the release against existing albums
+func (r Release) ID() string {
+	if r.ICPN != "" {
+		return r.ICPN
+	}
+	return r.GRid
+}
+
+// Date parses the release date, preferring the original release date
+func (r Release) Date() (time.Time, error) {
+	raw := r.OriginalReleaseDate
+	if raw == "" {
+		raw = r.ReleaseDate
+	}
+	if raw == "" {
+		return time.Time{}, errors.New("release date missing")
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid release date %q", raw)
+}
+
+type ReleaseDeal struct {
+	DealReleaseReferences []string `xml:"DealReleaseReference"`
+	Deals                 []Deal   `xml:"Deal"`
+}
+
+type Deal struct {
+	TakeDown bool `xml:"DealTerms>TakeDown"`
+}
+
+type PurgedRelease struct {
+	ICPN  string `xml:"ReleaseId>ICPN"`
+	GRid  string `xml:"ReleaseId>GRid"`
+	Title string `xml:"Title>TitleText"`
+}
+
+// Parse decodes an ERN message and checks that it carries what ingestion needs
+func Parse(r io.Reader) (*Message, error) {
+	var msg Message
+	if err := xml.NewDecoder(r).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("invalid ERN XML: %w", err)
+	}
+
+	switch msg.XMLName.Local {
+	case NewReleaseMessage, PurgeReleaseMessage:
+	default:
+		return nil, fmt.Errorf("unsupported message type %q", msg.XMLName.Local)
+	}
+
+	if strings.TrimSpace(msg.MessageHeader.MessageID) == "" {
+		return nil, errors.New("MessageHeader/MessageId is required")
+	}
+
+	return &msg, nil
+}
+
+// Kind derives the update semantics of the message
+func (m *Message) Kind() MessageKind {
+	if m.XMLName.Local == PurgeReleaseMessage {
+		return KindTakedown
+	}
+	if m.UpdateIndicator == "UpdateMessage" {
+		return KindUpdate
+	}
+	return KindNew
+}
+
+// PartyName resolves a display artist to a name using the party list
+func (m *Message) PartyName(artist DisplayArtist) string {
+	if artist.FullName != "" {
+		return artist.FullName
+	}
+	for _, p := range m.PartyList {
+		if p.PartyReference == artist.ArtistPartyReference {
+			return p.FullName
+		}
+	}
+	return ""
+}
+
+// SoundRecording looks up a resource by its message-local reference
+func (m *Message) SoundRecording(ref string) (SoundRecording, bool) {
+	for _, s := range m.SoundRecordings {
+		if s.ResourceReference == ref {
+			return s, true
+		}
+	}
+	return SoundRecording{}, false
+}
+
+// IsTakenDown reports whether a deal in the message takes the release down
+func (m *Message) IsTakenDown(releaseRef string) bool {
+	for _, rd := range m.ReleaseDeals {
+		for _, ref := range rd.DealReleaseReferences {
+			if ref != releaseRef {
+				continue
+			}
+			for _, d := range rd.Deals {
+				if d.TakeDown {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)D)?T?(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?$`)
+
+// ParseDuration converts an ISO 8601 duration such as PT3M25S into seconds
+func ParseDuration(raw string) (int, error) {
+	m := isoDuration.FindStringSubmatch(strings.TrimSpace(raw))
+	if m == nil || raw == "P" || raw == "PT" {
+		return 0, fmt.Errorf("invalid duration %q", raw)
+	}
+
+	total := 0.0
+	units := []float64{86400, 3600, 60, 1}
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", raw)
+		}
+		total += v * unit
+	}
+
+	return int(total + 0.5), nil
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	ratingsServiceURL        = getEnv("RATINGS_SERVICE_URL", "http://ratings-service:8003")
+	recommendationServiceURL = getEnv("RECOMMENDATION_SERVICE_URL", "http://recommendation-service:8006")
+	// Other services only accept catalog cleanup from callers holding the shared service token
+	internalServiceToken = getEnv("INTERNAL_SERVICE_TOKEN", "")
+)
+
+var cascadeClient = &http.Client{Timeout: 15 * time.Second}
+
+// purgeSongData removes what the other services keep about a song, the same cleanup the
+// gateway runs when a song is deleted: its ratings and its node in the recommendation
+// graph. Both calls are idempotent, so a failed takedown can simply be retried.
+func purgeSongData(ctx context.Context, songID string) error {
+	var failures []string
+
+	if err := serviceDelete(ctx, ratingsServiceURL+"/api/v1/songs/"+songID+"/ratings"); err != nil {
+		failures = append(failures, "ratings: "+err.Error())
+	}
+	if err := serviceDelete(ctx, recommendationServiceURL+"/api/v1/songs/"+songID); err != nil {
+		failures = append(failures, "recommendations: "+err.Error())
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("song %s: %s", songID, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func serviceDelete(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Service-Token", internalServiceToken)
+
+	// Propagiraj trace kontekst
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := cascadeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
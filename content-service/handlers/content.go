@@ -484,10 +484,40 @@ func SearchContent(c *gin.Context) {
 		return
 	}
 
+	// Search podcasts
+	var podcasts []models.Podcast
+	podcastCursor, err := contentDB.Collection("podcasts").Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search podcasts"})
+		return
+	}
+	defer podcastCursor.Close(ctx)
+
+	if err := podcastCursor.All(ctx, &podcasts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode podcasts"})
+		return
+	}
+
+	// Search episodes
+	var episodes []models.Episode
+	episodeCursor, err := contentDB.Collection("episodes").Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search episodes"})
+		return
+	}
+	defer episodeCursor.Close(ctx)
+
+	if err := episodeCursor.All(ctx, &episodes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode episodes"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"artists": artists,
-		"albums":  albums,
-		"songs":   songs,
+		"artists":  artists,
+		"albums":   albums,
+		"songs":    songs,
+		"podcasts": podcasts,
+		"episodes": episodes,
 	})
 }
 
@@ -496,7 +526,7 @@ func notifyFollowersAboutSong(artistIDs []primitive.ObjectID, songName string, s
 	for _, artistID := range artistIDs {
 		// Koristimo context.Background() jer je ovo u goroutini, ali možemo započeti novi span
 		ctx := context.Background()
-		followerIDs := getFollowers(ctx, artistID.Hex())
+		followerIDs := getFollowers(ctx, "artist", artistID.Hex())
 
 		// Get artist name
 		var artist models.Artist
@@ -515,7 +545,7 @@ func notifyFollowersAboutSong(artistIDs []primitive.ObjectID, songName string, s
 func notifyFollowersAboutAlbum(artistIDs []primitive.ObjectID, albumName string, albumID string) {
 	for _, artistID := range artistIDs {
 		ctx := context.Background()
-		followerIDs := getFollowers(ctx, artistID.Hex())
+		followerIDs := getFollowers(ctx, "artist", artistID.Hex())
 
 		// Get artist name
 		var artist models.Artist
@@ -531,9 +561,9 @@ func notifyFollowersAboutAlbum(artistIDs []primitive.ObjectID, albumName string,
 	}
 }
 
-func getFollowers(ctx context.Context, artistID string) []string {
+func getFollowers(ctx context.Context, targetType, targetID string) []string {
 	// Call subscriptions-service
-	subscriptionsURL := "http://subscriptions-service:8004/api/v1/subscriptions/followers/" + artistID
+	subscriptionsURL := "http://subscriptions-service:8004/api/v1/subscriptions/followers/" + targetID + "?type=" + targetType
 
 	req, err := http.NewRequestWithContext(ctx, "GET", subscriptionsURL, nil)
 	if err != nil {
@@ -0,0 +1,786 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/content-service/ddex"
+	"example.com/content-service/models"
+)
+
+var (
+	ddexDropDir  = getEnv("DDEX_DROP_DIR", "deliveries")
+	ddexMediaDir = getEnv("DDEX_MEDIA_DIR", "media")
+)
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// MediaDir returns the directory that ingested audio assets are copied to
+func MediaDir() string {
+	return ddexMediaDir
+}
+
+// EnsureDDEXIndexes makes message IDs unique so a delivery is applied only once
+func EnsureDDEXIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("ddex_messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "message_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("unique_message_id"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create DDEX indexes: %v", err)
+	}
+
+	_, err = db.Collection("albums").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "upc", Value: 1}},
+		Options: options.Index().SetSparse(true).SetName("upc_idx"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create DDEX indexes: %v", err)
+	}
+
+	_, err = db.Collection("songs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "album", Value: 1}, {Key: "isrc", Value: 1}},
+		Options: options.Index().SetName("album_isrc_idx"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create DDEX indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for DDEX ingestion ensured")
+}
+
+// StartDDEXPoller periodically ingests everything in the drop directory
+func StartDDEXPoller(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		files, err := findERNFiles(ddexDropDir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			report := ingestDDEXFile(context.Background(), file)
+			if report.Status != models.DDEXDuplicate {
+				log.Printf("DDEX message %s from %s: %s", report.MessageID, report.File, report.Status)
+			}
+		}
+	}
+}
+
+// IngestDDEX processes ERN messages from the drop directory
+func IngestDDEX(c *gin.Context) {
+	var req models.DDEXIngestRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+	}
+
+	// Keep the requested path inside the drop directory
+	root := filepath.Join(ddexDropDir, filepath.Clean("/"+req.Path))
+
+	files, err := findERNFiles(root)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read drop directory"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	reports := make([]models.DDEXReport, 0, len(files))
+	for _, file := range files {
+		reports = append(reports, ingestDDEXFile(ctx, file))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"processed": len(reports),
+		"reports":   reports,
+	})
+}
+
+func GetDDEXReports(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "received_at", Value: -1}}).SetLimit(100)
+	cursor, err := contentDB.Collection("ddex_messages").Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reports"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.DDEXReport{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode reports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reports)
+}
+
+func GetDDEXReport(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var report models.DDEXReport
+	err := contentDB.Collection("ddex_messages").FindOne(ctx, bson.M{"message_id": c.Param("messageId")}).Decode(&report)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// findERNFiles returns the XML messages under root (or root itself if it is a file)
+func findERNFiles(root string) ([]string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{root}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".xml") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// ingestDDEXFile applies a single ERN message to the catalog and records a report
+func ingestDDEXFile(ctx context.Context, path string) models.DDEXReport {
+	report := models.DDEXReport{
+		File:       path,
+		Releases:   []models.DDEXReleaseResult{},
+		ReceivedAt: time.Now(),
+	}
+	if rel, err := filepath.Rel(ddexDropDir, path); err == nil {
+		report.File = rel
+	}
+
+	msg, err := parseERNFile(path)
+	if err != nil {
+		report.Status = models.DDEXFailed
+		report.Errors = append(report.Errors, err.Error())
+		report.ProcessedAt = time.Now()
+		writeDDEXReportFile(path, report)
+		return report
+	}
+
+	report.MessageID = msg.MessageHeader.MessageID
+	report.MessageType = msg.XMLName.Local
+	report.Kind = string(msg.Kind())
+	report.Sender = msg.MessageHeader.MessageSender.FullName
+	if report.Sender == "" {
+		report.Sender = msg.MessageHeader.MessageSender.PartyID
+	}
+
+	claimed, existing, err := claimDDEXMessage(ctx, &report)
+	if err != nil {
+		report.Status = models.DDEXFailed
+		report.Errors = append(report.Errors, "Failed to record message: "+err.Error())
+		return report
+	}
+	if !claimed {
+		// Already ingested (or being ingested) - report the original outcome
+		existing.Status = models.DDEXDuplicate
+		return *existing
+	}
+
+	batchDir := filepath.Dir(path)
+
+	if msg.Kind() == ddex.KindTakedown {
+		for _, purged := range msg.PurgedReleases {
+			id := purged.ICPN
+			if id == "" {
+				id = purged.GRid
+			}
+			report.Releases = append(report.Releases, takedownRelease(ctx, id, "", purged.Title))
+		}
+	} else {
+		for _, rel := range msg.Releases {
+			if rel.IsTrackRelease() {
+				continue
+			}
+			if msg.IsTakenDown(rel.ReleaseReference) {
+				report.Releases = append(report.Releases, takedownRelease(ctx, rel.ID(), rel.ReleaseReference, rel.DisplayTitle()))
+				continue
+			}
+			report.Releases = append(report.Releases, upsertRelease(ctx, msg, rel, batchDir))
+		}
+	}
+
+	report.Status = models.DDEXProcessed
+	if len(report.Releases) == 0 {
+		report.Status = models.DDEXFailed
+		report.Errors = append(report.Errors, "Message contains no releases")
+	}
+	failed := 0
+	for _, r := range report.Releases {
+		if r.Action == "failed" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		report.Status = models.DDEXProcessedWithErrors
+		if failed == len(report.Releases) {
+			report.Status = models.DDEXFailed
+		}
+	}
+	report.ProcessedAt = time.Now()
+
+	if _, err := contentDB.Collection("ddex_messages").ReplaceOne(ctx, bson.M{"_id": report.ID}, report); err != nil {
+		log.Printf("Failed to store DDEX report for %s: %v", report.MessageID, err)
+	}
+	writeDDEXReportFile(path, report)
+
+	return report
+}
+
+func parseERNFile(path string) (*ddex.Message, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ddex.Parse(f)
+}
+
+// claimDDEXMessage records the message as in progress. It returns false with the
+// stored report when the message ID was seen before. Messages with a failed release
+// (failed or processed with errors) may be retried; applying a release again is safe.
+func claimDDEXMessage(ctx context.Context, report *models.DDEXReport) (bool, *models.DDEXReport, error) {
+	coll := contentDB.Collection("ddex_messages")
+
+	report.ID = primitive.NewObjectID()
+	report.Status = models.DDEXProcessing
+
+	_, err := coll.InsertOne(ctx, report)
+	if err == nil {
+		return true, nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return false, nil, err
+	}
+
+	var existing models.DDEXReport
+	if err := coll.FindOne(ctx, bson.M{"message_id": report.MessageID}).Decode(&existing); err != nil {
+		return false, nil, err
+	}
+	retryable := []models.DDEXStatus{models.DDEXFailed, models.DDEXProcessedWithErrors}
+	if existing.Status != models.DDEXFailed && existing.Status != models.DDEXProcessedWithErrors {
+		return false, &existing, nil
+	}
+
+	result, err := coll.UpdateOne(ctx,
+		bson.M{"_id": existing.ID, "status": bson.M{"$in": retryable}},
+		bson.M{"$set": bson.M{"status": models.DDEXProcessing, "file": report.File, "received_at": report.ReceivedAt}},
+	)
+	if err != nil {
+		return false, nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, &existing, nil
+	}
+
+	report.ID = existing.ID
+	return true, nil, nil
+}
+
+// upsertRelease creates or replaces an album and its tracks from a release
+func upsertRelease(ctx context.Context, msg *ddex.Message, rel ddex.Release, batchDir string) models.DDEXReleaseResult {
+	result := models.DDEXReleaseResult{
+		ReleaseReference: rel.ReleaseReference,
+		UPC:              rel.ID(),
+		Title:            rel.DisplayTitle(),
+	}
+	fail := func(reason string) models.DDEXReleaseResult {
+		result.Action = "failed"
+		result.Error = reason
+		return result
+	}
+
+	if result.UPC == "" {
+		return fail("Release has no ICPN or GRid")
+	}
+	if result.Title == "" {
+		return fail("Release has no title")
+	}
+
+	date, err := rel.Date()
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	genreID, err := resolveGenre(ctx, rel.Genres)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	artistIDs, err := resolveArtists(ctx, msg, rel.DisplayArtists, genreID)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if len(artistIDs) == 0 {
+		return fail("Release has no display artist")
+	}
+
+	albums := contentDB.Collection("albums")
+
+	var album models.Album
+	err = albums.FindOne(ctx, bson.M{"upc": result.UPC}).Decode(&album)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		album = models.Album{
+			ID:        primitive.NewObjectID(),
+			Name:      result.Title,
+			Date:      date,
+			Genre:     genreID,
+			Artists:   artistIDs,
+			UPC:       result.UPC,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		if _, err := albums.InsertOne(ctx, album); err != nil {
+			return fail("Failed to create album")
+		}
+		result.Action = "created"
+		if msg.Kind() == ddex.KindUpdate {
+			result.Warnings = append(result.Warnings, "Update received for unknown release, created it")
+		}
+	case err != nil:
+		return fail("Database error")
+	default:
+		update := bson.M{"$set": bson.M{
+			"name":       result.Title,
+			"date":       date,
+			"genre":      genreID,
+			"artists":    artistIDs,
+			"updated_at": time.Now(),
+		}}
+		if _, err := albums.UpdateOne(ctx, bson.M{"_id": album.ID}, update); err != nil {
+			return fail("Failed to update album")
+		}
+		result.Action = "updated"
+	}
+	result.AlbumID = album.ID.Hex()
+	for _, id := range artistIDs {
+		result.ArtistIDs = append(result.ArtistIDs, id.Hex())
+	}
+
+	// A release always carries its full track list, so tracks that are
+	// no longer delivered are removed from the album
+	keep := []primitive.ObjectID{}
+	resourcesFailed := 0
+	for _, ref := range rel.Resources() {
+		rec, ok := msg.SoundRecording(ref)
+		if !ok {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Resource %s not found in ResourceList", ref))
+			resourcesFailed++
+			continue
+		}
+
+		songID, warnings, err := upsertSoundRecording(ctx, msg, rec, album.ID, genreID, artistIDs, result.UPC, batchDir)
+		result.Warnings = append(result.Warnings, warnings...)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Resource %s: %v", ref, err))
+			resourcesFailed++
+			continue
+		}
+		keep = append(keep, songID)
+		result.SongIDs = append(result.SongIDs, songID.Hex())
+	}
+
+	if result.Action == "created" {
+		go notifyFollowersAboutAlbum(artistIDs, album.Name, album.ID.Hex())
+	}
+
+	// A track whose resource failed isn't in keep although the release still lists it,
+	// so nothing is pruned; the release fails and the message can be delivered again
+	if resourcesFailed > 0 {
+		result.Action = "failed"
+		result.Error = fmt.Sprintf("%d of %d resources could not be stored", resourcesFailed, len(rel.Resources()))
+		return result
+	}
+
+	if result.Action == "updated" {
+		result.Warnings = append(result.Warnings, removeDroppedTracks(ctx, album.ID, keep)...)
+	}
+
+	return result
+}
+
+// removeDroppedTracks deletes the album's songs that are not in keep, cleaning up their
+// ratings and recommendation data first. A song whose cleanup fails stays in the
+// catalog so the next delivery of the release removes it.
+func removeDroppedTracks(ctx context.Context, albumID primitive.ObjectID, keep []primitive.ObjectID) []string {
+	var warnings []string
+
+	cursor, err := contentDB.Collection("songs").Find(ctx, bson.M{"album": albumID, "_id": bson.M{"$nin": keep}})
+	if err != nil {
+		return []string{"Failed to look up tracks no longer in the release"}
+	}
+	var dropped []models.Song
+	if err := cursor.All(ctx, &dropped); err != nil {
+		return []string{"Failed to look up tracks no longer in the release"}
+	}
+
+	removable := []primitive.ObjectID{}
+	for _, song := range dropped {
+		if err := purgeSongData(ctx, song.ID.Hex()); err != nil {
+			log.Printf("DDEX update of album %s: %v", albumID.Hex(), err)
+			warnings = append(warnings, fmt.Sprintf("Track %s kept: failed to remove its data from other services", song.ID.Hex()))
+			continue
+		}
+		removable = append(removable, song.ID)
+	}
+	if len(removable) == 0 {
+		return warnings
+	}
+
+	removed, err := contentDB.Collection("songs").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": removable}})
+	if err == nil && removed.DeletedCount > 0 {
+		warnings = append(warnings, fmt.Sprintf("Removed %d tracks no longer in the release", removed.DeletedCount))
+	}
+	return warnings
+}
+
+func upsertSoundRecording(ctx context.Context, msg *ddex.Message, rec ddex.SoundRecording, albumID, genreID primitive.ObjectID, albumArtists []primitive.ObjectID, upc, batchDir string) (primitive.ObjectID, []string, error) {
+	var warnings []string
+
+	name := rec.DisplayTitle()
+	if name == "" {
+		return primitive.NilObjectID, nil, errors.New("sound recording has no title")
+	}
+
+	duration, err := ddex.ParseDuration(rec.Duration)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("Resource %s: %v", rec.ResourceReference, err))
+	}
+
+	artistIDs, err := resolveArtists(ctx, msg, rec.DisplayArtists, genreID)
+	if err != nil {
+		return primitive.NilObjectID, warnings, err
+	}
+	if len(artistIDs) == 0 {
+		artistIDs = albumArtists
+	}
+
+	audioURL, err := importDDEXAsset(batchDir, upc, rec.Files)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("Resource %s: %v", rec.ResourceReference, err))
+	}
+
+	songs := contentDB.Collection("songs")
+
+	set := bson.M{
+		"name":       name,
+		"duration":   duration,
+		"genre":      genreID,
+		"album":      albumID,
+		"artists":    artistIDs,
+		"isrc":       rec.ISRC,
+		"updated_at": time.Now(),
+	}
+	if audioURL != "" {
+		set["audio_url"] = audioURL
+	}
+
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"created_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	// A recording can appear on several releases (single, album, compilation); each
+	// release has its own song, matched by ISRC within the album
+	var song models.Song
+	if rec.ISRC != "" {
+		err = songs.FindOneAndUpdate(ctx, bson.M{"album": albumID, "isrc": rec.ISRC}, update, opts).Decode(&song)
+		if err == mongo.ErrNoDocuments {
+			// A song stored before the recording had an ISRC (an earlier delivery or a
+			// manually created song) is adopted by name, and gets the ISRC backfilled
+			err = songs.FindOneAndUpdate(ctx,
+				bson.M{"album": albumID, "name": name, "isrc": bson.M{"$in": bson.A{nil, ""}}},
+				update, opts,
+			).Decode(&song)
+		}
+		if err == mongo.ErrNoDocuments {
+			err = songs.FindOneAndUpdate(ctx, bson.M{"album": albumID, "isrc": rec.ISRC}, update, opts.SetUpsert(true)).Decode(&song)
+		}
+	} else {
+		err = songs.FindOneAndUpdate(ctx, bson.M{"album": albumID, "name": name}, update, opts.SetUpsert(true)).Decode(&song)
+	}
+	if err != nil {
+		return primitive.NilObjectID, warnings, errors.New("failed to store song")
+	}
+
+	return song.ID, warnings, nil
+}
+
+// takedownRelease removes an album and its songs from the catalog, along with the
+// songs' ratings and recommendation data
+func takedownRelease(ctx context.Context, upc, ref, title string) models.DDEXReleaseResult {
+	result := models.DDEXReleaseResult{
+		ReleaseReference: ref,
+		UPC:              upc,
+		Title:            title,
+		Action:           "taken_down",
+	}
+
+	if upc == "" {
+		result.Action = "failed"
+		result.Error = "Release has no ICPN or GRid"
+		return result
+	}
+
+	var album models.Album
+	err := contentDB.Collection("albums").FindOne(ctx, bson.M{"upc": upc}).Decode(&album)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			result.Action = "skipped"
+			result.Warnings = append(result.Warnings, "Release not in catalog")
+			return result
+		}
+		result.Action = "failed"
+		result.Error = "Database error"
+		return result
+	}
+	result.AlbumID = album.ID.Hex()
+	if result.Title == "" {
+		result.Title = album.Name
+	}
+
+	cursor, err := contentDB.Collection("songs").Find(ctx, bson.M{"album": album.ID})
+	if err != nil {
+		result.Action = "failed"
+		result.Error = "Database error"
+		return result
+	}
+	var songs []models.Song
+	if err := cursor.All(ctx, &songs); err != nil {
+		result.Action = "failed"
+		result.Error = "Database error"
+		return result
+	}
+	for _, s := range songs {
+		result.SongIDs = append(result.SongIDs, s.ID.Hex())
+	}
+
+	// Ratings and recommendations go first: if a service is down the album stays in the
+	// catalog and the failed message can be delivered again
+	for _, songID := range result.SongIDs {
+		if err := purgeSongData(ctx, songID); err != nil {
+			log.Printf("DDEX takedown of %s: %v", upc, err)
+			result.Action = "failed"
+			result.Error = "Failed to remove song data from other services"
+			return result
+		}
+	}
+
+	if _, err := contentDB.Collection("songs").DeleteMany(ctx, bson.M{"album": album.ID}); err != nil {
+		result.Action = "failed"
+		result.Error = "Failed to delete songs"
+		return result
+	}
+	if _, err := contentDB.Collection("albums").DeleteOne(ctx, bson.M{"_id": album.ID}); err != nil {
+		result.Action = "failed"
+		result.Error = "Failed to delete album"
+		return result
+	}
+
+	return result
+}
+
+// resolveArtists maps display artists onto catalog artists, creating missing ones
+func resolveArtists(ctx context.Context, msg *ddex.Message, displayArtists []ddex.DisplayArtist, genreID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ids := []primitive.ObjectID{}
+	seen := map[string]bool{}
+
+	for _, da := range displayArtists {
+		name := strings.TrimSpace(msg.PartyName(da))
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		var artist models.Artist
+		err := contentDB.Collection("artists").FindOne(ctx, exactNameFilter(name)).Decode(&artist)
+		if err == mongo.ErrNoDocuments {
+			artist = models.Artist{
+				ID:        primitive.NewObjectID(),
+				Name:      name,
+				Genres:    []primitive.ObjectID{genreID},
+				CreatedAt: time.Now(),
+				UpdatedAt: time.Now(),
+			}
+			if _, err := contentDB.Collection("artists").InsertOne(ctx, artist); err != nil {
+				return nil, fmt.Errorf("failed to create artist %s", name)
+			}
+		} else if err != nil {
+			return nil, errors.New("database error")
+		}
+
+		ids = append(ids, artist.ID)
+	}
+
+	return ids, nil
+}
+
+// resolveGenre maps the first delivered genre onto a catalog genre, creating it if needed
+func resolveGenre(ctx context.Context, genres []string) (primitive.ObjectID, error) {
+	for _, name := range genres {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var genre models.Genre
+		err := contentDB.Collection("genres").FindOne(ctx, exactNameFilter(name)).Decode(&genre)
+		if err == nil {
+			return genre.ID, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return primitive.NilObjectID, errors.New("database error")
+		}
+
+		genre = models.Genre{
+			ID:          primitive.NewObjectID(),
+			Name:        name,
+			Description: "Imported from DDEX delivery",
+			CreatedAt:   time.Now(),
+		}
+		if _, err := contentDB.Collection("genres").InsertOne(ctx, genre); err != nil {
+			return primitive.NilObjectID, fmt.Errorf("failed to create genre %s", name)
+		}
+		return genre.ID, nil
+	}
+
+	return primitive.NilObjectID, errors.New("release has no genre")
+}
+
+func exactNameFilter(name string) bson.M {
+	return bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(name) + "$", "$options": "i"}}
+}
+
+// importDDEXAsset copies the delivered audio file into the media directory and
+// returns the URL it is served from
+func importDDEXAsset(batchDir, upc string, uris []string) (string, error) {
+	if len(uris) == 0 {
+		return "", errors.New("no audio file delivered")
+	}
+
+	uri := uris[0]
+	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+		return uri, nil
+	}
+
+	// Asset paths are relative to the message and may not leave the batch directory
+	src := filepath.Join(batchDir, filepath.Clean("/"+filepath.FromSlash(uri)))
+	name := filepath.Base(src)
+	dstDir := filepath.Join(ddexMediaDir, safePathSegment(upc))
+
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return "", errors.New("failed to create media directory")
+	}
+	if err := copyFile(src, filepath.Join(dstDir, name)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("audio file %s missing from delivery", uri)
+		}
+		return "", fmt.Errorf("failed to import audio file %s", uri)
+	}
+
+	return "/api/v1/media/" + url.PathEscape(safePathSegment(upc)) + "/" + url.PathEscape(name), nil
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func safePathSegment(s string) string {
+	return unsafePathChars.ReplaceAllString(s, "_")
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeDDEXReportFile stores the report next to the message for the label's tooling
+func writeDDEXReportFile(path string, report models.DDEXReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return
+	}
+	reportPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".report.json"
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		log.Printf("Failed to write DDEX report %s: %v", reportPath, err)
+	}
+}
@@ -0,0 +1,785 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/content-service/models"
+	"example.com/content-service/podcast"
+)
+
+var podcastFeedsDir = getEnv("PODCAST_FEEDS_DIR", "feeds")
+
+const maxFeedSize = 10 << 20
+
+// Podcast handlers
+func CreatePodcast(c *gin.Context) {
+	var req models.CreatePodcastRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	categories := req.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+
+	show := models.Podcast{
+		ID:          primitive.NewObjectID(),
+		Name:        req.Name,
+		Description: req.Description,
+		Author:      req.Author,
+		ImageURL:    req.ImageURL,
+		Language:    req.Language,
+		Categories:  categories,
+		Explicit:    req.Explicit,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if _, err := contentDB.Collection("podcasts").InsertOne(ctx, show); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create podcast"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, show)
+}
+
+func GetPodcasts(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	filter := bson.M{}
+	if category := c.Query("category"); category != "" {
+		filter["categories"] = category
+	}
+
+	cursor, err := contentDB.Collection("podcasts").Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch podcasts"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var podcasts []models.Podcast
+	if err := cursor.All(ctx, &podcasts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode podcasts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, podcasts)
+}
+
+func GetPodcast(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid podcast ID"})
+		return
+	}
+
+	var show models.Podcast
+	err = contentDB.Collection("podcasts").FindOne(ctx, bson.M{"_id": objID}).Decode(&show)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Podcast not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, show)
+}
+
+func UpdatePodcast(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid podcast ID"})
+		return
+	}
+
+	var req models.UpdatePodcastRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if req.Name != "" {
+		set["name"] = req.Name
+	}
+	if req.Description != "" {
+		set["description"] = req.Description
+	}
+	if req.Author != "" {
+		set["author"] = req.Author
+	}
+	if req.ImageURL != "" {
+		set["image_url"] = req.ImageURL
+	}
+	if req.Language != "" {
+		set["language"] = req.Language
+	}
+	if req.Categories != nil {
+		set["categories"] = req.Categories
+	}
+	if req.Explicit != nil {
+		set["explicit"] = *req.Explicit
+	}
+
+	result, err := contentDB.Collection("podcasts").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": set})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update podcast"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Podcast not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Podcast updated successfully"})
+}
+
+// DeletePodcast removes a show together with its episodes and listening progress
+func DeletePodcast(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid podcast ID"})
+		return
+	}
+
+	result, err := contentDB.Collection("podcasts").DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete podcast"})
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Podcast not found"})
+		return
+	}
+
+	episodes, err := contentDB.Collection("episodes").DeleteMany(ctx, bson.M{"podcast": objID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete episodes"})
+		return
+	}
+	contentDB.Collection("episode_progress").DeleteMany(ctx, bson.M{"podcast_id": objID})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Podcast deleted successfully",
+		"podcast_id":       id,
+		"episodes_deleted": episodes.DeletedCount,
+	})
+}
+
+// Episode handlers
+func CreateEpisode(c *gin.Context) {
+	var req models.CreateEpisodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	podcastID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid podcast ID"})
+		return
+	}
+
+	var show models.Podcast
+	err = contentDB.Collection("podcasts").FindOne(ctx, bson.M{"_id": podcastID}).Decode(&show)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Podcast does not exist. Create podcast first."})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	publishedAt := time.Now()
+	if req.PublishedAt != nil {
+		publishedAt = *req.PublishedAt
+	}
+
+	episode := models.Episode{
+		ID:          primitive.NewObjectID(),
+		Podcast:     podcastID,
+		Name:        req.Name,
+		Description: req.Description,
+		AudioURL:    req.AudioURL,
+		Duration:    req.Duration,
+		Season:      req.Season,
+		Number:      req.Number,
+		Explicit:    req.Explicit,
+		PublishedAt: publishedAt,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if _, err := contentDB.Collection("episodes").InsertOne(ctx, episode); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create episode"})
+		return
+	}
+
+	go notifyFollowersAboutEpisodes(show, []string{episode.Name})
+
+	c.JSON(http.StatusCreated, episode)
+}
+
+func GetPodcastEpisodes(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	podcastID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid podcast ID"})
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "published_at", Value: -1}})
+	cursor, err := contentDB.Collection("episodes").Find(ctx, bson.M{"podcast": podcastID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episodes"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var episodes []models.Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode episodes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, episodes)
+}
+
+func GetEpisode(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode ID"})
+		return
+	}
+
+	var episode models.Episode
+	err = contentDB.Collection("episodes").FindOne(ctx, bson.M{"_id": objID}).Decode(&episode)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, episode)
+}
+
+func UpdateEpisode(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode ID"})
+		return
+	}
+
+	var req models.UpdateEpisodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if req.Name != "" {
+		set["name"] = req.Name
+	}
+	if req.Description != "" {
+		set["description"] = req.Description
+	}
+	if req.AudioURL != "" {
+		set["audio_url"] = req.AudioURL
+	}
+	if req.Duration > 0 {
+		set["duration"] = req.Duration
+	}
+	if req.Season != nil {
+		set["season"] = *req.Season
+	}
+	if req.Number != nil {
+		set["number"] = *req.Number
+	}
+	if req.Explicit != nil {
+		set["explicit"] = *req.Explicit
+	}
+	if req.PublishedAt != nil {
+		set["published_at"] = *req.PublishedAt
+	}
+
+	result, err := contentDB.Collection("episodes").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": set})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update episode"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Episode updated successfully"})
+}
+
+func DeleteEpisode(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode ID"})
+		return
+	}
+
+	result, err := contentDB.Collection("episodes").DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete episode"})
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+		return
+	}
+
+	contentDB.Collection("episode_progress").DeleteMany(ctx, bson.M{"episode_id": objID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Episode deleted successfully", "episode_id": id})
+}
+
+// ImportPodcastFeed creates or refreshes a show and its episodes from an RSS feed
+func ImportPodcastFeed(c *gin.Context) {
+	var req models.ImportPodcastFeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.FeedURL == "") == (req.File == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either feed_url or file"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var (
+		feed   *podcast.Feed
+		source string
+		err    error
+	)
+	if req.FeedURL != "" {
+		source = req.FeedURL
+		feed, err = fetchFeed(ctx, req.FeedURL)
+	} else {
+		// Keep the requested file inside the feeds directory
+		path := filepath.Join(podcastFeedsDir, filepath.Clean("/"+req.File))
+		source = "file:" + filepath.ToSlash(filepath.Clean(req.File))
+		feed, err = readFeedFile(path)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Feed file not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	show, created, err := upsertPodcastFromFeed(ctx, feed, source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import podcast"})
+		return
+	}
+
+	added, updated, warnings := upsertEpisodesFromFeed(ctx, show.ID, feed.Channel.Items)
+
+	// Followers only exist for shows we already had
+	if !created && len(added) > 0 {
+		go notifyFollowersAboutEpisodes(show, added)
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	c.JSON(status, gin.H{
+		"podcast":          show,
+		"created":          created,
+		"episodes_added":   len(added),
+		"episodes_updated": updated,
+		"warnings":         warnings,
+	})
+}
+
+func fetchFeed(ctx context.Context, feedURL string) (*podcast.Feed, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+	if err != nil {
+		return nil, errors.New("invalid feed URL")
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New("failed to fetch feed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned status %d", resp.StatusCode)
+	}
+
+	return podcast.Parse(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+func readFeedFile(path string) (*podcast.Feed, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return podcast.Parse(io.LimitReader(f, maxFeedSize))
+}
+
+func upsertPodcastFromFeed(ctx context.Context, feed *podcast.Feed, source string) (models.Podcast, bool, error) {
+	ch := feed.Channel
+
+	description := strings.TrimSpace(ch.Description)
+	if description == "" {
+		description = strings.TrimSpace(ch.Summary)
+	}
+
+	categories := ch.Categories()
+	if categories == nil {
+		categories = []string{}
+	}
+
+	podcasts := contentDB.Collection("podcasts")
+
+	var show models.Podcast
+	err := podcasts.FindOne(ctx, bson.M{"feed_url": source}).Decode(&show)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return show, false, err
+	}
+	created := err == mongo.ErrNoDocuments
+
+	err = podcasts.FindOneAndUpdate(ctx,
+		bson.M{"feed_url": source},
+		bson.M{
+			"$set": bson.M{
+				"name":        strings.TrimSpace(ch.Title),
+				"description": description,
+				"author":      strings.TrimSpace(ch.Author),
+				"image_url":   ch.Image(),
+				"language":    ch.Language,
+				"categories":  categories,
+				"explicit":    podcast.IsExplicit(ch.Explicit),
+				"feed_url":    source,
+				"updated_at":  time.Now(),
+			},
+			"$setOnInsert": bson.M{"created_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&show)
+	if err != nil {
+		return show, false, err
+	}
+
+	return show, created, nil
+}
+
+// upsertEpisodesFromFeed stores feed items matched by guid and returns the
+// names of newly added episodes
+func upsertEpisodesFromFeed(ctx context.Context, podcastID primitive.ObjectID, items []podcast.Item) ([]string, int, []string) {
+	var (
+		added    []string
+		updated  int
+		warnings = []string{}
+	)
+
+	for _, item := range items {
+		name := strings.TrimSpace(item.Title)
+		guid := item.ID()
+		if name == "" || guid == "" || item.Enclosure.URL == "" {
+			warnings = append(warnings, fmt.Sprintf("Skipped item %q: title, guid and enclosure are required", name))
+			continue
+		}
+
+		publishedAt, err := item.PublishedAt()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Item %q: %v", name, err))
+			publishedAt = time.Now()
+		}
+
+		duration, err := podcast.ParseDuration(item.Duration)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Item %q: %v", name, err))
+		}
+
+		result, err := contentDB.Collection("episodes").UpdateOne(ctx,
+			bson.M{"podcast": podcastID, "guid": guid},
+			bson.M{
+				"$set": bson.M{
+					"name":         name,
+					"description":  item.Text(),
+					"audio_url":    item.Enclosure.URL,
+					"duration":     duration,
+					"season":       podcast.ParseNumber(item.Season),
+					"number":       podcast.ParseNumber(item.Episode),
+					"explicit":     podcast.IsExplicit(item.Explicit),
+					"published_at": publishedAt,
+					"updated_at":   time.Now(),
+				},
+				"$setOnInsert": bson.M{"created_at": time.Now()},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Item %q: failed to store episode", name))
+			continue
+		}
+
+		if result.UpsertedCount > 0 {
+			added = append(added, name)
+		} else {
+			updated++
+		}
+	}
+
+	return added, updated, warnings
+}
+
+// Listening progress handlers
+func GetEpisodeProgress(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	episodeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode ID"})
+		return
+	}
+
+	var progress models.EpisodeProgress
+	err = contentDB.Collection("episode_progress").FindOne(ctx, bson.M{"user_id": userID, "episode_id": episodeID}).Decode(&progress)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusOK, models.EpisodeProgress{UserID: userID, EpisodeID: episodeID})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// UpdateEpisodeProgress stores where the user stopped listening so playback can resume
+func UpdateEpisodeProgress(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	episodeID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode ID"})
+		return
+	}
+
+	var req models.UpdateEpisodeProgressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	var episode models.Episode
+	err = contentDB.Collection("episodes").FindOne(ctx, bson.M{"_id": episodeID}).Decode(&episode)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	position := req.Position
+	if episode.Duration > 0 && position > episode.Duration {
+		position = episode.Duration
+	}
+
+	progress := models.EpisodeProgress{
+		UserID:    userID,
+		EpisodeID: episodeID,
+		PodcastID: episode.Podcast,
+		Position:  position,
+		Completed: req.Completed || (episode.Duration > 0 && position >= episode.Duration),
+		UpdatedAt: time.Now(),
+	}
+
+	_, err = contentDB.Collection("episode_progress").ReplaceOne(ctx,
+		bson.M{"user_id": userID, "episode_id": episodeID},
+		progress,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// GetPodcastProgress returns the user's resume positions for every episode of a show
+func GetPodcastProgress(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	podcastID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid podcast ID"})
+		return
+	}
+
+	cursor, err := contentDB.Collection("episode_progress").Find(ctx, bson.M{"user_id": userID, "podcast_id": podcastID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch progress"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	progress := []models.EpisodeProgress{}
+	if err := cursor.All(ctx, &progress); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// User ids are hex ObjectIDs as issued by users-service
+var userIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+func validUserID(userID string) bool {
+	return userIDPattern.MatchString(userID)
+}
+
+// ExportUserData returns the podcast listening progress of a user for their data export.
+// Called by the users-service export worker.
+func ExportUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	cursor, err := contentDB.Collection("episode_progress").Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch progress"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	progress := []models.EpisodeProgress{}
+	if err := cursor.All(ctx, &progress); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"episode_progress": progress})
+}
+
+// DeleteUserData removes the listening progress of a deleted account.
+// Called by the users-service deletion worker; safe to repeat.
+func DeleteUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	result, err := contentDB.Collection("episode_progress").DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User data deleted", "deleted_count": result.DeletedCount})
+}
+
+// EnsurePodcastIndexes creates the indexes used by feed imports and progress tracking
+func EnsurePodcastIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("episodes").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "podcast", Value: 1}, {Key: "published_at", Value: -1}},
+			Options: options.Index().SetName("podcast_published_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "podcast", Value: 1}, {Key: "guid", Value: 1}},
+			Options: options.Index().SetName("podcast_guid_idx").SetSparse(true),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to create podcast indexes: %v", err)
+	}
+
+	_, err = db.Collection("episode_progress").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "episode_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("unique_user_episode"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create podcast indexes: %v", err)
+	}
+}
+
+func notifyFollowersAboutEpisodes(show models.Podcast, episodeNames []string) {
+	ctx := context.Background()
+	followerIDs := getFollowers(ctx, "podcast", show.ID.Hex())
+
+	for _, name := range episodeNames {
+		message := "New episode '" + name + "' of " + show.Name
+		for _, userID := range followerIDs {
+			sendNotification(ctx, userID, message, "new_episode")
+		}
+	}
+}
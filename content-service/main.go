@@ -74,8 +74,20 @@ func main() {
 	router.Use(tracing.TracingMiddleware(serviceName))
 
 	handlers.InitHandlers(contentDB)
+	handlers.EnsureDDEXIndexes(contentDB)
+	handlers.EnsurePodcastIndexes(contentDB)
 	setupRoutes(router)
 
+	// Optional automatic ingestion of label deliveries (e.g. DDEX_POLL_INTERVAL=5m)
+	if interval := os.Getenv("DDEX_POLL_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil && d > 0 {
+			go handlers.StartDDEXPoller(d)
+			log.Printf("DDEX drop directory polling every %s", d)
+		} else {
+			log.Printf("Warning: invalid DDEX_POLL_INTERVAL %q", interval)
+		}
+	}
+
 	// TLS Configuration
 	tlsEnabled := os.Getenv("TLS_ENABLED")
 	certFile := os.Getenv("TLS_CERT_FILE")
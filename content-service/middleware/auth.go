@@ -33,21 +33,32 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Third-party OAuth tokens aren't accepted by this service
+		if claims.ClientID != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Third-party access tokens can't be used here"})
+			c.Abort()
+			return
+		}
+
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission allows the request only if the token grants perm
+func RequirePermission(perm string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
-			return
+		perms := c.GetStringSlice("permissions")
+		for _, p := range perms {
+			if p == perm {
+				c.Next()
+				return
+			}
 		}
-		c.Next()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission required: " + perm})
+		c.Abort()
 	}
 }
@@ -28,6 +28,7 @@ type Album struct {
 	Date      time.Time            `json:"date" bson:"date"`
 	Genre     primitive.ObjectID   `json:"genre" bson:"genre"`
 	Artists   []primitive.ObjectID `json:"artists" bson:"artists"`
+	UPC       string               `json:"upc,omitempty" bson:"upc,omitempty"` // DDEX ICPN/GRid for label deliveries
 	CreatedAt time.Time            `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time            `json:"updated_at" bson:"updated_at"`
 }
@@ -40,6 +41,7 @@ type Song struct {
 	Album     primitive.ObjectID   `json:"album" bson:"album"`
 	Artists   []primitive.ObjectID `json:"artists" bson:"artists"`
 	AudioURL  string               `json:"audio_url,omitempty" bson:"audio_url,omitempty"` // URL to audio file
+	ISRC      string               `json:"isrc,omitempty" bson:"isrc,omitempty"`
 	CreatedAt time.Time            `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time            `json:"updated_at" bson:"updated_at"`
 }
@@ -72,6 +74,101 @@ type CreateSongRequest struct {
 	AudioURL string   `json:"audio_url,omitempty"`
 }
 
+// Podcasts
+type Podcast struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Author      string             `json:"author" bson:"author"`
+	ImageURL    string             `json:"image_url,omitempty" bson:"image_url,omitempty"`
+	Language    string             `json:"language,omitempty" bson:"language,omitempty"`
+	Categories  []string           `json:"categories" bson:"categories"`
+	Explicit    bool               `json:"explicit" bson:"explicit"`
+	FeedURL     string             `json:"feed_url,omitempty" bson:"feed_url,omitempty"` // source of RSS imports
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type Episode struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Podcast     primitive.ObjectID `json:"podcast" bson:"podcast"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	AudioURL    string             `json:"audio_url" bson:"audio_url"`
+	Duration    int                `json:"duration" bson:"duration"` // in seconds
+	Season      int                `json:"season,omitempty" bson:"season,omitempty"`
+	Number      int                `json:"number,omitempty" bson:"number,omitempty"`
+	Explicit    bool               `json:"explicit" bson:"explicit"`
+	GUID        string             `json:"guid,omitempty" bson:"guid,omitempty"` // RSS item guid for imported episodes
+	PublishedAt time.Time          `json:"published_at" bson:"published_at"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// EpisodeProgress is a user's resume position within an episode
+type EpisodeProgress struct {
+	UserID    string             `json:"user_id" bson:"user_id"`
+	EpisodeID primitive.ObjectID `json:"episode_id" bson:"episode_id"`
+	PodcastID primitive.ObjectID `json:"podcast_id" bson:"podcast_id"`
+	Position  int                `json:"position" bson:"position"` // in seconds
+	Completed bool               `json:"completed" bson:"completed"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type CreatePodcastRequest struct {
+	Name        string   `json:"name" binding:"required,min=1,max=200"`
+	Description string   `json:"description" binding:"required,min=10"`
+	Author      string   `json:"author" binding:"required,min=1,max=200"`
+	ImageURL    string   `json:"image_url" binding:"omitempty,url"`
+	Language    string   `json:"language" binding:"omitempty,max=10"`
+	Categories  []string `json:"categories"`
+	Explicit    bool     `json:"explicit"`
+}
+
+type UpdatePodcastRequest struct {
+	Name        string   `json:"name" binding:"omitempty,min=1,max=200"`
+	Description string   `json:"description" binding:"omitempty,min=10"`
+	Author      string   `json:"author" binding:"omitempty,min=1,max=200"`
+	ImageURL    string   `json:"image_url" binding:"omitempty,url"`
+	Language    string   `json:"language" binding:"omitempty,max=10"`
+	Categories  []string `json:"categories"`
+	Explicit    *bool    `json:"explicit"`
+}
+
+type CreateEpisodeRequest struct {
+	Name        string     `json:"name" binding:"required,min=1,max=200"`
+	Description string     `json:"description"`
+	AudioURL    string     `json:"audio_url" binding:"required"`
+	Duration    int        `json:"duration" binding:"required,min=1"`
+	Season      int        `json:"season" binding:"min=0"`
+	Number      int        `json:"number" binding:"min=0"`
+	Explicit    bool       `json:"explicit"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+type UpdateEpisodeRequest struct {
+	Name        string     `json:"name" binding:"omitempty,min=1,max=200"`
+	Description string     `json:"description"`
+	AudioURL    string     `json:"audio_url"`
+	Duration    int        `json:"duration" binding:"omitempty,min=1"`
+	Season      *int       `json:"season" binding:"omitempty,min=0"`
+	Number      *int       `json:"number" binding:"omitempty,min=0"`
+	Explicit    *bool      `json:"explicit"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+// ImportPodcastFeedRequest imports an RSS feed either from a URL or from a
+// file in the local feeds directory
+type ImportPodcastFeedRequest struct {
+	FeedURL string `json:"feed_url" binding:"omitempty,url"`
+	File    string `json:"file"`
+}
+
+type UpdateEpisodeProgressRequest struct {
+	Position  int  `json:"position" binding:"min=0"`
+	Completed bool `json:"completed"`
+}
+
 // Subscription types
 type SubscriptionType string
 
@@ -97,3 +194,45 @@ type UserSubscriptions struct {
 	Artists []Artist `json:"artists"`
 	Genres  []Genre  `json:"genres"`
 }
+
+// DDEX ingestion
+type DDEXStatus string
+
+const (
+	DDEXProcessing          DDEXStatus = "processing"
+	DDEXProcessed           DDEXStatus = "processed"
+	DDEXProcessedWithErrors DDEXStatus = "processed_with_errors"
+	DDEXFailed              DDEXStatus = "failed"
+	DDEXDuplicate           DDEXStatus = "duplicate"
+)
+
+// DDEXReport is the processing report stored for every ingested ERN message
+type DDEXReport struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	MessageID   string              `json:"message_id" bson:"message_id"`
+	MessageType string              `json:"message_type" bson:"message_type"`
+	Kind        string              `json:"kind" bson:"kind"`
+	Sender      string              `json:"sender" bson:"sender"`
+	File        string              `json:"file" bson:"file"`
+	Status      DDEXStatus          `json:"status" bson:"status"`
+	Releases    []DDEXReleaseResult `json:"releases" bson:"releases"`
+	Errors      []string            `json:"errors,omitempty" bson:"errors,omitempty"`
+	ReceivedAt  time.Time           `json:"received_at" bson:"received_at"`
+	ProcessedAt time.Time           `json:"processed_at" bson:"processed_at"`
+}
+
+type DDEXReleaseResult struct {
+	ReleaseReference string   `json:"release_reference" bson:"release_reference"`
+	UPC              string   `json:"upc" bson:"upc"`
+	Title            string   `json:"title" bson:"title"`
+	Action           string   `json:"action" bson:"action"` // created, updated, taken_down, skipped, failed
+	AlbumID          string   `json:"album_id,omitempty" bson:"album_id,omitempty"`
+	SongIDs          []string `json:"song_ids,omitempty" bson:"song_ids,omitempty"`
+	ArtistIDs        []string `json:"artist_ids,omitempty" bson:"artist_ids,omitempty"`
+	Warnings         []string `json:"warnings,omitempty" bson:"warnings,omitempty"`
+	Error            string   `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+type DDEXIngestRequest struct {
+	Path string `json:"path"` // file or batch directory relative to the drop directory; empty scans everything
+}
@@ -0,0 +1,164 @@
+package podcast
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feed is an RSS 2.0 podcast feed with the iTunes namespace extensions we use
+type Feed struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel Channel  `xml:"channel"`
+}
+
+type Channel struct {
+	Title          string           `xml:"title"`
+	Link           string           `xml:"link"`
+	Description    string           `xml:"description"`
+	Language       string           `xml:"language"`
+	Author         string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	Summary        string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	Explicit       string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	ITunesImage    ITunesImage      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	ImageURL       string           `xml:"image>url"`
+	ITunesCategory []ITunesCategory `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+	Items          []Item           `xml:"item"`
+}
+
+type ITunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type ITunesCategory struct {
+	Text          string           `xml:"text,attr"`
+	Subcategories []ITunesCategory `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+}
+
+type Item struct {
+	Title       string    `xml:"title"`
+	Description string    `xml:"description"`
+	Summary     string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	GUID        string    `xml:"guid"`
+	PubDate     string    `xml:"pubDate"`
+	Enclosure   Enclosure `xml:"enclosure"`
+	Duration    string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Episode     string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	Season      string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	Explicit    string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	EpisodeType string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episodeType"`
+}
+
+type Enclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+// Parse decodes an RSS 2.0 podcast feed
+func Parse(r io.Reader) (*Feed, error) {
+	var feed Feed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("invalid RSS feed: %w", err)
+	}
+	if strings.TrimSpace(feed.Channel.Title) == "" {
+		return nil, errors.New("feed channel has no title")
+	}
+	return &feed, nil
+}
+
+// Image returns the show artwork, preferring the iTunes image
+func (c Channel) Image() string {
+	if c.ITunesImage.Href != "" {
+		return c.ITunesImage.Href
+	}
+	return c.ImageURL
+}
+
+// Categories flattens the iTunes category tree
+func (c Channel) Categories() []string {
+	var out []string
+	var walk func([]ITunesCategory)
+	walk = func(cats []ITunesCategory) {
+		for _, cat := range cats {
+			if cat.Text != "" {
+				out = append(out, cat.Text)
+			}
+			walk(cat.Subcategories)
+		}
+	}
+	walk(c.ITunesCategory)
+	return out
+}
+
+// ID returns a stable identifier for the episode within its feed
+func (i Item) ID() string {
+	if i.GUID != "" {
+		return strings.TrimSpace(i.GUID)
+	}
+	return i.Enclosure.URL
+}
+
+// Text returns the episode notes, falling back to the iTunes summary
+func (i Item) Text() string {
+	if i.Description != "" {
+		return strings.TrimSpace(i.Description)
+	}
+	return strings.TrimSpace(i.Summary)
+}
+
+// PublishedAt parses the RFC 822 publication date used by RSS
+func (i Item) PublishedAt() (time.Time, error) {
+	raw := strings.TrimSpace(i.PubDate)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid pubDate %q", i.PubDate)
+}
+
+// IsExplicit interprets the iTunes explicit flag ("yes", "true", "explicit")
+func IsExplicit(raw string) bool {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "yes", "true", "explicit":
+		return true
+	}
+	return false
+}
+
+// ParseDuration converts an itunes:duration value (seconds, MM:SS or HH:MM:SS) into seconds
+func ParseDuration(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, errors.New("duration missing")
+	}
+
+	parts := strings.Split(raw, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid duration %q", raw)
+	}
+
+	total := 0
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", raw)
+		}
+		total = total*60 + n
+	}
+	return total, nil
+}
+
+// ParseNumber parses optional numeric iTunes fields such as episode and season
+func ParseNumber(raw string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -17,21 +17,50 @@ func setupRoutes(router *gin.Engine) {
 		api.GET("/albums/:id", handlers.GetAlbum)
 		api.GET("/songs", handlers.GetSongs)
 		api.GET("/search", handlers.SearchContent)
+		api.GET("/podcasts", handlers.GetPodcasts)
+		api.GET("/podcasts/:id", handlers.GetPodcast)
+		api.GET("/podcasts/:id/episodes", handlers.GetPodcastEpisodes)
+		api.GET("/episodes/:id", handlers.GetEpisode)
 
-		// Admin routes
-		admin := api.Group("/")
-		admin.Use(middleware.AuthMiddleware())
-		admin.Use(middleware.AdminMiddleware())
+		// Catalog management (curators and admins)
+		catalog := api.Group("/")
+		catalog.Use(middleware.AuthMiddleware())
+		write := middleware.RequirePermission("catalog:write")
+		del := middleware.RequirePermission("catalog:delete")
 		{
-			admin.POST("/artists", handlers.CreateArtist)
-			admin.PUT("/artists/:id", handlers.UpdateArtist)
-			admin.POST("/albums", handlers.CreateAlbum)
-			admin.POST("/songs", handlers.CreateSong)
-			admin.DELETE("/songs/:id", handlers.DeleteSong)
+			catalog.POST("/artists", write, handlers.CreateArtist)
+			catalog.PUT("/artists/:id", write, handlers.UpdateArtist)
+			catalog.POST("/albums", write, handlers.CreateAlbum)
+			catalog.POST("/songs", write, handlers.CreateSong)
+			catalog.DELETE("/songs/:id", del, handlers.DeleteSong)
+
+			// Podcasts
+			catalog.POST("/podcasts", write, handlers.CreatePodcast)
+			catalog.POST("/podcasts/import", write, handlers.ImportPodcastFeed)
+			catalog.PUT("/podcasts/:id", write, handlers.UpdatePodcast)
+			catalog.DELETE("/podcasts/:id", del, handlers.DeletePodcast)
+			catalog.POST("/podcasts/:id/episodes", write, handlers.CreateEpisode)
+			catalog.PUT("/episodes/:id", write, handlers.UpdateEpisode)
+			catalog.DELETE("/episodes/:id", del, handlers.DeleteEpisode)
+
+			// DDEX label deliveries
+			catalog.POST("/ddex/ingest", write, handlers.IngestDDEX)
+			catalog.GET("/ddex/reports", write, handlers.GetDDEXReports)
+			catalog.GET("/ddex/reports/:messageId", write, handlers.GetDDEXReport)
 		}
 
+		// Audio assets imported from label deliveries
+		api.Static("/media", handlers.MediaDir())
+
 		// Authenticated user routes
 		api.GET("/songs/:id/stream", middleware.AuthMiddleware(), handlers.StreamSong)
+		api.GET("/podcasts/:id/progress", middleware.AuthMiddleware(), handlers.GetPodcastProgress)
+		api.GET("/episodes/:id/progress", middleware.AuthMiddleware(), handlers.GetEpisodeProgress)
+		api.PUT("/episodes/:id/progress", middleware.AuthMiddleware(), handlers.UpdateEpisodeProgress)
+
+		// Internal routes - data export and account deletion from users-service (not exposed through the gateway)
+		api.GET("/users/:userId/data", middleware.ServiceAuth(), handlers.ExportUserData)
+		api.DELETE("/users/:userId/data", middleware.ServiceAuth(), handlers.DeleteUserData)
 	}
 
 	router.GET("/health", func(c *gin.Context) {
@@ -1,8 +1,9 @@
-import { Component } from '@angular/core';
+import { Component, OnInit, AfterViewInit } from '@angular/core';
 import { CommonModule } from '@angular/common';
 import { FormsModule } from '@angular/forms';
 import { RouterLink } from '@angular/router';
 import { AuthService } from '../../services/auth.service';
+import { CaptchaWidgetId, RecaptchaService } from '../../services/recaptcha.service';
 
 @Component({
   selector: 'app-forgot-password',
@@ -35,6 +36,10 @@ import { AuthService } from '../../services/auth.service';
                    <label class="form-label text-white fw-bold small">Email address</label>
                    <input type="email" class="form-input" [(ngModel)]="email" name="email" required placeholder="name@domain.com">
                  </div>
+
+                 <div class="mb-4 d-flex justify-content-center">
+                   <div id="recaptcha-reset"></div>
+                 </div>
                  
                  <button class="btn-primary w-100 py-3 rounded-pill fw-bold text-uppercase" 
                          type="submit" 
@@ -55,21 +60,60 @@ import { AuthService } from '../../services/auth.service';
   `,
   styles: []
 })
-export class ForgotPasswordComponent {
+export class ForgotPasswordComponent implements OnInit, AfterViewInit {
   email = '';
   loading = false;
   successMessage: string | null = null;
   errorMessage: string | null = null;
 
-  constructor(private auth: AuthService) { }
+  // reCAPTCHA
+  recaptchaToken = '';
+  recaptchaWidgetId: CaptchaWidgetId | null = null;
+  recaptchaLoaded = false;
+  // Off when users-service has no CAPTCHA provider; forms are sent without a token then
+  captchaEnabled = false;
+
+  constructor(private auth: AuthService, private recaptchaService: RecaptchaService) { }
+
+  ngOnInit(): void {
+    this.recaptchaService.load().then(config => {
+      this.captchaEnabled = config.enabled;
+      this.recaptchaLoaded = true;
+    }).catch(err => {
+      console.error('Failed to load CAPTCHA:', err);
+    });
+  }
+
+  ngAfterViewInit(): void {
+    setTimeout(() => this.renderRecaptcha(), 500);
+  }
+
+  renderRecaptcha(): void {
+    if (!this.recaptchaLoaded) {
+      setTimeout(() => this.renderRecaptcha(), 500);
+      return;
+    }
+
+    const widgetId = this.recaptchaService.render('recaptcha-reset', (token: string) => {
+      this.recaptchaToken = token;
+    }, 'password_reset');
+    if (widgetId !== null) {
+      this.recaptchaWidgetId = widgetId;
+    }
+  }
 
   onSubmit(): void {
     if (!this.email) return;
 
+    if (this.captchaEnabled && !this.recaptchaToken) {
+      this.errorMessage = 'Molimo potvrdite da niste robot (CAPTCHA)';
+      return;
+    }
+
     this.loading = true;
     this.errorMessage = null;
 
-    this.auth.requestPasswordReset(this.email.trim()).subscribe({
+    this.auth.requestPasswordReset(this.email.trim(), this.recaptchaToken).subscribe({
       next: (res) => {
         // Backend always returns 200 OK even if email not found (security)
         this.successMessage = res.message || 'Ako email postoji, link je poslat.';
@@ -78,6 +122,11 @@ export class ForgotPasswordComponent {
       error: () => {
         this.errorMessage = 'Greška pri slanju zahteva.';
         this.loading = false;
+        // Tokens are single use
+        this.recaptchaToken = '';
+        if (this.recaptchaWidgetId !== null) {
+          this.recaptchaService.reset(this.recaptchaWidgetId);
+        }
       }
     });
   }
@@ -4,7 +4,7 @@ import { CommonModule } from '@angular/common';
 import { FormsModule } from '@angular/forms';
 
 import { AuthService } from '../../services/auth.service';
-import { RecaptchaService } from '../../services/recaptcha.service';
+import { CaptchaWidgetId, RecaptchaService } from '../../services/recaptcha.service';
 
 @Component({
   selector: 'app-login',
@@ -31,8 +31,9 @@ export class LoginComponent implements OnInit, AfterViewInit {
 
   // reCAPTCHA
   recaptchaToken = '';
-  recaptchaWidgetId: number | null = null;
+  recaptchaWidgetId: CaptchaWidgetId | null = null;
   recaptchaLoaded = false;
+  captchaEnabled = false;
 
   constructor(
     private authService: AuthService,
@@ -43,10 +44,11 @@ export class LoginComponent implements OnInit, AfterViewInit {
 
   ngOnInit(): void {
     // Load reCAPTCHA script
-    this.recaptchaService.load().then(() => {
+    this.recaptchaService.load().then(config => {
+      this.captchaEnabled = config.enabled;
       this.recaptchaLoaded = true;
     }).catch(err => {
-      console.error('Failed to load reCAPTCHA:', err);
+      console.error('Failed to load CAPTCHA:', err);
     });
   }
 
@@ -66,7 +68,7 @@ export class LoginComponent implements OnInit, AfterViewInit {
 
     const widgetId = this.recaptchaService.render('recaptcha-login', (token: string) => {
       this.recaptchaToken = token;
-    });
+    }, 'login');
 
     if (widgetId !== null) {
       this.recaptchaWidgetId = widgetId;
@@ -111,8 +113,8 @@ export class LoginComponent implements OnInit, AfterViewInit {
     if (!this.username || !this.password) return;
 
     // Check reCAPTCHA
-    if (!this.recaptchaToken) {
-      this.errorMessage = 'Molimo potvrdite da niste robot (reCAPTCHA)';
+    if (this.captchaEnabled && !this.recaptchaToken) {
+      this.errorMessage = 'Molimo potvrdite da niste robot (CAPTCHA)';
       return;
     }
 
@@ -4,7 +4,7 @@ import { CommonModule } from '@angular/common';
 import { FormsModule } from '@angular/forms';
 
 import { AuthService } from '../../services/auth.service';
-import { RecaptchaService } from '../../services/recaptcha.service';
+import { CaptchaWidgetId, RecaptchaService } from '../../services/recaptcha.service';
 
 @Component({
   selector: 'app-register',
@@ -32,8 +32,9 @@ export class RegisterComponent implements OnInit, AfterViewInit {
 
   // reCAPTCHA
   recaptchaToken = '';
-  recaptchaWidgetId: number | null = null;
+  recaptchaWidgetId: CaptchaWidgetId | null = null;
   recaptchaLoaded = false;
+  captchaEnabled = false;
 
   // Mapiranje backend poruka na srpski
   private errorMessages: { [key: string]: string } = {
@@ -57,10 +58,11 @@ export class RegisterComponent implements OnInit, AfterViewInit {
   ) {}
 
   ngOnInit(): void {
-    this.recaptchaService.load().then(() => {
+    this.recaptchaService.load().then(config => {
+      this.captchaEnabled = config.enabled;
       this.recaptchaLoaded = true;
     }).catch(err => {
-      console.error('Failed to load reCAPTCHA:', err);
+      console.error('Failed to load CAPTCHA:', err);
     });
   }
 
@@ -78,7 +80,7 @@ export class RegisterComponent implements OnInit, AfterViewInit {
 
     const widgetId = this.recaptchaService.render('recaptcha-register', (token: string) => {
       this.recaptchaToken = token;
-    });
+    }, 'register');
 
     if (widgetId !== null) {
       this.recaptchaWidgetId = widgetId;
@@ -105,8 +107,8 @@ export class RegisterComponent implements OnInit, AfterViewInit {
     }
 
     // Check reCAPTCHA
-    if (!this.recaptchaToken) {
-      this.errorMessage = 'Molimo potvrdite da niste robot (reCAPTCHA)';
+    if (this.captchaEnabled && !this.recaptchaToken) {
+      this.errorMessage = 'Molimo potvrdite da niste robot (CAPTCHA)';
       return;
     }
 
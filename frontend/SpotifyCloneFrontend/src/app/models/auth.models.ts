@@ -26,6 +26,8 @@ export type LoginUser = {
 export type VerifyOTPResponse = {
   token: string;
   user: LoginUser;
+  // Issued the first time this browser logs in; sent back on later logins
+  device_token?: string;
 };
 
 export type UpdateProfileRequest = {
@@ -30,9 +30,21 @@ export class AuthService {
     return !!localStorage.getItem('token');
   }
 
+  /** Header identifying this browser to the login risk checks, once it has a device token */
+  private deviceHeaders(): HttpHeaders {
+    const deviceToken = localStorage.getItem('device_token');
+    return deviceToken ? new HttpHeaders({ 'X-Device-Token': deviceToken }) : new HttpHeaders();
+  }
+
+  private rememberDevice(res: { device_token?: string }): void {
+    if (res.device_token) {
+      localStorage.setItem('device_token', res.device_token);
+    }
+  }
+
   // Step 1: Login (returns temp_token)
   login(payload: LoginRequest): Observable<LoginInitiateResponse> {
-    return this.http.post<LoginInitiateResponse>(`${this.apiBase}/login`, payload);
+    return this.http.post<LoginInitiateResponse>(`${this.apiBase}/login`, payload, { headers: this.deviceHeaders() });
   }
 
   // Step 2: Verify OTP (returns JWT)
@@ -40,6 +52,7 @@ export class AuthService {
     return this.http.post<VerifyOTPResponse>(`${this.apiBase}/verify-otp`, payload).pipe(
       tap((res) => {
         localStorage.setItem('token', res.token);
+        this.rememberDevice(res);
         if (res.user && res.user.role) {
           localStorage.setItem('user_role', res.user.role);
         }
@@ -95,8 +108,8 @@ export class AuthService {
     return this.http.post(`${this.apiBase}/change-password`, payload);
   }
 
-  requestPasswordReset(email: string): Observable<any> {
-    return this.http.post(`${this.apiBase}/reset-password`, { email });
+  requestPasswordReset(email: string, recaptchaToken?: string): Observable<any> {
+    return this.http.post(`${this.apiBase}/reset-password`, { email, recaptcha_token: recaptchaToken });
   }
 
   requestMagicLink(email: string): Observable<any> {
@@ -104,10 +117,11 @@ export class AuthService {
   }
 
   magicLogin(token: string): Observable<any> {
-    return this.http.get(`${this.apiBase}/magic-login?token=${token}`).pipe(
+    return this.http.get(`${this.apiBase}/magic-login?token=${token}`, { headers: this.deviceHeaders() }).pipe(
       tap((res: any) => {
         if (res.token) {
           localStorage.setItem('token', res.token);
+          this.rememberDevice(res);
           this.loggedInSubject.next(true);
         }
       })
@@ -1,63 +1,157 @@
 import { Injectable } from '@angular/core';
+import { HttpClient } from '@angular/common/http';
+import { firstValueFrom } from 'rxjs';
+
+import { AppConfig } from '../config';
 
 declare global {
   interface Window {
     grecaptcha: any;
-    onRecaptchaLoad: () => void;
+    hcaptcha: any;
+    turnstile: any;
+    onCaptchaLoad: () => void;
   }
 }
 
+/** CAPTCHA settings served by users-service (GET /captcha/config) */
+export interface CaptchaConfig {
+  enabled: boolean;
+  provider?: string;
+  site_key?: string;
+}
+
+// Widget IDs are numbers, except Turnstile's
+export type CaptchaWidgetId = number | string;
+
+// Fake provider (local setups): this token always passes
+const FAKE_PASS_TOKEN = 'pass';
+
+// Widget scripts, rendered explicitly and calling onCaptchaLoad when ready
+const WIDGET_SCRIPTS: Record<string, string> = {
+  'recaptcha-v2': 'https://www.google.com/recaptcha/api.js?onload=onCaptchaLoad&render=explicit',
+  'hcaptcha': 'https://js.hcaptcha.com/1/api.js?onload=onCaptchaLoad&render=explicit',
+  'turnstile': 'https://challenges.cloudflare.com/turnstile/v0/api.js?onload=onCaptchaLoad&render=explicit',
+};
+
 @Injectable({
   providedIn: 'root'
 })
 export class RecaptchaService {
-  private siteKey = '6Le-GU0sAAAAAKulZPg-IiJbh8pRFO7lvFjxW6X_';
+  private config: CaptchaConfig = { enabled: false };
   private loaded = false;
-  private loadPromise: Promise<void> | null = null;
+  private loadPromise: Promise<CaptchaConfig> | null = null;
+
+  // reCAPTCHA v3 and the fake provider have no widget; a token is requested per action instead
+  private invisible = new Map<CaptchaWidgetId, () => void>();
+  private nextInvisibleId = 1;
+
+  constructor(private http: HttpClient) { }
 
   /**
-   * Load reCAPTCHA script dynamically
+   * Fetch the CAPTCHA config and load the provider's script. Resolves with the config;
+   * when CAPTCHA is disabled nothing is loaded and no token is needed.
    */
-  load(): Promise<void> {
+  load(): Promise<CaptchaConfig> {
     if (this.loaded) {
-      return Promise.resolve();
+      return Promise.resolve(this.config);
     }
 
     if (this.loadPromise) {
       return this.loadPromise;
     }
 
-    this.loadPromise = new Promise((resolve, reject) => {
-      // Define callback
-      window.onRecaptchaLoad = () => {
+    this.loadPromise = firstValueFrom(this.http.get<CaptchaConfig>(`${AppConfig.apiUrl}/captcha/config`))
+      .then(config => {
+        this.config = config;
+        return this.loadScript();
+      })
+      .then(() => {
         this.loaded = true;
-        resolve();
-      };
+        return this.config;
+      })
+      .catch(err => {
+        this.loadPromise = null;
+        throw err;
+      });
+
+    return this.loadPromise;
+  }
+
+  private loadScript(): Promise<void> {
+    const { enabled, provider, site_key } = this.config;
+    if (!enabled || provider === 'fake') {
+      return Promise.resolve();
+    }
+
+    let src = provider ? WIDGET_SCRIPTS[provider] : undefined;
+    if (provider === 'recaptcha-v3') {
+      src = `https://www.google.com/recaptcha/api.js?onload=onCaptchaLoad&render=${encodeURIComponent(site_key || '')}`;
+    }
+    if (!src) {
+      return Promise.reject(new Error(`Unsupported CAPTCHA provider: ${provider}`));
+    }
+
+    return new Promise((resolve, reject) => {
+      window.onCaptchaLoad = () => resolve();
 
-      // Create script element
       const script = document.createElement('script');
-      script.src = `https://www.google.com/recaptcha/api.js?onload=onRecaptchaLoad&render=explicit`;
+      script.src = src!;
       script.async = true;
       script.defer = true;
-      script.onerror = () => reject(new Error('Failed to load reCAPTCHA'));
+      script.onerror = () => reject(new Error(`Failed to load ${provider}`));
 
       document.head.appendChild(script);
     });
+  }
 
-    return this.loadPromise;
+  /** Whether forms have to send a CAPTCHA token */
+  isEnabled(): boolean {
+    return this.config.enabled;
+  }
+
+  private widgetApi(): any {
+    switch (this.config.provider) {
+      case 'hcaptcha':
+        return window.hcaptcha;
+      case 'turnstile':
+        return window.turnstile;
+      default:
+        return window.grecaptcha;
+    }
   }
 
   /**
-   * Render reCAPTCHA widget in specified container
+   * Render the CAPTCHA in the container. The callback gets a token, or '' once it
+   * expires. action is what the backend verifies the token for (login, register,
+   * password_reset). Returns null when CAPTCHA is disabled.
    */
-  render(containerId: string, callback: (token: string) => void): number | null {
-    if (!this.loaded || !window.grecaptcha) {
-      console.error('reCAPTCHA not loaded');
+  render(containerId: string, callback: (token: string) => void, action: string): CaptchaWidgetId | null {
+    if (!this.loaded || !this.config.enabled) {
+      return null;
+    }
+
+    if (this.config.provider === 'fake' || this.config.provider === 'recaptcha-v3') {
+      const id = this.nextInvisibleId++;
+      const execute = this.config.provider === 'fake'
+        ? () => callback(FAKE_PASS_TOKEN)
+        : () => window.grecaptcha.ready(() => {
+          window.grecaptcha.execute(this.config.site_key, { action })
+            .then((token: string) => callback(token), () => callback(''));
+        });
+      this.invisible.set(id, execute);
+      execute();
+      return id;
+    }
+
+    const container = document.getElementById(containerId);
+    if (!container) {
+      console.error(`CAPTCHA container #${containerId} not found`);
       return null;
     }
 
-    return window.grecaptcha.render(containerId, {
-      sitekey: this.siteKey,
+    return this.widgetApi().render(container, {
+      sitekey: this.config.site_key,
+      action: action,
       callback: callback,
       'expired-callback': () => callback(''),
       'error-callback': () => callback('')
@@ -65,31 +159,21 @@ export class RecaptchaService {
   }
 
   /**
-   * Get current reCAPTCHA response token
+   * Reset the CAPTCHA after a submit; tokens are single use. Without a widget a new
+   * token is requested right away.
    */
-  getResponse(widgetId?: number): string {
-    if (!window.grecaptcha) return '';
-    return widgetId !== undefined
-      ? window.grecaptcha.getResponse(widgetId)
-      : window.grecaptcha.getResponse();
-  }
+  reset(widgetId: CaptchaWidgetId): void {
+    if (!this.config.enabled) return;
 
-  /**
-   * Reset reCAPTCHA widget
-   */
-  reset(widgetId?: number): void {
-    if (!window.grecaptcha) return;
-    if (widgetId !== undefined) {
-      window.grecaptcha.reset(widgetId);
-    } else {
-      window.grecaptcha.reset();
+    const execute = this.invisible.get(widgetId);
+    if (execute) {
+      execute();
+      return;
     }
-  }
 
-  /**
-   * Get site key (for component use)
-   */
-  getSiteKey(): string {
-    return this.siteKey;
+    const api = this.widgetApi();
+    if (api) {
+      api.reset(widgetId);
+    }
   }
 }
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"regexp"
 	"sort"
 	"time"
 
@@ -161,3 +162,79 @@ func GetUnreadCount(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"unread_count": count})
 }
+
+// User ids are hex ObjectIDs as issued by users-service
+var userIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+func validUserID(userID string) bool {
+	return userIDPattern.MatchString(userID)
+}
+
+// ExportUserData returns all notifications of a user for their data export.
+// Called by the users-service export worker.
+func ExportUserData(c *gin.Context) {
+	userID := c.Param("user_id")
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	notifications := []Notification{}
+	iter := session.Query(`
+		SELECT id, user_id, message, type, read, created_at
+		FROM notifications
+		WHERE user_id = ?
+	`, userID).WithContext(ctx).Iter()
+
+	var (
+		id        gocql.UUID
+		uid       string
+		message   string
+		ntype     string
+		read      bool
+		createdAt time.Time
+	)
+
+	for iter.Scan(&id, &uid, &message, &ntype, &read, &createdAt) {
+		notifications = append(notifications, Notification{
+			ID:        id.String(),
+			UserID:    uid,
+			Message:   message,
+			Type:      ntype,
+			Read:      read,
+			CreatedAt: createdAt,
+		})
+	}
+
+	if err := iter.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications})
+}
+
+// DeleteUserData drops the whole notifications partition of a deleted account.
+// Called by the users-service deletion worker; safe to repeat.
+func DeleteUserData(c *gin.Context) {
+	userID := c.Param("user_id")
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// user_id je partition key, pa jedan DELETE briše sve notifikacije korisnika
+	if err := session.Query(`
+		DELETE FROM notifications
+		WHERE user_id = ?
+	`, userID).WithContext(ctx).Exec(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User data deleted"})
+}
@@ -269,6 +269,10 @@ func setupRoutes(router *gin.Engine) {
 		api.GET("/notifications/unread/count", middleware.AuthMiddleware(), handlers.GetUnreadCount)
 		api.PUT("/notifications/:id/read", middleware.AuthMiddleware(), handlers.MarkAsRead)
 		api.POST("/notifications", handlers.CreateNotification) // Called by content-service
+
+		// Internal routes - data export and account deletion from users-service (not exposed through the gateway)
+		api.GET("/users/:user_id/data", middleware.ServiceAuth(), handlers.ExportUserData)
+		api.DELETE("/users/:user_id/data", middleware.ServiceAuth(), handlers.DeleteUserData)
 	}
 
 	router.GET("/health", func(c *gin.Context) {
@@ -0,0 +1,729 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	partyTTL        = 12 * time.Hour
+	maxPartyMembers = 50
+	maxChatHistory  = 100
+	maxChatLength   = 500
+	// At most chatRateLimit messages per chatRateWindow per member
+	chatRateLimit  = 5
+	chatRateWindow = 5 * time.Second
+	// Participants get the host's position this often so they can correct drift
+	partySyncPeriod = 5 * time.Second
+	// Drift below driftIgnoreMs is ignored, below driftSeekMs is fixed by nudging the
+	// playback rate, anything larger needs a hard seek
+	driftIgnoreMs = 250
+	driftSeekMs   = 2000
+	inviteCodeLen = 8
+)
+
+// No 0/O or 1/I so codes survive being read out loud
+const inviteAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var partyReactions = map[string]bool{
+	"fire": true, "heart": true, "clap": true, "laugh": true, "party": true, "thumbs_up": true,
+}
+
+// Only the host may steer party playback
+var hostActions = map[string]bool{
+	"play": true, "pause": true, "seek": true, "next": true, "previous": true, "track_ended": true,
+	"shuffle": true, "repeat": true, "add_to_queue": true, "clear_queue": true,
+}
+
+var (
+	ErrPartyNotFound = errors.New("party not found")
+	ErrNotMember     = errors.New("not a member of this party")
+	ErrNotHost       = errors.New("only the host can do that")
+	ErrPartyFull     = errors.New("party is full")
+)
+
+type Party struct {
+	ID        string        `json:"id"`
+	Code      string        `json:"code"`
+	Name      string        `json:"name"`
+	HostID    string        `json:"host_id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Members   []PartyMember `json:"members,omitempty"`
+}
+
+type PartyMember struct {
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type ChatMessage struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Text      string    `json:"text,omitempty"`
+	Reaction  string    `json:"reaction,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PartySync carries the server clock so participants can extrapolate the host's position
+type PartySync struct {
+	State      *PlaybackState `json:"state"`
+	ServerTime int64          `json:"server_time"`
+}
+
+type DriftReport struct {
+	DriftMs            int64   `json:"drift_ms"`
+	ExpectedPositionMs int64   `json:"expected_position_ms"`
+	Correction         string  `json:"correction"`
+	PlaybackRate       float64 `json:"playback_rate"`
+	ServerTime         int64   `json:"server_time"`
+}
+
+// partyMessage is anything a participant sends over the party WebSocket
+type partyMessage struct {
+	Command
+	Text       string `json:"text,omitempty"`
+	Reaction   string `json:"reaction,omitempty"`
+	ClientTime int64  `json:"client_time,omitempty"`
+}
+
+type CreatePartyRequest struct {
+	Name string `json:"name" binding:"max=100"`
+}
+
+type JoinPartyRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func partyKey(id string) string         { return "party:" + id }
+func partyStateKey(id string) string    { return "party:" + id + ":state" }
+func partyMembersKey(id string) string  { return "party:" + id + ":members" }
+func partyChatKey(id string) string     { return "party:" + id + ":chat" }
+func partyCodeKey(code string) string   { return "party-code:" + code }
+func partyHostKey(userID string) string { return "party-host:" + userID }
+func partyChannel(id string) string     { return "party-events:" + id }
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newInviteCode() (string, error) {
+	code := make([]byte, inviteCodeLen)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(inviteAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func loadParty(ctx context.Context, id string) (*Party, error) {
+	data, err := redisClient.Get(ctx, partyKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrPartyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Party
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func partyMembers(ctx context.Context, id string) ([]PartyMember, error) {
+	entries, err := redisClient.HGetAll(ctx, partyMembersKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]PartyMember, 0, len(entries))
+	for _, data := range entries {
+		var m PartyMember
+		if err := json.Unmarshal([]byte(data), &m); err == nil {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func isPartyMember(ctx context.Context, id, userID string) (bool, error) {
+	return redisClient.HExists(ctx, partyMembersKey(id), userID).Result()
+}
+
+// loadPartyForMember loads a party and checks the user belongs to it
+func loadPartyForMember(ctx context.Context, id, userID string) (*Party, error) {
+	party, err := loadParty(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	member, err := isPartyMember(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, ErrNotMember
+	}
+	return party, nil
+}
+
+// touchParty keeps an active party alive; idle parties expire after partyTTL
+func touchParty(ctx context.Context, p *Party) {
+	pipe := redisClient.Pipeline()
+	for _, key := range []string{partyKey(p.ID), partyStateKey(p.ID), partyMembersKey(p.ID), partyChatKey(p.ID), partyCodeKey(p.Code), partyHostKey(p.HostID)} {
+		pipe.Expire(ctx, key, partyTTL)
+	}
+	pipe.Exec(ctx)
+}
+
+func addPartyMember(ctx context.Context, p *Party, userID, username string) error {
+	data, err := json.Marshal(PartyMember{UserID: userID, Username: username, JoinedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(ctx, partyMembersKey(p.ID), userID, data).Err()
+}
+
+func endParty(ctx context.Context, p *Party) {
+	redisClient.Del(ctx, partyKey(p.ID), partyStateKey(p.ID), partyMembersKey(p.ID), partyChatKey(p.ID), partyCodeKey(p.Code), partyHostKey(p.HostID))
+	publishEvent(ctx, partyChannel(p.ID), "party_ended", gin.H{"party_id": p.ID})
+}
+
+// applyPartyCommand runs a host command against the party's playback state
+func applyPartyCommand(ctx context.Context, p *Party, cmd Command) (*PlaybackState, error) {
+	if !hostActions[cmd.Action] {
+		return nil, ErrInvalidCommand
+	}
+	state, err := updateState(ctx, partyStateKey(p.ID), partyTTL, func(s *PlaybackState, now time.Time) error {
+		return applyToState(ctx, p.HostID, s, cmd, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	publishEvent(ctx, partyChannel(p.ID), "state", PartySync{State: state, ServerTime: time.Now().UnixMilli()})
+	return state, nil
+}
+
+// measureDrift compares a participant's reported position against where the host is now
+func measureDrift(state *PlaybackState, trackID string, positionMs int64) DriftReport {
+	report := DriftReport{
+		ExpectedPositionMs: state.PositionMs,
+		Correction:         "none",
+		PlaybackRate:       1.0,
+		ServerTime:         time.Now().UnixMilli(),
+	}
+
+	if trackID != state.TrackID {
+		report.Correction = "seek"
+		return report
+	}
+
+	report.DriftMs = positionMs - state.PositionMs
+	abs := report.DriftMs
+	if abs < 0 {
+		abs = -abs
+	}
+
+	switch {
+	case !state.IsPlaying || abs < driftIgnoreMs:
+	case abs < driftSeekMs:
+		// Catch up (or fall back) over roughly 10 seconds instead of an audible jump
+		report.Correction = "rate"
+		if report.DriftMs > 0 {
+			report.PlaybackRate = 0.95
+		} else {
+			report.PlaybackRate = 1.05
+		}
+	default:
+		report.Correction = "seek"
+	}
+	return report
+}
+
+func partyError(c *gin.Context, err error) {
+	switch err {
+	case ErrPartyNotFound:
+		c.JSON(404, gin.H{"error": "Party not found"})
+	case ErrNotMember, ErrNotHost:
+		c.JSON(403, gin.H{"error": err.Error()})
+	case ErrPartyFull:
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		if status := commandStatus(err); status != 500 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Redis error"})
+	}
+}
+
+// CreateParty starts a listening party hosted by the caller, seeded from their current queue
+func CreateParty(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req CreatePartyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid data"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	if existing, err := redisClient.Get(ctx, partyHostKey(userID)).Result(); err == nil {
+		c.JSON(409, gin.H{"error": "You are already hosting a party", "party_id": existing})
+		return
+	}
+
+	party := &Party{
+		ID:        randomID(),
+		Name:      strings.TrimSpace(req.Name),
+		HostID:    userID,
+		CreatedAt: time.Now(),
+	}
+	if party.Name == "" {
+		party.Name = c.GetString("username") + "'s party"
+	}
+
+	// Claim a unique invite code
+	for attempt := 0; ; attempt++ {
+		if attempt == 5 {
+			c.JSON(500, gin.H{"error": "Failed to generate invite code"})
+			return
+		}
+		code, err := newInviteCode()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate invite code"})
+			return
+		}
+		ok, err := redisClient.SetNX(ctx, partyCodeKey(code), party.ID, partyTTL).Result()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Redis error"})
+			return
+		}
+		if ok {
+			party.Code = code
+			break
+		}
+	}
+
+	data, err := json.Marshal(party)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create party"})
+		return
+	}
+
+	// The party starts paused where the host's own playback is
+	seed := newState()
+	if state, err := GetState(ctx, userID); err == nil {
+		seed.Queue = state.Queue
+		seed.CurrentIndex = state.CurrentIndex
+		seed.TrackID = state.TrackID
+		seed.PositionMs = state.PositionMs
+		seed.Repeat = state.Repeat
+	}
+	seed.UpdatedAt = time.Now()
+	seedData, err := json.Marshal(seed)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create party"})
+		return
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, partyKey(party.ID), data, partyTTL)
+	pipe.Set(ctx, partyStateKey(party.ID), seedData, partyTTL)
+	pipe.Set(ctx, partyHostKey(userID), party.ID, partyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	if err := addPartyMember(ctx, party, userID, c.GetString("username")); err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+	touchParty(ctx, party)
+
+	party.Members, _ = partyMembers(ctx, party.ID)
+	c.JSON(201, party)
+}
+
+// JoinParty adds the caller to a party by invite code
+func JoinParty(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req JoinPartyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid data"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	id, err := redisClient.Get(ctx, partyCodeKey(code)).Result()
+	if err == redis.Nil {
+		c.JSON(404, gin.H{"error": "Invalid invite code"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	party, err := loadParty(ctx, id)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+
+	member, err := isPartyMember(ctx, id, userID)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	if !member {
+		count, err := redisClient.HLen(ctx, partyMembersKey(id)).Result()
+		if err != nil {
+			partyError(c, err)
+			return
+		}
+		if count >= maxPartyMembers {
+			partyError(c, ErrPartyFull)
+			return
+		}
+		if err := addPartyMember(ctx, party, userID, c.GetString("username")); err != nil {
+			partyError(c, err)
+			return
+		}
+		touchParty(ctx, party)
+		publishEvent(ctx, partyChannel(id), "member_joined", PartyMember{UserID: userID, Username: c.GetString("username"), JoinedAt: time.Now()})
+	}
+
+	party.Members, _ = partyMembers(ctx, id)
+	c.JSON(200, party)
+}
+
+func GetParty(c *gin.Context) {
+	ctx := c.Request.Context()
+	party, err := loadPartyForMember(ctx, c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+
+	state, err := readState(ctx, partyStateKey(party.ID))
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	party.Members, _ = partyMembers(ctx, party.ID)
+
+	c.JSON(200, gin.H{
+		"party":       party,
+		"state":       state,
+		"server_time": time.Now().UnixMilli(),
+	})
+}
+
+// LeaveParty removes the caller; the party ends when the host leaves
+func LeaveParty(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	party, err := loadPartyForMember(ctx, c.Param("id"), userID)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+
+	if party.HostID == userID {
+		endParty(ctx, party)
+		c.JSON(200, gin.H{"message": "Party ended"})
+		return
+	}
+
+	if err := redisClient.HDel(ctx, partyMembersKey(party.ID), userID).Err(); err != nil {
+		partyError(c, err)
+		return
+	}
+	publishEvent(ctx, partyChannel(party.ID), "member_left", gin.H{"user_id": userID})
+
+	c.JSON(200, gin.H{"message": "Left party"})
+}
+
+func EndParty(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	party, err := loadParty(ctx, c.Param("id"))
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	if party.HostID != userID {
+		partyError(c, ErrNotHost)
+		return
+	}
+
+	endParty(ctx, party)
+	c.JSON(200, gin.H{"message": "Party ended"})
+}
+
+// PartyCommand lets the host control party playback over REST as well as WebSocket
+func PartyCommand(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	party, err := loadPartyForMember(ctx, c.Param("id"), userID)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	if party.HostID != userID {
+		partyError(c, ErrNotHost)
+		return
+	}
+
+	var cmd Command
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid data"})
+		return
+	}
+
+	state, err := applyPartyCommand(ctx, party, cmd)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	touchParty(ctx, party)
+	c.JSON(200, state)
+}
+
+// GetPartyChat returns recent chat messages, oldest first
+func GetPartyChat(c *gin.Context) {
+	ctx := c.Request.Context()
+	party, err := loadPartyForMember(ctx, c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+
+	entries, err := redisClient.LRange(ctx, partyChatKey(party.ID), 0, maxChatHistory-1).Result()
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+
+	messages := make([]ChatMessage, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		var m ChatMessage
+		if err := json.Unmarshal([]byte(entries[i]), &m); err == nil {
+			messages = append(messages, m)
+		}
+	}
+	c.JSON(200, messages)
+}
+
+// allowChat applies the per-member chat/reaction rate limit
+func allowChat(ctx context.Context, partyID, userID string) bool {
+	key := "party:" + partyID + ":chat-rate:" + userID
+	count, err := redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return false
+	}
+	if count == 1 {
+		redisClient.Expire(ctx, key, chatRateWindow)
+	}
+	return count <= chatRateLimit
+}
+
+func postChat(ctx context.Context, p *Party, msg ChatMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// Reactions are fire-and-forget, only chat is kept for late joiners
+	if msg.Type == "chat" {
+		pipe := redisClient.Pipeline()
+		pipe.LPush(ctx, partyChatKey(p.ID), data)
+		pipe.LTrim(ctx, partyChatKey(p.ID), 0, maxChatHistory-1)
+		pipe.Expire(ctx, partyChatKey(p.ID), partyTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	publishEvent(ctx, partyChannel(p.ID), msg.Type, msg)
+	return nil
+}
+
+// PartySocket connects a participant to the party's sync, chat and reaction stream
+func PartySocket(c *gin.Context) {
+	claims, ok := authenticateSocket(c)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	party, err := loadPartyForMember(ctx, c.Param("id"), claims.UserID)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+
+	cl := &client{
+		hub:    partyHub,
+		room:   party.ID,
+		userID: claims.UserID,
+		device: Device{Name: claims.Username},
+		conn:   conn,
+		send:   make(chan []byte, 64),
+	}
+	cl.hub.add(cl)
+
+	if state, err := readState(ctx, partyStateKey(party.ID)); err == nil {
+		cl.reply(Event{Type: "state", Data: PartySync{State: state, ServerTime: time.Now().UnixMilli()}})
+	}
+	if members, err := partyMembers(ctx, party.ID); err == nil {
+		cl.reply(Event{Type: "members", Data: members})
+	}
+
+	done := make(chan struct{})
+	go cl.writePump()
+	go cl.syncLoop(party, done)
+	cl.readPump(nil, func(data []byte) { cl.handlePartyMessage(party, data) })
+	close(done)
+}
+
+// syncLoop periodically sends the host's extrapolated position for drift correction
+func (cl *client) syncLoop(p *Party, done <-chan struct{}) {
+	ticker := time.NewTicker(partySyncPeriod)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := loadParty(ctx, p.ID); err == ErrPartyNotFound {
+				cl.closeWith("party ended")
+				return
+			}
+			state, err := readState(ctx, partyStateKey(p.ID))
+			if err != nil {
+				continue
+			}
+			cl.reply(Event{Type: "sync", Data: PartySync{State: state, ServerTime: time.Now().UnixMilli()}})
+		}
+	}
+}
+
+func (cl *client) closeWith(reason string) {
+	cl.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason),
+		time.Now().Add(writeWait))
+	cl.conn.Close()
+}
+
+func (cl *client) handlePartyMessage(p *Party, data []byte) {
+	ctx := context.Background()
+
+	var msg partyMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		cl.reply(Event{Type: "error", Data: "Invalid message"})
+		return
+	}
+
+	member, err := isPartyMember(ctx, p.ID, cl.userID)
+	if err != nil {
+		cl.reply(Event{Type: "error", Data: "Party command failed"})
+		return
+	}
+	if !member {
+		cl.closeWith("not a member of this party")
+		return
+	}
+
+	switch msg.Action {
+	case "time_sync":
+		// NTP-style round trip so the client can estimate its clock offset
+		cl.reply(Event{Type: "time_sync", Data: gin.H{"client_time": msg.ClientTime, "server_time": time.Now().UnixMilli()}})
+
+	case "position":
+		if msg.PositionMs == nil {
+			cl.reply(Event{Type: "error", Data: ErrInvalidCommand.Error()})
+			return
+		}
+		state, err := readState(ctx, partyStateKey(p.ID))
+		if err != nil {
+			cl.reply(Event{Type: "error", Data: "Party command failed"})
+			return
+		}
+		cl.reply(Event{Type: "drift", Data: measureDrift(state, msg.TrackID, *msg.PositionMs)})
+
+	case "chat", "reaction":
+		chat := ChatMessage{
+			ID:        randomID(),
+			Type:      msg.Action,
+			UserID:    cl.userID,
+			Username:  cl.device.Name,
+			CreatedAt: time.Now(),
+		}
+		if msg.Action == "chat" {
+			chat.Text = strings.TrimSpace(msg.Text)
+			if chat.Text == "" || len(chat.Text) > maxChatLength {
+				cl.reply(Event{Type: "error", Data: "Message must be 1-500 characters"})
+				return
+			}
+		} else {
+			if !partyReactions[msg.Reaction] {
+				cl.reply(Event{Type: "error", Data: "Unknown reaction"})
+				return
+			}
+			chat.Reaction = msg.Reaction
+		}
+		if !allowChat(ctx, p.ID, cl.userID) {
+			cl.reply(Event{Type: "error", Data: "Slow down"})
+			return
+		}
+		if err := postChat(ctx, p, chat); err != nil {
+			cl.reply(Event{Type: "error", Data: "Party command failed"})
+		}
+
+	default:
+		if cl.userID != p.HostID {
+			cl.reply(Event{Type: "error", Data: ErrNotHost.Error()})
+			return
+		}
+		if _, err := applyPartyCommand(ctx, p, msg.Command); err != nil {
+			errMsg := err.Error()
+			if commandStatus(err) == 500 {
+				errMsg = "Party command failed"
+			}
+			cl.reply(Event{Type: "error", Data: errMsg})
+			return
+		}
+		touchParty(ctx, p)
+	}
+}
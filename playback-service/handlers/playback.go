@@ -0,0 +1,647 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+var redisClient *redis.Client
+
+func InitHandlers(client *redis.Client) {
+	redisClient = client
+}
+
+const (
+	maxQueueLength = 500
+	// Previous restarts the current track instead of going back once this far in
+	previousRestartMs = 3000
+	// Devices that have not pinged for this long are considered gone
+	deviceTTL = 90 * time.Second
+)
+
+const (
+	RepeatOff     = "off"
+	RepeatTrack   = "track"
+	RepeatContext = "context"
+)
+
+// PlaybackState is the single source of truth for a user's playback, shared by all devices
+type PlaybackState struct {
+	Queue         []string  `json:"queue"`
+	OriginalQueue []string  `json:"original_queue,omitempty"`
+	CurrentIndex  int       `json:"current_index"`
+	TrackID       string    `json:"track_id,omitempty"`
+	PositionMs    int64     `json:"position_ms"`
+	IsPlaying     bool      `json:"is_playing"`
+	Shuffle       bool      `json:"shuffle"`
+	Repeat        string    `json:"repeat"`
+	ActiveDevice  string    `json:"active_device,omitempty"`
+	Version       int64     `json:"version"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type Device struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	IsActive bool      `json:"is_active"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Command is a playback action, sent either over REST or the WebSocket channel
+type Command struct {
+	Action     string   `json:"action"`
+	TrackIDs   []string `json:"track_ids,omitempty"`
+	TrackID    string   `json:"track_id,omitempty"`
+	Index      *int     `json:"index,omitempty"`
+	PositionMs *int64   `json:"position_ms,omitempty"`
+	DeviceID   string   `json:"device_id,omitempty"`
+	State      *bool    `json:"state,omitempty"`
+	Repeat     string   `json:"repeat,omitempty"`
+	Play       *bool    `json:"play,omitempty"`
+}
+
+var (
+	ErrInvalidCommand = errors.New("invalid command")
+	ErrEmptyQueue     = errors.New("queue is empty")
+	ErrQueueFull      = errors.New("queue is full")
+	ErrUnknownDevice  = errors.New("device is not connected")
+	ErrConflict       = errors.New("playback state changed concurrently, retry")
+)
+
+func stateKey(userID string) string {
+	return "playback:" + userID
+}
+
+func devicesKey(userID string) string {
+	return "playback:" + userID + ":devices"
+}
+
+func eventsChannel(userID string) string {
+	return "playback-events:" + userID
+}
+
+func newState() *PlaybackState {
+	return &PlaybackState{Queue: []string{}, Repeat: RepeatOff}
+}
+
+// currentPosition extrapolates the stored position while a track is playing
+func (s *PlaybackState) currentPosition(now time.Time) int64 {
+	if !s.IsPlaying || s.UpdatedAt.IsZero() {
+		return s.PositionMs
+	}
+	return s.PositionMs + now.Sub(s.UpdatedAt).Milliseconds()
+}
+
+// settle freezes the extrapolated position so a state change starts from "now"
+func (s *PlaybackState) settle(now time.Time) {
+	s.PositionMs = s.currentPosition(now)
+	s.UpdatedAt = now
+}
+
+func (s *PlaybackState) setIndex(i int) {
+	s.CurrentIndex = i
+	s.TrackID = s.Queue[i]
+	s.PositionMs = 0
+}
+
+func loadState(ctx context.Context, tx *redis.Tx, key string) (*PlaybackState, error) {
+	data, err := tx.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return newState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := newState()
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// GetState returns the user's playback state with the position as of now
+func GetState(ctx context.Context, userID string) (*PlaybackState, error) {
+	return readState(ctx, stateKey(userID))
+}
+
+func readState(ctx context.Context, key string) (*PlaybackState, error) {
+	data, err := redisClient.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return newState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := newState()
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	state.PositionMs = state.currentPosition(now)
+	state.UpdatedAt = now
+	return state, nil
+}
+
+// ApplyCommand runs a command against the user's stored state and publishes the result to all devices
+func ApplyCommand(ctx context.Context, userID string, cmd Command) (*PlaybackState, error) {
+	state, err := updateState(ctx, stateKey(userID), 0, func(s *PlaybackState, now time.Time) error {
+		return applyToState(ctx, userID, s, cmd, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publishEvent(ctx, eventsChannel(userID), "state", state)
+	return state, nil
+}
+
+// updateState loads, mutates and saves a playback state under WATCH so two devices issuing
+// commands at once can't overwrite each other
+func updateState(ctx context.Context, key string, ttl time.Duration, mutate func(*PlaybackState, time.Time) error) (*PlaybackState, error) {
+	var result *PlaybackState
+
+	txf := func(tx *redis.Tx) error {
+		state, err := loadState(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if err := mutate(state, time.Now()); err != nil {
+			return err
+		}
+		state.Version++
+
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, ttl)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		result = state
+		return nil
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		err := redisClient.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, ErrConflict
+}
+
+func applyToState(ctx context.Context, userID string, s *PlaybackState, cmd Command, now time.Time) error {
+	s.settle(now)
+
+	switch cmd.Action {
+	case "play":
+		if len(cmd.TrackIDs) > 0 {
+			if len(cmd.TrackIDs) > maxQueueLength {
+				return ErrQueueFull
+			}
+			s.Queue = append([]string{}, cmd.TrackIDs...)
+			s.OriginalQueue = nil
+			start := 0
+			if cmd.Index != nil {
+				start = *cmd.Index
+			}
+			if start < 0 || start >= len(s.Queue) {
+				return ErrInvalidCommand
+			}
+			s.setIndex(start)
+			if s.Shuffle {
+				s.shuffle()
+			}
+		} else if cmd.Index != nil {
+			if *cmd.Index < 0 || *cmd.Index >= len(s.Queue) {
+				return ErrInvalidCommand
+			}
+			s.setIndex(*cmd.Index)
+		}
+		if len(s.Queue) == 0 {
+			return ErrEmptyQueue
+		}
+		if s.TrackID == "" {
+			s.setIndex(0)
+		}
+		if cmd.PositionMs != nil {
+			s.PositionMs = max(*cmd.PositionMs, 0)
+		}
+		s.IsPlaying = true
+
+	case "pause":
+		s.IsPlaying = false
+
+	case "seek":
+		if cmd.PositionMs == nil || *cmd.PositionMs < 0 || s.TrackID == "" {
+			return ErrInvalidCommand
+		}
+		s.PositionMs = *cmd.PositionMs
+
+	case "next":
+		if len(s.Queue) == 0 {
+			return ErrEmptyQueue
+		}
+		s.advance(false)
+
+	case "track_ended":
+		// Sent by the active device when a track finishes on its own
+		if len(s.Queue) == 0 {
+			return ErrEmptyQueue
+		}
+		s.advance(true)
+
+	case "previous":
+		if len(s.Queue) == 0 {
+			return ErrEmptyQueue
+		}
+		switch {
+		case s.PositionMs > previousRestartMs:
+			s.PositionMs = 0
+		case s.CurrentIndex > 0:
+			s.setIndex(s.CurrentIndex - 1)
+		case s.Repeat == RepeatContext:
+			s.setIndex(len(s.Queue) - 1)
+		default:
+			s.PositionMs = 0
+		}
+
+	case "transfer":
+		if cmd.DeviceID == "" {
+			return ErrInvalidCommand
+		}
+		online, err := isDeviceOnline(ctx, userID, cmd.DeviceID)
+		if err != nil {
+			return err
+		}
+		if !online {
+			return ErrUnknownDevice
+		}
+		s.ActiveDevice = cmd.DeviceID
+		if cmd.Play != nil {
+			s.IsPlaying = *cmd.Play && s.TrackID != ""
+		}
+
+	case "shuffle":
+		if cmd.State == nil {
+			return ErrInvalidCommand
+		}
+		if *cmd.State && !s.Shuffle {
+			s.Shuffle = true
+			s.shuffle()
+		} else if !*cmd.State && s.Shuffle {
+			s.Shuffle = false
+			s.unshuffle()
+		}
+
+	case "repeat":
+		switch cmd.Repeat {
+		case RepeatOff, RepeatTrack, RepeatContext:
+			s.Repeat = cmd.Repeat
+		default:
+			return ErrInvalidCommand
+		}
+
+	case "add_to_queue":
+		if cmd.TrackID == "" {
+			return ErrInvalidCommand
+		}
+		if len(s.Queue) >= maxQueueLength {
+			return ErrQueueFull
+		}
+		// Queued tracks play right after the current one
+		pos := min(s.CurrentIndex+1, len(s.Queue))
+		if s.TrackID == "" {
+			pos = len(s.Queue)
+		}
+		s.Queue = append(s.Queue[:pos], append([]string{cmd.TrackID}, s.Queue[pos:]...)...)
+		if s.Shuffle {
+			s.OriginalQueue = append(s.OriginalQueue, cmd.TrackID)
+		}
+
+	case "clear_queue":
+		s.Queue = []string{}
+		s.OriginalQueue = nil
+		s.CurrentIndex = 0
+		s.TrackID = ""
+		s.PositionMs = 0
+		s.IsPlaying = false
+
+	default:
+		return ErrInvalidCommand
+	}
+
+	return nil
+}
+
+// advance moves to the next track; auto only honours repeat-track when the track ended by itself
+func (s *PlaybackState) advance(auto bool) {
+	if auto && s.Repeat == RepeatTrack {
+		s.PositionMs = 0
+		return
+	}
+	if s.CurrentIndex+1 < len(s.Queue) {
+		s.setIndex(s.CurrentIndex + 1)
+		return
+	}
+	if s.Repeat != RepeatOff {
+		s.setIndex(0)
+		return
+	}
+	// End of queue
+	s.PositionMs = 0
+	s.IsPlaying = false
+}
+
+// shuffle randomizes the tracks after the current one and remembers the original order
+func (s *PlaybackState) shuffle() {
+	s.OriginalQueue = append([]string{}, s.Queue...)
+	if len(s.Queue) < 2 {
+		return
+	}
+	rest := s.Queue[s.CurrentIndex+1:]
+	rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+}
+
+func (s *PlaybackState) unshuffle() {
+	if s.OriginalQueue == nil {
+		return
+	}
+	s.Queue = s.OriginalQueue
+	s.OriginalQueue = nil
+	for i, id := range s.Queue {
+		if id == s.TrackID {
+			s.CurrentIndex = i
+			return
+		}
+	}
+	s.CurrentIndex = 0
+}
+
+// RegisterDevice marks a device as connected and broadcasts the new device list
+func RegisterDevice(ctx context.Context, userID string, d Device) error {
+	d.LastSeen = time.Now()
+	d.IsActive = false
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err := redisClient.HSet(ctx, devicesKey(userID), d.ID, data).Err(); err != nil {
+		return err
+	}
+	publishDevices(ctx, userID)
+	return nil
+}
+
+func TouchDevice(ctx context.Context, userID string, d Device) error {
+	d.LastSeen = time.Now()
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(ctx, devicesKey(userID), d.ID, data).Err()
+}
+
+// UnregisterDevice removes a disconnected device; playback stops if it was the active one
+func UnregisterDevice(ctx context.Context, userID, deviceID string) {
+	redisClient.HDel(ctx, devicesKey(userID), deviceID)
+
+	state, err := GetState(ctx, userID)
+	if err == nil && state.ActiveDevice == deviceID && state.IsPlaying {
+		ApplyCommand(ctx, userID, Command{Action: "pause"})
+	}
+	publishDevices(ctx, userID)
+}
+
+func isDeviceOnline(ctx context.Context, userID, deviceID string) (bool, error) {
+	data, err := redisClient.HGet(ctx, devicesKey(userID), deviceID).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var d Device
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return false, nil
+	}
+	return time.Since(d.LastSeen) < deviceTTL, nil
+}
+
+// ListDevices returns the user's connected devices, pruning ones that stopped pinging
+func ListDevices(ctx context.Context, userID string) ([]Device, error) {
+	entries, err := redisClient.HGetAll(ctx, devicesKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var active string
+	if state, err := GetState(ctx, userID); err == nil {
+		active = state.ActiveDevice
+	}
+
+	devices := make([]Device, 0, len(entries))
+	for id, data := range entries {
+		var d Device
+		if err := json.Unmarshal([]byte(data), &d); err != nil || time.Since(d.LastSeen) >= deviceTTL {
+			redisClient.HDel(ctx, devicesKey(userID), id)
+			continue
+		}
+		d.IsActive = d.ID == active
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+func publishDevices(ctx context.Context, userID string) {
+	devices, err := ListDevices(ctx, userID)
+	if err != nil {
+		return
+	}
+	publishEvent(ctx, eventsChannel(userID), "devices", devices)
+}
+
+// Event is what every connected device receives when playback changes
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// publishEvent goes through Redis pub/sub so devices connected to other instances get it too
+func publishEvent(ctx context.Context, channel, eventType string, data interface{}) {
+	payload, err := json.Marshal(Event{Type: eventType, Data: data})
+	if err != nil {
+		return
+	}
+	redisClient.Publish(ctx, channel, payload)
+}
+
+// REST handlers
+
+func commandStatus(err error) int {
+	switch err {
+	case ErrInvalidCommand, ErrEmptyQueue, ErrQueueFull:
+		return 400
+	case ErrUnknownDevice:
+		return 404
+	case ErrConflict:
+		return 409
+	}
+	return 500
+}
+
+func runCommand(c *gin.Context, cmd Command) {
+	userID := c.GetString("user_id")
+
+	state, err := ApplyCommand(c.Request.Context(), userID, cmd)
+	if err != nil {
+		status := commandStatus(err)
+		if status == 500 {
+			c.JSON(500, gin.H{"error": "Redis error"})
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, state)
+}
+
+func bindCommand(c *gin.Context, action string) {
+	var cmd Command
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&cmd); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid data"})
+			return
+		}
+	}
+	cmd.Action = action
+	runCommand(c, cmd)
+}
+
+func GetPlayback(c *gin.Context) {
+	state, err := GetState(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+	c.JSON(200, state)
+}
+
+func Play(c *gin.Context)     { bindCommand(c, "play") }
+func Pause(c *gin.Context)    { bindCommand(c, "pause") }
+func Seek(c *gin.Context)     { bindCommand(c, "seek") }
+func Next(c *gin.Context)     { bindCommand(c, "next") }
+func Previous(c *gin.Context) { bindCommand(c, "previous") }
+func Transfer(c *gin.Context) { bindCommand(c, "transfer") }
+func Shuffle(c *gin.Context)  { bindCommand(c, "shuffle") }
+func Repeat(c *gin.Context)   { bindCommand(c, "repeat") }
+
+func AddToQueue(c *gin.Context) { bindCommand(c, "add_to_queue") }
+func ClearQueue(c *gin.Context) { bindCommand(c, "clear_queue") }
+
+func GetQueue(c *gin.Context) {
+	state, err := GetState(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	upcoming := []string{}
+	if state.TrackID != "" && state.CurrentIndex+1 < len(state.Queue) {
+		upcoming = state.Queue[state.CurrentIndex+1:]
+	}
+	c.JSON(200, gin.H{
+		"currently_playing": state.TrackID,
+		"queue":             upcoming,
+	})
+}
+
+func GetDevices(c *gin.Context) {
+	devices, err := ListDevices(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+	c.JSON(200, devices)
+}
+
+// User ids are hex ObjectIDs; anything else could address another key in Redis
+var userIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+func validUserID(userID string) bool {
+	return userIDPattern.MatchString(userID)
+}
+
+// ExportUserData returns the user's playback state, queue and devices for their
+// data export. Called by the users-service export worker.
+func ExportUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	if !validUserID(userID) {
+		c.JSON(400, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	state, err := GetState(ctx, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+	devices, err := ListDevices(ctx, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"playback": state, "devices": devices})
+}
+
+// DeleteUserData clears the playback state and devices of a deleted account and
+// ends the party it hosts. Called by the users-service deletion worker; safe to repeat.
+func DeleteUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	if !validUserID(userID) {
+		c.JSON(400, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if partyID, err := redisClient.Get(ctx, partyHostKey(userID)).Result(); err == nil {
+		if party, err := loadParty(ctx, partyID); err == nil {
+			endParty(ctx, party)
+		}
+	} else if err != redis.Nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	if err := redisClient.Del(ctx, stateKey(userID), devicesKey(userID), partyHostKey(userID)).Err(); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete user data"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "User data deleted"})
+}
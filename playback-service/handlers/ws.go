@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/playback-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = 30 * time.Second
+	maxMessageSize = 64 * 1024
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Auth is done with the JWT, the gateway already handles CORS
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type client struct {
+	hub    *hub
+	room   string
+	userID string
+	device Device
+	conn   *websocket.Conn
+	send   chan []byte
+}
+
+// hub fans out Redis pub/sub events to the WebSocket connections on this instance.
+// Rooms are user IDs for playback and party IDs for listening parties.
+type hub struct {
+	mu      sync.RWMutex
+	clients map[string]map[*client]bool
+}
+
+var (
+	playbackHub = &hub{clients: map[string]map[*client]bool{}}
+	partyHub    = &hub{clients: map[string]map[*client]bool{}}
+)
+
+func (h *hub) add(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c.room] == nil {
+		h.clients[c.room] = map[*client]bool{}
+	}
+	h.clients[c.room][c] = true
+}
+
+func (h *hub) remove(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if set, ok := h.clients[c.room]; ok {
+		if set[c] {
+			delete(set, c)
+			close(c.send)
+		}
+		if len(set) == 0 {
+			delete(h.clients, c.room)
+		}
+	}
+}
+
+func (h *hub) broadcast(room string, payload []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients[room] {
+		select {
+		case c.send <- payload:
+		default:
+			// Slow client, drop the event; it resyncs from the next state push
+		}
+	}
+}
+
+// StartEventRelay subscribes to playback and party events from all instances and delivers them locally
+func StartEventRelay(ctx context.Context) {
+	pubsub := redisClient.PSubscribe(ctx, "playback-events:*", "party-events:*")
+
+	go func() {
+		defer pubsub.Close()
+		for msg := range pubsub.Channel() {
+			if room, ok := strings.CutPrefix(msg.Channel, "playback-events:"); ok {
+				playbackHub.broadcast(room, []byte(msg.Payload))
+			} else if room, ok := strings.CutPrefix(msg.Channel, "party-events:"); ok {
+				partyHub.broadcast(room, []byte(msg.Payload))
+			}
+		}
+	}()
+}
+
+// authenticateSocket reads the JWT from the auth header or, for browsers, the ?token= query
+func authenticateSocket(c *gin.Context) (*utils.Claims, bool) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		token = c.Query("token")
+	}
+	claims, err := utils.ValidateJWT(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return nil, false
+	}
+	// Sockets are for our own clients only, not third-party apps
+	if claims.ClientID != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Third-party access tokens can't be used here"})
+		return nil, false
+	}
+	return claims, true
+}
+
+// PlaybackSocket upgrades to a WebSocket for one device. Browsers can't set headers on
+// WebSocket requests, so the token may also be passed as ?token=
+func PlaybackSocket(c *gin.Context) {
+	claims, ok := authenticateSocket(c)
+	if !ok {
+		return
+	}
+
+	device := Device{
+		ID:   c.Query("device_id"),
+		Name: c.DefaultQuery("name", "Unknown device"),
+		Type: c.DefaultQuery("type", "computer"),
+	}
+	if device.ID == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		device.ID = hex.EncodeToString(b)
+	}
+	if len(device.ID) > 64 || len(device.Name) > 100 || len(device.Type) > 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+
+	cl := &client{
+		hub:    playbackHub,
+		room:   claims.UserID,
+		userID: claims.UserID,
+		device: device,
+		conn:   conn,
+		send:   make(chan []byte, 32),
+	}
+	cl.hub.add(cl)
+
+	ctx := context.Background()
+	if err := RegisterDevice(ctx, cl.userID, device); err != nil {
+		log.Printf("Failed to register playback device: %v", err)
+	}
+
+	// Initial sync so the device starts from the current state
+	if state, err := GetState(ctx, cl.userID); err == nil {
+		cl.reply(Event{Type: "state", Data: state})
+	}
+	cl.reply(Event{Type: "device", Data: device})
+
+	go cl.writePump()
+	cl.readPump(func() {
+		TouchDevice(ctx, cl.userID, cl.device)
+	}, cl.handlePlaybackMessage)
+
+	UnregisterDevice(ctx, cl.userID, cl.device.ID)
+}
+
+// readPump reads messages until the connection drops; onPong runs on every heartbeat
+func (cl *client) readPump(onPong func(), handle func([]byte)) {
+	defer func() {
+		cl.hub.remove(cl)
+		cl.conn.Close()
+	}()
+
+	cl.conn.SetReadLimit(maxMessageSize)
+	cl.conn.SetReadDeadline(time.Now().Add(pongWait))
+	cl.conn.SetPongHandler(func(string) error {
+		cl.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if onPong != nil {
+			onPong()
+		}
+		return nil
+	})
+
+	for {
+		_, data, err := cl.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		handle(data)
+	}
+}
+
+func (cl *client) handlePlaybackMessage(data []byte) {
+	ctx := context.Background()
+
+	var cmd Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		cl.reply(Event{Type: "error", Data: "Invalid message"})
+		return
+	}
+
+	// Play on a device with nothing active makes that device the active one
+	if cmd.Action == "play" {
+		if state, err := GetState(ctx, cl.userID); err == nil && state.ActiveDevice == "" {
+			ApplyCommand(ctx, cl.userID, Command{Action: "transfer", DeviceID: cl.device.ID})
+		}
+	}
+
+	if _, err := ApplyCommand(ctx, cl.userID, cmd); err != nil {
+		msg := err.Error()
+		if commandStatus(err) == 500 {
+			msg = "Playback command failed"
+		}
+		cl.reply(Event{Type: "error", Data: msg})
+	}
+}
+
+func (cl *client) reply(e Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	cl.hub.mu.RLock()
+	defer cl.hub.mu.RUnlock()
+	if cl.hub.clients[cl.room][cl] {
+		select {
+		case cl.send <- payload:
+		default:
+		}
+	}
+}
+
+func (cl *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		cl.conn.Close()
+	}()
+
+	for {
+		select {
+		case payload, ok := <-cl.send:
+			cl.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				cl.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := cl.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			cl.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
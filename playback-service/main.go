@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"example.com/playback-service/handlers"
+	"example.com/playback-service/middleware"
+	"example.com/playback-service/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+var redisClient *redis.Client
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8007"
+	}
+
+	// Inicijalizuj distributed tracing
+	serviceName := os.Getenv("SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "playback-service"
+	}
+
+	tp, err := tracing.InitTracer(serviceName)
+	if err != nil {
+		log.Printf("Warning: Failed to initialize tracing: %v", err)
+	} else {
+		defer func() {
+			if err := tp.Shutdown(context.Background()); err != nil {
+				log.Printf("Error shutting down tracer: %v", err)
+			}
+		}()
+		log.Println("Distributed tracing initialized")
+	}
+
+	redisURI := os.Getenv("REDIS_URI")
+	if redisURI == "" {
+		redisURI = "redis://localhost:6379"
+	}
+
+	opt, err := redis.ParseURL(redisURI)
+	if err != nil {
+		log.Fatal("Failed to parse Redis URI:", err)
+	}
+
+	redisClient = redis.NewClient(opt)
+
+	ctx := context.Background()
+	if _, err := redisClient.Ping(ctx).Result(); err != nil {
+		log.Fatal("Failed to connect to Redis:", err)
+	}
+
+	log.Println("Connected to Redis")
+
+	router := gin.Default()
+	router.Use(corsMiddleware())
+	// Dodaj tracing middleware
+	router.Use(tracing.TracingMiddleware(serviceName))
+
+	handlers.InitHandlers(redisClient)
+	// Relay playback events published by any instance to this instance's WebSocket clients
+	handlers.StartEventRelay(ctx)
+	setupRoutes(router)
+
+	// TLS Configuration
+	tlsEnabled := os.Getenv("TLS_ENABLED")
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+
+	if certFile == "" {
+		certFile = "certs/cert.pem"
+	}
+	if keyFile == "" {
+		keyFile = "certs/key.pem"
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	go func() {
+		if tlsEnabled == "true" {
+			log.Printf("Playback service starting on HTTPS port %s", port)
+			if err := srv.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start HTTPS server: %v", err)
+			}
+		} else {
+			log.Printf("Playback service starting on port %s", port)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start server: %v", err)
+			}
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	log.Println("Shutting down...")
+
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctxShutdown)
+	redisClient.Close()
+}
+
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
+
+func setupRoutes(router *gin.Engine) {
+	api := router.Group("/api/v1")
+	{
+		api.GET("/playback", middleware.ScopedAuthMiddleware("playback:read"), handlers.GetPlayback)
+		api.PUT("/playback/play", middleware.ScopedAuthMiddleware("playback:control"), handlers.Play)
+		api.PUT("/playback/pause", middleware.ScopedAuthMiddleware("playback:control"), handlers.Pause)
+		api.PUT("/playback/seek", middleware.ScopedAuthMiddleware("playback:control"), handlers.Seek)
+		api.POST("/playback/next", middleware.ScopedAuthMiddleware("playback:control"), handlers.Next)
+		api.POST("/playback/previous", middleware.ScopedAuthMiddleware("playback:control"), handlers.Previous)
+		api.PUT("/playback/shuffle", middleware.ScopedAuthMiddleware("playback:control"), handlers.Shuffle)
+		api.PUT("/playback/repeat", middleware.ScopedAuthMiddleware("playback:control"), handlers.Repeat)
+		api.PUT("/playback/transfer", middleware.ScopedAuthMiddleware("playback:control"), handlers.Transfer)
+		api.GET("/playback/queue", middleware.ScopedAuthMiddleware("playback:read"), handlers.GetQueue)
+		api.POST("/playback/queue", middleware.ScopedAuthMiddleware("playback:control"), handlers.AddToQueue)
+		api.DELETE("/playback/queue", middleware.ScopedAuthMiddleware("playback:control"), handlers.ClearQueue)
+		api.GET("/playback/devices", middleware.ScopedAuthMiddleware("playback:read"), handlers.GetDevices)
+
+		// WebSocket - authenticates itself since browsers can't send the auth header
+		api.GET("/playback/ws", handlers.PlaybackSocket)
+
+		// Listening parties
+		api.POST("/parties", middleware.AuthMiddleware(), handlers.CreateParty)
+		api.POST("/parties/join", middleware.AuthMiddleware(), handlers.JoinParty)
+		api.GET("/parties/:id", middleware.AuthMiddleware(), handlers.GetParty)
+		api.DELETE("/parties/:id", middleware.AuthMiddleware(), handlers.EndParty)
+		api.POST("/parties/:id/leave", middleware.AuthMiddleware(), handlers.LeaveParty)
+		api.POST("/parties/:id/command", middleware.AuthMiddleware(), handlers.PartyCommand)
+		api.GET("/parties/:id/chat", middleware.AuthMiddleware(), handlers.GetPartyChat)
+		api.GET("/parties/:id/ws", handlers.PartySocket)
+
+		// Internal routes - data export and account deletion from users-service (not exposed through the gateway)
+		api.GET("/users/:userId/data", middleware.ServiceAuth(), handlers.ExportUserData)
+		api.DELETE("/users/:userId/data", middleware.ServiceAuth(), handlers.DeleteUserData)
+	}
+
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"example.com/playback-service/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// AuthMiddleware accepts first-party access tokens only
+func AuthMiddleware() gin.HandlerFunc {
+	return authenticate("")
+}
+
+// ScopedAuthMiddleware is for the routes the gateway opens to third-party apps:
+// their access tokens are accepted too when granted scope
+func ScopedAuthMiddleware(scope string) gin.HandlerFunc {
+	return authenticate(scope)
+}
+
+func authenticate(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.GetHeader("Authorization")
+		if h == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing auth header"})
+			c.Abort()
+			return
+		}
+
+		parts := strings.Split(h, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+			c.Abort()
+			return
+		}
+
+		claims, err := utils.ValidateJWT(parts[1])
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		// Third-party tokens only work on the routes opened to OAuth apps, within their scope
+		if claims.ClientID != "" && (scope == "" || claims.UserID == "" || !claims.HasScope(scope)) {
+			if scope != "" {
+				c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "Third-party access tokens can't be used here"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", claims.UserID)
+		c.Set("username", claims.Username)
+		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
+		c.Next()
+	}
+}
+
+// RequirePermission allows the request only if the token grants perm
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perms := c.GetStringSlice("permissions")
+		for _, p := range perms {
+			if p == perm {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission required: " + perm})
+		c.Abort()
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceAuth guards the internal routes: the calling service has to send the shared
+// INTERNAL_SERVICE_TOKEN in X-Service-Token. Without a configured token every call is
+// refused.
+func ServiceAuth() gin.HandlerFunc {
+	token := os.Getenv("INTERNAL_SERVICE_TOKEN")
+	return func(c *gin.Context) {
+		got := c.GetHeader("X-Service-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Service credential required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
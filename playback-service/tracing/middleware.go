@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TracingMiddleware(serviceName string) gin.HandlerFunc {
+	tracer := otel.Tracer(serviceName)
+
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		spanName := fmt.Sprintf("%s %s", c.Request.Method, c.FullPath())
+		if c.FullPath() == "" {
+			spanName = fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path)
+		}
+
+		ctx, span := tracer.Start(ctx, spanName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(c.Request.Method),
+				semconv.HTTPRouteKey.String(c.FullPath()),
+				attribute.String("http.client_ip", c.ClientIP()),
+			),
+		)
+		defer span.End()
+
+		c.Header("X-Trace-ID", span.SpanContext().TraceID().String())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		statusCode := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(statusCode))
+		if statusCode >= 400 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", statusCode))
+		}
+	}
+}
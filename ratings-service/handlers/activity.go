@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var usersServiceURL = getEnv("USERS_SERVICE_URL", "http://users-service:8001")
+
+// publishActivity hands a rating to users-service, which puts it in the feeds of the
+// user's followers if they share their ratings
+func publishActivity(ctx context.Context, rating Rating) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"user_id":    rating.UserID,
+		"type":       "rating",
+		"target_id":  rating.SongID,
+		"rating":     rating.Rating,
+		"created_at": rating.Created,
+	})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", usersServiceURL+"/api/v1/activity", bytes.NewBuffer(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Service-Token", internalServiceToken)
+
+	// Propagiraj trace kontekst
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
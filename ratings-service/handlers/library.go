@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const maxLibraryIDs = 50
+
+var (
+	recommendationServiceURL = getEnv("RECOMMENDATION_SERVICE_URL", "http://recommendation-service:8006")
+	// Other services only accept internal calls from callers holding the shared service token
+	internalServiceToken = getEnv("INTERNAL_SERVICE_TOKEN", "")
+)
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Library item types and the recommendation signal each one maps to
+var libraryTypes = map[string]string{
+	"songs":   "song",
+	"albums":  "album",
+	"artists": "artist",
+}
+
+type LibraryItem struct {
+	ID      string    `json:"id"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Library entries are kept in a sorted set per user and type, scored by
+// the time they were added (unix millis)
+func libraryKey(userID, itemType string) string {
+	return "library:" + userID + ":" + itemType
+}
+
+// parseLibraryRequest validates the :type param and the comma-separated ids query
+func parseLibraryRequest(c *gin.Context) (string, []string, bool) {
+	itemType := c.Param("type")
+	if _, ok := libraryTypes[itemType]; !ok {
+		c.JSON(400, gin.H{"error": "Type must be one of songs, albums, artists"})
+		return "", nil, false
+	}
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		if len(id) > 64 {
+			c.JSON(400, gin.H{"error": "Invalid id"})
+			return "", nil, false
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		c.JSON(400, gin.H{"error": "ids query parameter required"})
+		return "", nil, false
+	}
+	if len(ids) > maxLibraryIDs {
+		c.JSON(400, gin.H{"error": "Too many ids (max " + strconv.Itoa(maxLibraryIDs) + ")"})
+		return "", nil, false
+	}
+
+	return itemType, ids, true
+}
+
+// SaveToLibrary adds songs, albums or artists to the user's library
+func SaveToLibrary(c *gin.Context) {
+	userID := c.GetString("user_id")
+	itemType, ids, ok := parseLibraryRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	now := float64(time.Now().UnixMilli())
+
+	members := make([]redis.Z, len(ids))
+	for i, id := range ids {
+		members[i] = redis.Z{Score: now, Member: id}
+	}
+
+	// NX keeps the original added-at time for items already in the library
+	added, err := redisClient.ZAddNX(ctx, libraryKey(userID, itemType), members...).Result()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	go sendLibrarySignals(context.WithoutCancel(ctx), userID, libraryTypes[itemType], ids, "save")
+
+	c.JSON(200, gin.H{"message": "Saved to library", "added": added})
+}
+
+// RemoveFromLibrary removes songs, albums or artists from the user's library
+func RemoveFromLibrary(c *gin.Context) {
+	userID := c.GetString("user_id")
+	itemType, ids, ok := parseLibraryRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+
+	removed, err := redisClient.ZRem(ctx, libraryKey(userID, itemType), members...).Result()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	go sendLibrarySignals(context.WithoutCancel(ctx), userID, libraryTypes[itemType], ids, "remove")
+
+	c.JSON(200, gin.H{"message": "Removed from library", "removed": removed})
+}
+
+// LibraryContains reports for each id whether it is in the user's library
+func LibraryContains(c *gin.Context) {
+	userID := c.GetString("user_id")
+	itemType, ids, ok := parseLibraryRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	scores, err := redisClient.ZMScore(ctx, libraryKey(userID, itemType), ids...).Result()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	// ZMSCORE returns 0 for missing members, added-at scores are always positive
+	contains := make([]bool, len(ids))
+	for i, score := range scores {
+		contains[i] = score > 0
+	}
+
+	c.JSON(200, contains)
+}
+
+// GetLibrary lists library items of one type, most recently added first
+func GetLibrary(c *gin.Context) {
+	userID := c.GetString("user_id")
+	itemType := c.Param("type")
+	if _, ok := libraryTypes[itemType]; !ok {
+		c.JSON(400, gin.H{"error": "Type must be one of songs, albums, artists"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 50 {
+		c.JSON(400, gin.H{"error": "limit must be between 1 and 50"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(400, gin.H{"error": "offset must be 0 or greater"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := libraryKey(userID, itemType)
+
+	total, err := redisClient.ZCard(ctx, key).Result()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	entries, err := redisClient.ZRevRangeWithScores(ctx, key, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	items := make([]LibraryItem, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, LibraryItem{
+			ID:      e.Member.(string),
+			AddedAt: time.UnixMilli(int64(e.Score)),
+		})
+	}
+
+	c.JSON(200, gin.H{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// sendLibrarySignals feeds library changes to the recommendation graph
+func sendLibrarySignals(ctx context.Context, userID, targetType string, ids []string, action string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"user_id":    userID,
+		"type":       targetType,
+		"target_ids": ids,
+		"action":     action,
+	})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", recommendationServiceURL+"/api/v1/recommendations/signals", bytes.NewBuffer(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Service-Token", internalServiceToken)
+
+	// Propagiraj trace kontekst
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
@@ -3,6 +3,9 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +31,14 @@ type CreateRatingRequest struct {
 	Rating int    `json:"rating" binding:"required,min=1,max=5"`
 }
 
+// User ids are Mongo ObjectIDs; anything else must not reach a SCAN pattern, where
+// *, ? and [ would match other users' keys
+var userIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+func validUserID(userID string) bool {
+	return userIDPattern.MatchString(userID)
+}
+
 func scanKeys(ctx context.Context, pattern string) ([]string, error) {
 	var cursor uint64
 	var keys []string
@@ -76,6 +87,8 @@ func CreateRating(c *gin.Context) {
 		return
 	}
 
+	go publishActivity(context.WithoutCancel(ctx), rating)
+
 	c.JSON(201, rating)
 }
 
@@ -203,3 +216,122 @@ func DeleteAllSongRatings(c *gin.Context) {
 
 	c.JSON(200, gin.H{"message": "All ratings for song deleted", "deleted_count": result})
 }
+
+// GetUserRecentRatings returns the songs a user rated most recently, for their public
+// profile. Called by users-service, which decides who may see them.
+func GetUserRecentRatings(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("userId"))
+	if !validUserID(userID) {
+		c.JSON(400, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	ctx := c.Request.Context()
+	keys, err := scanKeys(ctx, "rating:*:"+userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	ratings := []Rating{}
+	for _, key := range keys {
+		val, err := redisClient.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		var r Rating
+		if err := json.Unmarshal([]byte(val), &r); err == nil {
+			ratings = append(ratings, r)
+		}
+	}
+
+	sort.Slice(ratings, func(i, j int) bool { return ratings[i].Created.After(ratings[j].Created) })
+	if len(ratings) > limit {
+		ratings = ratings[:limit]
+	}
+
+	c.JSON(200, gin.H{"ratings": ratings})
+}
+
+// ExportUserData returns a user's ratings and library for their data export.
+// Called by the users-service export worker.
+func ExportUserData(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("userId"))
+	if !validUserID(userID) {
+		c.JSON(400, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	keys, err := scanKeys(ctx, "rating:*:"+userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+
+	ratings := []Rating{}
+	for _, key := range keys {
+		val, err := redisClient.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		var r Rating
+		if err := json.Unmarshal([]byte(val), &r); err == nil {
+			ratings = append(ratings, r)
+		}
+	}
+
+	library := map[string][]LibraryItem{}
+	for itemType := range libraryTypes {
+		entries, err := redisClient.ZRangeWithScores(ctx, libraryKey(userID, itemType), 0, -1).Result()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Redis error"})
+			return
+		}
+		items := []LibraryItem{}
+		for _, e := range entries {
+			items = append(items, LibraryItem{
+				ID:      e.Member.(string),
+				AddedAt: time.UnixMilli(int64(e.Score)),
+			})
+		}
+		library[itemType] = items
+	}
+
+	c.JSON(200, gin.H{"ratings": ratings, "library": library})
+}
+
+// DeleteUserData removes every rating and library entry of a deleted account.
+// Called by the users-service deletion worker; safe to repeat.
+func DeleteUserData(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("userId"))
+	if !validUserID(userID) {
+		c.JSON(400, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	keys, err := scanKeys(ctx, "rating:*:"+userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Redis error"})
+		return
+	}
+	for itemType := range libraryTypes {
+		keys = append(keys, libraryKey(userID, itemType))
+	}
+
+	result, err := redisClient.Del(ctx, keys...).Result()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete user data"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "User data deleted", "deleted_count": result})
+}
@@ -132,13 +132,26 @@ func corsMiddleware() gin.HandlerFunc {
 func setupRoutes(router *gin.Engine) {
 	api := router.Group("/api/v1")
 	{
-		api.POST("/ratings", middleware.AuthMiddleware(), handlers.CreateRating)
-		api.GET("/ratings", middleware.AuthMiddleware(), handlers.GetRatings)
+		api.POST("/ratings", middleware.ScopedAuthMiddleware("ratings:write"), handlers.CreateRating)
+		api.GET("/ratings", middleware.ScopedAuthMiddleware("ratings:read"), handlers.GetRatings)
 		api.GET("/ratings/:songId", handlers.GetSongRatings)
-		api.DELETE("/ratings/:songId", middleware.AuthMiddleware(), handlers.DeleteRating)
-
-		// Admin route - delete all ratings for a song (used when song is deleted)
-		api.DELETE("/ratings/:songId/all", middleware.AuthMiddleware(), middleware.AdminMiddleware(), handlers.DeleteAllSongRatings)
+		api.DELETE("/ratings/:songId", middleware.ScopedAuthMiddleware("ratings:write"), handlers.DeleteRating)
+
+		// Catalog route - delete all ratings for a song (used when song is deleted)
+		api.DELETE("/ratings/:songId/all", middleware.AuthMiddleware(), middleware.RequirePermission("catalog:delete"), handlers.DeleteAllSongRatings)
+		// Internal route - the same cleanup for DDEX takedowns from content-service (not exposed through the gateway)
+		api.DELETE("/songs/:songId/ratings", middleware.ServiceAuth(), handlers.DeleteAllSongRatings)
+
+		// Library - liked songs, saved albums and artists
+		api.GET("/library/:type", middleware.ScopedAuthMiddleware("library:read"), handlers.GetLibrary)
+		api.PUT("/library/:type", middleware.ScopedAuthMiddleware("library:write"), handlers.SaveToLibrary)
+		api.DELETE("/library/:type", middleware.ScopedAuthMiddleware("library:write"), handlers.RemoveFromLibrary)
+		api.GET("/library/:type/contains", middleware.ScopedAuthMiddleware("library:read"), handlers.LibraryContains)
+
+		// Internal routes - data export, account deletion and public profiles from users-service (not exposed through the gateway)
+		api.GET("/users/:userId/data", middleware.ServiceAuth(), handlers.ExportUserData)
+		api.GET("/users/:userId/ratings", middleware.ServiceAuth(), handlers.GetUserRecentRatings)
+		api.DELETE("/users/:userId/data", middleware.ServiceAuth(), handlers.DeleteUserData)
 	}
 
 	router.GET("/health", func(c *gin.Context) {
@@ -25,13 +25,13 @@ func GetRecommendations(c *gin.Context) {
 	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
 	defer session.Close(ctx)
 
-	// ✅ Ne preporučuj pesme koje je user već ocenio
+	// ✅ Ne preporučuj pesme koje je user već ocenio ili lajkovao
 	// ✅ Koristi ctx iz request-a
 	result, err := session.Run(ctx,
-		`MATCH (u:User {id: $userID})-[:RATED]->(:Song)-[:HAS_GENRE]->(g:Genre)
-		 MATCH (similar:User)-[:RATED]->(similarSong:Song)-[:HAS_GENRE]->(g)
+		`MATCH (u:User {id: $userID})-[:RATED|LIKED]->(:Song)-[:HAS_GENRE]->(g:Genre)
+		 MATCH (similar:User)-[:RATED|LIKED]->(similarSong:Song)-[:HAS_GENRE]->(g)
 		 WHERE similar.id <> $userID
-		   AND NOT (u)-[:RATED]->(similarSong)
+		   AND NOT (u)-[:RATED|LIKED]->(similarSong)
 		 RETURN similarSong.id as songId, COUNT(*) as score
 		 ORDER BY score DESC
 		 LIMIT 10`,
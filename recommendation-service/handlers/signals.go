@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// LibrarySignal is sent by ratings-service whenever a user's library changes
+type LibrarySignal struct {
+	UserID    string   `json:"user_id" binding:"required"`
+	Type      string   `json:"type" binding:"required,oneof=song album artist"`
+	TargetIDs []string `json:"target_ids" binding:"required,min=1,max=50"`
+	Action    string   `json:"action" binding:"required,oneof=save remove"`
+}
+
+// Library saves are positive signals, each type gets its own relationship
+var signalQueries = map[string]map[string]string{
+	"song": {
+		"save": `MERGE (u:User {id: $userID})
+		         WITH u UNWIND $ids AS id
+		         MERGE (s:Song {id: id})
+		         MERGE (u)-[r:LIKED]->(s)
+		         ON CREATE SET r.created_at = datetime()`,
+		"remove": `MATCH (:User {id: $userID})-[r:LIKED]->(s:Song)
+		           WHERE s.id IN $ids
+		           DELETE r`,
+	},
+	"album": {
+		"save": `MERGE (u:User {id: $userID})
+		         WITH u UNWIND $ids AS id
+		         MERGE (a:Album {id: id})
+		         MERGE (u)-[r:SAVED]->(a)
+		         ON CREATE SET r.created_at = datetime()`,
+		"remove": `MATCH (:User {id: $userID})-[r:SAVED]->(a:Album)
+		           WHERE a.id IN $ids
+		           DELETE r`,
+	},
+	"artist": {
+		"save": `MERGE (u:User {id: $userID})
+		         WITH u UNWIND $ids AS id
+		         MERGE (a:Artist {id: id})
+		         MERGE (u)-[r:FOLLOWS]->(a)
+		         ON CREATE SET r.created_at = datetime()`,
+		"remove": `MATCH (:User {id: $userID})-[r:FOLLOWS]->(a:Artist)
+		           WHERE a.id IN $ids
+		           DELETE r`,
+	},
+}
+
+// RecordSignal applies a library change to the recommendation graph (internal, service-to-service)
+func RecordSignal(c *gin.Context) {
+	var req LibrarySignal
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	result, err := session.Run(ctx, signalQueries[req.Type][req.Action],
+		map[string]any{"userID": req.UserID, "ids": req.TargetIDs},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record signal"})
+		return
+	}
+	if _, err := result.Consume(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record signal"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Signal recorded"})
+}
+
+// User ids are hex ObjectIDs as issued by users-service
+var userIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+func validUserID(userID string) bool {
+	return userIDPattern.MatchString(userID)
+}
+
+// ExportUserData lists the user's relationships in the graph for their data export
+// (internal, service-to-service)
+func ExportUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	result, err := session.Run(ctx,
+		`MATCH (:User {id: $userID})-[r]->(t)
+		 RETURN type(r) AS type, labels(t)[0] AS target_type, t.id AS target_id, properties(r) AS properties`,
+		map[string]any{"userID": userID},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export user data"})
+		return
+	}
+
+	relationships := []map[string]any{}
+	for result.Next(ctx) {
+		record := result.Record()
+		relType, _ := record.Get("type")
+		targetType, _ := record.Get("target_type")
+		targetID, _ := record.Get("target_id")
+		properties, _ := record.Get("properties")
+
+		relationships = append(relationships, map[string]any{
+			"type":        relType,
+			"target_type": targetType,
+			"target_id":   targetID,
+			"properties":  properties,
+		})
+	}
+
+	if err := result.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export user data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"relationships": relationships})
+}
+
+// DeleteUserData removes a deleted account's node and all of its relationships
+// from the graph (internal, service-to-service; safe to repeat)
+func DeleteUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userId"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	result, err := session.Run(ctx,
+		`MATCH (u:User {id: $userID}) DETACH DELETE u`,
+		map[string]any{"userID": userID},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user data"})
+		return
+	}
+	if _, err := result.Consume(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User data deleted"})
+}
@@ -155,10 +155,19 @@ func corsMiddleware() gin.HandlerFunc {
 func setupRoutes(router *gin.Engine) {
 	api := router.Group("/api/v1")
 	{
-		api.GET("/recommendations", middleware.AuthMiddleware(), handlers.GetRecommendations)
+		api.GET("/recommendations", middleware.ScopedAuthMiddleware("recommendations:read"), handlers.GetRecommendations)
 
-		// Admin route - delete song from recommendation graph (used when song is deleted)
-		api.DELETE("/recommendations/songs/:songId", middleware.AuthMiddleware(), middleware.AdminMiddleware(), handlers.DeleteSong)
+		// Catalog route - delete song from recommendation graph (used when song is deleted)
+		api.DELETE("/recommendations/songs/:songId", middleware.AuthMiddleware(), middleware.RequirePermission("catalog:delete"), handlers.DeleteSong)
+		// Internal route - the same cleanup for DDEX takedowns from content-service (not exposed through the gateway)
+		api.DELETE("/songs/:songId", middleware.ServiceAuth(), handlers.DeleteSong)
+
+		// Internal route - library changes from ratings-service (not exposed through the gateway)
+		api.POST("/recommendations/signals", middleware.ServiceAuth(), handlers.RecordSignal)
+
+		// Internal routes - data export and account deletion from users-service (not exposed through the gateway)
+		api.GET("/users/:userId/data", middleware.ServiceAuth(), handlers.ExportUserData)
+		api.DELETE("/users/:userId/data", middleware.ServiceAuth(), handlers.DeleteUserData)
 	}
 
 	router.GET("/health", func(c *gin.Context) {
@@ -8,7 +8,18 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// AuthMiddleware accepts first-party access tokens only
 func AuthMiddleware() gin.HandlerFunc {
+	return authenticate("")
+}
+
+// ScopedAuthMiddleware is for the routes the gateway opens to third-party apps:
+// their access tokens are accepted too when granted scope
+func ScopedAuthMiddleware(scope string) gin.HandlerFunc {
+	return authenticate(scope)
+}
+
+func authenticate(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -31,21 +42,35 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Third-party tokens only work on the routes opened to OAuth apps, within their scope
+		if claims.ClientID != "" && (scope == "" || claims.UserID == "" || !claims.HasScope(scope)) {
+			if scope != "" {
+				c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "Third-party access tokens can't be used here"})
+			c.Abort()
+			return
+		}
+
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission allows the request only if the token grants perm
+func RequirePermission(perm string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role := c.GetString("role")
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
-			return
+		perms := c.GetStringSlice("permissions")
+		for _, p := range perms {
+			if p == perm {
+				c.Next()
+				return
+			}
 		}
-		c.Next()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission required: " + perm})
+		c.Abort()
 	}
 }
@@ -2,34 +2,35 @@ package utils
 
 import (
 	"errors"
-	"os"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("default-secret-key-change-in-production")
-
-func init() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret != "" {
-		jwtSecret = []byte(secret)
-	}
-}
-
 type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// Permissions granted by the user's roles, e.g. catalog:write
+	Permissions []string `json:"permissions,omitempty"`
+	// ClientID and Scope are set on tokens issued to third-party apps through OAuth
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func ValidateJWT(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key, err := lookupKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.alg {
 			return nil, errors.New("unexpected signing method")
 		}
-		return jwtSecret, nil
-	})
+		return key.key, nil
+	}, jwt.WithValidMethods([]string{"EdDSA", "RS256"}), jwt.WithIssuer("users-service"))
 	if err != nil {
 		return nil, err
 	}
@@ -39,3 +40,13 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 	}
 	return nil, errors.New("invalid token")
 }
+
+// HasScope reports whether a third-party token was granted scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -16,6 +16,7 @@ const adminUser = {
     first_name: "Admin",
     last_name: "User",
     role: "admin",
+    roles: ["admin"],
     email_verified: true,
     email_verification_token: "",
     email_verification_token_exp: new Date(0),
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	usersServiceURL = getEnv("USERS_SERVICE_URL", "http://users-service:8001")
+	// users-service only takes activity from callers holding the shared service token
+	internalServiceToken = getEnv("INTERNAL_SERVICE_TOKEN", "")
+)
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// publishActivity hands a followed artist to users-service, which puts it in the feeds
+// of the user's followers if they share that activity
+func publishActivity(ctx context.Context, subscription Subscription) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"user_id":    subscription.UserID,
+		"type":       "artist_follow",
+		"target_id":  subscription.TargetID,
+		"name":       subscription.Name,
+		"created_at": subscription.CreatedAt,
+	})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", usersServiceURL+"/api/v1/activity", bytes.NewBuffer(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Service-Token", internalServiceToken)
+
+	// Propagiraj trace kontekst
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
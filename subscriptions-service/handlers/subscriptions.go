@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,18 +23,26 @@ func InitHandlers(client *redis.Client) {
 type Subscription struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
-	Type      string    `json:"type"` // "artist" or "genre"
+	Type      string    `json:"type"` // "artist", "genre" or "podcast"
 	TargetID  string    `json:"target_id"`
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 type CreateSubscriptionRequest struct {
-	Type     string `json:"type" binding:"required,oneof=artist genre"`
+	Type     string `json:"type" binding:"required,oneof=artist genre podcast"`
 	TargetID string `json:"target_id" binding:"required"`
 	Name     string `json:"name"`
 }
 
+// User ids are Mongo ObjectIDs; anything else must not reach a SCAN pattern, where
+// *, ? and [ would match other users' keys
+var userIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+func validUserID(userID string) bool {
+	return userIDPattern.MatchString(userID)
+}
+
 func scanKeys(ctx context.Context, pattern string) ([]string, error) {
 	var cursor uint64
 	var keys []string
@@ -82,11 +93,17 @@ func CreateSubscription(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
+	existed, _ := redisClient.Exists(ctx, key).Result()
 	if err := redisClient.Set(ctx, key, data, 0).Err(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
 		return
 	}
 
+	// Following an artist again doesn't show up in feeds twice
+	if subscription.Type == "artist" && existed == 0 {
+		go publishActivity(context.WithoutCancel(ctx), subscription)
+	}
+
 	c.JSON(http.StatusCreated, subscription)
 }
 
@@ -106,6 +123,7 @@ func GetSubscriptions(c *gin.Context) {
 
 	var artists []Subscription
 	var genres []Subscription
+	var podcasts []Subscription
 
 	for _, key := range keys {
 		data, err := redisClient.Get(ctx, key).Result()
@@ -119,13 +137,16 @@ func GetSubscriptions(c *gin.Context) {
 				artists = append(artists, subscription)
 			} else if subscription.Type == "genre" {
 				genres = append(genres, subscription)
+			} else if subscription.Type == "podcast" {
+				podcasts = append(podcasts, subscription)
 			}
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"artists": artists,
-		"genres":  genres,
+		"artists":  artists,
+		"genres":   genres,
+		"podcasts": podcasts,
 	})
 }
 
@@ -159,6 +180,8 @@ func DeleteSubscription(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Subscription deleted successfully"})
 }
 
+// GetFollowersByArtist returns followers of an artist, or of another target
+// type (e.g. a podcast) when ?type= is given
 func GetFollowersByArtist(c *gin.Context) {
 	artistID := c.Param("artist_id")
 	if artistID == "" {
@@ -166,10 +189,16 @@ func GetFollowersByArtist(c *gin.Context) {
 		return
 	}
 
+	subType := c.DefaultQuery("type", "artist")
+	if subType != "artist" && subType != "genre" && subType != "podcast" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription type"})
+		return
+	}
+
 	ctx := c.Request.Context()
 
-	// Pattern: subscription:*:artist:artistID
-	pattern := "subscription:*:artist:" + artistID
+	// Pattern: subscription:*:type:targetID
+	pattern := "subscription:*:" + subType + ":" + artistID
 	keys, err := scanKeys(ctx, pattern)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch followers"})
@@ -223,3 +252,105 @@ func CheckSubscription(c *gin.Context) {
 	subscribed := exists > 0
 	c.JSON(http.StatusOK, gin.H{"subscribed": subscribed})
 }
+
+// GetUserArtists returns the artists a user follows, most recently followed first, for
+// their public profile. Called by users-service, which decides who may see them.
+func GetUserArtists(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("user_id"))
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	ctx := c.Request.Context()
+	keys, err := scanKeys(ctx, "subscription:"+userID+":artist:*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+
+	artists := []Subscription{}
+	for _, key := range keys {
+		data, err := redisClient.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+
+		var subscription Subscription
+		if err := json.Unmarshal([]byte(data), &subscription); err == nil {
+			artists = append(artists, subscription)
+		}
+	}
+
+	sort.Slice(artists, func(i, j int) bool { return artists[i].CreatedAt.After(artists[j].CreatedAt) })
+	if len(artists) > limit {
+		artists = artists[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"artists": artists})
+}
+
+// ExportUserData returns all subscriptions of a user for their data export.
+// Called by the users-service export worker.
+func ExportUserData(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("user_id"))
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	keys, err := scanKeys(ctx, "subscription:"+userID+":*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+
+	subscriptions := []Subscription{}
+	for _, key := range keys {
+		data, err := redisClient.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+
+		var subscription Subscription
+		if err := json.Unmarshal([]byte(data), &subscription); err == nil {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions})
+}
+
+// DeleteUserData removes all subscriptions of a deleted account.
+// Called by the users-service deletion worker; safe to repeat.
+func DeleteUserData(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("user_id"))
+	if !validUserID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	keys, err := scanKeys(ctx, "subscription:"+userID+":*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+
+	var deleted int64
+	if len(keys) > 0 {
+		deleted, err = redisClient.Del(ctx, keys...).Result()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subscriptions"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User data deleted", "deleted_count": deleted})
+}
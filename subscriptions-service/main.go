@@ -145,6 +145,11 @@ func setupRoutes(router *gin.Engine) {
 		api.GET("/subscriptions/:target_id", middleware.AuthMiddleware(), handlers.CheckSubscription)
 		api.DELETE("/subscriptions/:id", middleware.AuthMiddleware(), handlers.DeleteSubscription)
 		api.GET("/subscriptions/followers/:artist_id", handlers.GetFollowersByArtist) // Called by content-service
+
+		// Internal routes - data export, account deletion and public profiles from users-service (not exposed through the gateway)
+		api.GET("/users/:user_id/data", middleware.ServiceAuth(), handlers.ExportUserData)
+		api.GET("/users/:user_id/artists", middleware.ServiceAuth(), handlers.GetUserArtists)
+		api.DELETE("/users/:user_id/data", middleware.ServiceAuth(), handlers.DeleteUserData)
 	}
 
 	router.GET("/health", func(c *gin.Context) {
@@ -32,9 +32,17 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Third-party OAuth tokens aren't accepted by this service
+		if claims.ClientID != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Third-party access tokens can't be used here"})
+			c.Abort()
+			return
+		}
+
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
 		c.Next()
 	}
 }
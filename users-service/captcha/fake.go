@@ -0,0 +1,109 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Fake tokens decide their own outcome, so tests and local setups don't need a real
+// CAPTCHA service:
+//
+//	pass                  solved
+//	pass:<score>          solved with a score, e.g. pass:0.9
+//	pass:<score>:<action> solved for an action, e.g. pass:0.9:login
+//	fail                  rejected
+//	error                 the service can't be reached (Fake only)
+const (
+	FakePass  = "pass"
+	FakeFail  = "fail"
+	FakeError = "error"
+)
+
+// Fake verifies fake tokens in-process. Scores and actions in a token are checked like
+// reCAPTCHA v3 does, against a threshold of 0.5.
+type Fake struct {
+	Key string
+}
+
+func (p *Fake) Name() string { return ProviderFake }
+
+func (p *Fake) SiteKey() string { return p.Key }
+
+func (p *Fake) Verify(ctx context.Context, token, remoteIP, action string) (*Result, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+	if token == FakeError {
+		return nil, errors.New("fake captcha service unavailable")
+	}
+
+	response := fakeResponse(token)
+	result := &Result{
+		Success:    response.Success,
+		Score:      response.Score,
+		Action:     response.Action,
+		Hostname:   response.Hostname,
+		ErrorCodes: response.ErrorCodes,
+	}
+	if result.Success && strings.Contains(token, ":") && result.Score < 0.5 {
+		result.Success = false
+		result.ErrorCodes = append(result.ErrorCodes, "score-too-low")
+	}
+	checkAction(result, action)
+	return result, nil
+}
+
+func fakeResponse(token string) siteVerifyResponse {
+	parts := strings.SplitN(token, ":", 3)
+	if parts[0] != FakePass {
+		return siteVerifyResponse{ErrorCodes: []string{"invalid-input-response"}}
+	}
+
+	response := siteVerifyResponse{Success: true, Hostname: "localhost"}
+	if len(parts) > 1 {
+		score, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || score < 0 || score > 1 {
+			return siteVerifyResponse{ErrorCodes: []string{"invalid-input-response"}}
+		}
+		response.Score = score
+	}
+	if len(parts) > 2 {
+		response.Action = parts[2]
+	}
+	return response
+}
+
+// FakeServer is a siteverify endpoint answering fake tokens; point a real provider at
+// it with CAPTCHA_VERIFY_URL to exercise the HTTP path. With Secret set, requests with
+// another secret are rejected like the real services do.
+type FakeServer struct {
+	Secret string
+}
+
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	var response siteVerifyResponse
+	switch {
+	case s.Secret != "" && r.PostForm.Get("secret") != s.Secret:
+		response.ErrorCodes = []string{"invalid-input-secret"}
+	case r.PostForm.Get("response") == "":
+		response.ErrorCodes = []string{"missing-input-response"}
+	default:
+		response = fakeResponse(r.PostForm.Get("response"))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
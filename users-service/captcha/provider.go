@@ -0,0 +1,137 @@
+// Package captcha verifies CAPTCHA tokens solved in the browser with one of several
+// providers, chosen by configuration.
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Actions a token can be requested for; score-based providers may use a different
+// threshold per action
+const (
+	ActionLogin         = "login"
+	ActionRegister      = "register"
+	ActionPasswordReset = "password_reset"
+)
+
+// ErrMissingToken is returned when no token was sent
+var ErrMissingToken = errors.New("captcha token is missing")
+
+// Result is the provider's verdict on a token
+type Result struct {
+	Success bool
+	// Score-based providers only: 1.0 is very likely a human
+	Score      float64
+	Action     string
+	Hostname   string
+	ErrorCodes []string
+}
+
+// Provider checks a token with the CAPTCHA service. An error means the service could
+// not be asked; a rejected token is a Result without Success.
+type Provider interface {
+	Name() string
+	// SiteKey is the public key the frontend renders the widget with
+	SiteKey() string
+	Verify(ctx context.Context, token, remoteIP, action string) (*Result, error)
+}
+
+// Provider names for CAPTCHA_PROVIDER
+const (
+	ProviderRecaptchaV2 = "recaptcha-v2"
+	ProviderRecaptchaV3 = "recaptcha-v3"
+	ProviderHCaptcha    = "hcaptcha"
+	ProviderTurnstile   = "turnstile"
+	ProviderFake        = "fake"
+	ProviderNone        = "none"
+)
+
+// FromEnv configures the provider from CAPTCHA_PROVIDER, CAPTCHA_SECRET, CAPTCHA_SITE_KEY
+// and CAPTCHA_VERIFY_URL (to point a provider at a fake server). reCAPTCHA v3 also
+// reads CAPTCHA_MIN_SCORE and per-action thresholds from CAPTCHA_ACTION_SCORES, e.g.
+// "login=0.7,register=0.5".
+//
+// It returns nil when CAPTCHA is disabled (CAPTCHA_PROVIDER=none, the older
+// RECAPTCHA_DISABLED=true, or no provider and no secret configured).
+func FromEnv() (Provider, error) {
+	secret := os.Getenv("CAPTCHA_SECRET")
+	if secret == "" {
+		secret = os.Getenv("RECAPTCHA_SECRET_KEY")
+	}
+	siteKey := os.Getenv("CAPTCHA_SITE_KEY")
+	verifyURL := os.Getenv("CAPTCHA_VERIFY_URL")
+
+	name := os.Getenv("CAPTCHA_PROVIDER")
+	if name == "" {
+		switch {
+		case os.Getenv("RECAPTCHA_DISABLED") == "true":
+			name = ProviderNone
+		case secret != "":
+			name = ProviderRecaptchaV2
+		default:
+			log.Println("CAPTCHA not configured, CAPTCHA checks are disabled")
+			return nil, nil
+		}
+	}
+
+	switch name {
+	case ProviderNone:
+		return nil, nil
+	case ProviderFake:
+		return &Fake{Key: siteKey}, nil
+	case ProviderRecaptchaV2, ProviderRecaptchaV3, ProviderHCaptcha, ProviderTurnstile:
+	default:
+		return nil, fmt.Errorf("unknown CAPTCHA_PROVIDER %q", name)
+	}
+
+	if secret == "" {
+		return nil, fmt.Errorf("CAPTCHA_SECRET is required for the %s provider", name)
+	}
+	verifier := siteVerifier{secret: secret, siteKey: siteKey, url: verifyURL}
+
+	switch name {
+	case ProviderRecaptchaV2:
+		return &RecaptchaV2{verifier}, nil
+	case ProviderRecaptchaV3:
+		minScore := 0.5
+		if value := os.Getenv("CAPTCHA_MIN_SCORE"); value != "" {
+			score, err := strconv.ParseFloat(value, 64)
+			if err != nil || score < 0 || score > 1 {
+				return nil, fmt.Errorf("invalid CAPTCHA_MIN_SCORE %q", value)
+			}
+			minScore = score
+		}
+		actionScores, err := parseActionScores(os.Getenv("CAPTCHA_ACTION_SCORES"))
+		if err != nil {
+			return nil, err
+		}
+		return &RecaptchaV3{siteVerifier: verifier, MinScore: minScore, ActionScores: actionScores}, nil
+	case ProviderHCaptcha:
+		return &HCaptcha{verifier}, nil
+	default:
+		return &Turnstile{verifier}, nil
+	}
+}
+
+func parseActionScores(value string) (map[string]float64, error) {
+	scores := map[string]float64{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		action, rawScore, ok := strings.Cut(pair, "=")
+		score, err := strconv.ParseFloat(strings.TrimSpace(rawScore), 64)
+		if !ok || err != nil || score < 0 || score > 1 {
+			return nil, fmt.Errorf("invalid CAPTCHA_ACTION_SCORES entry %q", pair)
+		}
+		scores[strings.TrimSpace(action)] = score
+	}
+	return scores, nil
+}
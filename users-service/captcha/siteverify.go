@@ -0,0 +1,179 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Verification endpoints; all of them speak the same siteverify protocol
+const (
+	RecaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	TurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// siteVerifyResponse is the answer of a siteverify endpoint
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Hostname   string   `json:"hostname,omitempty"`
+	ErrorCodes []string `json:"error-codes,omitempty"`
+	Score      float64  `json:"score,omitempty"`
+	Action     string   `json:"action,omitempty"`
+}
+
+// siteVerifier posts tokens to a siteverify endpoint; url overrides the provider's own
+type siteVerifier struct {
+	secret  string
+	siteKey string
+	url     string
+}
+
+func (v siteVerifier) SiteKey() string { return v.siteKey }
+
+func (v siteVerifier) verify(ctx context.Context, defaultURL, token, remoteIP string, extra url.Values) (*Result, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	for key, values := range extra {
+		form[key] = values
+	}
+
+	endpoint := v.url
+	if endpoint == "" {
+		endpoint = defaultURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("captcha verification request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("captcha verification returned status %d", resp.StatusCode)
+	}
+
+	var body siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid captcha verification response: %w", err)
+	}
+	return &Result{
+		Success:    body.Success,
+		Score:      body.Score,
+		Action:     body.Action,
+		Hostname:   body.Hostname,
+		ErrorCodes: body.ErrorCodes,
+	}, nil
+}
+
+// checkAction fails a result issued for a different action than the one being performed,
+// so a token solved on one form can't be replayed on another
+func checkAction(result *Result, action string) {
+	if result.Success && action != "" && result.Action != "" && result.Action != action {
+		result.Success = false
+		result.ErrorCodes = append(result.ErrorCodes, "action-mismatch")
+	}
+}
+
+// RecaptchaV2 is the checkbox / invisible reCAPTCHA
+type RecaptchaV2 struct {
+	siteVerifier
+}
+
+func (p *RecaptchaV2) Name() string { return ProviderRecaptchaV2 }
+
+func (p *RecaptchaV2) Verify(ctx context.Context, token, remoteIP, action string) (*Result, error) {
+	return p.verify(ctx, RecaptchaVerifyURL, token, remoteIP, nil)
+}
+
+// RecaptchaV3 is the score-based reCAPTCHA. A token passes when it was issued for the
+// action and its score reaches the action's threshold, or MinScore.
+type RecaptchaV3 struct {
+	siteVerifier
+	MinScore     float64
+	ActionScores map[string]float64
+}
+
+func (p *RecaptchaV3) Name() string { return ProviderRecaptchaV3 }
+
+func (p *RecaptchaV3) Verify(ctx context.Context, token, remoteIP, action string) (*Result, error) {
+	result, err := p.verify(ctx, RecaptchaVerifyURL, token, remoteIP, nil)
+	if err != nil {
+		return nil, err
+	}
+	checkScore(result, action, p.threshold(action))
+	return result, nil
+}
+
+func (p *RecaptchaV3) threshold(action string) float64 {
+	if score, ok := p.ActionScores[action]; ok {
+		return score
+	}
+	return p.MinScore
+}
+
+// checkScore applies the checks of a score-based provider: v3 always reports the action,
+// so unlike checkAction a missing one fails too
+func checkScore(result *Result, action string, minScore float64) {
+	if !result.Success {
+		return
+	}
+	if action != "" && result.Action != action {
+		result.Success = false
+		result.ErrorCodes = append(result.ErrorCodes, "action-mismatch")
+		return
+	}
+	if result.Score < minScore {
+		result.Success = false
+		result.ErrorCodes = append(result.ErrorCodes, "score-too-low")
+	}
+}
+
+// HCaptcha verifies hCaptcha tokens; with a site key configured, tokens issued for
+// other sites are rejected
+type HCaptcha struct {
+	siteVerifier
+}
+
+func (p *HCaptcha) Name() string { return ProviderHCaptcha }
+
+func (p *HCaptcha) Verify(ctx context.Context, token, remoteIP, action string) (*Result, error) {
+	var extra url.Values
+	if p.siteKey != "" {
+		extra = url.Values{"sitekey": {p.siteKey}}
+	}
+	return p.verify(ctx, HCaptchaVerifyURL, token, remoteIP, extra)
+}
+
+// Turnstile verifies Cloudflare Turnstile tokens, including the action the widget was
+// rendered with when it set one
+type Turnstile struct {
+	siteVerifier
+}
+
+func (p *Turnstile) Name() string { return ProviderTurnstile }
+
+func (p *Turnstile) Verify(ctx context.Context, token, remoteIP, action string) (*Result, error) {
+	result, err := p.verify(ctx, TurnstileVerifyURL, token, remoteIP, nil)
+	if err != nil {
+		return nil, err
+	}
+	checkAction(result, action)
+	return result, nil
+}
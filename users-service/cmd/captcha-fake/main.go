@@ -0,0 +1,27 @@
+// Command captcha-fake serves a siteverify endpoint that answers fake CAPTCHA tokens
+// (see captcha.FakeServer), for running users-service against a real provider
+// implementation without reaching the internet:
+//
+//	go run ./cmd/captcha-fake -addr :8099 -secret test-secret
+//	CAPTCHA_PROVIDER=recaptcha-v3 CAPTCHA_SECRET=test-secret \
+//	CAPTCHA_VERIFY_URL=http://localhost:8099/siteverify go run .
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"example.com/users-service/captcha"
+)
+
+func main() {
+	addr := flag.String("addr", ":8099", "address to listen on")
+	secret := flag.String("secret", "", "secret the service must send (any when empty)")
+	flag.Parse()
+
+	http.Handle("/siteverify", &captcha.FakeServer{Secret: *secret})
+
+	log.Printf("Fake CAPTCHA siteverify listening on %s/siteverify", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,211 @@
+// Command pwned-loader imports breached password hashes into the range files that
+// users-service checks new passwords against.
+//
+// Sources can be combined and the loader can be re-run to update the corpus; when a
+// hash is already present the higher breach count is kept.
+//
+//	go run ./cmd/pwned-loader -hashes pwned-passwords-sha1-ordered-by-hash.txt
+//	go run ./cmd/pwned-loader -ranges ./hibp-download
+//	go run ./cmd/pwned-loader -plain data/password_blacklist.txt
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"example.com/users-service/utils"
+)
+
+// Pending hashes are written out in batches to bound memory: a batch is flushed once
+// it holds this many distinct hashes, however many prefixes they span (~100 MB)
+const flushThreshold = 1 << 20
+
+var (
+	hexPrefix = regexp.MustCompile(`^[0-9A-Fa-f]{5}$`)
+	hexHash   = regexp.MustCompile(`^[0-9A-Fa-f]{40}$`)
+)
+
+type loader struct {
+	dir     string
+	pending map[string]map[string]int
+	// Distinct hashes in pending
+	pendingEntries int
+	added          int
+	written        int
+}
+
+func (l *loader) add(prefix, suffix string, count int) error {
+	prefix = strings.ToUpper(prefix)
+	if l.pending[prefix] == nil {
+		l.pending[prefix] = map[string]int{}
+	}
+	if count > l.pending[prefix][suffix] {
+		if _, seen := l.pending[prefix][suffix]; !seen {
+			l.pendingEntries++
+		}
+		l.pending[prefix][suffix] = count
+	}
+	l.added++
+
+	if l.pendingEntries >= flushThreshold {
+		return l.flush()
+	}
+	return nil
+}
+
+// flush merges the pending entries into the range files on disk
+func (l *loader) flush() error {
+	for prefix, entries := range l.pending {
+		path := utils.PwnedRangePath(l.dir, prefix)
+		existing, err := utils.ReadPwnedRange(path)
+		if err != nil {
+			return err
+		}
+		for suffix, count := range entries {
+			if count > existing[suffix] {
+				existing[suffix] = count
+			}
+		}
+		if err := utils.WritePwnedRange(path, existing); err != nil {
+			return err
+		}
+		l.written++
+	}
+	l.pending = map[string]map[string]int{}
+	l.pendingEntries = 0
+	return nil
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// loadHashes reads full "SHA1:COUNT" lines, e.g. the HIBP ordered-by-hash download
+func (l *loader) loadHashes(path string) error {
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(in)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, count, err := utils.ParsePwnedLine(line)
+		if err != nil || !hexHash.MatchString(hash) {
+			return fmt.Errorf("%s:%d: expected SHA1[:COUNT]", path, lineNo)
+		}
+		if err := l.add(hash[:utils.PwnedPrefixLength], hash[utils.PwnedPrefixLength:], count); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// loadRanges imports a directory of range files named by their prefix, as saved from
+// the HIBP range API or its downloader
+func (l *loader) loadRanges(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		prefix := strings.TrimSuffix(f.Name(), ".txt")
+		if f.IsDir() || !hexPrefix.MatchString(prefix) {
+			continue
+		}
+		entries, err := utils.ReadPwnedRange(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		for suffix, count := range entries {
+			if len(suffix) != utils.PwnedSuffixLength {
+				return fmt.Errorf("%s: invalid hash suffix %q", f.Name(), suffix)
+			}
+			if err := l.add(prefix, suffix, count); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadPlain hashes a plaintext list of passwords, counting each as one breach
+func (l *loader) loadPlain(path string) error {
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		password := strings.TrimRight(scanner.Text(), "\r")
+		if password == "" || strings.HasPrefix(password, "#") {
+			continue
+		}
+		prefix, suffix := utils.SplitPwnedHash(password)
+		if err := l.add(prefix, suffix, 1); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func main() {
+	defaultDir := os.Getenv("PWNED_PASSWORDS_DIR")
+	if defaultDir == "" {
+		defaultDir = "data/pwned"
+	}
+
+	dir := flag.String("dir", defaultDir, "corpus directory users-service reads (PWNED_PASSWORDS_DIR)")
+	hashes := flag.String("hashes", "", `file of "SHA1:COUNT" lines, "-" for stdin`)
+	ranges := flag.String("ranges", "", "directory of HIBP range files named by prefix")
+	plain := flag.String("plain", "", "file of plaintext passwords, one per line")
+	flag.Parse()
+
+	if *hashes == "" && *ranges == "" && *plain == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", *dir, err)
+	}
+
+	l := &loader{dir: *dir, pending: map[string]map[string]int{}}
+
+	if *hashes != "" {
+		if err := l.loadHashes(*hashes); err != nil {
+			log.Fatalf("Failed to import hashes: %v", err)
+		}
+	}
+	if *ranges != "" {
+		if err := l.loadRanges(*ranges); err != nil {
+			log.Fatalf("Failed to import range files: %v", err)
+		}
+	}
+	if *plain != "" {
+		if err := l.loadPlain(*plain); err != nil {
+			log.Fatalf("Failed to import passwords: %v", err)
+		}
+	}
+	if err := l.flush(); err != nil {
+		log.Fatalf("Failed to write range files: %v", err)
+	}
+
+	log.Printf("Imported %d hashes into %s (%d range file writes)", l.added, *dir, l.written)
+}
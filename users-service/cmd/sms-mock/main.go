@@ -0,0 +1,31 @@
+// Command sms-mock serves a Twilio-compatible Messages API that keeps messages in
+// memory and prints them (see sms.MockServer), for running users-service with the real
+// SMS provider without sending anything:
+//
+//	go run ./cmd/sms-mock -addr :8098 -sid ACtest -token test-token
+//	SMS_PROVIDER=twilio TWILIO_ACCOUNT_SID=ACtest TWILIO_AUTH_TOKEN=test-token \
+//	SMS_FROM=+15005550006 SMS_API_URL=http://localhost:8098 go run .
+//
+// Sent messages can be read back with
+// GET /2010-04-01/Accounts/ACtest/Messages.json?To=+381641234567.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"example.com/users-service/sms"
+)
+
+func main() {
+	addr := flag.String("addr", ":8098", "address to listen on")
+	sid := flag.String("sid", "", "account SID the service must use (any when empty)")
+	token := flag.String("token", "", "auth token the service must use")
+	flag.Parse()
+
+	http.Handle("/2010-04-01/Accounts/", &sms.MockServer{AccountSID: *sid, AuthToken: *token})
+
+	log.Printf("Mock SMS API listening on %s/2010-04-01/Accounts/{sid}/Messages.json", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -14,12 +14,22 @@ var (
 
 	// PasswordMaxAgeDuration je izračunata duration za proveru isteka
 	PasswordMaxAgeDuration time.Duration
+
+	// PasswordHistorySize je broj poslednjih lozinki koje korisnik ne sme ponovo da koristi
+	// Default: 5 (PASSWORD_HISTORY_SIZE env varijabla)
+	PasswordHistorySize int = 5
 )
 
 // InitPasswordConfig učitava konfiguraciju za istek lozinke
 // Ako je postavljena PASSWORD_MAX_AGE_MINUTES, koristi se ta vrednost u minutama (za demo)
 // Inače se koristi PASSWORD_MAX_AGE_DAYS (default 60)
 func InitPasswordConfig() {
+	if sizeStr := os.Getenv("PASSWORD_HISTORY_SIZE"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && size > 0 {
+			PasswordHistorySize = size
+		}
+	}
+
 	// Prvo proveri da li je postavljena demo varijabla u minutama
 	if minutesStr := os.Getenv("PASSWORD_MAX_AGE_MINUTES"); minutesStr != "" {
 		if minutes, err := strconv.Atoi(minutesStr); err == nil && minutes > 0 {
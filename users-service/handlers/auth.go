@@ -2,11 +2,11 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,9 +15,10 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 
+	"example.com/users-service/captcha"
 	"example.com/users-service/config"
+	"example.com/users-service/mail"
 	"example.com/users-service/models"
 	"example.com/users-service/utils"
 )
@@ -36,11 +37,15 @@ func InitHandlers(db *mongo.Database, redis *redis.Client) {
 func Register(c *gin.Context) {
 	var req models.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Invalid request data")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Invalid request data")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
 
+	if !checkCaptcha(c, nil, req.RecaptchaToken, captcha.ActionRegister) {
+		return
+	}
+
 	// Input validation
 	req.Username = utils.SanitizeString(req.Username)
 	req.Email = utils.SanitizeString(req.Email)
@@ -49,67 +54,81 @@ func Register(c *gin.Context) {
 
 	// Validate username format
 	if !utils.ValidateUsername(req.Username) {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Invalid username format")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Invalid username format")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be 3-50 alphanumeric characters or underscore"})
 		return
 	}
 
 	// Validate email
 	if !utils.ValidateEmail(req.Email) {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Invalid email format")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Invalid email format")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
 		return
 	}
 
 	// Validate names
 	if !utils.ValidateName(req.FirstName) || !utils.ValidateName(req.LastName) {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Invalid name format")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Invalid name format")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Names must contain only letters and be 2-50 characters"})
 		return
 	}
 
 	// Check for dangerous characters
 	if utils.ContainsSpecialChars(req.Username) || utils.ContainsSpecialChars(req.FirstName) || utils.ContainsSpecialChars(req.LastName) {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Special characters detected")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Special characters detected")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Input contains invalid characters"})
 		return
 	}
 
 	// Password strength validation
 	if !utils.ValidatePasswordStrength(req.Password) {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Weak password")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Weak password")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must contain uppercase, lowercase, number and special character"})
 		return
 	}
 
 	// Check password blacklist
 	if utils.IsPasswordBlacklisted(req.Password) {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Blacklisted password")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Blacklisted password")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This password is too common. Please choose a more unique password"})
 		return
 	}
 
+	// Check the breached password corpus
+	if breached, count := utils.IsPasswordBreached(req.Password); breached {
+		logSecurityEvent(c, nil, "validation_failed", "register", fmt.Sprintf("Breached password (%d breaches)", count))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":        fmt.Sprintf("This password has appeared in %d data breaches. Please choose a different password", count),
+			"breach_count": count,
+		})
+		return
+	}
+
 	ctx := c.Request.Context()
 
 	// Check if username exists
 	var existingUser models.User
 	err := usersDB.Collection("users").FindOne(ctx, bson.M{"username": req.Username}).Decode(&existingUser)
 	if err == nil {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Username already exists")
+		logSecurityEvent(c, nil, "validation_failed", "register", "Username already exists")
 		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 		return
 	}
 
-	// Check if email exists
-	err = usersDB.Collection("users").FindOne(ctx, bson.M{"email": req.Email}).Decode(&existingUser)
-	if err == nil {
-		utils.LogSecurityEvent("validation_failed", "register", c.ClientIP(), "Email already exists")
+	// Check if email exists, including addresses reserved by pending or revertible changes
+	inUse, err := emailInUse(ctx, req.Email, primitive.NilObjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if inUse {
+		logSecurityEvent(c, nil, "validation_failed", "register", "Email already exists")
 		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
 		return
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
 		return
@@ -122,19 +141,22 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	role := models.RoleRegular
-	if strings.HasPrefix(req.Email, "admin@") {
-		role = models.RoleAdmin
+	// Emails go out in the chosen language, or the browser's if it is supported
+	locale := req.Locale
+	if locale == "" {
+		locale = mail.LocaleFromAcceptLanguage(c.GetHeader("Accept-Language"))
 	}
 
 	user := models.User{
 		ID:                        primitive.NewObjectID(),
 		Username:                  req.Username,
 		Email:                     req.Email,
-		PasswordHash:              string(hashedPassword),
+		PasswordHash:              hashedPassword,
+		PasswordHistory:           []string{hashedPassword},
 		FirstName:                 req.FirstName,
 		LastName:                  req.LastName,
-		Role:                      role,
+		Locale:                    locale,
+		Role:                      models.RoleRegular,
 		EmailVerified:             false,
 		EmailVerificationToken:    verificationToken,
 		EmailVerificationTokenExp: time.Now().Add(24 * time.Hour),
@@ -157,11 +179,12 @@ func Register(c *gin.Context) {
 		frontendURL = "http://localhost:4200"
 	}
 	verificationLink := fmt.Sprintf("%s/verify-email?token=%s", frontendURL, verificationToken)
-	emailBody := fmt.Sprintf("Hi %s,\n\nPlease verify your email by clicking this link:\n%s\n\nThis link expires in 24 hours.", req.FirstName, verificationLink)
-
-	go utils.SendEmail(req.Email, "Verify your email", emailBody)
+	queueEmail(&user, user.Email, "verify_email", map[string]interface{}{
+		"Link":  verificationLink,
+		"Hours": 24,
+	}, 24*time.Hour)
 
-	utils.LogSecurityEvent("success", "register", c.ClientIP(), fmt.Sprintf("User %s registered", req.Username))
+	logSecurityEvent(c, &user, "success", "register", fmt.Sprintf("User %s registered", req.Username))
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Registration successful. Please check your email to verify your account.",
@@ -186,7 +209,7 @@ func VerifyEmail(c *gin.Context) {
 	}).Decode(&user)
 
 	if err != nil {
-		utils.LogSecurityEvent("failed", "email_verification", c.ClientIP(), "Invalid or expired token")
+		logSecurityEvent(c, nil, "failed", "email_verification", "Invalid or expired token")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
 		return
 	}
@@ -207,7 +230,7 @@ func VerifyEmail(c *gin.Context) {
 		return
 	}
 
-	utils.LogSecurityEvent("success", "email_verification", c.ClientIP(), fmt.Sprintf("User %s verified email", user.Username))
+	logSecurityEvent(c, &user, "success", "email_verification", fmt.Sprintf("User %s verified email", user.Username))
 
 	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully. You can now login."})
 }
@@ -216,7 +239,7 @@ func VerifyEmail(c *gin.Context) {
 func Login(c *gin.Context) {
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.LogSecurityEvent("validation_failed", "login", c.ClientIP(), "Invalid request data")
+		logSecurityEvent(c, nil, "validation_failed", "login", "Invalid request data")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
@@ -226,49 +249,82 @@ func Login(c *gin.Context) {
 
 	ctx := c.Request.Context()
 
+	// Unknown usernames are throttled like real ones, so the limits don't reveal which exist
 	var user models.User
 	err := usersDB.Collection("users").FindOne(ctx, bson.M{"username": req.Username}).Decode(&user)
+	userFound := err == nil
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	throttle := &loginThrottle{ip: c.ClientIP(), username: req.Username}
+	if userFound {
+		throttle.knownNetwork = knownLoginNetwork(ctx, &user, throttle.ip)
+	}
+	wait, err := loadLoginThrottle(ctx, throttle)
 	if err != nil {
-		utils.LogSecurityEvent("failed", "login", c.ClientIP(), fmt.Sprintf("User %s not found", req.Username))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process login"})
+		return
+	}
+	if wait > 0 {
+		logSecurityEvent(c, &models.User{ID: user.ID, Username: req.Username}, "blocked", "login_throttled", fmt.Sprintf("User %s login throttled for %s", req.Username, wait.Round(time.Second)))
+		respondThrottled(c, wait)
+		return
+	}
+	if throttle.captchaRequired() && !checkCaptcha(c, &models.User{ID: user.ID, Username: req.Username}, req.RecaptchaToken, captcha.ActionLogin) {
+		return
+	}
+
+	// loginFailed counts the failure and tells the client when the next attempt needs a CAPTCHA
+	loginFailed := func() {
+		if err := recordLoginFailure(ctx, throttle); err != nil {
+			log.Printf("Failed to record login failure: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials", "captcha_required": throttle.captchaRequired()})
+	}
+
+	if !userFound {
+		logSecurityEvent(c, &models.User{Username: req.Username}, "failed", "login", fmt.Sprintf("User %s not found", req.Username))
+		loginFailed()
 		return
 	}
 
 	// Check if account is locked
 	if time.Now().Before(user.LockedUntil) {
-		utils.LogSecurityEvent("failed", "login", c.ClientIP(), fmt.Sprintf("User %s account locked", req.Username))
+		logSecurityEvent(c, &user, "failed", "login", fmt.Sprintf("User %s account locked", req.Username))
 		c.JSON(http.StatusForbidden, gin.H{"error": "Account is temporarily locked due to multiple failed login attempts"})
 		return
 	}
+	if user.PendingDeletion() {
+		logSecurityEvent(c, &user, "failed", "login", fmt.Sprintf("User %s account is being deleted", req.Username))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is being deleted"})
+		return
+	}
 
 	// Verify password
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
-	if err != nil {
-		// Increment failed attempts
-		failedAttempts := user.FailedLoginAttempts + 1
-		update := bson.M{
-			"$set": bson.M{
-				"failed_login_attempts": failedAttempts,
-				"last_failed_login":     time.Now(),
-			},
-		}
-
-		// Lock account after 5 failed attempts
-		if failedAttempts >= 5 {
-			update["$set"].(bson.M)["locked_until"] = time.Now().Add(15 * time.Minute)
-			utils.LogSecurityEvent("failed", "login", c.ClientIP(), fmt.Sprintf("User %s account locked after 5 failed attempts", req.Username))
-		}
-
-		usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+	match, needsRehash := utils.VerifyPassword(user.PasswordHash, req.Password)
+	if !match {
+		// The account isn't locked; throttling slows attackers without locking out the owner
+		usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$inc": bson.M{"failed_login_attempts": 1},
+			"$set": bson.M{"last_failed_login": time.Now()},
+		})
 
-		utils.LogSecurityEvent("failed", "login", c.ClientIP(), fmt.Sprintf("User %s invalid password", req.Username))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		logSecurityEvent(c, &user, "failed", "login", fmt.Sprintf("User %s invalid password", req.Username))
+		loginFailed()
 		return
 	}
+	clearLoginFailures(ctx, throttle)
+
+	// Upgrade bcrypt or outdated Argon2id hashes while the plaintext is at hand
+	if needsRehash {
+		rehashPassword(ctx, &user, req.Password)
+	}
 
 	// Check if email is verified
 	if !user.EmailVerified {
-		utils.LogSecurityEvent("failed", "login", c.ClientIP(), fmt.Sprintf("User %s email not verified", req.Username))
+		logSecurityEvent(c, &user, "failed", "login", fmt.Sprintf("User %s email not verified", req.Username))
 		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email before logging in"})
 		return
 	}
@@ -277,7 +333,7 @@ func Login(c *gin.Context) {
 	if config.IsPasswordExpired(user.PasswordChangedAt) {
 		passwordAge := config.GetPasswordAge(user.PasswordChangedAt)
 		maxAge := config.GetPasswordMaxAgeString()
-		utils.LogSecurityEvent("failed", "login", c.ClientIP(),
+		logSecurityEvent(c, &user, "failed", "login",
 			fmt.Sprintf("User %s password expired (age: %v, max: %s)", req.Username, passwordAge.Round(time.Minute), maxAge))
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":       "Lozinka je istekla. Molimo resetujte vašu lozinku.",
@@ -294,60 +350,54 @@ func Login(c *gin.Context) {
 	passwordAge := config.GetPasswordAge(user.PasswordChangedAt)
 	if passwordAge > warningThreshold {
 		daysLeft := config.GetDaysUntilExpiry(user.PasswordChangedAt)
-		var timeUnit string
-		if config.PasswordMaxAgeDays == 0 {
-			timeUnit = "minuta"
-		} else {
-			timeUnit = "dana"
-		}
-		emailBody := fmt.Sprintf("Zdravo %s,\n\nVaša lozinka će isteći za %d %s. Molimo promenite je što pre.", user.FirstName, daysLeft, timeUnit)
-		go utils.SendEmail(user.Email, "Upozorenje o isteku lozinke", emailBody)
+		queueEmail(&user, user.Email, "password_expiry", map[string]interface{}{
+			"Count":     daysLeft,
+			"InMinutes": config.PasswordMaxAgeDays == 0,
+		}, 0)
 	}
 
-	// Generate OTP
-	otp, err := utils.GenerateOTP()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OTP"})
-		return
-	}
+	// Reset failed login attempts
+	usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"failed_login_attempts": 0,
+		},
+	})
 
-	// Save OTP to Redis
-	err = utils.SaveOTPToRedis(ctx, redisClient, user.ID.Hex(), otp, 5*time.Minute)
+	// Compare with earlier logins; the verdict travels with the pending login, which is
+	// bound to this client and waits for the user's second factor
+	risk := assessLogin(ctx, c, &user)
+	challenge, err := newLoginChallenge(c, &user, user.SecondFactor(), risk)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save OTP"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save temp token"})
 		return
 	}
+	tempToken := challenge.token
 
-	// Generate temp token
-	tempToken, err := utils.GenerateTempToken(user.ID.Hex())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate temp token"})
+	// Users with an authenticator app don't need an email round trip
+	if user.SecondFactor() == models.TwoFactorTOTP {
+		logSecurityEvent(c, &user, "success", "login_totp_required", fmt.Sprintf("User %s TOTP required", req.Username))
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Enter the code from your authenticator app",
+			"temp_token": tempToken,
+			"method":     models.TwoFactorTOTP,
+		})
 		return
 	}
 
-	// Save temp token
-	err = utils.SaveTempToken(ctx, redisClient, tempToken, user.ID.Hex())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save temp token"})
+	// Send a code or approval request over the channel the user prefers
+	channel, ok := sendLoginChallenge(c, &user, challenge)
+	if !ok {
 		return
 	}
 
-	// Send OTP email
-	emailBody := fmt.Sprintf("Hi %s,\n\nYour OTP code is: %s\n\nThis code expires in 5 minutes.", user.FirstName, otp)
-	go utils.SendEmail(user.Email, "Your OTP Code", emailBody)
-
-	// Reset failed login attempts
-	usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
-		"$set": bson.M{
-			"failed_login_attempts": 0,
-		},
-	})
-
-	utils.LogSecurityEvent("success", "login_otp_sent", c.ClientIP(), fmt.Sprintf("User %s OTP sent", req.Username))
+	logSecurityEvent(c, &user, "success", "login_otp_sent", fmt.Sprintf("User %s %s challenge sent", req.Username, channel.Name()))
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":    "OTP sent to your email",
+		"message":    channel.Prompt(&user),
 		"temp_token": tempToken,
+		"method":     channel.Name(),
+		"channels":   availableOTPChannels(ctx, &user),
 	})
 }
 
@@ -355,58 +405,151 @@ func Login(c *gin.Context) {
 func VerifyOTP(c *gin.Context) {
 	var req models.VerifyOTPRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.LogSecurityEvent("validation_failed", "verify_otp", c.ClientIP(), "Invalid request data")
+		logSecurityEvent(c, nil, "validation_failed", "verify_otp", "Invalid request data")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
 
 	ctx := c.Request.Context()
 
-	// Verify temp token
-	userID, err := utils.VerifyTempToken(ctx, redisClient, req.TempToken)
-	if err != nil {
-		utils.LogSecurityEvent("failed", "verify_otp", c.ClientIP(), "Invalid temp token")
+	challenge, user, ok := pendingLogin(c, req.TempToken, "verify_otp")
+	if !ok {
+		return
+	}
+
+	// The attempt is counted before the code is compared, so a burst of parallel
+	// guesses can't get past the limit
+	reserved, err := challenge.reserveAttempt(ctx)
+	if errors.Is(err, errChallengeNotFound) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired temp token"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !reserved {
+		logSecurityEvent(c, user, "blocked", "verify_otp", fmt.Sprintf("User %s out of code attempts", user.Username))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes. Please log in again."})
+		return
+	}
+
+	// codeFailed counts a wrong code; after too many the pending login is gone
+	codeFailed := func(message string) {
+		if challenge.recordFailure(ctx) {
+			logSecurityEvent(c, user, "blocked", "verify_otp", fmt.Sprintf("User %s out of code attempts, temp token revoked", user.Username))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes. Please log in again."})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+	}
+
+	risk := challenge.Risk
 
-	// Verify OTP
-	valid, err := utils.VerifyOTP(ctx, redisClient, userID, req.OTPCode)
+	// A high-risk login that already passed its second factor waits for the emailed code
+	if risk != nil && risk.StepUpPending {
+		if !challenge.checkCode(req.OTPCode) {
+			logSecurityEvent(c, user, "failed", "login_step_up", fmt.Sprintf("User %s invalid verification code", user.Username))
+			codeFailed("Invalid verification code")
+			return
+		}
+		challenge.recordSuccess(ctx)
+		logSecurityEvent(c, user, "success", "login_step_up", fmt.Sprintf("User %s verified a high-risk login", user.Username))
+
+		finishLogin(c, user, challenge)
+		return
+	}
+
+	// Verify the factor the login was started with; codes sent by email or SMS belong
+	// to this login only
+	var valid bool
+	switch {
+	case challenge.Factor == models.TwoFactorTOTP && req.RecoveryCode != "":
+		valid, err = useRecoveryCode(ctx, user, req.RecoveryCode)
+		if valid {
+			logSecurityEvent(c, user, "success", "recovery_code_used", fmt.Sprintf("User %s used a recovery code", user.Username))
+		}
+	case challenge.Factor == models.TwoFactorTOTP:
+		valid, err = verifyTOTP(ctx, user, req.OTPCode)
+	default:
+		valid = challenge.checkCode(req.OTPCode)
+	}
 	if err != nil || !valid {
-		utils.LogSecurityEvent("failed", "verify_otp", c.ClientIP(), "Invalid OTP")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid OTP code"})
+		logSecurityEvent(c, user, "failed", "verify_otp", fmt.Sprintf("Invalid %s code", challenge.Factor))
+		codeFailed("Invalid OTP code")
 		return
 	}
+	challenge.recordSuccess(ctx)
 
-	// Get user
-	objID, _ := primitive.ObjectIDFromHex(userID)
-	var user models.User
-	err = usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+	// Codes sent by email or SMS already came with the login details; authenticator
+	// users get an extra emailed code
+	if risk != nil && risk.StepUp && challenge.Factor == models.TwoFactorTOTP {
+		if err := startLoginStepUp(ctx, user, challenge); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification code"})
+			return
+		}
+		logSecurityEvent(c, user, "warning", "login_step_up_required", fmt.Sprintf("User %s high-risk login (score %d)", user.Username, risk.Score))
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":          "This login looks unusual. Enter the code we sent to your email to continue",
+			"step_up_required": true,
+			"temp_token":       req.TempToken,
+			"method":           models.TwoFactorEmail,
+		})
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user.ID.Hex(), user.Username, string(user.Role))
+	finishLogin(c, user, challenge)
+}
+
+// finishLogin consumes the pending login and issues the tokens. Only one request can
+// consume it, so the same temp token never yields two sessions.
+func finishLogin(c *gin.Context, user *models.User, challenge *loginChallenge) {
+	if !challenge.consume(c.Request.Context()) {
+		logSecurityEvent(c, user, "blocked", "verify_otp", fmt.Sprintf("User %s temp token already used", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired temp token"})
+		return
+	}
+	respondWithToken(c, user, challenge.Risk)
+}
+
+// respondWithToken finishes a successful login by opening a session and issuing its
+// tokens, and remembers where the login came from
+func respondWithToken(c *gin.Context, user *models.User, risk *models.LoginRisk) {
+	token, refreshToken, err := createSession(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	deviceToken := recordLogin(c.Request.Context(), user, risk)
 
-	utils.LogSecurityEvent("success", "login", c.ClientIP(), fmt.Sprintf("User %s logged in", user.Username))
+	logSecurityEvent(c, user, "success", "login", fmt.Sprintf("User %s logged in", user.Username))
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+	c.JSON(http.StatusOK, loginResponse(user, token, refreshToken, deviceToken))
+}
+
+// loginResponse is the body of a completed login. device_token is only there when a
+// new one was issued; the client sends it as X-Device-Token on later logins.
+func loginResponse(user *models.User, token, refreshToken, deviceToken string) gin.H {
+	resp := gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
 		"user": gin.H{
-			"id":         user.ID.Hex(),
-			"username":   user.Username,
-			"email":      user.Email,
-			"first_name": user.FirstName,
-			"last_name":  user.LastName,
-			"role":       user.Role,
+			"id":          user.ID.Hex(),
+			"username":    user.Username,
+			"email":       user.Email,
+			"first_name":  user.FirstName,
+			"last_name":   user.LastName,
+			"role":        user.PrimaryRole(),
+			"roles":       user.EffectiveRoles(),
+			"permissions": user.Permissions(),
 		},
-	})
+	}
+	if deviceToken != "" {
+		resp["device_token"] = deviceToken
+	}
+	return resp
 }
 
 // RequestMagicLink sends magic link for account recovery
@@ -452,11 +595,12 @@ func RequestMagicLink(c *gin.Context) {
 		frontendURL = "http://localhost:4200"
 	}
 	magicLink := fmt.Sprintf("%s/magic-login?token=%s", frontendURL, token)
-	emailBody := fmt.Sprintf("Hi %s,\n\nClick this link to login:\n%s\n\nThis link expires in 15 minutes.", user.FirstName, magicLink)
+	queueEmail(&user, user.Email, "magic_link", map[string]interface{}{
+		"Link":    magicLink,
+		"Minutes": 15,
+	}, 15*time.Minute)
 
-	go utils.SendEmail(user.Email, "Magic Login Link", emailBody)
-
-	utils.LogSecurityEvent("success", "magic_link_sent", c.ClientIP(), fmt.Sprintf("Magic link sent to %s", req.Email))
+	logSecurityEvent(c, &user, "success", "magic_link_sent", fmt.Sprintf("Magic link sent to %s", req.Email))
 
 	c.JSON(http.StatusOK, gin.H{"message": "If email exists, magic link has been sent"})
 }
@@ -464,7 +608,7 @@ func RequestMagicLink(c *gin.Context) {
 func ResetPassword(c *gin.Context) {
 	var req models.ResetPasswordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.LogSecurityEvent("validation_failed", "reset_password", c.ClientIP(), "Invalid request data")
+		logSecurityEvent(c, nil, "validation_failed", "reset_password", "Invalid request data")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
@@ -472,11 +616,15 @@ func ResetPassword(c *gin.Context) {
 	req.Email = utils.SanitizeString(req.Email)
 
 	if !utils.ValidateEmail(req.Email) {
-		utils.LogSecurityEvent("validation_failed", "reset_password", c.ClientIP(), "Invalid email format")
+		logSecurityEvent(c, nil, "validation_failed", "reset_password", "Invalid email format")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
 		return
 	}
 
+	if !checkCaptcha(c, nil, req.RecaptchaToken, captcha.ActionPasswordReset) {
+		return
+	}
+
 	ctx := c.Request.Context()
 
 	var user models.User
@@ -515,12 +663,9 @@ func ResetPassword(c *gin.Context) {
 		frontendURL = "http://localhost:4200"
 	}
 	resetLink := fmt.Sprintf("%s/reset-password?token=%s", frontendURL, resetToken)
-	emailBody := fmt.Sprintf("Hi %s,\n\nClick this link to reset your password:\n%s\n\nThis link expires in 1 hour.\n\nIf you didn't request this, please ignore this email.",
-		user.FirstName, resetLink)
-
-	go utils.SendEmail(user.Email, "Password Reset Request", emailBody)
+	queueEmail(&user, user.Email, "password_reset", map[string]interface{}{"Link": resetLink}, time.Hour)
 
-	utils.LogSecurityEvent("success", "reset_password_sent", c.ClientIP(), fmt.Sprintf("Reset link sent to %s", req.Email))
+	logSecurityEvent(c, &user, "success", "reset_password_sent", fmt.Sprintf("Reset link sent to %s", req.Email))
 
 	c.JSON(http.StatusOK, gin.H{"message": "If email exists, reset link has been sent"})
 }
@@ -529,25 +674,35 @@ func ResetPassword(c *gin.Context) {
 func ResetPasswordConfirm(c *gin.Context) {
 	var req models.ResetPasswordConfirmRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.LogSecurityEvent("validation_failed", "reset_password_confirm", c.ClientIP(), "Invalid request data")
+		logSecurityEvent(c, nil, "validation_failed", "reset_password_confirm", "Invalid request data")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
 
 	// Validate new password
 	if !utils.ValidatePasswordStrength(req.NewPassword) {
-		utils.LogSecurityEvent("validation_failed", "reset_password_confirm", c.ClientIP(), "Weak password")
+		logSecurityEvent(c, nil, "validation_failed", "reset_password_confirm", "Weak password")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must contain uppercase, lowercase, number and special character"})
 		return
 	}
 
 	// Check password blacklist
 	if utils.IsPasswordBlacklisted(req.NewPassword) {
-		utils.LogSecurityEvent("validation_failed", "reset_password_confirm", c.ClientIP(), "Blacklisted password")
+		logSecurityEvent(c, nil, "validation_failed", "reset_password_confirm", "Blacklisted password")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This password is too common. Please choose a more unique password"})
 		return
 	}
 
+	// Check the breached password corpus
+	if breached, count := utils.IsPasswordBreached(req.NewPassword); breached {
+		logSecurityEvent(c, nil, "validation_failed", "reset_password_confirm", fmt.Sprintf("Breached password (%d breaches)", count))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":        fmt.Sprintf("This password has appeared in %d data breaches. Please choose a different password", count),
+			"breach_count": count,
+		})
+		return
+	}
+
 	ctx := c.Request.Context()
 
 	var user models.User
@@ -557,30 +712,33 @@ func ResetPasswordConfirm(c *gin.Context) {
 	}).Decode(&user)
 
 	if err != nil {
-		utils.LogSecurityEvent("failed", "reset_password_confirm", c.ClientIP(), "Invalid or expired token")
+		logSecurityEvent(c, nil, "failed", "reset_password_confirm", "Invalid or expired token")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
 		return
 	}
 
+	if passwordReused(&user, req.NewPassword) {
+		logSecurityEvent(c, &user, "validation_failed", "reset_password_confirm", fmt.Sprintf("User %s reused a recent password", user.Username))
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You cannot reuse any of your last %d passwords", config.PasswordHistorySize)})
+		return
+	}
+
 	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
 	// Update password and clear token
-	update := bson.M{
-		"$set": bson.M{
-			"password_hash":          string(hashedPassword),
-			"password_changed_at":    time.Now(),
-			"verification_token":     "",
-			"verification_token_exp": time.Time{},
-			"updated_at":             time.Now(),
-			"failed_login_attempts":  0,
-			"locked_until":           time.Time{},
-		},
-	}
+	fields := passwordChangeFields(&user, hashedPassword)
+	fields["password_changed_at"] = time.Now()
+	fields["verification_token"] = ""
+	fields["verification_token_exp"] = time.Time{}
+	fields["updated_at"] = time.Now()
+	fields["failed_login_attempts"] = 0
+	fields["locked_until"] = time.Time{}
+	update := bson.M{"$set": fields}
 
 	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, update)
 	if err != nil {
@@ -589,11 +747,14 @@ func ResetPasswordConfirm(c *gin.Context) {
 	}
 
 	// Send confirmation email
-	emailBody := fmt.Sprintf("Hi %s,\n\nYour password has been successfully reset.\n\nIf you didn't make this change, please contact support immediately.",
-		user.FirstName)
-	go utils.SendEmail(user.Email, "Password Reset Successful", emailBody)
+	queueEmail(&user, user.Email, "password_reset_done", nil, 0)
 
-	utils.LogSecurityEvent("success", "reset_password_confirm", c.ClientIP(), fmt.Sprintf("User %s reset password", user.Username))
+	// Whoever knew the old password may still hold a session
+	if _, err := revokeAllSessions(ctx, user.ID.Hex(), ""); err != nil {
+		log.Printf("Failed to revoke sessions for %s: %v", user.Username, err)
+	}
+
+	logSecurityEvent(c, &user, "success", "reset_password_confirm", fmt.Sprintf("User %s reset password", user.Username))
 
 	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully. You can now login with your new password."})
 }
@@ -624,6 +785,32 @@ func EnsureUserIndexes(db *mongo.Database) {
 				SetName("verification_token_idx").
 				SetSparse(true),
 		},
+		{
+			// Two accounts can't wait for the same new address
+			Keys: bson.D{{Key: "pending_email", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetName("unique_pending_email").
+				SetPartialFilterExpression(bson.M{"pending_email": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{{Key: "previous_email", Value: 1}},
+			Options: options.Index().
+				SetName("previous_email_idx").
+				SetSparse(true),
+		},
+		{
+			Keys: bson.D{{Key: "email_change_token", Value: 1}},
+			Options: options.Index().
+				SetName("email_change_token_idx").
+				SetSparse(true),
+		},
+		{
+			Keys: bson.D{{Key: "email_revert_token", Value: 1}},
+			Options: options.Index().
+				SetName("email_revert_token_idx").
+				SetSparse(true),
+		},
 	}
 
 	_, err := users.Indexes().CreateMany(ctx, indexes)
@@ -651,17 +838,21 @@ func MagicLogin(c *gin.Context) {
 	}).Decode(&user)
 
 	if err != nil {
-		utils.LogSecurityEvent("failed", "magic_login", c.ClientIP(), "Invalid or expired token")
+		logSecurityEvent(c, nil, "failed", "magic_login", "Invalid or expired token")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired magic link"})
 		return
 	}
 
 	// Check if email is verified
 	if !user.EmailVerified {
-		utils.LogSecurityEvent("failed", "magic_login", c.ClientIP(), fmt.Sprintf("User %s email not verified", user.Username))
+		logSecurityEvent(c, &user, "failed", "magic_login", fmt.Sprintf("User %s email not verified", user.Username))
 		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email first"})
 		return
 	}
+	if user.PendingDeletion() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is being deleted"})
+		return
+	}
 
 	// Clear verification token
 	update := bson.M{
@@ -680,27 +871,42 @@ func MagicLogin(c *gin.Context) {
 		return
 	}
 
+	// The link skips the second factor, so a high-risk login has to enter an emailed
+	// code first and then continues through /verify-otp
+	risk := assessLogin(ctx, c, &user)
+	if risk.StepUp {
+		challenge, err := newLoginChallenge(c, &user, models.TwoFactorEmail, risk)
+		if err == nil {
+			err = startLoginStepUp(ctx, &user, challenge)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification code"})
+			return
+		}
+		logSecurityEvent(c, &user, "warning", "login_step_up_required", fmt.Sprintf("User %s high-risk magic link login (score %d)", user.Username, risk.Score))
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":          "This login looks unusual. Enter the code we sent to your email to continue",
+			"step_up_required": true,
+			"temp_token":       challenge.token,
+			"method":           models.TwoFactorEmail,
+		})
+		return
+	}
+
 	// Generate JWT token
-	jwtToken, err := utils.GenerateJWT(user.ID.Hex(), user.Username, string(user.Role))
+	jwtToken, refreshToken, err := createSession(c, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	deviceToken := recordLogin(ctx, &user, risk)
 
-	utils.LogSecurityEvent("success", "magic_login", c.ClientIP(), fmt.Sprintf("User %s logged in via magic link", user.Username))
+	logSecurityEvent(c, &user, "success", "magic_login", fmt.Sprintf("User %s logged in via magic link", user.Username))
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": jwtToken,
-		"user": gin.H{
-			"id":         user.ID.Hex(),
-			"username":   user.Username,
-			"email":      user.Email,
-			"first_name": user.FirstName,
-			"last_name":  user.LastName,
-			"role":       user.Role,
-		},
-		"message": "Login successful",
-	})
+	resp := loginResponse(&user, jwtToken, refreshToken, deviceToken)
+	resp["message"] = "Login successful"
+	c.JSON(http.StatusOK, resp)
 }
 
 // ResetPasswordConfirmValidate validates reset token from GET link
@@ -723,7 +929,7 @@ func ResetPasswordConfirmValidate(c *gin.Context) {
 	}
 
 	if count == 0 {
-		utils.LogSecurityEvent("failed", "reset_password_validate", c.ClientIP(), "Invalid/expired token")
+		logSecurityEvent(c, nil, "failed", "reset_password_validate", "Invalid/expired token")
 		c.JSON(http.StatusBadRequest, gin.H{"valid": false, "error": "Invalid or expired token"})
 		return
 	}
@@ -736,25 +942,35 @@ func ResetPasswordConfirmValidate(c *gin.Context) {
 func ChangePassword(c *gin.Context) {
 	var req models.ChangePasswordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.LogSecurityEvent("validation_failed", "change_password", c.ClientIP(), "Invalid request data")
+		logSecurityEvent(c, nil, "validation_failed", "change_password", "Invalid request data")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
 
 	// Strength validation
 	if !utils.ValidatePasswordStrength(req.NewPassword) {
-		utils.LogSecurityEvent("validation_failed", "change_password", c.ClientIP(), "Weak password")
+		logSecurityEvent(c, nil, "validation_failed", "change_password", "Weak password")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must contain uppercase, lowercase, number and special character"})
 		return
 	}
 
 	// Check password blacklist
 	if utils.IsPasswordBlacklisted(req.NewPassword) {
-		utils.LogSecurityEvent("validation_failed", "change_password", c.ClientIP(), "Blacklisted password")
+		logSecurityEvent(c, nil, "validation_failed", "change_password", "Blacklisted password")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "This password is too common. Please choose a more unique password"})
 		return
 	}
 
+	// Check the breached password corpus
+	if breached, count := utils.IsPasswordBreached(req.NewPassword); breached {
+		logSecurityEvent(c, nil, "validation_failed", "change_password", fmt.Sprintf("Breached password (%d breaches)", count))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":        fmt.Sprintf("This password has appeared in %d data breaches. Please choose a different password", count),
+			"breach_count": count,
+		})
+		return
+	}
+
 	userIDAny, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
@@ -778,43 +994,51 @@ func ChangePassword(c *gin.Context) {
 
 	// Must be at least 1 day since last change
 	if time.Since(user.PasswordChangedAt) < 24*time.Hour {
-		utils.LogSecurityEvent("failed", "change_password", c.ClientIP(), "Password change too soon (<24h)")
+		logSecurityEvent(c, nil, "failed", "change_password", "Password change too soon (<24h)")
 		c.JSON(http.StatusForbidden, gin.H{"error": "Password can be changed only if it is at least 1 day old"})
 		return
 	}
 
 	// Verify current password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
-		utils.LogSecurityEvent("failed", "change_password", c.ClientIP(), "Invalid current password")
+	if match, _ := utils.VerifyPassword(user.PasswordHash, req.CurrentPassword); !match {
+		logSecurityEvent(c, nil, "failed", "change_password", "Invalid current password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid current password"})
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if passwordReused(&user, req.NewPassword) {
+		logSecurityEvent(c, nil, "validation_failed", "change_password", fmt.Sprintf("User %s reused a recent password", user.Username))
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You cannot reuse any of your last %d passwords", config.PasswordHistorySize)})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"password_hash":       string(hashedPassword),
-			"password_changed_at": time.Now(),
-			"updated_at":          time.Now(),
-		},
-	}
+	fields := passwordChangeFields(&user, hashedPassword)
+	fields["password_changed_at"] = time.Now()
+	fields["updated_at"] = time.Now()
+	update := bson.M{"$set": fields}
 
 	if _, err := usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
 		return
 	}
 
-	utils.LogSecurityEvent("success", "change_password", c.ClientIP(), "Password changed")
+	// Sign out every other device, the one changing the password stays logged in
+	if _, err := revokeAllSessions(ctx, userIDHex, currentSessionID(c)); err != nil {
+		log.Printf("Failed to revoke sessions for %s: %v", user.Username, err)
+	}
+
+	logSecurityEvent(c, nil, "success", "change_password", "Password changed")
 
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 }
 
-// Logout revokes current JWT by blacklisting its JTI in Redis until expiration
+// Logout revokes current JWT by blacklisting its JTI in Redis until expiration and ends its session
 func Logout(c *gin.Context) {
 	claimsAny, exists := c.Get("claims")
 	if !exists {
@@ -842,6 +1066,14 @@ func Logout(c *gin.Context) {
 		return
 	}
 
-	utils.LogSecurityEvent("success", "logout", c.ClientIP(), "Token revoked (blacklisted)")
+	// End the session too so its refresh token can't mint new access tokens
+	if claims.SessionID != "" {
+		if err := revokeSession(ctx, claims.UserID, claims.SessionID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+			return
+		}
+	}
+
+	logSecurityEvent(c, nil, "success", "logout", "Token revoked (blacklisted)")
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
 }
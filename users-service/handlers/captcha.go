@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/users-service/captcha"
+	"example.com/users-service/models"
+)
+
+// nil when CAPTCHA checks are disabled
+var captchaProvider captcha.Provider
+
+// InitCaptcha picks the CAPTCHA provider (see captcha.FromEnv)
+func InitCaptcha() {
+	provider, err := captcha.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure CAPTCHA: %v", err)
+	}
+	captchaProvider = provider
+
+	if provider != nil {
+		log.Printf("CAPTCHA provider: %s", provider.Name())
+	}
+}
+
+func captchaEnabled() bool {
+	return captchaProvider != nil
+}
+
+// checkCaptcha verifies the CAPTCHA token sent for action, by user if known. When it
+// fails the response is already written and the handler has to stop. A provider that
+// can't be reached fails closed.
+func checkCaptcha(c *gin.Context, user *models.User, token, action string) bool {
+	if captchaProvider == nil {
+		return true
+	}
+
+	result, err := captchaProvider.Verify(c.Request.Context(), token, c.ClientIP(), action)
+	switch {
+	case errors.Is(err, captcha.ErrMissingToken):
+		logSecurityEvent(c, user, "validation_failed", "captcha_"+action, "Missing CAPTCHA token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CAPTCHA verification required", "captcha_required": true})
+		return false
+	case err != nil:
+		logSecurityEvent(c, user, "error", "captcha_"+action, fmt.Sprintf("%s verification failed: %v", captchaProvider.Name(), err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "CAPTCHA verification is unavailable, please try again later"})
+		return false
+	case !result.Success:
+		logSecurityEvent(c, user, "failed", "captcha_"+action, fmt.Sprintf("%s rejected token (score %.1f): %v", captchaProvider.Name(), result.Score, result.ErrorCodes))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CAPTCHA verification failed", "captcha_required": true})
+		return false
+	}
+	return true
+}
+
+// GetCaptchaConfig tells the frontend which CAPTCHA widget to render
+func GetCaptchaConfig(c *gin.Context) {
+	if captchaProvider == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  true,
+		"provider": captchaProvider.Name(),
+		"site_key": captchaProvider.SiteKey(),
+	})
+}
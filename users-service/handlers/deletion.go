@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const (
+	accountDeletionsCollection = "account_deletions"
+
+	// The users step runs last: once the user document is gone nothing can be retried
+	usersDeletionStep = "users"
+
+	deletionLease      = 5 * time.Minute
+	deletionMaxBackoff = time.Hour
+)
+
+var (
+	// Time the user has to change their mind before data is purged
+	deletionGracePeriod = 7 * 24 * time.Hour
+
+	// Services holding user data; each exposes internal GET (export) and DELETE
+	// (purge) endpoints at /api/v1/users/:id/data
+	userDataServices = map[string]string{
+		"content":        serviceURL("CONTENT_SERVICE_URL", "http://content-service:8002"),
+		"ratings":        serviceURL("RATINGS_SERVICE_URL", "http://ratings-service:8003"),
+		"subscriptions":  serviceURL("SUBSCRIPTIONS_SERVICE_URL", "http://subscriptions-service:8004"),
+		"notifications":  serviceURL("NOTIFICATIONS_SERVICE_URL", "http://notifications-service:8005"),
+		"recommendation": serviceURL("RECOMMENDATION_SERVICE_URL", "http://recommendation-service:8006"),
+		"playback":       serviceURL("PLAYBACK_SERVICE_URL", "http://playback-service:8007"),
+	}
+
+	// Carries the service token, so certificates are always verified; InitAccountDeletion
+	// adds the internal CA when the services use one
+	serviceClient = &http.Client{Timeout: 30 * time.Second}
+
+	// Shared credential the other services require on their internal routes
+	internalServiceToken = os.Getenv("INTERNAL_SERVICE_TOKEN")
+)
+
+func serviceURL(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// newServiceRequest builds a call to an internal route of another service
+func newServiceRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Service-Token", internalServiceToken)
+	return req, nil
+}
+
+// InitAccountDeletion reads the grace period configuration and the CA bundle
+// (INTERNAL_CA_FILE) that signs the other services' certificates
+func InitAccountDeletion() {
+	if d, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE")); err == nil && d >= 0 {
+		deletionGracePeriod = d
+	}
+
+	if caFile := os.Getenv("INTERNAL_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatalf("Failed to read INTERNAL_CA_FILE: %v", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in INTERNAL_CA_FILE %s", caFile)
+		}
+		serviceClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		}
+	}
+}
+
+// EnsureAccountDeletionIndexes keeps one deletion per user and lets the worker find due ones
+func EnsureAccountDeletionIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("unique_user_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "scheduled_for", Value: 1}},
+			Options: options.Index().SetName("status_scheduled_idx"),
+		},
+	}
+
+	if _, err := db.Collection(accountDeletionsCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for account_deletions ensured")
+}
+
+func newDeletionSteps(now time.Time) map[string]*models.DeletionStep {
+	steps := map[string]*models.DeletionStep{
+		usersDeletionStep: {NextAttemptAt: now},
+	}
+	for name := range userDataServices {
+		steps[name] = &models.DeletionStep{NextAttemptAt: now}
+	}
+	return steps
+}
+
+// DeleteAccount schedules the account for deletion after the grace period and signs
+// the user out everywhere. Logging in again during the grace period is allowed so the
+// user can cancel.
+func DeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if user.DeletionScheduledFor != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         "Account deletion is already scheduled",
+			"scheduled_for": user.DeletionScheduledFor,
+		})
+		return
+	}
+
+	now := time.Now()
+	deletion := models.AccountDeletion{
+		UserID:       objID,
+		Username:     user.Username,
+		Status:       models.DeletionPending,
+		RequestedAt:  now,
+		ScheduledFor: now.Add(deletionGracePeriod),
+		Steps:        newDeletionSteps(now),
+	}
+
+	// A cancelled earlier request is replaced by the new one
+	_, err = usersDB.Collection(accountDeletionsCollection).ReplaceOne(ctx,
+		bson.M{"user_id": objID, "status": models.DeletionCancelled},
+		deletion,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account deletion is already scheduled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+
+	if _, err := usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": objID},
+		bson.M{"$set": bson.M{"deletion_scheduled_for": deletion.ScheduledFor, "updated_at": now}},
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+
+	// Ending all sessions makes the middleware reject every outstanding access token
+	if _, err := revokeAllSessions(ctx, userID, ""); err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", userID, err)
+	}
+
+	queueEmail(&user, user.Email, "account_deletion_scheduled", map[string]interface{}{
+		"ScheduledFor": deletion.ScheduledFor,
+	}, 0)
+
+	logSecurityEvent(c, nil, "success", "delete_account", fmt.Sprintf("User %s scheduled account deletion", user.Username))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "Account scheduled for deletion",
+		"scheduled_for": deletion.ScheduledFor,
+	})
+}
+
+// GetAccountDeletion shows whether the account is scheduled for deletion
+func GetAccountDeletion(c *gin.Context) {
+	objID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx := c.Request.Context()
+
+	var deletion models.AccountDeletion
+	err := usersDB.Collection(accountDeletionsCollection).FindOne(ctx, bson.M{
+		"user_id": objID,
+		"status":  bson.M{"$ne": models.DeletionCancelled},
+	}).Decode(&deletion)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No deletion scheduled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":        deletion.Status,
+		"requested_at":  deletion.RequestedAt,
+		"scheduled_for": deletion.ScheduledFor,
+	})
+}
+
+// CancelAccountDeletion undoes a deletion request while the grace period lasts
+func CancelAccountDeletion(c *gin.Context) {
+	objID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx := c.Request.Context()
+
+	// Only a pending deletion whose grace period hasn't ended can be cancelled; the
+	// worker claims due deletions with the same condition, so the two can't both win
+	res, err := usersDB.Collection(accountDeletionsCollection).UpdateOne(ctx,
+		bson.M{
+			"user_id":       objID,
+			"status":        models.DeletionPending,
+			"scheduled_for": bson.M{"$gt": time.Now()},
+		},
+		bson.M{"$set": bson.M{"status": models.DeletionCancelled}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel account deletion"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "No cancellable deletion scheduled"})
+		return
+	}
+
+	if _, err := usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": objID},
+		bson.M{"$unset": bson.M{"deletion_scheduled_for": ""}, "$set": bson.M{"updated_at": time.Now()}},
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel account deletion"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "delete_account_cancel", fmt.Sprintf("User %s cancelled account deletion", c.GetString("username")))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled"})
+}
+
+// GetAccountDeletions lists deletions for support, e.g. ?status=in_progress to spot
+// services that keep failing
+func GetAccountDeletions(c *gin.Context) {
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.D{{Key: "scheduled_for", Value: -1}}).SetLimit(100)
+	cursor, err := usersDB.Collection(accountDeletionsCollection).Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deletions"})
+		return
+	}
+
+	deletions := []models.AccountDeletion{}
+	if err := cursor.All(ctx, &deletions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deletions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deletions": deletions})
+}
+
+// StartDeletionWorker purges accounts whose grace period has ended and retries failed
+// steps with backoff. Several replicas can run it; a lease keeps each deletion on one.
+func StartDeletionWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for {
+					deletion, err := claimDeletion(ctx)
+					if err != nil {
+						if err != mongo.ErrNoDocuments {
+							log.Printf("Failed to claim account deletion: %v", err)
+						}
+						break
+					}
+					processDeletion(ctx, deletion)
+				}
+			}
+		}
+	}()
+}
+
+func claimDeletion(ctx context.Context) (*models.AccountDeletion, error) {
+	now := time.Now()
+
+	var deletion models.AccountDeletion
+	err := usersDB.Collection(accountDeletionsCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"status":        bson.M{"$in": []string{models.DeletionPending, models.DeletionInProgress}},
+			"scheduled_for": bson.M{"$lte": now},
+			"locked_until":  bson.M{"$lt": now},
+		},
+		bson.M{"$set": bson.M{"status": models.DeletionInProgress, "locked_until": now.Add(deletionLease)}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&deletion)
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+func processDeletion(ctx context.Context, deletion *models.AccountDeletion) {
+	userID := deletion.UserID.Hex()
+	coll := usersDB.Collection(accountDeletionsCollection)
+
+	if deletion.Status == models.DeletionPending {
+		// Sessions created during the grace period end now
+		if _, err := revokeAllSessions(ctx, userID, ""); err != nil {
+			log.Printf("Failed to revoke sessions for user %s: %v", userID, err)
+		}
+		utils.LogSecurityEvent("success", "delete_account_start", "system", fmt.Sprintf("Purging data of user %s", deletion.Username))
+	}
+
+	now := time.Now()
+	var retryAt time.Time
+	scheduleRetry := func(step *models.DeletionStep) {
+		if retryAt.IsZero() || step.NextAttemptAt.Before(retryAt) {
+			retryAt = step.NextAttemptAt
+		}
+	}
+
+	for name, baseURL := range userDataServices {
+		step := deletionStep(deletion, name)
+		if step.Done {
+			continue
+		}
+		if now.Before(step.NextAttemptAt) {
+			scheduleRetry(step)
+			continue
+		}
+
+		err := purgeServiceData(ctx, baseURL, userID)
+		recordDeletionStep(ctx, deletion, name, step, err)
+		if err != nil {
+			log.Printf("Account deletion of %s failed in %s (attempt %d): %v", userID, name, step.Attempts, err)
+			scheduleRetry(step)
+		}
+	}
+
+	if step := deletionStep(deletion, usersDeletionStep); retryAt.IsZero() && !now.Before(step.NextAttemptAt) {
+		err := purgeLocalData(ctx, deletion.UserID)
+		recordDeletionStep(ctx, deletion, usersDeletionStep, step, err)
+		if err == nil {
+			completed := time.Now()
+			// Only the id is kept, as proof the deletion happened
+			if _, err := coll.UpdateOne(ctx, bson.M{"_id": deletion.ID}, bson.M{
+				"$set":   bson.M{"status": models.DeletionCompleted, "completed_at": completed},
+				"$unset": bson.M{"username": ""},
+			}); err != nil {
+				log.Printf("Failed to mark account deletion of %s completed: %v", userID, err)
+			}
+			utils.LogSecurityEvent("success", "delete_account_complete", "system", fmt.Sprintf("User %s deleted", userID))
+		} else {
+			log.Printf("Account deletion of %s failed in users (attempt %d): %v", userID, step.Attempts, err)
+			scheduleRetry(step)
+		}
+	} else if !step.Done {
+		scheduleRetry(step)
+	}
+
+	// Release the lease; keeping it until the earliest retry stops the worker from
+	// picking the deletion up again before any step is due
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": deletion.ID}, bson.M{"$set": bson.M{"locked_until": retryAt}}); err != nil {
+		log.Printf("Failed to release account deletion of %s: %v", userID, err)
+	}
+}
+
+func deletionStep(deletion *models.AccountDeletion, name string) *models.DeletionStep {
+	if deletion.Steps == nil {
+		deletion.Steps = map[string]*models.DeletionStep{}
+	}
+	if deletion.Steps[name] == nil {
+		deletion.Steps[name] = &models.DeletionStep{}
+	}
+	return deletion.Steps[name]
+}
+
+// recordDeletionStep stores the outcome of one attempt; failures back off
+// exponentially up to deletionMaxBackoff
+func recordDeletionStep(ctx context.Context, deletion *models.AccountDeletion, name string, step *models.DeletionStep, err error) {
+	now := time.Now()
+	step.Attempts++
+	if err == nil {
+		step.Done = true
+		step.LastError = ""
+		step.CompletedAt = &now
+	} else {
+		backoff := time.Minute << min(step.Attempts-1, 6)
+		if backoff > deletionMaxBackoff {
+			backoff = deletionMaxBackoff
+		}
+		step.LastError = err.Error()
+		step.NextAttemptAt = now.Add(backoff)
+	}
+
+	if _, err := usersDB.Collection(accountDeletionsCollection).UpdateOne(ctx,
+		bson.M{"_id": deletion.ID},
+		bson.M{"$set": bson.M{"steps." + name: step}},
+	); err != nil {
+		log.Printf("Failed to record account deletion step %s: %v", name, err)
+	}
+}
+
+func purgeServiceData(ctx context.Context, baseURL, userID string) error {
+	req, err := newServiceRequest(ctx, "DELETE", baseURL+"/api/v1/users/"+userID+"/data", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// purgeLocalData removes everything users-service keeps about the user, the user
+// document last so a failure part way leaves the step retryable
+func purgeLocalData(ctx context.Context, userID primitive.ObjectID) error {
+	userIDHex := userID.Hex()
+
+	if _, err := revokeAllSessions(ctx, userIDHex, ""); err != nil {
+		return fmt.Errorf("sessions: %w", err)
+	}
+	if err := purgeActivity(ctx, userIDHex); err != nil {
+		return fmt.Errorf("activity: %w", err)
+	}
+
+	cursor, err := usersDB.Collection(oauthConsentsCollection).Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return fmt.Errorf("oauth consents: %w", err)
+	}
+	var consents []models.OAuthConsent
+	if err := cursor.All(ctx, &consents); err != nil {
+		return fmt.Errorf("oauth consents: %w", err)
+	}
+	for _, consent := range consents {
+		setKey := oauthGrantSetKey(userIDHex, consent.ClientID)
+		keys, err := redisClient.SMembers(ctx, setKey).Result()
+		if err != nil {
+			return fmt.Errorf("oauth grants: %w", err)
+		}
+		if err := redisClient.Del(ctx, append(keys, setKey)...).Err(); err != nil {
+			return fmt.Errorf("oauth grants: %w", err)
+		}
+	}
+
+	cursor, err = usersDB.Collection(dataExportsCollection).Find(ctx, bson.M{"user_id": userID, "file_id": bson.M{"$exists": true}})
+	if err != nil {
+		return fmt.Errorf("data exports: %w", err)
+	}
+	var exports []models.DataExport
+	if err := cursor.All(ctx, &exports); err != nil {
+		return fmt.Errorf("data exports: %w", err)
+	}
+	if len(exports) > 0 {
+		bucket, err := gridfs.NewBucket(usersDB, options.GridFSBucket().SetName(exportBucket))
+		if err != nil {
+			return fmt.Errorf("data exports: %w", err)
+		}
+		for _, export := range exports {
+			if err := bucket.Delete(export.FileID); err != nil && err != gridfs.ErrFileNotFound {
+				return fmt.Errorf("data exports: %w", err)
+			}
+		}
+	}
+
+	cleanups := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{oauthConsentsCollection, bson.M{"user_id": userID}},
+		{emailOutboxCollection, bson.M{"user_id": userID}},
+		{loginProfilesCollection, bson.M{"user_id": userID}},
+		{oauthClientsCollection, bson.M{"owner_id": userID}},
+		{"webauthn_credentials", bson.M{"user_id": userID}},
+		{dataExportsCollection, bson.M{"user_id": userID}},
+		{followsCollection, bson.M{"$or": []bson.M{{"follower_id": userID}, {"followee_id": userID}}}},
+		{blocksCollection, bson.M{"$or": []bson.M{{"blocker_id": userID}, {"blocked_id": userID}}}},
+		{"users", bson.M{"_id": userID}},
+	}
+	for _, cleanup := range cleanups {
+		if _, err := usersDB.Collection(cleanup.collection).DeleteMany(ctx, cleanup.filter); err != nil {
+			return fmt.Errorf("%s: %w", cleanup.collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/mail"
+	"example.com/users-service/models"
+)
+
+const (
+	emailOutboxCollection = "email_outbox"
+
+	emailLease       = 2 * time.Minute
+	emailSendTimeout = 30 * time.Second
+	emailMaxBackoff  = time.Hour
+
+	// Delivered and failed messages are kept this long for the admin status view
+	emailRetention = 30 * 24 * time.Hour
+)
+
+var (
+	mailTransport    mail.Transport
+	emailMaxAttempts = 8
+	defaultLocale    = mail.DefaultLocale
+
+	// Wakes the worker so new messages don't wait for the next tick
+	emailWake = make(chan struct{}, 1)
+)
+
+// InitEmail picks the transport (see mail.TransportFromEnv) and reads EMAIL_MAX_ATTEMPTS
+// and DEFAULT_LOCALE
+func InitEmail() {
+	transport, err := mail.TransportFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure email transport: %v", err)
+	}
+	mailTransport = transport
+
+	if n, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS")); err == nil && n > 0 {
+		emailMaxAttempts = n
+	}
+	if locale := mail.NormalizeLocale(os.Getenv("DEFAULT_LOCALE")); locale != "" {
+		defaultLocale = locale
+	}
+
+	log.Printf("Email transport: %s", mailTransport.Name())
+}
+
+// EnsureEmailOutboxIndexes lets the worker find due messages, admins search by recipient
+// and drops old messages after emailRetention
+func EnsureEmailOutboxIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+			Options: options.Index().SetName("status_next_attempt"),
+		},
+		{
+			Keys:    bson.D{{Key: "to", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("to_created_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("user_id_idx").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("created_at_ttl").SetExpireAfterSeconds(int32(emailRetention.Seconds())),
+		},
+	}
+
+	if _, err := db.Collection(emailOutboxCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Fatalf("Failed to create email outbox indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for email outbox ensured")
+}
+
+// userLocale is the user's preferred language for emails
+func userLocale(user *models.User) string {
+	if locale := mail.NormalizeLocale(user.Locale); locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// queueEmail renders a template in the user's language and puts it in the outbox.
+// validFor > 0 stops retries once the code or link in the message is no longer usable.
+func queueEmail(user *models.User, to, template string, data map[string]interface{}, validFor time.Duration) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data["Name"]; !ok {
+		data["Name"] = user.FirstName
+	}
+
+	locale := userLocale(user)
+	msg, err := mail.Render(template, locale, data)
+	if err != nil {
+		log.Printf("Failed to render email %s: %v", template, err)
+		return
+	}
+
+	now := time.Now()
+	message := models.EmailMessage{
+		To:            to,
+		Template:      template,
+		Locale:        locale,
+		Subject:       msg.Subject,
+		TextBody:      msg.Text,
+		HTMLBody:      msg.HTML,
+		Status:        models.EmailQueued,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	if !user.ID.IsZero() {
+		message.UserID = &user.ID
+	}
+	if validFor > 0 {
+		expiresAt := now.Add(validFor)
+		message.ExpiresAt = &expiresAt
+	}
+
+	// Not tied to the request, a client disconnecting must not drop the message
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := usersDB.Collection(emailOutboxCollection).InsertOne(ctx, message); err != nil {
+		log.Printf("Failed to queue email %s to %s: %v", template, to, err)
+		return
+	}
+
+	select {
+	case emailWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartEmailWorker delivers queued messages, retrying failures with backoff
+func StartEmailWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-emailWake:
+			}
+
+			for {
+				message, err := claimEmail(ctx)
+				if err != nil {
+					if err != mongo.ErrNoDocuments {
+						log.Printf("Failed to claim email: %v", err)
+					}
+					break
+				}
+				deliverEmail(ctx, message)
+			}
+		}
+	}()
+}
+
+func claimEmail(ctx context.Context) (*models.EmailMessage, error) {
+	now := time.Now()
+
+	var message models.EmailMessage
+	err := usersDB.Collection(emailOutboxCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"status":          bson.M{"$in": []string{models.EmailQueued, models.EmailSending}},
+			"next_attempt_at": bson.M{"$lte": now},
+			"locked_until":    bson.M{"$lt": now},
+		},
+		bson.M{"$set": bson.M{"status": models.EmailSending, "locked_until": now.Add(emailLease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func deliverEmail(ctx context.Context, message *models.EmailMessage) {
+	coll := usersDB.Collection(emailOutboxCollection)
+	dropBodies := bson.M{"text_body": "", "html_body": ""}
+
+	if message.ExpiresAt != nil && time.Now().After(*message.ExpiresAt) {
+		coll.UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{
+			"$set":   bson.M{"status": models.EmailExpired, "locked_until": time.Time{}},
+			"$unset": dropBodies,
+		})
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	err := mailTransport.Send(sendCtx, &mail.Message{
+		ID:      message.ID.Hex(),
+		To:      message.To,
+		Subject: message.Subject,
+		Text:    message.TextBody,
+		HTML:    message.HTMLBody,
+	})
+	cancel()
+
+	message.Attempts++
+	now := time.Now()
+
+	if err == nil {
+		_, err := coll.UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{
+			"$set": bson.M{
+				"status":       models.EmailSent,
+				"transport":    mailTransport.Name(),
+				"attempts":     message.Attempts,
+				"sent_at":      now,
+				"locked_until": time.Time{},
+			},
+			"$unset": bson.M{"text_body": "", "html_body": "", "last_error": ""},
+		})
+		if err != nil {
+			log.Printf("Failed to mark email %s sent: %v", message.ID.Hex(), err)
+		}
+		return
+	}
+
+	log.Printf("Email %s (%s) to %s failed (attempt %d): %v", message.ID.Hex(), message.Template, message.To, message.Attempts, err)
+
+	set := bson.M{
+		"transport":    mailTransport.Name(),
+		"attempts":     message.Attempts,
+		"last_error":   err.Error(),
+		"locked_until": time.Time{},
+	}
+	if mail.IsPermanent(err) || message.Attempts >= emailMaxAttempts {
+		// The body is kept so an admin can retry once the cause is fixed
+		set["status"] = models.EmailFailed
+	} else {
+		backoff := 30 * time.Second << min(message.Attempts-1, 7)
+		set["status"] = models.EmailQueued
+		set["next_attempt_at"] = now.Add(min(backoff, emailMaxBackoff))
+	}
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to record email %s failure: %v", message.ID.Hex(), err)
+	}
+}
+
+// GetEmailOutbox lets admins check delivery, filtered by ?status, ?to and ?template,
+// newest first. Message bodies are never returned.
+func GetEmailOutbox(c *gin.Context) {
+	filter := bson.M{}
+	for _, field := range []string{"status", "to", "template"} {
+		if value := c.Query(field); value != "" {
+			filter[field] = value
+		}
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		objID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+		filter["user_id"] = objID
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	ctx := c.Request.Context()
+	coll := usersDB.Collection(emailOutboxCollection)
+
+	cursor, err := coll.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	messages := []models.EmailMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Totals per status across the whole outbox
+	counts := gin.H{}
+	cursor, err = coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err == nil {
+		var groups []struct {
+			Status string `bson:"_id"`
+			Count  int    `bson:"count"`
+		}
+		if cursor.All(ctx, &groups) == nil {
+			for _, g := range groups {
+				counts[g.Status] = g.Count
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages, "counts": counts})
+}
+
+// GetEmailMessage shows the delivery status of one message
+func GetEmailMessage(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id"})
+		return
+	}
+
+	var message models.EmailMessage
+	err = usersDB.Collection(emailOutboxCollection).FindOne(c.Request.Context(), bson.M{"_id": objID}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+// RetryEmailMessage queues a failed message again, e.g. after the SMTP relay was fixed.
+// Messages past their expiry are marked expired by the worker instead of being sent.
+func RetryEmailMessage(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message id"})
+		return
+	}
+
+	result, err := usersDB.Collection(emailOutboxCollection).UpdateOne(c.Request.Context(),
+		bson.M{"_id": objID, "status": models.EmailFailed},
+		bson.M{"$set": bson.M{
+			"status":          models.EmailQueued,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed messages can be retried"})
+		return
+	}
+
+	select {
+	case emailWake <- struct{}{}:
+	default:
+	}
+
+	logSecurityEvent(c, nil, "success", "email_retry", "Email "+objID.Hex()+" queued again by "+c.GetString("username"))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message queued again"})
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const emailChangeTokenTTL = 24 * time.Hour
+
+// How long the old address can undo a change; it stays reserved for the account meanwhile
+var emailRevertTTL = 7 * 24 * time.Hour
+
+// InitEmailChange reads EMAIL_CHANGE_REVERT_TTL
+func InitEmailChange() {
+	if d, err := time.ParseDuration(os.Getenv("EMAIL_CHANGE_REVERT_TTL")); err == nil && d > 0 {
+		emailRevertTTL = d
+	}
+}
+
+// emailInUse reports whether another account uses the address, is changing to it, or
+// can still revert to it
+func emailInUse(ctx context.Context, email string, exceptID primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": bson.M{"$ne": exceptID},
+		"$or": bson.A{
+			bson.M{"email": email},
+			bson.M{"pending_email": email, "email_change_token_exp": bson.M{"$gt": now}},
+			bson.M{"previous_email": email, "email_revert_token_exp": bson.M{"$gt": now}},
+		},
+	}
+	count, err := usersDB.Collection("users").CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+func emailChangeLink(path, token string) string {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	return fmt.Sprintf("%s/api/v1/%s?token=%s", baseURL, path, token)
+}
+
+// RequestEmailChange starts changing the account email. The new address gets a
+// verification link and the current one a link to revert the change.
+func RequestEmailChange(c *gin.Context) {
+	var req models.ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logSecurityEvent(c, nil, "validation_failed", "change_email", "Invalid request data")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	req.NewEmail = utils.SanitizeString(req.NewEmail)
+	if !utils.ValidateEmail(req.NewEmail) {
+		logSecurityEvent(c, nil, "validation_failed", "change_email", "Invalid email format")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	objID, _ := primitive.ObjectIDFromHex(userID.(string))
+	ctx := c.Request.Context()
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if match, _ := utils.VerifyPassword(user.PasswordHash, req.Password); !match {
+		logSecurityEvent(c, nil, "failed", "change_email", fmt.Sprintf("User %s entered invalid password", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	if req.NewEmail == user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New email must be different from the current one"})
+		return
+	}
+
+	inUse, err := emailInUse(ctx, req.NewEmail, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if inUse {
+		logSecurityEvent(c, nil, "validation_failed", "change_email", fmt.Sprintf("User %s requested an email already in use", user.Username))
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	changeToken, err := utils.GenerateVerificationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate verification token"})
+		return
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"pending_email":          req.NewEmail,
+		"email_change_token":     changeToken,
+		"email_change_token_exp": now.Add(emailChangeTokenTTL),
+		"updated_at":             now,
+	}
+
+	// While an earlier change can still be reverted, that link keeps pointing to the
+	// original address; otherwise a second change could take the revert away from its owner
+	revertToken := ""
+	revertExp := user.EmailRevertTokenExp
+	if !now.Before(user.EmailRevertTokenExp) {
+		revertToken, err = utils.GenerateVerificationToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate verification token"})
+			return
+		}
+		revertExp = now.Add(emailRevertTTL)
+		set["previous_email"] = user.Email
+		set["email_revert_token"] = revertToken
+		set["email_revert_token_exp"] = revertExp
+	}
+
+	// Expired requests of other accounts must not hold the address through the unique index
+	_, err = usersDB.Collection("users").UpdateMany(ctx,
+		bson.M{"pending_email": req.NewEmail, "email_change_token_exp": bson.M{"$lte": now}},
+		bson.M{"$unset": bson.M{"pending_email": "", "email_change_token": "", "email_change_token_exp": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request email change"})
+		return
+	}
+
+	queueEmail(&user, req.NewEmail, "email_change_verify", map[string]interface{}{
+		"Link":     emailChangeLink("verify-email-change", changeToken),
+		"NewEmail": req.NewEmail,
+		"Hours":    24,
+	}, emailChangeTokenTTL)
+
+	// Without a revert link the notice points to the one sent with the earlier change
+	revertLink := ""
+	if revertToken != "" {
+		revertLink = emailChangeLink("revert-email-change", revertToken)
+	}
+	queueEmail(&user, user.Email, "email_change_notice", map[string]interface{}{
+		"Link":        revertLink,
+		"NewEmail":    req.NewEmail,
+		"RevertUntil": revertExp,
+	}, 0)
+
+	logSecurityEvent(c, nil, "success", "change_email_request", fmt.Sprintf("User %s requested email change", user.Username))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "Please check your new email to confirm the change",
+		"pending_email": req.NewEmail,
+	})
+}
+
+// CancelEmailChange drops a pending email change that hasn't been confirmed yet
+func CancelEmailChange(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	objID, _ := primitive.ObjectIDFromHex(userID.(string))
+	ctx := c.Request.Context()
+
+	result, err := usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": objID, "pending_email": bson.M{"$exists": true}},
+		bson.M{
+			"$set":   bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{"pending_email": "", "email_change_token": "", "email_change_token_exp": ""},
+		},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel email change"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending email change"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "change_email_cancel", "Pending email change cancelled")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email change cancelled"})
+}
+
+// VerifyEmailChange confirms the new address from the link sent to it
+func VerifyEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var user models.User
+	err := usersDB.Collection("users").FindOne(ctx, bson.M{
+		"email_change_token":     token,
+		"email_change_token_exp": bson.M{"$gt": time.Now()},
+	}).Decode(&user)
+	if err != nil {
+		logSecurityEvent(c, nil, "failed", "change_email_verify", "Invalid or expired token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	clearPending := bson.M{"pending_email": "", "email_change_token": "", "email_change_token_exp": ""}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "email_change_token": token},
+		bson.M{
+			"$set": bson.M{
+				"email":          user.PendingEmail,
+				"email_verified": true,
+				"updated_at":     time.Now(),
+			},
+			"$unset": clearPending,
+		},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Another account registered the address after the change was requested
+			usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$unset": clearPending})
+			logSecurityEvent(c, &user, "failed", "change_email_verify", fmt.Sprintf("User %s new email already taken", user.Username))
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+		return
+	}
+
+	logSecurityEvent(c, &user, "success", "change_email_verify", fmt.Sprintf("User %s changed email", user.Username))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email changed successfully",
+		"email":   user.PendingEmail,
+	})
+}
+
+// RevertEmailChange restores the previous address from the link sent to it. A change the
+// owner didn't make means someone else got in, so every session is signed out.
+func RevertEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var user models.User
+	err := usersDB.Collection("users").FindOne(ctx, bson.M{
+		"email_revert_token":     token,
+		"email_revert_token_exp": bson.M{"$gt": time.Now()},
+	}).Decode(&user)
+	if err != nil {
+		logSecurityEvent(c, nil, "failed", "change_email_revert", "Invalid or expired token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired revert link"})
+		return
+	}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "email_revert_token": token},
+		bson.M{
+			"$set": bson.M{
+				"email":          user.PreviousEmail,
+				"email_verified": true,
+				"updated_at":     time.Now(),
+			},
+			"$unset": bson.M{
+				"pending_email":          "",
+				"email_change_token":     "",
+				"email_change_token_exp": "",
+				"previous_email":         "",
+				"email_revert_token":     "",
+				"email_revert_token_exp": "",
+			},
+		},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert email change"})
+		return
+	}
+
+	if _, err := revokeAllSessions(ctx, user.ID.Hex(), ""); err != nil {
+		log.Printf("Failed to revoke sessions for %s: %v", user.Username, err)
+	}
+
+	logSecurityEvent(c, &user, "success", "change_email_revert", fmt.Sprintf("User %s reverted email change", user.Username))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email change reverted and all devices signed out. We recommend resetting your password.",
+		"email":   user.PreviousEmail,
+	})
+}
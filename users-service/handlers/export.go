@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+)
+
+const (
+	dataExportsCollection = "data_exports"
+	// GridFS bucket holding the finished archives
+	exportBucket = "export_files"
+
+	exportLease       = 10 * time.Minute
+	exportMaxAttempts = 5
+)
+
+var (
+	// How long the download link stays valid
+	exportLinkTTL = 48 * time.Hour
+	// Minimum time between two export requests of the same user
+	exportCooldown = 24 * time.Hour
+
+	notificationsServiceURL = serviceURL("NOTIFICATIONS_SERVICE_URL", "http://notifications-service:8005")
+)
+
+// InitDataExport reads the export configuration
+func InitDataExport() {
+	if d, err := time.ParseDuration(os.Getenv("EXPORT_LINK_TTL")); err == nil && d > 0 {
+		exportLinkTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("EXPORT_COOLDOWN")); err == nil && d >= 0 {
+		exportCooldown = d
+	}
+}
+
+// EnsureDataExportIndexes creates indexes for the export worker and download links
+func EnsureDataExportIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "requested_at", Value: -1}},
+			Options: options.Index().SetName("user_requested_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+			Options: options.Index().SetName("status_next_attempt_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetName("token_hash_idx").SetSparse(true),
+		},
+	}
+
+	if _, err := db.Collection(dataExportsCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for data_exports ensured")
+}
+
+func latestExport(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error) {
+	var export models.DataExport
+	err := usersDB.Collection(dataExportsCollection).FindOne(ctx,
+		bson.M{"user_id": userID},
+		options.FindOne().SetSort(bson.D{{Key: "requested_at", Value: -1}}),
+	).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// RequestDataExport queues a "download my data" job; the user is notified when the
+// archive is ready
+func RequestDataExport(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx := c.Request.Context()
+
+	last, err := latestExport(ctx, userID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if last != nil {
+		if last.Status == models.ExportPending || last.Status == models.ExportProcessing {
+			c.JSON(http.StatusConflict, gin.H{"error": "An export is already being prepared", "export": last})
+			return
+		}
+		if last.Status != models.ExportFailed && time.Since(last.RequestedAt) < exportCooldown {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":           "You can request one export per day",
+				"next_request_at": last.RequestedAt.Add(exportCooldown),
+			})
+			return
+		}
+	}
+
+	now := time.Now()
+	export := models.DataExport{
+		UserID:        userID,
+		Status:        models.ExportPending,
+		RequestedAt:   now,
+		NextAttemptAt: now,
+	}
+	res, err := usersDB.Collection(dataExportsCollection).InsertOne(ctx, export)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request export"})
+		return
+	}
+	export.ID = res.InsertedID.(primitive.ObjectID)
+
+	logSecurityEvent(c, nil, "success", "data_export_request", fmt.Sprintf("User %s requested a data export", c.GetString("username")))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Your export is being prepared. We will notify you when it is ready.",
+		"export":  export,
+	})
+}
+
+// GetDataExport shows the status of the user's latest export
+func GetDataExport(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	export, err := latestExport(c.Request.Context(), userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No export requested"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// DownloadDataExport streams the archive. The link from the notification is the only
+// credential, so it can be opened straight from the email.
+func DownloadDataExport(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var export models.DataExport
+	err := usersDB.Collection(dataExportsCollection).FindOne(ctx, bson.M{
+		"token_hash": hashRefreshSecret(token),
+		"status":     models.ExportReady,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&export)
+	if err != nil {
+		logSecurityEvent(c, nil, "failed", "data_export_download", "Invalid or expired export link")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired download link"})
+		return
+	}
+
+	bucket, err := gridfs.NewBucket(usersDB, options.GridFSBucket().SetName(exportBucket))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open export"})
+		return
+	}
+	stream, err := bucket.OpenDownloadStream(export.FileID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open export"})
+		return
+	}
+	defer stream.Close()
+
+	logSecurityEvent(c, nil, "success", "data_export_download", fmt.Sprintf("Export %s downloaded", export.ID.Hex()))
+
+	filename := fmt.Sprintf("data-export-%s.zip", export.RequestedAt.Format("2006-01-02"))
+	c.DataFromReader(http.StatusOK, export.Size, "application/zip", stream, map[string]string{
+		"Content-Disposition": `attachment; filename="` + filename + `"`,
+		"Cache-Control":       "no-store",
+	})
+}
+
+// StartExportWorker builds queued exports and drops archives whose link has expired
+func StartExportWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for {
+					export, err := claimExport(ctx)
+					if err != nil {
+						if err != mongo.ErrNoDocuments {
+							log.Printf("Failed to claim data export: %v", err)
+						}
+						break
+					}
+					processExport(ctx, export)
+				}
+				expireExports(ctx)
+			}
+		}
+	}()
+}
+
+func claimExport(ctx context.Context) (*models.DataExport, error) {
+	now := time.Now()
+
+	var export models.DataExport
+	err := usersDB.Collection(dataExportsCollection).FindOneAndUpdate(ctx,
+		bson.M{
+			"status":          bson.M{"$in": []string{models.ExportPending, models.ExportProcessing}},
+			"next_attempt_at": bson.M{"$lte": now},
+			"locked_until":    bson.M{"$lt": now},
+		},
+		bson.M{"$set": bson.M{"status": models.ExportProcessing, "locked_until": now.Add(exportLease)}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func processExport(ctx context.Context, export *models.DataExport) {
+	coll := usersDB.Collection(dataExportsCollection)
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": export.UserID}).Decode(&user); err != nil {
+		// Account deleted in the meantime, nothing left to export
+		coll.UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{"$set": bson.M{"status": models.ExportFailed, "last_error": "user not found"}})
+		return
+	}
+
+	fileID, size, err := buildExportArchive(ctx, export, &user)
+	if err != nil {
+		export.Attempts++
+		update := bson.M{"attempts": export.Attempts, "last_error": err.Error(), "locked_until": time.Time{}}
+		if export.Attempts >= exportMaxAttempts {
+			update["status"] = models.ExportFailed
+			notifyExportFailed(&user)
+		} else {
+			update["next_attempt_at"] = time.Now().Add(time.Minute << export.Attempts)
+		}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{"$set": update}); err != nil {
+			log.Printf("Failed to record data export failure: %v", err)
+		}
+		log.Printf("Data export %s failed (attempt %d): %v", export.ID.Hex(), export.Attempts, err)
+		return
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		log.Printf("Failed to generate export link: %v", err)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(exportLinkTTL)
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{
+		"$set": bson.M{
+			"status":       models.ExportReady,
+			"file_id":      fileID,
+			"size":         size,
+			"token_hash":   hashRefreshSecret(token),
+			"completed_at": now,
+			"expires_at":   expiresAt,
+			"locked_until": time.Time{},
+		},
+		"$unset": bson.M{"last_error": ""},
+	}); err != nil {
+		log.Printf("Failed to mark data export %s ready: %v", export.ID.Hex(), err)
+		return
+	}
+
+	notifyExportReady(&user, token, expiresAt)
+}
+
+// buildExportArchive collects the user's data from every service into a ZIP of JSON
+// files and stores it in GridFS
+func buildExportArchive(ctx context.Context, export *models.DataExport, user *models.User) (primitive.ObjectID, int64, error) {
+	files, err := collectLocalData(ctx, user)
+	if err != nil {
+		return primitive.NilObjectID, 0, err
+	}
+
+	for name, baseURL := range userDataServices {
+		data, err := fetchServiceData(ctx, baseURL, user.ID.Hex())
+		if err != nil {
+			return primitive.NilObjectID, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		files[name+".json"] = data
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: export.RequestedAt})
+		if err != nil {
+			return primitive.NilObjectID, 0, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return primitive.NilObjectID, 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return primitive.NilObjectID, 0, err
+	}
+
+	bucket, err := gridfs.NewBucket(usersDB, options.GridFSBucket().SetName(exportBucket))
+	if err != nil {
+		return primitive.NilObjectID, 0, err
+	}
+	size := int64(buf.Len())
+	fileID, err := bucket.UploadFromStream(export.ID.Hex()+".zip", &buf)
+	if err != nil {
+		return primitive.NilObjectID, 0, err
+	}
+	return fileID, size, nil
+}
+
+// collectLocalData gathers what users-service itself knows about the user
+func collectLocalData(ctx context.Context, user *models.User) (map[string][]byte, error) {
+	userIDHex := user.ID.Hex()
+	sections := map[string]interface{}{"profile.json": user}
+
+	sessionIDs, err := redisClient.SMembers(ctx, userSessionsKey(userIDHex)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("sessions: %w", err)
+	}
+	sessions := []gin.H{}
+	for _, id := range sessionIDs {
+		session, err := loadSession(ctx, id)
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, gin.H{
+			"device":     session.Device,
+			"user_agent": session.UserAgent,
+			"ip":         session.IP,
+			"created_at": session.CreatedAt,
+			"last_seen":  session.LastSeen,
+			"expires_at": session.ExpiresAt,
+		})
+	}
+	sections["sessions.json"] = sessions
+
+	queries := []struct {
+		file       string
+		collection string
+		filter     bson.M
+		out        interface{}
+	}{
+		{"passkeys.json", "webauthn_credentials", bson.M{"user_id": user.ID}, &[]models.WebAuthnCredential{}},
+		{"authorized_apps.json", oauthConsentsCollection, bson.M{"user_id": user.ID}, &[]models.OAuthConsent{}},
+		{"developer_apps.json", oauthClientsCollection, bson.M{"owner_id": user.ID}, &[]models.OAuthClient{}},
+		{"role_history.json", roleAuditCollection, bson.M{"target_id": user.ID}, &[]models.RoleAuditEntry{}},
+		{"emails.json", emailOutboxCollection, bson.M{"user_id": user.ID}, &[]models.EmailMessage{}},
+		{"known_devices.json", loginProfilesCollection, bson.M{"user_id": user.ID}, &[]models.LoginProfile{}},
+		{"security_events.json", securityEventsCollection, bson.M{"actor_id": user.ID.Hex()}, &[]models.SecurityEvent{}},
+		{"follows.json", followsCollection, bson.M{"$or": []bson.M{{"follower_id": user.ID}, {"followee_id": user.ID}}}, &[]models.Follow{}},
+		{"blocked_users.json", blocksCollection, bson.M{"blocker_id": user.ID}, &[]models.Block{}},
+	}
+	for _, q := range queries {
+		cursor, err := usersDB.Collection(q.collection).Find(ctx, q.filter)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.collection, err)
+		}
+		if err := cursor.All(ctx, q.out); err != nil {
+			return nil, fmt.Errorf("%s: %w", q.collection, err)
+		}
+		sections[q.file] = q.out
+	}
+
+	files := map[string][]byte{}
+	for name, section := range sections {
+		data, err := json.MarshalIndent(section, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+func fetchServiceData(ctx context.Context, baseURL, userID string) ([]byte, error) {
+	req, err := newServiceRequest(ctx, "GET", baseURL+"/api/v1/users/"+userID+"/data", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func notifyExportReady(user *models.User, token string, expiresAt time.Time) {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	link := fmt.Sprintf("%s/api/v1/profile/export/download?token=%s", baseURL, token)
+
+	queueEmail(user, user.Email, "data_export_ready", map[string]interface{}{
+		"Link":      link,
+		"ExpiresAt": expiresAt,
+	}, time.Until(expiresAt))
+
+	go sendNotification(user.ID.Hex(), "data_export", "Your data export is ready. Check your email for the download link.")
+}
+
+func notifyExportFailed(user *models.User) {
+	queueEmail(user, user.Email, "data_export_failed", nil, 0)
+
+	go sendNotification(user.ID.Hex(), "data_export", "We could not prepare your data export. Please request it again later.")
+}
+
+// sendNotification posts an in-app notification through notifications-service
+func sendNotification(userID, notificationType, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]string{
+		"user_id": userID,
+		"message": message,
+		"type":    notificationType,
+	})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", notificationsServiceURL+"/api/v1/notifications", bytes.NewBuffer(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to send notification to %s: %v", userID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// expireExports deletes archives whose download link has lapsed
+func expireExports(ctx context.Context) {
+	coll := usersDB.Collection(dataExportsCollection)
+
+	cursor, err := coll.Find(ctx, bson.M{"status": models.ExportReady, "expires_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		log.Printf("Failed to look up expired exports: %v", err)
+		return
+	}
+	var expired []models.DataExport
+	if err := cursor.All(ctx, &expired); err != nil {
+		log.Printf("Failed to look up expired exports: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	bucket, err := gridfs.NewBucket(usersDB, options.GridFSBucket().SetName(exportBucket))
+	if err != nil {
+		log.Printf("Failed to open export bucket: %v", err)
+		return
+	}
+	for _, export := range expired {
+		if err := bucket.Delete(export.FileID); err != nil && err != gridfs.ErrFileNotFound {
+			log.Printf("Failed to delete export archive %s: %v", export.ID.Hex(), err)
+			continue
+		}
+		coll.UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{
+			"$set":   bson.M{"status": models.ExportExpired},
+			"$unset": bson.M{"file_id": "", "token_hash": ""},
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+)
+
+// Activity is fanned out on write: each follower's feed is a sorted set of activity
+// ids scored by time (unix millis), the activity itself is stored once. Feeds only keep
+// the newest entries; activity older than activityTTL disappears from them.
+const (
+	feedMaxEntries = 500
+	activityTTL    = 30 * 24 * time.Hour
+	// Followers written to Redis per pipeline while fanning out
+	feedFanOutBatch = 500
+	// Entries copied into a feed when its owner starts following someone
+	feedBackfillEntries = 50
+)
+
+func activityKey(id string) string {
+	return "activity:" + id
+}
+
+func feedKey(userID string) string {
+	return "feed:" + userID
+}
+
+// The user's own recent activity, used to fill the feed of a new follower
+func userActivitiesKey(userID string) string {
+	return "activities:" + userID
+}
+
+// addToFeed queues adding an activity to a sorted set, trimmed to the newest entries
+func addToFeed(ctx context.Context, pipe redis.Pipeliner, key string, entries ...redis.Z) {
+	pipe.ZAdd(ctx, key, entries...)
+	pipe.ZRemRangeByRank(ctx, key, 0, -feedMaxEntries-1)
+	pipe.Expire(ctx, key, activityTTL)
+}
+
+// RecordActivity takes activity from the other services and writes it to the feeds of
+// the user's followers, unless the user keeps that type of activity to themselves.
+// Internal route for callers holding the service token, not exposed through the gateway.
+func RecordActivity(c *gin.Context) {
+	var req models.ActivityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	objID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.DeletionScheduledFor != nil || user.Visibility() == models.ProfilePrivate || !user.SharesActivity(req.Type) {
+		c.JSON(http.StatusAccepted, gin.H{"shared": false})
+		return
+	}
+
+	id, err := randomHex(12)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record activity"})
+		return
+	}
+	activity := models.Activity{
+		ID:        id,
+		UserID:    req.UserID,
+		Type:      req.Type,
+		TargetID:  req.TargetID,
+		Name:      req.Name,
+		Rating:    req.Rating,
+		CreatedAt: req.CreatedAt,
+	}
+	if activity.CreatedAt.IsZero() || activity.CreatedAt.After(time.Now()) {
+		activity.CreatedAt = time.Now()
+	}
+	raw, err := json.Marshal(activity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record activity"})
+		return
+	}
+
+	entry := redis.Z{Score: float64(activity.CreatedAt.UnixMilli()), Member: id}
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, activityKey(id), raw, activityTTL)
+	addToFeed(ctx, pipe, userActivitiesKey(req.UserID), entry)
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record activity"})
+		return
+	}
+
+	followers, err := fanOutActivity(ctx, objID, entry)
+	if err != nil {
+		log.Printf("Failed to fan out activity %s of %s: %v", id, req.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deliver activity"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "shared": true, "followers": followers})
+}
+
+// fanOutActivity adds an activity to the feed of everyone following the user
+func fanOutActivity(ctx context.Context, userID primitive.ObjectID, entry redis.Z) (int, error) {
+	cursor, err := usersDB.Collection(followsCollection).Find(ctx,
+		bson.M{"followee_id": userID, "status": models.FollowAccepted},
+		options.Find().SetProjection(bson.M{"follower_id": 1}).SetBatchSize(feedFanOutBatch))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	delivered := 0
+	pipe := redisClient.Pipeline()
+	for cursor.Next(ctx) {
+		var follow models.Follow
+		if err := cursor.Decode(&follow); err != nil {
+			return delivered, err
+		}
+		addToFeed(ctx, pipe, feedKey(follow.FollowerID.Hex()), entry)
+		delivered++
+
+		if pipe.Len() >= feedFanOutBatch*3 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return delivered, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return delivered, err
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// backfillFeed copies the recent activity of a newly followed user into the follower's
+// feed, so it doesn't start out empty
+func backfillFeed(ctx context.Context, followerID, followeeID primitive.ObjectID) {
+	entries, err := redisClient.ZRevRangeWithScores(ctx, userActivitiesKey(followeeID.Hex()), 0, feedBackfillEntries-1).Result()
+	if err != nil || len(entries) == 0 {
+		return
+	}
+
+	pipe := redisClient.Pipeline()
+	addToFeed(ctx, pipe, feedKey(followerID.Hex()), entries...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to backfill feed of %s: %v", followerID.Hex(), err)
+	}
+}
+
+// GetFeed shows what the people the user follows have been doing, newest first. Older
+// pages are fetched with before=<next_before>.
+func GetFeed(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+	maxScore := "+inf"
+	if before := c.Query("before"); before != "" {
+		if _, err := strconv.ParseInt(before, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before"})
+			return
+		}
+		maxScore = "(" + before
+	}
+
+	userID := c.GetString("user_id")
+	objID, _ := primitive.ObjectIDFromHex(userID)
+	ctx := c.Request.Context()
+
+	entries, err := redisClient.ZRevRangeByScoreWithScores(ctx, feedKey(userID), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   maxScore,
+		Count: limit,
+	}).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+		return
+	}
+
+	activities, err := loadActivities(ctx, entries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+		return
+	}
+	actors, err := followedActors(ctx, objID, activities)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load feed"})
+		return
+	}
+
+	// Drop what the user may no longer see: activity that expired, of people they
+	// unfollowed or who blocked them, or that its author stopped sharing
+	items := []gin.H{}
+	var stale []interface{}
+	for i, activity := range activities {
+		if activity == nil {
+			stale = append(stale, entries[i].Member)
+			continue
+		}
+		actor := actors[activity.UserID]
+		if actor == nil {
+			stale = append(stale, entries[i].Member)
+			continue
+		}
+		if actor.Visibility() == models.ProfilePrivate || !actor.SharesActivity(activity.Type) {
+			continue
+		}
+
+		item := gin.H{
+			"id":         activity.ID,
+			"type":       activity.Type,
+			"user":       userCard(actor),
+			"target_id":  activity.TargetID,
+			"created_at": activity.CreatedAt,
+		}
+		if activity.Name != "" {
+			item["name"] = activity.Name
+		}
+		if activity.Rating != 0 {
+			item["rating"] = activity.Rating
+		}
+		items = append(items, item)
+	}
+	if len(stale) > 0 {
+		redisClient.ZRem(ctx, feedKey(userID), stale...)
+	}
+
+	response := gin.H{"items": items}
+	if int64(len(entries)) == limit {
+		response["next_before"] = strconv.FormatInt(int64(entries[len(entries)-1].Score), 10)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// loadActivities returns the activity of each feed entry, nil where it has expired
+func loadActivities(ctx context.Context, entries []redis.Z) ([]*models.Activity, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = activityKey(entry.Member.(string))
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	activities := make([]*models.Activity, len(entries))
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var activity models.Activity
+		if err := json.Unmarshal([]byte(raw), &activity); err == nil {
+			activities[i] = &activity
+		}
+	}
+	return activities, nil
+}
+
+// followedActors loads the authors of the activities the user still follows
+func followedActors(ctx context.Context, userID primitive.ObjectID, activities []*models.Activity) (map[string]*models.User, error) {
+	seen := map[primitive.ObjectID]bool{}
+	var ids []primitive.ObjectID
+	for _, activity := range activities {
+		if activity == nil {
+			continue
+		}
+		objID, err := primitive.ObjectIDFromHex(activity.UserID)
+		if err != nil || seen[objID] {
+			continue
+		}
+		seen[objID] = true
+		ids = append(ids, objID)
+	}
+	actors := map[string]*models.User{}
+	if len(ids) == 0 {
+		return actors, nil
+	}
+
+	cursor, err := usersDB.Collection(followsCollection).Find(ctx, bson.M{
+		"follower_id": userID,
+		"followee_id": bson.M{"$in": ids},
+		"status":      models.FollowAccepted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var follows []models.Follow
+	if err := cursor.All(ctx, &follows); err != nil {
+		return nil, err
+	}
+	followed := make([]primitive.ObjectID, 0, len(follows))
+	for _, follow := range follows {
+		followed = append(followed, follow.FolloweeID)
+	}
+	if len(followed) == 0 {
+		return actors, nil
+	}
+
+	cursor, err = usersDB.Collection("users").Find(ctx, bson.M{
+		"_id":                    bson.M{"$in": followed},
+		"deletion_scheduled_for": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	for i := range users {
+		actors[users[i].ID.Hex()] = &users[i]
+	}
+	return actors, nil
+}
+
+func activitySharing(user *models.User) gin.H {
+	sharing := gin.H{}
+	for _, activityType := range models.ActivityTypes {
+		sharing[activityType] = user.SharesActivity(activityType)
+	}
+	return sharing
+}
+
+// GetActivitySharing shows which activity goes to the user's followers
+func GetActivitySharing(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sharing": activitySharing(user)})
+}
+
+// UpdateActivitySharing turns sharing of activity types on or off, e.g.
+// {"rating": false}. Hidden activity also disappears from feeds it already reached.
+func UpdateActivitySharing(c *gin.Context) {
+	var req map[string]bool
+	if err := c.ShouldBindJSON(&req); err != nil || len(req) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	hidden := []string{}
+	for _, activityType := range models.ActivityTypes {
+		share, set := req[activityType]
+		if !set {
+			share = user.SharesActivity(activityType)
+		}
+		if !share {
+			hidden = append(hidden, activityType)
+		}
+		delete(req, activityType)
+	}
+	if len(req) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown activity type, expected rating or artist_follow"})
+		return
+	}
+
+	_, err := usersDB.Collection("users").UpdateOne(c.Request.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"hidden_activity": hidden, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update sharing"})
+		return
+	}
+	user.HiddenActivity = hidden
+
+	c.JSON(http.StatusOK, gin.H{"sharing": activitySharing(user)})
+}
+
+// purgeActivity removes the user's activity and feed
+func purgeActivity(ctx context.Context, userID string) error {
+	ids, err := redisClient.ZRange(ctx, userActivitiesKey(userID), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userActivitiesKey(userID), feedKey(userID)}
+	for _, id := range ids {
+		keys = append(keys, activityKey(id))
+	}
+	return redisClient.Del(ctx, keys...).Err()
+}
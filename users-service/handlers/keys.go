@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const signingKeysCollection = "signing_keys"
+
+var (
+	signingAlgorithm = utils.AlgEdDSA
+	// A new key takes over at the start of every rotation period
+	keyRotationInterval = 30 * 24 * time.Hour
+	// New keys appear in the JWKS this long before they sign anything, so
+	// verifiers refresh their cache before the first token with the new kid arrives
+	keyPublishAhead = 15 * time.Minute
+)
+
+// InitSigningKeys reads the key configuration and makes sure a signing key exists
+// before the server accepts logins
+func InitSigningKeys() {
+	switch alg := os.Getenv("JWT_SIGNING_ALG"); alg {
+	case "":
+	case utils.AlgEdDSA, utils.AlgRS256:
+		signingAlgorithm = alg
+	default:
+		log.Fatalf("Unsupported JWT_SIGNING_ALG %q (use EdDSA or RS256)", alg)
+	}
+	if d, err := time.ParseDuration(os.Getenv("JWT_KEY_ROTATION")); err == nil && d > 0 {
+		keyRotationInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("JWT_KEY_PUBLISH_AHEAD")); err == nil && d >= 0 {
+		keyPublishAhead = d
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := rotateSigningKeys(ctx); err != nil {
+		log.Fatalf("Failed to initialize JWT signing keys: %v", err)
+	}
+}
+
+// EnsureSigningKeyIndexes makes key creation race-free across replicas and lets
+// MongoDB drop keys once nothing they signed can still be valid
+func EnsureSigningKeyIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "period", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("unique_period"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0).SetName("expires_at_ttl"),
+		},
+	}
+
+	if _, err := db.Collection(signingKeysCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for signing_keys ensured")
+}
+
+// StartKeyRotation creates upcoming keys on schedule and reloads keys created by other replicas
+func StartKeyRotation(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				if err := rotateSigningKeys(rctx); err != nil {
+					log.Printf("JWT key rotation failed: %v", err)
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+func keyPeriod(t time.Time) int64 {
+	return t.Unix() / int64(keyRotationInterval/time.Second)
+}
+
+func periodStart(period int64) time.Time {
+	return time.Unix(period*int64(keyRotationInterval/time.Second), 0)
+}
+
+// rotateSigningKeys ensures keys exist for the current period and, once inside the
+// publish-ahead window, for the next one, then loads every unexpired key
+func rotateSigningKeys(ctx context.Context) error {
+	now := time.Now()
+
+	periods := []int64{keyPeriod(now)}
+	if next := keyPeriod(now.Add(keyPublishAhead)); next != periods[0] {
+		periods = append(periods, next)
+	}
+	for _, period := range periods {
+		if err := createSigningKey(ctx, period); err != nil {
+			return err
+		}
+	}
+
+	cursor, err := usersDB.Collection(signingKeysCollection).Find(ctx, bson.M{"expires_at": bson.M{"$gt": now}})
+	if err != nil {
+		return err
+	}
+	var stored []models.SigningKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+
+	keys := make([]*utils.SigningKey, 0, len(stored))
+	for _, s := range stored {
+		private, err := utils.ParsePrivateKey(s.PrivateKey)
+		if err != nil {
+			log.Printf("Skipping unreadable signing key %s: %v", s.KID, err)
+			continue
+		}
+		keys = append(keys, &utils.SigningKey{
+			KID:        s.KID,
+			Algorithm:  s.Algorithm,
+			Private:    private,
+			ActiveFrom: s.ActiveFrom,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+	utils.SetSigningKeys(keys)
+	return nil
+}
+
+// createSigningKey inserts the key for a period unless another replica already did
+func createSigningKey(ctx context.Context, period int64) error {
+	count, err := usersDB.Collection(signingKeysCollection).CountDocuments(ctx, bson.M{"period": period})
+	if err != nil || count > 0 {
+		return err
+	}
+
+	private, err := utils.GenerateSigningKey(signingAlgorithm)
+	if err != nil {
+		return err
+	}
+	encoded, err := utils.MarshalPrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	// The key stops signing when the next period starts; tokens it signed last
+	// stay valid for one more access token lifetime
+	key := models.SigningKey{
+		KID:        utils.KeyID(private),
+		Algorithm:  signingAlgorithm,
+		PrivateKey: encoded,
+		Period:     period,
+		CreatedAt:  time.Now(),
+		ActiveFrom: periodStart(period),
+		ExpiresAt:  periodStart(period + 1).Add(utils.AccessTokenTTL + time.Minute),
+	}
+
+	_, err = usersDB.Collection(signingKeysCollection).InsertOne(ctx, key)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err == nil {
+		log.Printf("Created JWT signing key %s (%s), active from %s", key.KID, key.Algorithm, key.ActiveFrom.Format(time.RFC3339))
+	}
+	return err
+}
+
+// GetJWKS publishes the public signing keys for the other services
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": utils.PublicJWKS()})
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"example.com/users-service/models"
+)
+
+// How long a push approval can be answered
+const loginApprovalTTL = 5 * time.Minute
+
+func loginApprovalKey(id string) string {
+	return "login_approval:" + id
+}
+func userLoginApprovalsKey(userID string) string {
+	return "login_approvals:" + userID
+}
+
+// createLoginApproval stores a pending approval for the login, replacing an earlier one
+func createLoginApproval(ctx context.Context, user *models.User, ch *loginChallenge) (*models.LoginApproval, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	approval := &models.LoginApproval{
+		ID:        id,
+		UserID:    user.ID.Hex(),
+		Status:    models.ApprovalPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(loginApprovalTTL),
+	}
+	if ch.Risk != nil {
+		approval.Device = ch.Risk.Device
+		approval.Place = ch.Risk.Place
+		approval.Reasons = ch.Risk.Reasons
+	}
+	raw, err := json.Marshal(approval)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := redisClient.TxPipeline()
+	if ch.ApprovalID != "" {
+		pipe.Del(ctx, loginApprovalKey(ch.ApprovalID))
+		pipe.SRem(ctx, userLoginApprovalsKey(approval.UserID), ch.ApprovalID)
+	}
+	pipe.Set(ctx, loginApprovalKey(id), raw, loginApprovalTTL)
+	pipe.SAdd(ctx, userLoginApprovalsKey(approval.UserID), id)
+	pipe.Expire(ctx, userLoginApprovalsKey(approval.UserID), loginApprovalTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	if err := ch.setApproval(ctx, id); err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// loadLoginApproval returns the approval, or nil once it's gone or expired
+func loadLoginApproval(ctx context.Context, id string) (*models.LoginApproval, error) {
+	raw, err := redisClient.Get(ctx, loginApprovalKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var approval models.LoginApproval
+	if err := json.Unmarshal(raw, &approval); err != nil {
+		return nil, err
+	}
+	return &approval, nil
+}
+
+func deleteLoginApproval(ctx context.Context, userID, id string) {
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, loginApprovalKey(id))
+	pipe.SRem(ctx, userLoginApprovalsKey(userID), id)
+	pipe.Exec(ctx)
+}
+
+func loginApprovalView(approval *models.LoginApproval) gin.H {
+	return gin.H{
+		"id":         approval.ID,
+		"status":     approval.Status,
+		"device":     approval.Device,
+		"ip":         approval.Place.IP,
+		"location":   approval.Place.Location,
+		"reasons":    approval.Reasons,
+		"created_at": approval.CreatedAt,
+		"expires_at": approval.ExpiresAt,
+	}
+}
+
+// VerifyPush is polled by a login waiting for push approval. It answers 202 while the
+// request is pending and issues the tokens once it's approved.
+func VerifyPush(c *gin.Context) {
+	var req models.VerifyPushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	ch, user, ok := pendingLogin(c, req.TempToken, "verify_push")
+	if !ok {
+		return
+	}
+
+	var approval *models.LoginApproval
+	if ch.ApprovalID != "" {
+		var err error
+		if approval, err = loadLoginApproval(ctx, ch.ApprovalID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load approval"})
+			return
+		}
+	}
+	if approval == nil || approval.UserID != ch.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No approval pending for this login, request a new one or use a code"})
+		return
+	}
+
+	switch approval.Status {
+	case models.ApprovalPending:
+		c.JSON(http.StatusAccepted, gin.H{"status": approval.Status, "expires_at": approval.ExpiresAt})
+		return
+	case models.ApprovalDenied:
+		ch.revoke(ctx)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "The sign-in was denied", "status": approval.Status})
+		return
+	}
+
+	// The approval showed where the login comes from, so it covers the step-up too
+	finishLogin(c, user, ch)
+}
+
+// GetLoginApprovals lists the sign-ins waiting for the user's approval
+func GetLoginApprovals(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	ids, err := redisClient.SMembers(ctx, userLoginApprovalsKey(userID)).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load approvals"})
+		return
+	}
+
+	approvals := []gin.H{}
+	for _, id := range ids {
+		approval, err := loadLoginApproval(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load approvals"})
+			return
+		}
+		if approval == nil {
+			redisClient.SRem(ctx, userLoginApprovalsKey(userID), id)
+			continue
+		}
+		if approval.Status == models.ApprovalPending {
+			approvals = append(approvals, loginApprovalView(approval))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"approvals": approvals})
+}
+
+// AnswerLoginApproval approves or denies a pending sign-in from a signed-in device
+func AnswerLoginApproval(c *gin.Context) {
+	var req models.LoginApprovalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "approve is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	approval, err := loadLoginApproval(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load approval"})
+		return
+	}
+	if approval == nil || approval.UserID != c.GetString("user_id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval not found or expired"})
+		return
+	}
+	if approval.Status != models.ApprovalPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "This sign-in has already been " + approval.Status})
+		return
+	}
+
+	approval.Status = models.ApprovalDenied
+	if *req.Approve {
+		approval.Status = models.ApprovalApproved
+	}
+	raw, err := json.Marshal(approval)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save approval"})
+		return
+	}
+	// Only answer while it's still there; an expired approval must not come back
+	if err := redisClient.SetArgs(ctx, loginApprovalKey(approval.ID), raw, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval not found or expired"})
+		return
+	}
+
+	if *req.Approve {
+		logSecurityEvent(c, nil, "success", "login_approved",
+			fmt.Sprintf("User %s approved a sign-in from %s", c.GetString("username"), locationOrIP(&approval.Place)))
+	} else {
+		logSecurityEvent(c, nil, "warning", "login_denied",
+			fmt.Sprintf("User %s denied a sign-in from %s", c.GetString("username"), locationOrIP(&approval.Place)))
+	}
+
+	c.JSON(http.StatusOK, loginApprovalView(approval))
+}
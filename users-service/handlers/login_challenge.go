@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+// A login that passed the password waits for its second factor as a challenge in Redis.
+// The client only gets a random token; the challenge is stored under the token's hash,
+// bound to the network and browser that started the login, and deleted once the login
+// completes, is denied or runs out of code attempts.
+const loginChallengeTTL = 10 * time.Minute
+
+var (
+	errChallengeNotFound = errors.New("login challenge not found or expired")
+	errChallengeMismatch = errors.New("login challenge used from another client")
+)
+
+// loginChallenge is a pending login. Its code is kept only as a hash.
+type loginChallenge struct {
+	token string
+	key   string
+	// Code attempts of the user across logins, as of the last reserveAttempt
+	userAttempts int64
+
+	UserID      string
+	Factor      string
+	Fingerprint string
+	Attempts    int64
+	CodeHash    string
+	CodeExpires time.Time
+	Risk        *models.LoginRisk
+	ApprovalID  string
+	ExpiresAt   time.Time
+}
+
+func loginChallengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "login_challenge:" + hex.EncodeToString(sum[:])
+}
+
+// clientFingerprint identifies the client starting a login by its network (/24 or /48,
+// so a change of address within the provider's pool doesn't break the login) and its
+// user agent
+func clientFingerprint(c *gin.Context) string {
+	sum := sha256.Sum256([]byte(utils.NetworkPrefix(c.ClientIP()) + "\x00" + c.Request.UserAgent()))
+	return hex.EncodeToString(sum[:16])
+}
+
+func hashLoginCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newLoginChallenge starts a pending login for user with the given factor
+func newLoginChallenge(c *gin.Context, user *models.User, factor string, risk *models.LoginRisk) (*loginChallenge, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := &loginChallenge{
+		token:       token,
+		key:         loginChallengeKey(token),
+		UserID:      user.ID.Hex(),
+		Factor:      factor,
+		Fingerprint: clientFingerprint(c),
+		Risk:        risk,
+		ExpiresAt:   time.Now().Add(loginChallengeTTL),
+	}
+	riskJSON, err := json.Marshal(risk)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := c.Request.Context()
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, ch.key,
+		"user_id", ch.UserID,
+		"factor", ch.Factor,
+		"fingerprint", ch.Fingerprint,
+		"attempts", 0,
+		"risk", riskJSON,
+		"expires_at", ch.ExpiresAt.Unix(),
+	)
+	pipe.ExpireAt(ctx, ch.key, ch.ExpiresAt)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// loadLoginChallenge returns the pending login for token if it was started by this client
+func loadLoginChallenge(c *gin.Context, token string) (*loginChallenge, error) {
+	key := loginChallengeKey(token)
+	fields, err := redisClient.HGetAll(c.Request.Context(), key).Result()
+	if err != nil {
+		return nil, err
+	}
+	// A challenge deleted while being updated can leave fields behind without a user
+	if fields["user_id"] == "" {
+		return nil, errChallengeNotFound
+	}
+
+	ch := &loginChallenge{
+		token:       token,
+		key:         key,
+		UserID:      fields["user_id"],
+		Factor:      fields["factor"],
+		Fingerprint: fields["fingerprint"],
+		CodeHash:    fields["code"],
+		ApprovalID:  fields["approval_id"],
+	}
+	ch.Attempts, _ = strconv.ParseInt(fields["attempts"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+	ch.ExpiresAt = time.Unix(expiresAt, 0)
+	codeExpires, _ := strconv.ParseInt(fields["code_expires_at"], 10, 64)
+	ch.CodeExpires = time.Unix(codeExpires, 0)
+	if raw := fields["risk"]; raw != "" && raw != "null" {
+		var risk models.LoginRisk
+		if err := json.Unmarshal([]byte(raw), &risk); err == nil {
+			ch.Risk = &risk
+		}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(ch.Fingerprint), []byte(clientFingerprint(c))) != 1 {
+		return ch, errChallengeMismatch
+	}
+	return ch, nil
+}
+
+// pendingLogin loads the challenge for token and its user. When it fails the response
+// is already written.
+func pendingLogin(c *gin.Context, token, action string) (*loginChallenge, *models.User, bool) {
+	ch, err := loadLoginChallenge(c, token)
+	switch {
+	case errors.Is(err, errChallengeNotFound):
+		logSecurityEvent(c, nil, "failed", action, "Invalid or expired temp token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired temp token"})
+		return nil, nil, false
+	case errors.Is(err, errChallengeMismatch):
+		logSecurityEvent(c, nil, "blocked", action, fmt.Sprintf("Temp token of user %s used from another client", ch.UserID))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired temp token"})
+		return nil, nil, false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load login"})
+		return nil, nil, false
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(ch.UserID)
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(c.Request.Context(), bson.M{"_id": objID}).Decode(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+	return ch, &user, true
+}
+
+// update sets fields of a challenge without extending its life; if it was deleted in
+// the meantime the leftovers expire with it and don't load
+func (ch *loginChallenge) update(ctx context.Context, values ...interface{}) error {
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, ch.key, values...)
+	pipe.ExpireAt(ctx, ch.key, ch.ExpiresAt)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// setCode replaces the code the pending login accepts, for loginCodeTTL
+func (ch *loginChallenge) setCode(ctx context.Context, code string) error {
+	ch.CodeHash = hashLoginCode(code)
+	ch.CodeExpires = time.Now().Add(loginCodeTTL)
+	return ch.update(ctx, "code", ch.CodeHash, "code_expires_at", ch.CodeExpires.Unix())
+}
+
+func (ch *loginChallenge) setFactor(ctx context.Context, factor string) error {
+	ch.Factor = factor
+	return ch.update(ctx, "factor", factor)
+}
+
+func (ch *loginChallenge) saveRisk(ctx context.Context) error {
+	raw, err := json.Marshal(ch.Risk)
+	if err != nil {
+		return err
+	}
+	return ch.update(ctx, "risk", raw)
+}
+
+func (ch *loginChallenge) setApproval(ctx context.Context, approvalID string) error {
+	ch.ApprovalID = approvalID
+	return ch.update(ctx, "approval_id", approvalID)
+}
+
+// checkCode compares a code with the one sent for this login
+func (ch *loginChallenge) checkCode(code string) bool {
+	if ch.CodeHash == "" || code == "" || time.Now().After(ch.CodeExpires) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(ch.CodeHash), []byte(hashLoginCode(code))) == 1
+}
+
+func otpUserFailuresKey(userID string) string {
+	return "otp_fail:user:" + userID
+}
+
+// attemptsExhausted tells whether the pending login has used up its code attempts,
+// or the user has across logins
+func (ch *loginChallenge) attemptsExhausted(ctx context.Context) bool {
+	userFailures, _ := redisClient.Get(ctx, otpUserFailuresKey(ch.UserID)).Int64()
+	return ch.Attempts >= otpMaxAttempts || userFailures >= otpUserMaxAttempts
+}
+
+// reserveAttemptScript counts a code attempt on the challenge (KEYS[1]) and on the
+// user (KEYS[2]) in one step, and deletes the challenge once either is over its limit.
+// Returns {status, attempts, user attempts}: 1 go ahead, 0 over the limit, -1 no challenge.
+var reserveAttemptScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return {-1, 0, 0}
+end
+local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+local failures = redis.call("INCR", KEYS[2])
+redis.call("EXPIRE", KEYS[2], ARGV[1])
+if attempts > tonumber(ARGV[2]) or failures > tonumber(ARGV[3]) then
+	redis.call("DEL", KEYS[1])
+	return {0, attempts, failures}
+end
+return {1, attempts, failures}
+`)
+
+// reserveAttempt counts an attempt before the code is compared, so parallel requests
+// on one temp token can't get more guesses than the limits allow. It returns false
+// once the pending login or the user is out of attempts; the login is then revoked.
+func (ch *loginChallenge) reserveAttempt(ctx context.Context) (bool, error) {
+	res, err := reserveAttemptScript.Run(ctx, redisClient,
+		[]string{ch.key, otpUserFailuresKey(ch.UserID)},
+		int(otpFailureWindow.Seconds()), otpMaxAttempts, otpUserMaxAttempts,
+	).Int64Slice()
+	if err != nil {
+		return false, err
+	}
+	if res[0] == -1 {
+		return false, errChallengeNotFound
+	}
+
+	ch.Attempts = res[1]
+	ch.userAttempts = res[2]
+	if res[0] == 0 {
+		ch.revoke(ctx)
+		return false, nil
+	}
+	return true, nil
+}
+
+// recordFailure is called when the reserved attempt had a wrong code. Once the pending
+// login runs out of attempts it is deleted and the user has to start over with the
+// password.
+func (ch *loginChallenge) recordFailure(ctx context.Context) (exhausted bool) {
+	if ch.Attempts < otpMaxAttempts && ch.userAttempts < otpUserMaxAttempts {
+		return false
+	}
+	ch.revoke(ctx)
+	return true
+}
+
+// recordSuccess gives back the reserved attempt to the user's counter, which only
+// counts wrong codes across logins
+func (ch *loginChallenge) recordSuccess(ctx context.Context) {
+	redisClient.Decr(ctx, otpUserFailuresKey(ch.UserID))
+}
+
+// consume deletes the challenge, reporting whether this call did; only that caller may
+// finish the login, so a token can't be used twice, even concurrently
+func (ch *loginChallenge) consume(ctx context.Context) bool {
+	deleted, err := redisClient.Del(ctx, ch.key).Result()
+	if err != nil || deleted != 1 {
+		return false
+	}
+	if ch.ApprovalID != "" {
+		deleteLoginApproval(ctx, ch.UserID, ch.ApprovalID)
+	}
+	return true
+}
+
+// revoke ends the pending login without completing it
+func (ch *loginChallenge) revoke(ctx context.Context) {
+	redisClient.Del(ctx, ch.key)
+	if ch.ApprovalID != "" {
+		deleteLoginApproval(ctx, ch.UserID, ch.ApprovalID)
+	}
+}
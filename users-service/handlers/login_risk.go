@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const (
+	loginProfilesCollection = "login_profiles"
+
+	maxKnownDevices  = 20
+	maxKnownNetworks = 50
+
+	// Below this many logins there is no pattern to compare the hour against
+	minLoginsForHourCheck = 10
+	// GeoIP is city-level at best, shorter jumps are noise
+	minTravelKm = 500
+)
+
+var (
+	riskWeights = map[string]int{
+		models.RiskNewDevice:        2,
+		models.RiskNewNetwork:       1,
+		models.RiskUnusualHour:      1,
+		models.RiskImpossibleTravel: 3,
+	}
+
+	// A new device alone sends an alert; a new device from a new network, or impossible
+	// travel, also needs the emailed verification code
+	loginAlertScore  = 2
+	loginStepUpScore = 3
+	maxTravelKmh     = 1000.0
+)
+
+// InitLoginRisk reads LOGIN_ALERT_SCORE, LOGIN_STEP_UP_SCORE and LOGIN_MAX_TRAVEL_KMH
+func InitLoginRisk() {
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_ALERT_SCORE")); err == nil && n > 0 {
+		loginAlertScore = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_STEP_UP_SCORE")); err == nil && n > 0 {
+		loginStepUpScore = n
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("LOGIN_MAX_TRAVEL_KMH"), 64); err == nil && v > 0 {
+		maxTravelKmh = v
+	}
+}
+
+// EnsureLoginProfileIndexes keeps one login profile per user
+func EnsureLoginProfileIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.Collection(loginProfilesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("unique_user_id"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create login profile indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for login profiles ensured")
+}
+
+// Devices are recognized by a random token issued on their first login and sent back
+// on later ones; only its hash is kept in the login profile
+const deviceTokenHeader = "X-Device-Token"
+
+// knownDeviceID returns the stored ID of the device presenting its token, or "" when
+// there is no token or it wasn't issued to this user
+func knownDeviceID(c *gin.Context, profile *models.LoginProfile) string {
+	token := c.GetHeader(deviceTokenHeader)
+	if token == "" || profile == nil {
+		return ""
+	}
+	id := hashRefreshSecret(token)
+	for _, d := range profile.Devices {
+		if d.ID == id {
+			return id
+		}
+	}
+	return ""
+}
+
+func loadLoginProfile(ctx context.Context, userID primitive.ObjectID) (*models.LoginProfile, error) {
+	var profile models.LoginProfile
+	err := usersDB.Collection(loginProfilesCollection).FindOne(ctx, bson.M{"user_id": userID}).Decode(&profile)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// assessLogin compares a login with the user's previous ones. The first login has
+// nothing to compare to and is never flagged.
+func assessLogin(ctx context.Context, c *gin.Context, user *models.User) *models.LoginRisk {
+	now := time.Now()
+	ip := c.ClientIP()
+
+	risk := &models.LoginRisk{
+		Device:  utils.DescribeDevice(c.Request.UserAgent()),
+		Network: utils.NetworkPrefix(ip),
+		Place:   models.LoginPlace{At: now, IP: ip},
+	}
+	if location := utils.LookupIP(ip); location != nil {
+		risk.Place.Location = location.String()
+		risk.Place.Located = true
+		risk.Place.Latitude = location.Latitude
+		risk.Place.Longitude = location.Longitude
+	}
+
+	profile, err := loadLoginProfile(ctx, user.ID)
+	if err != nil {
+		// Don't lock users out over a lookup failure
+		log.Printf("Failed to load login profile for %s: %v", user.Username, err)
+		return risk
+	}
+	risk.DeviceID = knownDeviceID(c, profile)
+	if profile == nil || profile.Logins == 0 {
+		return risk
+	}
+
+	if risk.DeviceID == "" {
+		risk.Reasons = append(risk.Reasons, models.RiskNewDevice)
+	}
+
+	knownNetwork := false
+	for _, n := range profile.Networks {
+		knownNetwork = knownNetwork || n.Prefix == risk.Network
+	}
+	if !knownNetwork {
+		risk.Reasons = append(risk.Reasons, models.RiskNewNetwork)
+	}
+
+	if last := profile.LastLogin; last != nil && last.Located && risk.Place.Located {
+		km := utils.DistanceKm(last.Latitude, last.Longitude, risk.Place.Latitude, risk.Place.Longitude)
+		hours := now.Sub(last.At).Hours()
+		if km >= minTravelKm && (hours <= 0 || km/hours > maxTravelKmh) {
+			risk.Reasons = append(risk.Reasons, models.RiskImpossibleTravel)
+		}
+	}
+
+	if profile.Logins >= minLoginsForHourCheck && len(profile.HourCounts) == 24 {
+		h := now.UTC().Hour()
+		around := profile.HourCounts[(h+23)%24] + profile.HourCounts[h] + profile.HourCounts[(h+1)%24]
+		if float64(around) < 0.05*float64(profile.Logins) {
+			risk.Reasons = append(risk.Reasons, models.RiskUnusualHour)
+		}
+	}
+
+	for _, reason := range risk.Reasons {
+		risk.Score += riskWeights[reason]
+	}
+	risk.StepUp = risk.Score >= loginStepUpScore
+	return risk
+}
+
+// recordLogin remembers the device, network and place of a completed login and alerts
+// the user if it looked suspicious. A device without a token gets a new one, returned
+// for the client to keep and send on its next login.
+func recordLogin(ctx context.Context, user *models.User, risk *models.LoginRisk) string {
+	if risk == nil {
+		return ""
+	}
+
+	var deviceToken string
+	if risk.DeviceID == "" {
+		token, err := randomHex(32)
+		if err != nil {
+			log.Printf("Failed to issue device token for %s: %v", user.Username, err)
+		} else {
+			deviceToken = token
+			risk.DeviceID = hashRefreshSecret(token)
+		}
+	}
+
+	profile, err := loadLoginProfile(ctx, user.ID)
+	if err != nil {
+		log.Printf("Failed to load login profile for %s: %v", user.Username, err)
+		return ""
+	}
+	if profile == nil {
+		profile = &models.LoginProfile{UserID: user.ID}
+	}
+	if len(profile.HourCounts) != 24 {
+		profile.HourCounts = make([]int, 24)
+	}
+
+	now := risk.Place.At
+	found := false
+	for i := range profile.Devices {
+		if profile.Devices[i].ID == risk.DeviceID {
+			profile.Devices[i].LastSeen = now
+			profile.Devices[i].LastIP = risk.Place.IP
+			profile.Devices[i].Location = risk.Place.Location
+			found = true
+		}
+	}
+	if !found && risk.DeviceID != "" {
+		profile.Devices = append(profile.Devices, models.KnownDevice{
+			ID:        risk.DeviceID,
+			Label:     risk.Device,
+			LastIP:    risk.Place.IP,
+			Location:  risk.Place.Location,
+			FirstSeen: now,
+			LastSeen:  now,
+		})
+	}
+	// Forget the devices that haven't been used the longest
+	sort.Slice(profile.Devices, func(i, j int) bool { return profile.Devices[i].LastSeen.After(profile.Devices[j].LastSeen) })
+	if len(profile.Devices) > maxKnownDevices {
+		profile.Devices = profile.Devices[:maxKnownDevices]
+	}
+
+	found = false
+	for i := range profile.Networks {
+		if profile.Networks[i].Prefix == risk.Network {
+			profile.Networks[i].LastSeen = now
+			found = true
+		}
+	}
+	if !found {
+		profile.Networks = append(profile.Networks, models.KnownNetwork{Prefix: risk.Network, FirstSeen: now, LastSeen: now})
+	}
+	sort.Slice(profile.Networks, func(i, j int) bool { return profile.Networks[i].LastSeen.After(profile.Networks[j].LastSeen) })
+	if len(profile.Networks) > maxKnownNetworks {
+		profile.Networks = profile.Networks[:maxKnownNetworks]
+	}
+
+	profile.HourCounts[now.UTC().Hour()]++
+	profile.Logins++
+	place := risk.Place
+	profile.LastLogin = &place
+
+	_, err = usersDB.Collection(loginProfilesCollection).ReplaceOne(ctx,
+		bson.M{"user_id": user.ID}, profile, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("Failed to save login profile for %s: %v", user.Username, err)
+	}
+
+	if len(risk.Reasons) > 0 {
+		utils.RecordSecurityEvent(models.SecurityEvent{
+			Type:    "warning",
+			Action:  "suspicious_login",
+			ActorID: user.ID.Hex(),
+			Actor:   user.Username,
+			IP:      risk.Place.IP,
+			TraceID: utils.TraceID(ctx),
+			Details: fmt.Sprintf("User %s logged in from %s (%s), score %d: %v", user.Username, risk.Device, locationOrIP(&risk.Place), risk.Score, risk.Reasons),
+		})
+	}
+	if risk.Score >= loginAlertScore {
+		sendLoginAlert(user, risk)
+	}
+	return deviceToken
+}
+
+func locationOrIP(place *models.LoginPlace) string {
+	if place.Location != "" {
+		return place.Location
+	}
+	return place.IP
+}
+
+func loginDetails(risk *models.LoginRisk) map[string]interface{} {
+	return map[string]interface{}{
+		"Device":   risk.Device,
+		"IP":       risk.Place.IP,
+		"Location": risk.Place.Location,
+		"Time":     risk.Place.At,
+		"Reasons":  risk.Reasons,
+	}
+}
+
+func sendLoginAlert(user *models.User, risk *models.LoginRisk) {
+	queueEmail(user, user.Email, "login_alert", loginDetails(risk), 0)
+
+	message := fmt.Sprintf("New sign-in from %s (%s). If this wasn't you, change your password and sign out all devices.",
+		risk.Device, locationOrIP(&risk.Place))
+	go sendNotification(user.ID.Hex(), "security_alert", message)
+}
+
+// startLoginStepUp emails a verification code together with the login details; the
+// pending login only completes once the code is entered
+func startLoginStepUp(ctx context.Context, user *models.User, ch *loginChallenge) error {
+	ch.Risk.StepUpPending = true
+	if err := ch.saveRisk(ctx); err != nil {
+		return err
+	}
+	return otpChannels[models.TwoFactorEmail].Send(ctx, user, ch)
+}
+
+// GetKnownDevices lists the devices and networks the user has logged in from
+func GetKnownDevices(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	profile, err := loadLoginProfile(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if profile == nil {
+		profile = &models.LoginProfile{Devices: []models.KnownDevice{}, Networks: []models.KnownNetwork{}}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"devices":    profile.Devices,
+		"networks":   profile.Networks,
+		"last_login": profile.LastLogin,
+	})
+}
+
+// ForgetKnownDevice removes a device, so the next login from it is treated as new
+func ForgetKnownDevice(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+
+	result, err := usersDB.Collection(loginProfilesCollection).UpdateOne(c.Request.Context(),
+		bson.M{"user_id": userID, "devices.id": c.Param("id")},
+		bson.M{"$pull": bson.M{"devices": bson.M{"id": c.Param("id")}}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forget device"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "forget_device", fmt.Sprintf("User %s removed a known device", c.GetString("username")))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device removed"})
+}
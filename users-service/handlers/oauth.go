@@ -0,0 +1,818 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const (
+	oauthClientsCollection  = "oauth_clients"
+	oauthConsentsCollection = "oauth_consents"
+
+	// Authorization codes are exchanged right after the redirect
+	oauthCodeTTL = time.Minute
+)
+
+// RFC 7636: 43-128 characters from the unreserved set
+var pkceVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// oauthGrant is what an authorization code or refresh token stands for
+type oauthGrant struct {
+	ClientID      string `json:"client_id"`
+	UserID        string `json:"user_id"`
+	Username      string `json:"username"`
+	Scope         string `json:"scope"`
+	RedirectURI   string `json:"redirect_uri,omitempty"`
+	CodeChallenge string `json:"code_challenge,omitempty"`
+}
+
+func oauthCodeKey(code string) string     { return "oauth_code:" + hashRefreshSecret(code) }
+func oauthRefreshKey(token string) string { return "oauth_refresh:" + hashRefreshSecret(token) }
+func oauthRevokedKey(jti string) string   { return "oauth_revoked:" + jti }
+func oauthGrantSetKey(userID, clientID string) string {
+	return "oauth_grants:" + userID + ":" + clientID
+}
+
+// EnsureOAuthIndexes creates indexes for registered clients and user consents
+func EnsureOAuthIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "client_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("unique_client_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "owner_id", Value: 1}},
+			Options: options.Index().SetName("owner_id_idx"),
+		},
+	}
+	if _, err := db.Collection(oauthClientsCollection).Indexes().CreateMany(ctx, clientIndexes); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	consentIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "client_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("unique_user_client"),
+		},
+	}
+	if _, err := db.Collection(oauthConsentsCollection).Indexes().CreateMany(ctx, consentIndexes); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for oauth_clients and oauth_consents ensured")
+}
+
+// oauthError responds in the RFC 6749 error format
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+func parseScope(scope string) []string {
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func containsAll(have, want []string) bool {
+	set := map[string]bool{}
+	for _, s := range have {
+		set[s] = true
+	}
+	for _, s := range want {
+		if !set[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// validRedirectURI allows https, loopback http for local development and
+// private-use schemes (com.example.app:/callback) for native apps
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(u.Scheme, ".")
+	}
+}
+
+func findOAuthClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := usersDB.Collection(oauthClientsCollection).FindOne(ctx, bson.M{"client_id": clientID}).Decode(&client)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// authenticateClient reads client credentials from HTTP Basic auth or the form body.
+// Public clients only identify themselves; confidential clients must present their secret.
+func authenticateClient(c *gin.Context) (*models.OAuthClient, bool) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+	if clientID == "" {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication required")
+		return nil, false
+	}
+
+	client, err := findOAuthClient(c.Request.Context(), clientID)
+	if err != nil {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Unknown client")
+		return nil, false
+	}
+
+	if client.Confidential {
+		if secret == "" || bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)) != nil {
+			logSecurityEvent(c, nil, "failed", "oauth_client_auth", fmt.Sprintf("Invalid secret for client %s", clientID))
+			oauthError(c, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+			return nil, false
+		}
+	}
+	return client, true
+}
+
+// RegisterOAuthClient lets a developer register an application
+func RegisterOAuthClient(c *gin.Context) {
+	var req models.RegisterOAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid redirect URI: " + uri})
+			return
+		}
+	}
+	scopes := parseScope(strings.Join(req.Scopes, " "))
+	for _, s := range scopes {
+		if _, ok := models.OAuthScopes[s]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + s})
+			return
+		}
+	}
+
+	ownerID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	clientID, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register client"})
+		return
+	}
+
+	client := models.OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		OwnerID:      ownerID,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       scopes,
+		Confidential: req.Confidential,
+		CreatedAt:    time.Now(),
+	}
+
+	secret := ""
+	if req.Confidential {
+		if secret, err = randomHex(32); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register client"})
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register client"})
+			return
+		}
+		client.SecretHash = string(hash)
+	}
+
+	if _, err := usersDB.Collection(oauthClientsCollection).InsertOne(c.Request.Context(), client); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register client"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "oauth_client_register", fmt.Sprintf("Client %s (%s) registered", clientID, req.Name))
+
+	resp := gin.H{"client": client}
+	if secret != "" {
+		// Only shown once, it is stored hashed
+		resp["client_secret"] = secret
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// GetOAuthClients lists the applications registered by the user
+func GetOAuthClients(c *gin.Context) {
+	ownerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx := c.Request.Context()
+
+	cursor, err := usersDB.Collection(oauthClientsCollection).Find(ctx, bson.M{"owner_id": ownerID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load clients"})
+		return
+	}
+	clients := []models.OAuthClient{}
+	if err := cursor.All(ctx, &clients); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load clients"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// DeleteOAuthClient removes an application; its refresh tokens stop working because
+// the client no longer authenticates, and issued access tokens run out shortly
+func DeleteOAuthClient(c *gin.Context) {
+	ownerID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	clientID := c.Param("client_id")
+	ctx := c.Request.Context()
+
+	res, err := usersDB.Collection(oauthClientsCollection).DeleteOne(ctx, bson.M{"client_id": clientID, "owner_id": ownerID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete client"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+
+	if _, err := usersDB.Collection(oauthConsentsCollection).DeleteMany(ctx, bson.M{"client_id": clientID}); err != nil {
+		log.Printf("Failed to delete consents for client %s: %v", clientID, err)
+	}
+
+	logSecurityEvent(c, nil, "success", "oauth_client_delete", fmt.Sprintf("Client %s deleted", clientID))
+	c.JSON(http.StatusOK, gin.H{"message": "Client deleted"})
+}
+
+// validateAuthorizeRequest checks everything the consent screen depends on. Errors
+// are returned to the caller rather than redirected, because until the client and
+// redirect URI are verified the redirect target can't be trusted.
+func validateAuthorizeRequest(c *gin.Context, req *models.AuthorizeRequest) (*models.OAuthClient, []string, bool) {
+	client, err := findOAuthClient(c.Request.Context(), req.ClientID)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_client", "Unknown client")
+		return nil, nil, false
+	}
+
+	registered := false
+	for _, uri := range client.RedirectURIs {
+		if uri == req.RedirectURI {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client")
+		return nil, nil, false
+	}
+
+	if req.ResponseType != "code" {
+		oauthError(c, http.StatusBadRequest, "unsupported_response_type", "Only the authorization code flow is supported")
+		return nil, nil, false
+	}
+	if req.CodeChallengeMethod != "S256" || req.CodeChallenge == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "PKCE with code_challenge_method=S256 is required")
+		return nil, nil, false
+	}
+
+	scopes := parseScope(req.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !containsAll(client.Scopes, scopes) {
+		oauthError(c, http.StatusBadRequest, "invalid_scope", "Requested scope exceeds what the client registered")
+		return nil, nil, false
+	}
+
+	return client, scopes, true
+}
+
+func authorizeRedirect(redirectURI string, params url.Values) string {
+	sep := "?"
+	if strings.Contains(redirectURI, "?") {
+		sep = "&"
+	}
+	return redirectURI + sep + params.Encode()
+}
+
+// GetAuthorize returns what the consent screen shows for an authorization request
+func GetAuthorize(c *gin.Context) {
+	var req models.AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Missing authorization request parameters")
+		return
+	}
+
+	client, scopes, ok := validateAuthorizeRequest(c, &req)
+	if !ok {
+		return
+	}
+
+	scopeInfo := make([]gin.H, 0, len(scopes))
+	for _, s := range scopes {
+		scopeInfo = append(scopeInfo, gin.H{"scope": s, "description": models.OAuthScopes[s]})
+	}
+
+	// A previous consent covering every scope lets the app skip the prompt
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	var consent models.OAuthConsent
+	consented := usersDB.Collection(oauthConsentsCollection).FindOne(c.Request.Context(),
+		bson.M{"user_id": userID, "client_id": client.ClientID}).Decode(&consent) == nil &&
+		containsAll(consent.Scopes, scopes)
+
+	c.JSON(http.StatusOK, gin.H{
+		"client":    gin.H{"client_id": client.ClientID, "name": client.Name},
+		"scopes":    scopeInfo,
+		"consented": consented,
+	})
+}
+
+// PostAuthorize records the user's decision and returns where to send the browser
+func PostAuthorize(c *gin.Context) {
+	var req models.AuthorizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Missing authorization request parameters")
+		return
+	}
+
+	client, scopes, ok := validateAuthorizeRequest(c, &req)
+	if !ok {
+		return
+	}
+
+	params := url.Values{}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	if !req.Approve {
+		params.Set("error", "access_denied")
+		c.JSON(http.StatusOK, gin.H{"redirect_to": authorizeRedirect(req.RedirectURI, params)})
+		return
+	}
+
+	userIDHex := c.GetString("user_id")
+	userID, err := primitive.ObjectIDFromHex(userIDHex)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	_, err = usersDB.Collection(oauthConsentsCollection).UpdateOne(ctx,
+		bson.M{"user_id": userID, "client_id": client.ClientID},
+		bson.M{
+			"$addToSet": bson.M{"scopes": bson.M{"$each": scopes}},
+			"$set":      bson.M{"granted_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save consent"})
+		return
+	}
+
+	code, err := randomHex(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create authorization code"})
+		return
+	}
+
+	grant, _ := json.Marshal(oauthGrant{
+		ClientID:      client.ClientID,
+		UserID:        userIDHex,
+		Username:      c.GetString("username"),
+		Scope:         strings.Join(scopes, " "),
+		RedirectURI:   req.RedirectURI,
+		CodeChallenge: req.CodeChallenge,
+	})
+	if err := redisClient.Set(ctx, oauthCodeKey(code), grant, oauthCodeTTL).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create authorization code"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "oauth_authorize",
+		fmt.Sprintf("User %s authorized client %s for %s", c.GetString("username"), client.ClientID, strings.Join(scopes, " ")))
+
+	params.Set("code", code)
+	c.JSON(http.StatusOK, gin.H{"redirect_to": authorizeRedirect(req.RedirectURI, params)})
+}
+
+// issueOAuthTokens mints an access token and, for user grants, a refresh token
+func issueOAuthTokens(ctx context.Context, grant oauthGrant) (gin.H, error) {
+	accessToken, _, err := utils.GenerateOAuthToken(grant.UserID, grant.Username, grant.ClientID, grant.Scope, utils.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(utils.AccessTokenTTL.Seconds()),
+		"scope":        grant.Scope,
+	}
+
+	if grant.UserID == "" {
+		return resp, nil
+	}
+
+	refreshToken, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	grant.RedirectURI = ""
+	grant.CodeChallenge = ""
+	raw, _ := json.Marshal(grant)
+
+	key := oauthRefreshKey(refreshToken)
+	setKey := oauthGrantSetKey(grant.UserID, grant.ClientID)
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, key, raw, refreshTokenTTL)
+	pipe.SAdd(ctx, setKey, key)
+	pipe.Expire(ctx, setKey, refreshTokenTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	resp["refresh_token"] = refreshToken
+	return resp, nil
+}
+
+// consentCovers checks the user still has the app authorized for scope
+func consentCovers(ctx context.Context, userIDHex, clientID string, scopes []string) bool {
+	userID, err := primitive.ObjectIDFromHex(userIDHex)
+	if err != nil {
+		return false
+	}
+	var consent models.OAuthConsent
+	if err := usersDB.Collection(oauthConsentsCollection).FindOne(ctx,
+		bson.M{"user_id": userID, "client_id": clientID}).Decode(&consent); err != nil {
+		return false
+	}
+	return containsAll(consent.Scopes, scopes)
+}
+
+// OAuthToken is the token endpoint for the authorization_code, refresh_token and
+// client_credentials grants
+func OAuthToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var grant oauthGrant
+
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		raw, err := redisClient.GetDel(ctx, oauthCodeKey(c.PostForm("code"))).Bytes()
+		if err != nil || json.Unmarshal(raw, &grant) != nil {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code")
+			return
+		}
+		if grant.ClientID != client.ClientID || grant.RedirectURI != c.PostForm("redirect_uri") {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client or redirect URI")
+			return
+		}
+
+		verifier := c.PostForm("code_verifier")
+		if !pkceVerifierPattern.MatchString(verifier) {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid code_verifier")
+			return
+		}
+		sum := sha256.Sum256([]byte(verifier))
+		challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(challenge), []byte(grant.CodeChallenge)) != 1 {
+			logSecurityEvent(c, nil, "failed", "oauth_token", fmt.Sprintf("PKCE verification failed for client %s", client.ClientID))
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+			return
+		}
+
+	case "refresh_token":
+		// Refresh tokens are single use, every exchange rotates them
+		key := oauthRefreshKey(c.PostForm("refresh_token"))
+		raw, err := redisClient.GetDel(ctx, key).Bytes()
+		if err != nil || json.Unmarshal(raw, &grant) != nil {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid or expired refresh token")
+			return
+		}
+		redisClient.SRem(ctx, oauthGrantSetKey(grant.UserID, grant.ClientID), key)
+
+		if grant.ClientID != client.ClientID {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "Refresh token was issued to another client")
+			return
+		}
+
+		// A narrower scope may be requested, never a wider one
+		if requested := parseScope(c.PostForm("scope")); len(requested) > 0 {
+			if !containsAll(parseScope(grant.Scope), requested) {
+				oauthError(c, http.StatusBadRequest, "invalid_scope", "Requested scope exceeds the original grant")
+				return
+			}
+			grant.Scope = strings.Join(requested, " ")
+		}
+
+	case "client_credentials":
+		if !client.Confidential {
+			oauthError(c, http.StatusUnauthorized, "unauthorized_client", "Public clients can't use client credentials")
+			return
+		}
+
+		allowed := []string{}
+		for _, s := range client.Scopes {
+			if models.OAuthClientScopes[s] {
+				allowed = append(allowed, s)
+			}
+		}
+		scopes := parseScope(c.PostForm("scope"))
+		if len(scopes) == 0 {
+			scopes = allowed
+		}
+		if len(scopes) == 0 || !containsAll(allowed, scopes) {
+			oauthError(c, http.StatusBadRequest, "invalid_scope", "Requested scope is not available without a user")
+			return
+		}
+		grant = oauthGrant{ClientID: client.ClientID, Scope: strings.Join(scopes, " ")}
+
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant_type")
+		return
+	}
+
+	if grant.UserID != "" {
+		if !consentCovers(ctx, grant.UserID, client.ClientID, parseScope(grant.Scope)) {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "The user revoked access for this application")
+			return
+		}
+
+		userID, _ := primitive.ObjectIDFromHex(grant.UserID)
+		var user models.User
+		if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "User no longer exists")
+			return
+		}
+		if time.Now().Before(user.LockedUntil) {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "Account is temporarily locked")
+			return
+		}
+		if user.PendingDeletion() {
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "Account is being deleted")
+			return
+		}
+		grant.Username = user.Username
+	}
+
+	resp, err := issueOAuthTokens(ctx, grant)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "Failed to issue tokens")
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// lookupOAuthToken resolves a token string to its access-token claims or refresh grant
+func lookupOAuthToken(ctx context.Context, token string) (*utils.Claims, *oauthGrant) {
+	if claims, err := utils.ValidateJWT(token); err == nil {
+		if claims.ClientID == "" {
+			return nil, nil
+		}
+		if n, _ := redisClient.Exists(ctx, oauthRevokedKey(claims.ID)).Result(); n > 0 {
+			return nil, nil
+		}
+		return claims, nil
+	}
+
+	raw, err := redisClient.Get(ctx, oauthRefreshKey(token)).Bytes()
+	if err != nil {
+		return nil, nil
+	}
+	var grant oauthGrant
+	if json.Unmarshal(raw, &grant) != nil {
+		return nil, nil
+	}
+	return nil, &grant
+}
+
+// IntrospectOAuthToken implements RFC 7662 for the client that owns the token
+func IntrospectOAuthToken(c *gin.Context) {
+	client, ok := authenticateClient(c)
+	if !ok {
+		return
+	}
+	if !client.Confidential {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Introspection requires a confidential client")
+		return
+	}
+
+	claims, grant := lookupOAuthToken(c.Request.Context(), c.PostForm("token"))
+
+	switch {
+	case claims != nil && claims.ClientID == client.ClientID:
+		c.JSON(http.StatusOK, gin.H{
+			"active":     true,
+			"token_type": "access_token",
+			"scope":      claims.Scope,
+			"client_id":  claims.ClientID,
+			"username":   claims.Username,
+			"sub":        claims.Subject,
+			"exp":        claims.ExpiresAt.Unix(),
+			"iat":        claims.IssuedAt.Unix(),
+			"iss":        claims.Issuer,
+			"jti":        claims.ID,
+		})
+	case grant != nil && grant.ClientID == client.ClientID:
+		c.JSON(http.StatusOK, gin.H{
+			"active":     true,
+			"token_type": "refresh_token",
+			"scope":      grant.Scope,
+			"client_id":  grant.ClientID,
+			"username":   grant.Username,
+			"sub":        grant.UserID,
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{"active": false})
+	}
+}
+
+// RevokeOAuthToken implements RFC 7009; unknown tokens are not an error
+func RevokeOAuthToken(c *gin.Context) {
+	client, ok := authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	token := c.PostForm("token")
+	claims, grant := lookupOAuthToken(ctx, token)
+
+	switch {
+	case claims != nil && claims.ClientID == client.ClientID:
+		ttl := time.Until(claims.ExpiresAt.Time)
+		if ttl > 0 {
+			if err := redisClient.Set(ctx, oauthRevokedKey(claims.ID), "1", ttl).Err(); err != nil {
+				oauthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", "Failed to revoke token")
+				return
+			}
+		}
+	case grant != nil && grant.ClientID == client.ClientID:
+		key := oauthRefreshKey(token)
+		pipe := redisClient.TxPipeline()
+		pipe.Del(ctx, key)
+		pipe.SRem(ctx, oauthGrantSetKey(grant.UserID, grant.ClientID), key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			oauthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", "Failed to revoke token")
+			return
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// OAuthTokenRevoked lets the gateway check access-token revocation.
+// Internal route, not exposed through the gateway.
+func OAuthTokenRevoked(c *gin.Context) {
+	n, err := redisClient.Exists(c.Request.Context(), oauthRevokedKey(c.Param("jti"))).Result()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Revocation list unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": n > 0})
+}
+
+// OAuthUserInfo returns the profile of the user behind an OAuth access token
+func OAuthUserInfo(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token is not bound to a user"})
+		return
+	}
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(c.Request.Context(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sub":        user.ID.Hex(),
+		"username":   user.Username,
+		"email":      user.Email,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+	})
+}
+
+// GetOAuthConsents lists the apps the user has authorized
+func GetOAuthConsents(c *gin.Context) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx := c.Request.Context()
+
+	cursor, err := usersDB.Collection(oauthConsentsCollection).Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load authorized apps"})
+		return
+	}
+	consents := []models.OAuthConsent{}
+	if err := cursor.All(ctx, &consents); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load authorized apps"})
+		return
+	}
+
+	apps := make([]gin.H, 0, len(consents))
+	for _, consent := range consents {
+		name := ""
+		if client, err := findOAuthClient(ctx, consent.ClientID); err == nil {
+			name = client.Name
+		}
+		apps = append(apps, gin.H{
+			"client_id":  consent.ClientID,
+			"name":       name,
+			"scopes":     consent.Scopes,
+			"granted_at": consent.GrantedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apps": apps})
+}
+
+// RevokeOAuthConsent removes an app's access to the account, including its refresh tokens
+func RevokeOAuthConsent(c *gin.Context) {
+	userIDHex := c.GetString("user_id")
+	userID, _ := primitive.ObjectIDFromHex(userIDHex)
+	clientID := c.Param("client_id")
+	ctx := c.Request.Context()
+
+	res, err := usersDB.Collection(oauthConsentsCollection).DeleteOne(ctx, bson.M{"user_id": userID, "client_id": clientID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "App not authorized"})
+		return
+	}
+
+	setKey := oauthGrantSetKey(userIDHex, clientID)
+	keys, err := redisClient.SMembers(ctx, setKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("Failed to load refresh tokens for %s/%s: %v", userIDHex, clientID, err)
+	}
+	if len(keys) > 0 {
+		redisClient.Del(ctx, append(keys, setKey)...)
+	}
+
+	logSecurityEvent(c, nil, "success", "oauth_consent_revoke", fmt.Sprintf("Access revoked for client %s", clientID))
+	c.JSON(http.StatusOK, gin.H{"message": "Access revoked"})
+}
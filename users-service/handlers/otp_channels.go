@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/users-service/models"
+	"example.com/users-service/sms"
+	"example.com/users-service/utils"
+)
+
+// Login codes and approvals reach the user over one of several channels. Every channel
+// has its own limit per user, since each SMS costs money and repeated pushes are a way
+// to pester a user into approving; SMS is also limited per phone number.
+const loginCodeTTL = 5 * time.Minute
+
+type channelLimit struct {
+	max    int64
+	window time.Duration
+}
+
+var (
+	smsSender sms.Sender
+
+	otpChannelLimits = map[string]channelLimit{
+		models.TwoFactorEmail: {max: 5, window: 15 * time.Minute},
+		models.TwoFactorSMS:   {max: 3, window: 15 * time.Minute},
+		models.TwoFactorPush:  {max: 5, window: 15 * time.Minute},
+	}
+	smsNumberLimit = channelLimit{max: 10, window: 24 * time.Hour}
+
+	errChannelUnavailable = errors.New("channel is not available for this user")
+)
+
+// channelLimitedError means the channel's limit is used up for now
+type channelLimitedError struct {
+	channel    string
+	retryAfter time.Duration
+}
+
+func (e *channelLimitedError) Error() string {
+	return fmt.Sprintf("%s limit reached, retry in %v", e.channel, e.retryAfter)
+}
+
+// InitOTPChannels configures the SMS provider (see sms.FromEnv) and reads the channel
+// limits from OTP_EMAIL_RATE_LIMIT, OTP_SMS_RATE_LIMIT, OTP_PUSH_RATE_LIMIT and
+// OTP_SMS_NUMBER_RATE_LIMIT, written as "<count>/<window>", e.g. "3/15m"
+func InitOTPChannels() {
+	sender, err := sms.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure SMS: %v", err)
+	}
+	smsSender = sender
+	log.Printf("SMS provider: %s", sender.Name())
+
+	for name := range otpChannelLimits {
+		env := "OTP_" + strings.ToUpper(name) + "_RATE_LIMIT"
+		if limit, ok := channelLimitFromEnv(env); ok {
+			otpChannelLimits[name] = limit
+		}
+	}
+	if limit, ok := channelLimitFromEnv("OTP_SMS_NUMBER_RATE_LIMIT"); ok {
+		smsNumberLimit = limit
+	}
+}
+
+func channelLimitFromEnv(env string) (channelLimit, bool) {
+	value := os.Getenv(env)
+	if value == "" {
+		return channelLimit{}, false
+	}
+	count, window, _ := strings.Cut(value, "/")
+	max, err := strconv.ParseInt(count, 10, 64)
+	duration, err2 := time.ParseDuration(window)
+	if err != nil || err2 != nil || max <= 0 || duration <= 0 {
+		log.Fatalf("Invalid %s %q, expected e.g. 3/15m", env, value)
+	}
+	return channelLimit{max: max, window: duration}, true
+}
+
+func channelRateKey(channel, userID string) string {
+	return "otp_rate:" + channel + ":" + userID
+}
+
+func smsNumberRateKey(number string) string {
+	return "otp_rate:sms_number:" + number
+}
+
+// takeChannelQuota counts one message on key, failing once the limit is used up
+func takeChannelQuota(ctx context.Context, channel, key string, limit channelLimit) error {
+	count, err := redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if count == 1 {
+		redisClient.Expire(ctx, key, limit.window)
+	}
+	if count <= limit.max {
+		return nil
+	}
+
+	ttl, err := redisClient.PTTL(ctx, key).Result()
+	if err != nil || ttl <= 0 {
+		ttl = limit.window
+	}
+	return &channelLimitedError{channel: channel, retryAfter: ttl}
+}
+
+// otpChannel delivers the second factor of a pending login
+type otpChannel interface {
+	Name() string
+	// Available reports whether the user can be reached over the channel right now
+	Available(ctx context.Context, user *models.User) bool
+	// Send delivers a new code or approval request for the login; a high-risk login
+	// includes the login details, so it doubles as the step-up verification
+	Send(ctx context.Context, user *models.User, ch *loginChallenge) error
+	// Prompt tells the user where to look
+	Prompt(user *models.User) string
+}
+
+var otpChannels = map[string]otpChannel{
+	models.TwoFactorEmail: emailOTPChannel{},
+	models.TwoFactorSMS:   smsOTPChannel{},
+	models.TwoFactorPush:  pushOTPChannel{},
+}
+
+// Order the channels are offered in
+var otpChannelNames = []string{models.TwoFactorEmail, models.TwoFactorSMS, models.TwoFactorPush}
+
+// availableOTPChannels lists the channels the user can switch a pending login to
+func availableOTPChannels(ctx context.Context, user *models.User) []string {
+	names := []string{}
+	for _, name := range otpChannelNames {
+		if otpChannels[name].Available(ctx, user) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// sendOverChannel checks the channel can be used and its limit, then sends
+func sendOverChannel(ctx context.Context, channel otpChannel, user *models.User, ch *loginChallenge) error {
+	if !channel.Available(ctx, user) {
+		return errChannelUnavailable
+	}
+	if err := takeChannelQuota(ctx, channel.Name(), channelRateKey(channel.Name(), user.ID.Hex()), otpChannelLimits[channel.Name()]); err != nil {
+		return err
+	}
+	return channel.Send(ctx, user, ch)
+}
+
+// sendLoginChallenge sends the second factor over the login's channel and falls back
+// to email when that channel can't be used right now. When it fails the response is
+// already written.
+func sendLoginChallenge(c *gin.Context, user *models.User, ch *loginChallenge) (otpChannel, bool) {
+	ctx := c.Request.Context()
+
+	channel := otpChannels[ch.Factor]
+	if channel.Name() != models.TwoFactorEmail {
+		err := sendOverChannel(ctx, channel, user, ch)
+		if err == nil {
+			return channel, true
+		}
+		logSecurityEvent(c, user, "warning", "otp_channel_fallback",
+			fmt.Sprintf("User %s %s unavailable, falling back to email: %v", user.Username, channel.Name(), err))
+
+		channel = otpChannels[models.TwoFactorEmail]
+		if err := ch.setFactor(ctx, channel.Name()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save login"})
+			return nil, false
+		}
+	}
+
+	if !respondChannelError(c, user, channel, sendOverChannel(ctx, channel, user, ch)) {
+		return nil, false
+	}
+	return channel, true
+}
+
+// respondChannelError writes the response for a failed send and reports whether err was nil
+func respondChannelError(c *gin.Context, user *models.User, channel otpChannel, err error) bool {
+	var limited *channelLimitedError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &limited):
+		logSecurityEvent(c, user, "blocked", "otp_rate_limited", fmt.Sprintf("User %s %s limit reached", user.Username, channel.Name()))
+		seconds := int(limited.retryAfter.Round(time.Second).Seconds())
+		if seconds < 1 {
+			seconds = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       fmt.Sprintf("Too many codes requested by %s. Please try again later.", channel.Name()),
+			"retry_after": seconds,
+		})
+	case errors.Is(err, errChannelUnavailable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You can't receive codes by %s", channel.Name())})
+	default:
+		logSecurityEvent(c, user, "error", "otp_send", fmt.Sprintf("User %s %s delivery failed: %v", user.Username, channel.Name(), err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to send the code by %s", channel.Name())})
+	}
+	return false
+}
+
+// issueLoginCode replaces the code the pending login accepts
+func issueLoginCode(ctx context.Context, ch *loginChallenge) (string, error) {
+	code, err := utils.GenerateOTP()
+	if err != nil {
+		return "", err
+	}
+	if err := ch.setCode(ctx, code); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// emailOTPChannel sends codes through the email outbox
+type emailOTPChannel struct{}
+
+func (emailOTPChannel) Name() string { return models.TwoFactorEmail }
+
+func (emailOTPChannel) Available(ctx context.Context, user *models.User) bool { return true }
+
+func (emailOTPChannel) Send(ctx context.Context, user *models.User, ch *loginChallenge) error {
+	code, err := issueLoginCode(ctx, ch)
+	if err != nil {
+		return err
+	}
+
+	minutes := int(loginCodeTTL.Minutes())
+	if ch.Risk != nil && ch.Risk.StepUp {
+		data := loginDetails(ch.Risk)
+		data["Code"] = code
+		data["Minutes"] = minutes
+		queueEmail(user, user.Email, "login_verification", data, loginCodeTTL)
+	} else {
+		queueEmail(user, user.Email, "login_otp", map[string]interface{}{
+			"Code":    code,
+			"Minutes": minutes,
+		}, loginCodeTTL)
+	}
+	return nil
+}
+
+func (emailOTPChannel) Prompt(user *models.User) string { return "OTP sent to your email" }
+
+// Text messages per locale; kept short so they fit in one SMS
+var smsTexts = map[string]map[string]string{
+	"login_code": {
+		"en": "%s is your %s login code. It expires in %d minutes. Don't share it with anyone.",
+		"sr": "%s je vaš %s kod za prijavu. Važi %d minuta. Ne delite ga ni sa kim.",
+	},
+	"login_verification": {
+		"en": "%s is your %s code to confirm a sign-in from %s. It expires in %d minutes. If this wasn't you, change your password.",
+		"sr": "%s je vaš %s kod za potvrdu prijave sa lokacije %s. Važi %d minuta. Ako to niste bili vi, promenite lozinku.",
+	},
+	"phone_verification": {
+		"en": "%s is your %s code to verify this phone number. It expires in %d minutes.",
+		"sr": "%s je vaš %s kod za potvrdu ovog broja telefona. Važi %d minuta.",
+	},
+}
+
+func smsText(user *models.User, name string, args ...interface{}) string {
+	texts := smsTexts[name]
+	text, ok := texts[userLocale(user)]
+	if !ok {
+		text = texts["en"]
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// sendSMS sends a text message, giving the provider a bounded time to answer
+func sendSMS(ctx context.Context, to, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	return smsSender.Send(ctx, to, body)
+}
+
+// smsOTPChannel texts codes to the user's verified phone number
+type smsOTPChannel struct{}
+
+func (smsOTPChannel) Name() string { return models.TwoFactorSMS }
+
+func (smsOTPChannel) Available(ctx context.Context, user *models.User) bool {
+	return user.HasVerifiedPhone()
+}
+
+func (smsOTPChannel) Send(ctx context.Context, user *models.User, ch *loginChallenge) error {
+	if err := takeChannelQuota(ctx, models.TwoFactorSMS, smsNumberRateKey(user.Phone), smsNumberLimit); err != nil {
+		return err
+	}
+	code, err := issueLoginCode(ctx, ch)
+	if err != nil {
+		return err
+	}
+
+	minutes := int(loginCodeTTL.Minutes())
+	var text string
+	if ch.Risk != nil && ch.Risk.StepUp {
+		text = smsText(user, "login_verification", code, totpIssuer(), locationOrIP(&ch.Risk.Place), minutes)
+	} else {
+		text = smsText(user, "login_code", code, totpIssuer(), minutes)
+	}
+	return sendSMS(ctx, user.Phone, text)
+}
+
+func (smsOTPChannel) Prompt(user *models.User) string {
+	return "OTP sent by SMS to " + sms.Mask(user.Phone)
+}
+
+// pushOTPChannel asks a device where the user is signed in to approve the login
+type pushOTPChannel struct{}
+
+func (pushOTPChannel) Name() string { return models.TwoFactorPush }
+
+func (pushOTPChannel) Available(ctx context.Context, user *models.User) bool {
+	n, err := redisClient.SCard(ctx, userSessionsKey(user.ID.Hex())).Result()
+	return err == nil && n > 0
+}
+
+func (pushOTPChannel) Send(ctx context.Context, user *models.User, ch *loginChallenge) error {
+	approval, err := createLoginApproval(ctx, user, ch)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Sign-in attempt from %s (%s). Open the app on a signed-in device to approve or deny it.",
+		approval.Device, locationOrIP(&approval.Place))
+	go sendNotification(user.ID.Hex(), "login_approval", message)
+	return nil
+}
+
+func (pushOTPChannel) Prompt(user *models.User) string {
+	return "Approve the sign-in on a device where you're signed in"
+}
+
+// ResendOTP sends a pending login a new code or approval request, over the same channel
+// or another one the user picks
+func ResendOTP(c *gin.Context) {
+	var req models.ResendOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	ch, user, ok := pendingLogin(c, req.TempToken, "resend_otp")
+	if !ok {
+		return
+	}
+
+	if ch.attemptsExhausted(ctx) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes. Please log in again."})
+		return
+	}
+
+	method := req.Method
+	if method == "" {
+		method = ch.Factor
+	}
+
+	stepUp := ch.Risk != nil && ch.Risk.StepUpPending
+	switch {
+	case stepUp:
+		// Only a code can finish the step-up of an authenticator login
+		if method == models.TwoFactorPush || method == models.TwoFactorTOTP {
+			method = models.TwoFactorEmail
+		}
+	case ch.Factor == models.TwoFactorTOTP:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Enter the code from your authenticator app or a recovery code"})
+		return
+	}
+
+	channel := otpChannels[method]
+	if !respondChannelError(c, user, channel, sendOverChannel(ctx, channel, user, ch)) {
+		return
+	}
+	if !stepUp && ch.Factor != channel.Name() {
+		if err := ch.setFactor(ctx, channel.Name()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save login"})
+			return
+		}
+	}
+
+	logSecurityEvent(c, user, "success", "login_otp_resent", fmt.Sprintf("User %s requested a new %s challenge", user.Username, channel.Name()))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    channel.Prompt(user),
+		"temp_token": req.TempToken,
+		"method":     channel.Name(),
+	})
+}
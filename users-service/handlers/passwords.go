@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"example.com/users-service/config"
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+// passwordReused reports whether the password matches the current one or one of the
+// last config.PasswordHistorySize passwords
+func passwordReused(user *models.User, password string) bool {
+	if match, _ := utils.VerifyPassword(user.PasswordHash, password); match {
+		return true
+	}
+	for i, hash := range user.PasswordHistory {
+		if i >= config.PasswordHistorySize {
+			break
+		}
+		if match, _ := utils.VerifyPassword(hash, password); match {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordChangeFields returns the fields that store a new password hash and remember
+// it in the history, trimmed to config.PasswordHistorySize
+func passwordChangeFields(user *models.User, hash string) bson.M {
+	history := append([]string{hash}, user.PasswordHistory...)
+	if len(user.PasswordHistory) == 0 && user.PasswordHash != "" {
+		// Accounts created before the history existed only know their current hash
+		history = append(history, user.PasswordHash)
+	}
+	if len(history) > config.PasswordHistorySize {
+		history = history[:config.PasswordHistorySize]
+	}
+	return bson.M{
+		"password_hash":    hash,
+		"password_history": history,
+	}
+}
+
+// rehashPassword replaces a legacy bcrypt or weaker Argon2id hash after a successful
+// login. The update is conditioned on the old hash so a concurrent password change wins.
+func rehashPassword(ctx context.Context, user *models.User, password string) {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for %s: %v", user.Username, err)
+		return
+	}
+
+	update := bson.M{"password_hash": hash}
+	if len(user.PasswordHistory) > 0 && user.PasswordHistory[0] == user.PasswordHash {
+		history := append([]string{hash}, user.PasswordHistory[1:]...)
+		update["password_history"] = history
+	}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "password_hash": user.PasswordHash},
+		bson.M{"$set": update},
+	)
+	if err != nil {
+		log.Printf("Failed to rehash password for %s: %v", user.Username, err)
+		return
+	}
+	user.PasswordHash = hash
+}
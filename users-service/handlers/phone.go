@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"example.com/users-service/models"
+	"example.com/users-service/sms"
+	"example.com/users-service/utils"
+)
+
+// A number only becomes the user's phone once they enter the code texted to it. The
+// code is only sent after the current password is entered, so the code also serves as
+// the recent password check when verifying.
+const (
+	phoneCodeTTL         = 10 * time.Minute
+	phoneCodeMaxAttempts = 5
+)
+
+type pendingPhone struct {
+	Phone    string `json:"phone"`
+	Code     string `json:"code"`
+	Attempts int    `json:"attempts"`
+}
+
+func pendingPhoneKey(userID string) string {
+	return "phone_verify:" + userID
+}
+
+// RequestPhoneVerification texts a code to a new phone number
+func RequestPhoneVerification(c *gin.Context) {
+	var req models.PhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	phone, err := sms.NormalizeE164(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Enter the phone number with its country code, e.g. +381641234567"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if match, _ := utils.VerifyPassword(user.PasswordHash, req.Password); !match {
+		logSecurityEvent(c, nil, "failed", "phone_verification", fmt.Sprintf("User %s entered invalid password", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+	if user.Phone == phone && user.PhoneVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This phone number is already verified"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	channel := otpChannels[models.TwoFactorSMS]
+	err = takeChannelQuota(ctx, models.TwoFactorSMS, channelRateKey(models.TwoFactorSMS, user.ID.Hex()), otpChannelLimits[models.TwoFactorSMS])
+	if err == nil {
+		err = takeChannelQuota(ctx, models.TwoFactorSMS, smsNumberRateKey(phone), smsNumberLimit)
+	}
+	if !respondChannelError(c, user, channel, err) {
+		return
+	}
+
+	code, err := utils.GenerateOTP()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
+		return
+	}
+	raw, _ := json.Marshal(pendingPhone{Phone: phone, Code: code})
+	if err := redisClient.Set(ctx, pendingPhoneKey(user.ID.Hex()), raw, phoneCodeTTL).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save code"})
+		return
+	}
+
+	text := smsText(user, "phone_verification", code, totpIssuer(), int(phoneCodeTTL.Minutes()))
+	if !respondChannelError(c, user, channel, sendSMS(ctx, phone, text)) {
+		redisClient.Del(ctx, pendingPhoneKey(user.ID.Hex()))
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "phone_verification_sent", fmt.Sprintf("User %s verifying phone %s", user.Username, sms.Mask(phone)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Verification code sent to " + sms.Mask(phone),
+		"phone":   phone,
+	})
+}
+
+// VerifyPhone makes the number the code was sent to the user's phone
+func VerifyPhone(c *gin.Context) {
+	var req models.PhoneCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := pendingPhoneKey(user.ID.Hex())
+	raw, err := redisClient.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No phone verification in progress or the code expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load code"})
+		return
+	}
+	var pending pendingPhone
+	if err := json.Unmarshal(raw, &pending); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load code"})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(pending.Code), []byte(req.Code)) != 1 {
+		pending.Attempts++
+		if pending.Attempts >= phoneCodeMaxAttempts {
+			redisClient.Del(ctx, key)
+			logSecurityEvent(c, nil, "blocked", "phone_verify", fmt.Sprintf("User %s out of phone code attempts", user.Username))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes. Request a new one."})
+			return
+		}
+		raw, _ = json.Marshal(pending)
+		redisClient.SetArgs(ctx, key, raw, redis.SetArgs{Mode: "XX", KeepTTL: true})
+
+		logSecurityEvent(c, nil, "failed", "phone_verify", fmt.Sprintf("User %s invalid phone code", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"phone":          pending.Phone,
+			"phone_verified": true,
+			"updated_at":     time.Now(),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save phone number"})
+		return
+	}
+	redisClient.Del(ctx, key)
+
+	logSecurityEvent(c, nil, "success", "phone_verified", fmt.Sprintf("User %s verified phone %s", user.Username, sms.Mask(pending.Phone)))
+	sendPhoneChangedNotice(user, pending.Phone, user.Phone)
+
+	c.JSON(http.StatusOK, gin.H{"phone": pending.Phone, "phone_verified": true})
+}
+
+// RemovePhone deletes the user's phone number; SMS codes fall back to email
+func RemovePhone(c *gin.Context) {
+	var req models.RemovePhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if match, _ := utils.VerifyPassword(user.PasswordHash, req.Password); !match {
+		logSecurityEvent(c, nil, "failed", "phone_remove", fmt.Sprintf("User %s entered invalid password", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+	if user.Phone == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No phone number set"})
+		return
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"phone_verified": false, "updated_at": time.Now()},
+		"$unset": bson.M{"phone": ""},
+	}
+	if user.TwoFactorMethod == models.TwoFactorSMS {
+		update["$set"].(bson.M)["two_factor_method"] = models.TwoFactorEmail
+	}
+
+	ctx := c.Request.Context()
+	if _, err := usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove phone number"})
+		return
+	}
+	redisClient.Del(ctx, pendingPhoneKey(user.ID.Hex()))
+
+	logSecurityEvent(c, nil, "success", "phone_removed", fmt.Sprintf("User %s removed phone %s", user.Username, sms.Mask(user.Phone)))
+	sendPhoneChangedNotice(user, "", user.Phone)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Phone number removed"})
+}
+
+// sendPhoneChangedNotice tells the account email about a new or removed phone number;
+// SMS codes go to that number, so an unnoticed change hands over the second factor
+func sendPhoneChangedNotice(user *models.User, phone, previous string) {
+	data := map[string]interface{}{"Phone": "", "PreviousPhone": ""}
+	if phone != "" {
+		data["Phone"] = sms.Mask(phone)
+	}
+	if previous != "" {
+		data["PreviousPhone"] = sms.Mask(previous)
+	}
+	queueEmail(user, user.Email, "phone_changed", data, 0)
+}
@@ -39,20 +39,27 @@ func GetProfile(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":         user.ID.Hex(),
-		"username":   user.Username,
-		"email":      user.Email,
-		"first_name": user.FirstName,
-		"last_name":  user.LastName,
-		"role":       user.Role,
-		"created_at": user.CreatedAt,
+		"id":                 user.ID.Hex(),
+		"username":           user.Username,
+		"email":              user.Email,
+		"pending_email":      user.PendingEmail,
+		"locale":             userLocale(&user),
+		"first_name":         user.FirstName,
+		"last_name":          user.LastName,
+		"display_name":       user.DisplayName,
+		"avatar_url":         user.AvatarURL,
+		"profile_visibility": user.Visibility(),
+		"role":               user.PrimaryRole(),
+		"roles":              user.EffectiveRoles(),
+		"permissions":        user.Permissions(),
+		"created_at":         user.CreatedAt,
 	})
 }
 
 func UpdateProfile(c *gin.Context) {
 	var req models.UpdateProfileRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.LogSecurityEvent("validation_failed", "update_profile", c.ClientIP(), "Invalid request data")
+		logSecurityEvent(c, nil, "validation_failed", "update_profile", "Invalid request data")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
@@ -63,7 +70,7 @@ func UpdateProfile(c *gin.Context) {
 
 	// Validate names
 	if !utils.ValidateName(req.FirstName) || !utils.ValidateName(req.LastName) {
-		utils.LogSecurityEvent("validation_failed", "update_profile", c.ClientIP(), "Invalid name format")
+		logSecurityEvent(c, nil, "validation_failed", "update_profile", "Invalid name format")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Names must contain only letters and be 2-50 characters"})
 		return
 	}
@@ -77,13 +84,15 @@ func UpdateProfile(c *gin.Context) {
 	objID, _ := primitive.ObjectIDFromHex(userID.(string))
 	ctx := c.Request.Context()
 
-	update := bson.M{
-		"$set": bson.M{
-			"first_name": req.FirstName,
-			"last_name":  req.LastName,
-			"updated_at": time.Now(),
-		},
+	fields := bson.M{
+		"first_name": req.FirstName,
+		"last_name":  req.LastName,
+		"updated_at": time.Now(),
+	}
+	if req.Locale != "" {
+		fields["locale"] = req.Locale
 	}
+	update := bson.M{"$set": fields}
 
 	result, err := usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": objID}, update)
 	if err != nil {
@@ -96,44 +105,12 @@ func UpdateProfile(c *gin.Context) {
 		return
 	}
 
-	utils.LogSecurityEvent("success", "update_profile", c.ClientIP(), "User updated profile")
+	logSecurityEvent(c, nil, "success", "update_profile", "User updated profile")
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Profile updated successfully",
 		"first_name": req.FirstName,
 		"last_name":  req.LastName,
+		"locale":     req.Locale,
 	})
 }
-
-func DeleteAccount(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	objID, _ := primitive.ObjectIDFromHex(userID.(string))
-	ctx := c.Request.Context()
-
-	// Optionally we could just soft delete by setting a flag,
-	// but requirement says "Brisanje Naloga", implying hard delete or full deactivation.
-	// For "GDPR" usually means hard delete or anonymization.
-	// We will hard delete for now as it's simplest and definitive.
-
-	_, err := usersDB.Collection("users").DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
-		return
-	}
-
-	// In a real system we might want to revoke tokens here too, but Logout logic handles individual tokens.
-	// Valid tokens might technically still work until expiry if we don't blacklist them all,
-	// but since the user is gone from DB, most protected routes should fail if they check DB.
-	// (Our AuthMiddleware verifies token signature, but often checks DB or Redis too?
-	// Let's assume AuthMiddleware might strictly check Redis or just signature.
-	// The handlers check DB, so they will fail if user is gone.)
-
-	utils.LogSecurityEvent("success", "delete_account", c.ClientIP(), "User deleted account")
-
-	c.JSON(http.StatusOK, gin.H{"message": "Account deleted successfully"})
-}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const roleAuditCollection = "role_audit"
+
+var errConcurrentUpdate = errors.New("user modified concurrently")
+
+// EnsureRoleAuditIndexes creates indexes for browsing the audit log per user
+func EnsureRoleAuditIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("target_created_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("created_at_idx"),
+		},
+	}
+
+	if _, err := db.Collection(roleAuditCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for role_audit ensured")
+}
+
+// BootstrapAdmins grants admin to the usernames listed in BOOTSTRAP_ADMINS, which is
+// how the first admin is created now that roles are no longer derived from the email
+func BootstrapAdmins() {
+	raw := os.Getenv("BOOTSTRAP_ADMINS")
+	if raw == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, username := range strings.Split(raw, ",") {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+
+		var user models.User
+		if err := usersDB.Collection("users").FindOne(ctx, bson.M{"username": username}).Decode(&user); err != nil {
+			log.Printf("Bootstrap admin %s not found, skipping", username)
+			continue
+		}
+		if user.HasRole(models.RoleAdmin) {
+			continue
+		}
+
+		if err := changeRoles(ctx, &user, models.RoleAdmin, "grant"); err != nil {
+			log.Printf("Failed to bootstrap admin %s: %v", username, err)
+			continue
+		}
+		recordRoleChange(ctx, &user, models.RoleAdmin, "grant", "system", "system", "")
+		log.Printf("Granted admin role to %s (BOOTSTRAP_ADMINS)", username)
+	}
+}
+
+// changeRoles grants or revokes one role. The write is conditioned on updated_at so
+// two admins editing the same user at once can't silently overwrite each other.
+func changeRoles(ctx context.Context, user *models.User, role models.Role, action string) error {
+	roles := []models.Role{}
+	for _, r := range user.EffectiveRoles() {
+		if r != models.RoleRegular && !(action == "revoke" && r == role) {
+			roles = append(roles, r)
+		}
+	}
+	if action == "grant" {
+		roles = append(roles, role)
+	}
+
+	updated := models.User{Roles: roles}
+	now := time.Now()
+
+	res, err := usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "updated_at": user.UpdatedAt},
+		bson.M{"$set": bson.M{
+			"roles":      roles,
+			"role":       updated.PrimaryRole(),
+			"updated_at": now,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errConcurrentUpdate
+	}
+
+	user.Roles = roles
+	user.Role = updated.PrimaryRole()
+	user.UpdatedAt = now
+	return nil
+}
+
+func recordRoleChange(ctx context.Context, target *models.User, role models.Role, action, actorID, actorUsername, ip string) {
+	entry := models.RoleAuditEntry{
+		Action:         action,
+		Role:           role,
+		TargetID:       target.ID,
+		TargetUsername: target.Username,
+		ActorID:        actorID,
+		ActorUsername:  actorUsername,
+		IP:             ip,
+		CreatedAt:      time.Now(),
+	}
+	if _, err := usersDB.Collection(roleAuditCollection).InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to write role audit entry: %v", err)
+	}
+
+	utils.RecordSecurityEvent(models.SecurityEvent{
+		Type:    "success",
+		Action:  "role_" + action,
+		ActorID: actorID,
+		Actor:   actorUsername,
+		IP:      ip,
+		TraceID: utils.TraceID(ctx),
+		Details: fmt.Sprintf("%s %s role %s for %s", actorUsername, action, role, target.Username),
+	})
+}
+
+func findTargetUser(c *gin.Context) (*models.User, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(c.Request.Context(), bson.M{"_id": objID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	return &user, true
+}
+
+func userRolesResponse(user *models.User) gin.H {
+	return gin.H{
+		"user_id":     user.ID.Hex(),
+		"username":    user.Username,
+		"role":        user.PrimaryRole(),
+		"roles":       user.EffectiveRoles(),
+		"permissions": user.Permissions(),
+	}
+}
+
+// GetRoles lists the roles and the permissions each one grants
+func GetRoles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"roles": models.RolePermissions})
+}
+
+// GetUserRoles shows the roles and effective permissions of a user
+func GetUserRoles(c *gin.Context) {
+	user, ok := findTargetUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, userRolesResponse(user))
+}
+
+// GrantRole adds a role to a user
+func GrantRole(c *gin.Context) {
+	var req models.GrantRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if !models.IsValidRole(req.Role) || req.Role == models.RoleRegular {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	user, ok := findTargetUser(c)
+	if !ok {
+		return
+	}
+	if user.HasRole(req.Role) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already has this role"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := changeRoles(ctx, user, req.Role, "grant"); err != nil {
+		if err == errConcurrentUpdate {
+			c.JSON(http.StatusConflict, gin.H{"error": "User was modified concurrently, please retry"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant role"})
+		return
+	}
+
+	recordRoleChange(ctx, user, req.Role, "grant", c.GetString("user_id"), c.GetString("username"), c.ClientIP())
+
+	// New permissions show up on the user's next token refresh
+	c.JSON(http.StatusOK, userRolesResponse(user))
+}
+
+// RevokeRole removes a role from a user and signs them out so the old permissions stop working
+func RevokeRole(c *gin.Context) {
+	role := models.Role(c.Param("role"))
+	if !models.IsValidRole(role) || role == models.RoleRegular {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	user, ok := findTargetUser(c)
+	if !ok {
+		return
+	}
+	if !user.HasRole(role) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User does not have this role"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if role == models.RoleAdmin {
+		if user.ID.Hex() == c.GetString("user_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot revoke your own admin role"})
+			return
+		}
+		admins, err := usersDB.Collection("users").CountDocuments(ctx, bson.M{
+			"$or": []bson.M{{"role": models.RoleAdmin}, {"roles": models.RoleAdmin}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if admins <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot revoke the last admin"})
+			return
+		}
+	}
+
+	if err := changeRoles(ctx, user, role, "revoke"); err != nil {
+		if err == errConcurrentUpdate {
+			c.JSON(http.StatusConflict, gin.H{"error": "User was modified concurrently, please retry"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke role"})
+		return
+	}
+
+	recordRoleChange(ctx, user, role, "revoke", c.GetString("user_id"), c.GetString("username"), c.ClientIP())
+
+	if _, err := revokeAllSessions(ctx, user.ID.Hex(), ""); err != nil {
+		log.Printf("Failed to revoke sessions for %s: %v", user.Username, err)
+	}
+
+	c.JSON(http.StatusOK, userRolesResponse(user))
+}
+
+// GetRoleAudit lists role changes, newest first, optionally for one user
+func GetRoleAudit(c *gin.Context) {
+	filter := bson.M{}
+	if userID := c.Query("user_id"); userID != "" {
+		objID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+		filter["target_id"] = objID
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := usersDB.Collection(roleAuditCollection).Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit log"})
+		return
+	}
+
+	entries := []models.RoleAuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
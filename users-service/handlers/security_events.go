@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const (
+	securityEventsCollection = "security_events"
+
+	// Events stay searchable this long; the log files have their own retention
+	securityEventRetention = 90 * 24 * time.Hour
+
+	securityEventBatch         = 100
+	securityEventFlushInterval = time.Second
+
+	// Window of the user's own "recent security activity" view
+	securityActivityWindow = 30 * 24 * time.Hour
+)
+
+// Events waiting to be stored; requests never block on the database for logging
+var securityEventQueue = make(chan models.SecurityEvent, 1000)
+
+// InitSecurityEvents sends every logged security event to the queryable store as well
+func InitSecurityEvents() {
+	utils.SetSecurityEventSink(func(event models.SecurityEvent) {
+		select {
+		case securityEventQueue <- event:
+		default:
+			log.Printf("Security event queue full, %s event not stored", event.Action)
+		}
+	})
+}
+
+// EnsureSecurityEventIndexes supports searching by user, IP and action, newest first, and
+// drops events after securityEventRetention. IDs are assigned when the event happens, so
+// they sort by time.
+func EnsureSecurityEventIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "time", Value: 1}},
+			Options: options.Index().SetName("time_ttl").SetExpireAfterSeconds(int32(securityEventRetention.Seconds())),
+		},
+		{
+			Keys:    bson.D{{Key: "actor_id", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("actor_id_id").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "ip", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("ip_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("action_id"),
+		},
+	}
+	if _, err := db.Collection(securityEventsCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Fatalf("Failed to create security event indexes: %v", err)
+	}
+	log.Println("MongoDB indexes for security events ensured")
+}
+
+// StartSecurityEventWriter stores queued events in batches
+func StartSecurityEventWriter(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(securityEventFlushInterval)
+		defer ticker.Stop()
+
+		batch := make([]interface{}, 0, securityEventBatch)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			// Unordered so one bad document doesn't drop the rest of the batch
+			insertCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, err := usersDB.Collection(securityEventsCollection).InsertMany(insertCtx, batch, options.InsertMany().SetOrdered(false))
+			cancel()
+			if err != nil {
+				log.Printf("Failed to store %d security events: %v", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				// Store what is already queued before stopping
+				for {
+					select {
+					case event := <-securityEventQueue:
+						batch = append(batch, event)
+					default:
+						flush()
+						return
+					}
+				}
+			case event := <-securityEventQueue:
+				batch = append(batch, event)
+				if len(batch) >= securityEventBatch {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+// logSecurityEvent logs an event of the current request. The actor is the authenticated
+// user, or user when the request is the one logging them in.
+func logSecurityEvent(c *gin.Context, user *models.User, eventType, action, details string) {
+	event := utils.RequestSecurityEvent(c, eventType, action, details)
+	if user != nil {
+		if !user.ID.IsZero() {
+			event.ActorID = user.ID.Hex()
+		}
+		event.Actor = user.Username
+	}
+	utils.RecordSecurityEvent(event)
+}
+
+// GetSecurityEvents searches the security log by user, IP, action, type and time range,
+// newest first. Older pages are fetched with before=<id of the last event>.
+func GetSecurityEvents(c *gin.Context) {
+	filter := bson.M{}
+	if userID := c.Query("user_id"); userID != "" {
+		if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+		filter["actor_id"] = userID
+	}
+	for param, field := range map[string]string{"username": "actor", "ip": "ip", "action": "action", "type": "type", "trace_id": "trace_id"} {
+		if value := c.Query(param); value != "" {
+			filter[field] = value
+		}
+	}
+
+	timeRange := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lt"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " time, expected RFC 3339"})
+			return
+		}
+		timeRange[op] = t
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+
+	if before := c.Query("before"); before != "" {
+		objID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before id"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": objID}
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	events, err := findSecurityEvents(c.Request.Context(), filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load security events"})
+		return
+	}
+
+	response := gin.H{"events": events}
+	if int64(len(events)) == limit {
+		response["next_before"] = events[len(events)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSecurityActivity shows the user their own recent security events: logins, password
+// and email changes, new devices and the like
+func GetSecurityActivity(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filter := bson.M{
+		"actor_id": c.GetString("user_id"),
+		"time":     bson.M{"$gte": time.Now().Add(-securityActivityWindow)},
+	}
+	events, err := findSecurityEvents(c.Request.Context(), filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load security activity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+func findSecurityEvents(ctx context.Context, filter bson.M, limit int64) ([]models.SecurityEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := usersDB.Collection(securityEventsCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := []models.SecurityEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+// How long a session survives without being refreshed
+var refreshTokenTTL = 30 * 24 * time.Hour
+
+// Rotated-out refresh hashes kept per session for reuse detection
+const maxPreviousRefreshHashes = 20
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errRefreshReused   = errors.New("refresh token reused")
+	errRefreshInvalid  = errors.New("invalid refresh token")
+)
+
+func init() {
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && ttl > 0 {
+		refreshTokenTTL = ttl
+	}
+}
+
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func userSessionsKey(userID string) string {
+	return "sessions:" + userID
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitRefreshToken parses the opaque "<session id>.<secret>" token
+func splitRefreshToken(token string) (string, string, bool) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", false
+	}
+	return sessionID, secret, true
+}
+
+func loadSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	raw, err := redisClient.Get(ctx, sessionKey(sessionID)).Bytes()
+	if err == redis.Nil {
+		return nil, errSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.Session
+	if err := json.Unmarshal(raw, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func saveSession(ctx context.Context, pipe redis.Pipeliner, session *models.Session) error {
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(session.ExpiresAt)
+	pipe.Set(ctx, sessionKey(session.ID), raw, ttl)
+	pipe.SAdd(ctx, userSessionsKey(session.UserID), session.ID)
+	pipe.Expire(ctx, userSessionsKey(session.UserID), refreshTokenTTL)
+	return nil
+}
+
+// generateAccessToken issues a JWT carrying the user's roles and permissions
+func generateAccessToken(user *models.User, sessionID string) (string, error) {
+	roles := make([]string, 0, len(user.EffectiveRoles()))
+	for _, r := range user.EffectiveRoles() {
+		roles = append(roles, string(r))
+	}
+	return utils.GenerateJWT(user.ID.Hex(), user.Username, string(user.PrimaryRole()), roles, user.Permissions(), sessionID)
+}
+
+// createSession starts a new session for the device making the request and
+// returns the access token bound to it together with the first refresh token
+func createSession(c *gin.Context, user *models.User) (string, string, error) {
+	ctx := c.Request.Context()
+
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:          sessionID,
+		UserID:      user.ID.Hex(),
+		Device:      utils.DescribeDevice(c.Request.UserAgent()),
+		UserAgent:   c.Request.UserAgent(),
+		IP:          c.ClientIP(),
+		CreatedAt:   now,
+		LastSeen:    now,
+		ExpiresAt:   now.Add(refreshTokenTTL),
+		RefreshHash: hashRefreshSecret(secret),
+	}
+
+	pipe := redisClient.TxPipeline()
+	if err := saveSession(ctx, pipe, session); err != nil {
+		return "", "", err
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", "", err
+	}
+
+	token, err := generateAccessToken(user, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return token, sessionID + "." + secret, nil
+}
+
+// rotateRefreshToken swaps the presented refresh token for a new one. Presenting a
+// token that was already rotated out means it leaked, so the whole session is revoked.
+func rotateRefreshToken(ctx context.Context, sessionID, secret, ip string) (*models.Session, string, error) {
+	newSecret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	presented := hashRefreshSecret(secret)
+	var session *models.Session
+
+	txf := func(tx *redis.Tx) error {
+		raw, err := tx.Get(ctx, sessionKey(sessionID)).Bytes()
+		if err == redis.Nil {
+			return errSessionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		session = &models.Session{}
+		if err := json.Unmarshal(raw, session); err != nil {
+			return err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(session.RefreshHash)) != 1 {
+			for _, old := range session.PreviousHashes {
+				if subtle.ConstantTimeCompare([]byte(presented), []byte(old)) == 1 {
+					return errRefreshReused
+				}
+			}
+			return errRefreshInvalid
+		}
+
+		session.PreviousHashes = append(session.PreviousHashes, session.RefreshHash)
+		if len(session.PreviousHashes) > maxPreviousRefreshHashes {
+			session.PreviousHashes = session.PreviousHashes[len(session.PreviousHashes)-maxPreviousRefreshHashes:]
+		}
+		session.RefreshHash = hashRefreshSecret(newSecret)
+		session.LastSeen = time.Now()
+		session.ExpiresAt = session.LastSeen.Add(refreshTokenTTL)
+		session.IP = ip
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return saveSession(ctx, pipe, session)
+		})
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		err = redisClient.Watch(ctx, txf, sessionKey(sessionID))
+		if err == redis.TxFailedErr {
+			// A concurrent refresh won; retrying with the same token reports it as reuse
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return session, "", err
+	}
+	return session, sessionID + "." + newSecret, nil
+}
+
+func revokeSession(ctx context.Context, userID, sessionID string) error {
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, sessionKey(sessionID))
+	pipe.SRem(ctx, userSessionsKey(userID), sessionID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// revokeAllSessions ends every session of the user except keepSessionID (if set)
+func revokeAllSessions(ctx context.Context, userID, keepSessionID string) (int, error) {
+	ids, err := redisClient.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	pipe := redisClient.TxPipeline()
+	for _, id := range ids {
+		if id == keepSessionID {
+			continue
+		}
+		pipe.Del(ctx, sessionKey(id))
+		pipe.SRem(ctx, userSessionsKey(userID), id)
+		revoked++
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+func currentSessionID(c *gin.Context) string {
+	claimsAny, exists := c.Get("claims")
+	if !exists {
+		return ""
+	}
+	return claimsAny.(*utils.Claims).SessionID
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token
+func RefreshToken(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	sessionID, secret, ok := splitRefreshToken(req.RefreshToken)
+	if !ok {
+		logSecurityEvent(c, nil, "failed", "refresh_token", "Malformed refresh token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	session, refreshToken, err := rotateRefreshToken(ctx, sessionID, secret, c.ClientIP())
+	switch {
+	case errors.Is(err, errRefreshReused):
+		// Both the thief and the victim hold tokens from this family, end it for everyone
+		_ = revokeSession(ctx, session.UserID, session.ID)
+		logSecurityEvent(c, nil, "alert", "refresh_token",
+			fmt.Sprintf("Refresh token reuse detected for user %s, session %s revoked", session.UserID, session.ID))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+		return
+	case errors.Is(err, errSessionNotFound), errors.Is(err, errRefreshInvalid):
+		logSecurityEvent(c, nil, "failed", "refresh_token", "Invalid or expired refresh token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	// Claims are rebuilt from the stored user so role changes and lockouts take effect
+	objID, err := primitive.ObjectIDFromHex(session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		_ = revokeSession(ctx, session.UserID, session.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if time.Now().Before(user.LockedUntil) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is temporarily locked"})
+		return
+	}
+	if user.PendingDeletion() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is being deleted"})
+		return
+	}
+
+	token, err := generateAccessToken(&user, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
+	})
+}
+
+// GetSessions lists the user's active sessions, marking the one making the request
+func GetSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	ids, err := redisClient.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sessions"})
+		return
+	}
+
+	current := currentSessionID(c)
+	sessions := make([]gin.H, 0, len(ids))
+	for _, id := range ids {
+		session, err := loadSession(ctx, id)
+		if errors.Is(err, errSessionNotFound) {
+			// Expired on its own, drop the stale index entry
+			redisClient.SRem(ctx, userSessionsKey(userID), id)
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sessions"})
+			return
+		}
+
+		sessions = append(sessions, gin.H{
+			"id":         session.ID,
+			"device":     session.Device,
+			"user_agent": session.UserAgent,
+			"ip":         session.IP,
+			"created_at": session.CreatedAt,
+			"last_seen":  session.LastSeen,
+			"expires_at": session.ExpiresAt,
+			"current":    session.ID == current,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession signs out a single device
+func RevokeSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.Param("id")
+	ctx := c.Request.Context()
+
+	session, err := loadSession(ctx, sessionID)
+	if err != nil || session.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := revokeSession(ctx, userID, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "revoke_session", fmt.Sprintf("Session %s revoked", sessionID))
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeAllSessions signs out every device, optionally keeping the current one
+func RevokeAllSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	keep := ""
+	if c.Query("keep_current") == "true" {
+		keep = currentSessionID(c)
+	}
+
+	revoked, err := revokeAllSessions(ctx, userID, keep)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "revoke_sessions", fmt.Sprintf("%d sessions revoked", revoked))
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "revoked": revoked})
+}
@@ -0,0 +1,769 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const (
+	followsCollection = "follows"
+	blocksCollection  = "blocks"
+
+	// How many top artists and recent ratings a profile shows
+	profileActivityLimit = 10
+	// Ratings and subscriptions are only decoration; a slow service mustn't hold up the profile
+	profileActivityTimeout = 5 * time.Second
+)
+
+// EnsureSocialIndexes keeps one follow and one block per pair of users and lets the
+// follower and following lists be read newest first
+func EnsureSocialIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	follows := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "follower_id", Value: 1}, {Key: "followee_id", Value: 1}},
+			Options: options.Index().SetName("follower_followee_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "followee_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("followee_status_idx"),
+		},
+		{
+			Keys:    bson.D{{Key: "follower_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("follower_status_idx"),
+		},
+	}
+	if _, err := db.Collection(followsCollection).Indexes().CreateMany(ctx, follows); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	blocks := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "blocker_id", Value: 1}, {Key: "blocked_id", Value: 1}},
+			Options: options.Index().SetName("blocker_blocked_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "blocked_id", Value: 1}},
+			Options: options.Index().SetName("blocked_idx"),
+		},
+	}
+	if _, err := db.Collection(blocksCollection).Indexes().CreateMany(ctx, blocks); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for follows and blocks ensured")
+}
+
+// findUserByRef looks a user up by username, or by id for links that carry one
+func findUserByRef(ctx context.Context, ref string) (*models.User, error) {
+	var user models.User
+	err := usersDB.Collection("users").FindOne(ctx, bson.M{"username": ref}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		objID, idErr := primitive.ObjectIDFromHex(ref)
+		if idErr != nil {
+			return nil, err
+		}
+		err = usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// socialTarget loads the user named in the URL. Accounts being deleted don't exist for
+// other users. When it fails the response is already written.
+func socialTarget(c *gin.Context) (*models.User, bool) {
+	target, err := findUserByRef(c.Request.Context(), c.Param("username"))
+	if err == mongo.ErrNoDocuments || (err == nil && target.DeletionScheduledFor != nil) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	return target, true
+}
+
+// visibleTarget is socialTarget for users the viewer may interact with: a block in
+// either direction makes them look like they don't exist
+func visibleTarget(c *gin.Context, viewer *models.User) (*models.User, bool) {
+	target, ok := socialTarget(c)
+	if !ok {
+		return nil, false
+	}
+	if target.ID == viewer.ID {
+		return target, true
+	}
+
+	blocked, err := isBlocked(c.Request.Context(), viewer.ID, target.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	if blocked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return target, true
+}
+
+// isBlocked reports whether either user blocked the other
+func isBlocked(ctx context.Context, a, b primitive.ObjectID) (bool, error) {
+	count, err := usersDB.Collection(blocksCollection).CountDocuments(ctx, bson.M{
+		"$or": []bson.M{
+			{"blocker_id": a, "blocked_id": b},
+			{"blocker_id": b, "blocked_id": a},
+		},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// findFollow returns the follow from follower to followee, or nil if there is none
+func findFollow(ctx context.Context, follower, followee primitive.ObjectID) (*models.Follow, error) {
+	var follow models.Follow
+	err := usersDB.Collection(followsCollection).
+		FindOne(ctx, bson.M{"follower_id": follower, "followee_id": followee}).
+		Decode(&follow)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &follow, nil
+}
+
+// canSeeProfile tells whether the viewer may see the target's full profile and lists;
+// follow is the viewer's follow of the target, if any
+func canSeeProfile(viewer, target *models.User, follow *models.Follow) bool {
+	if viewer.ID == target.ID {
+		return true
+	}
+	switch target.Visibility() {
+	case models.ProfilePublic:
+		return true
+	case models.ProfileFollowers:
+		return follow != nil && follow.Status == models.FollowAccepted
+	}
+	return false
+}
+
+func followCounts(ctx context.Context, userID primitive.ObjectID) (followers, following int64, err error) {
+	coll := usersDB.Collection(followsCollection)
+	followers, err = coll.CountDocuments(ctx, bson.M{"followee_id": userID, "status": models.FollowAccepted})
+	if err != nil {
+		return 0, 0, err
+	}
+	following, err = coll.CountDocuments(ctx, bson.M{"follower_id": userID, "status": models.FollowAccepted})
+	return followers, following, err
+}
+
+// publicName is how the user is shown to others
+func publicName(user *models.User) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Username
+}
+
+func userCard(user *models.User) gin.H {
+	return gin.H{
+		"id":           user.ID.Hex(),
+		"username":     user.Username,
+		"display_name": publicName(user),
+		"avatar_url":   user.AvatarURL,
+	}
+}
+
+// fetchProfileActivity gets a section of the profile from another service; a failure
+// only leaves the section empty
+func fetchProfileActivity(ctx context.Context, endpoint, field string) []json.RawMessage {
+	items := []json.RawMessage{}
+
+	ctx, cancel := context.WithTimeout(ctx, profileActivityTimeout)
+	defer cancel()
+
+	req, err := newServiceRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return items
+	}
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to load %s for profile: %v", field, err)
+		return items
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to load %s for profile: status %d", field, resp.StatusCode)
+		return items
+	}
+
+	var body map[string][]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return items
+	}
+	if body[field] != nil {
+		items = body[field]
+	}
+	return items
+}
+
+// GetUserProfile shows another user's public profile. Followers-only profiles show
+// just the name and avatar to people who don't follow them, so they can ask to.
+func GetUserProfile(c *gin.Context) {
+	viewer, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	target, ok := visibleTarget(c, viewer)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	self := viewer.ID == target.ID
+
+	var follow, followsYou *models.Follow
+	if !self {
+		var err error
+		if follow, err = findFollow(ctx, viewer.ID, target.ID); err == nil {
+			followsYou, err = findFollow(ctx, target.ID, viewer.ID)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	if !self && target.Visibility() == models.ProfilePrivate {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	profile := userCard(target)
+	profile["profile_visibility"] = target.Visibility()
+	if !self {
+		profile["follow_status"] = ""
+		if follow != nil {
+			profile["follow_status"] = follow.Status
+		}
+		profile["follows_you"] = followsYou != nil && followsYou.Status == models.FollowAccepted
+	}
+
+	if !canSeeProfile(viewer, target, follow) {
+		profile["restricted"] = true
+		c.JSON(http.StatusOK, profile)
+		return
+	}
+
+	followers, following, err := followCounts(ctx, target.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	userID := target.ID.Hex()
+	limit := strconv.Itoa(profileActivityLimit)
+	profile["restricted"] = false
+	profile["followers_count"] = followers
+	profile["following_count"] = following
+	profile["member_since"] = target.CreatedAt
+	profile["top_artists"] = fetchProfileActivity(ctx,
+		userDataServices["subscriptions"]+"/api/v1/users/"+userID+"/artists?limit="+limit, "artists")
+	profile["recently_rated"] = fetchProfileActivity(ctx,
+		userDataServices["ratings"]+"/api/v1/users/"+userID+"/ratings?limit="+limit, "ratings")
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateSocialProfile sets the display name, avatar and who can see the profile
+func UpdateSocialProfile(c *gin.Context) {
+	var req models.SocialProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	fields := bson.M{"updated_at": time.Now()}
+	unset := bson.M{}
+	if req.DisplayName != nil {
+		name := utils.SanitizeString(*req.DisplayName)
+		if name == "" {
+			unset["display_name"] = ""
+		} else {
+			fields["display_name"] = name
+		}
+		user.DisplayName = name
+	}
+	if req.AvatarURL != nil {
+		if *req.AvatarURL == "" {
+			unset["avatar_url"] = ""
+		} else {
+			u, err := url.Parse(*req.AvatarURL)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar must be an http(s) URL"})
+				return
+			}
+			fields["avatar_url"] = u.String()
+		}
+		user.AvatarURL = *req.AvatarURL
+	}
+	if req.Visibility != "" {
+		fields["profile_visibility"] = req.Visibility
+	}
+
+	update := bson.M{"$set": fields}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	ctx := c.Request.Context()
+	if _, err := usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	// Nobody has to be approved any more once the profile is public
+	if req.Visibility == models.ProfilePublic && user.Visibility() != models.ProfilePublic {
+		acceptPendingFollows(ctx, user.ID)
+	}
+	if req.Visibility != "" {
+		user.ProfileVisibility = req.Visibility
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"display_name":       user.DisplayName,
+		"avatar_url":         user.AvatarURL,
+		"profile_visibility": user.Visibility(),
+	})
+}
+
+// acceptPendingFollows accepts every follow request of the user
+func acceptPendingFollows(ctx context.Context, userID primitive.ObjectID) {
+	filter := bson.M{"followee_id": userID, "status": models.FollowPending}
+	cursor, err := usersDB.Collection(followsCollection).Find(ctx, filter)
+	if err != nil {
+		return
+	}
+	var pending []models.Follow
+	if err := cursor.All(ctx, &pending); err != nil || len(pending) == 0 {
+		return
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(pending))
+	for _, follow := range pending {
+		ids = append(ids, follow.ID)
+	}
+	filter["_id"] = bson.M{"$in": ids}
+	_, err = usersDB.Collection(followsCollection).UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{"status": models.FollowAccepted, "accepted_at": time.Now()}})
+	if err != nil {
+		return
+	}
+	for _, follow := range pending {
+		backfillFeed(ctx, follow.FollowerID, userID)
+	}
+}
+
+// FollowUser follows a public profile, or asks to follow a followers-only one
+func FollowUser(c *gin.Context) {
+	viewer, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	target, ok := visibleTarget(c, viewer)
+	if !ok {
+		return
+	}
+	if target.ID == viewer.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't follow yourself"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := findFollow(ctx, viewer.ID, target.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if existing != nil {
+		c.JSON(http.StatusOK, gin.H{"username": target.Username, "status": existing.Status})
+		return
+	}
+
+	var status string
+	switch target.Visibility() {
+	case models.ProfilePublic:
+		status = models.FollowAccepted
+	case models.ProfileFollowers:
+		status = models.FollowPending
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	now := time.Now()
+	follow := models.Follow{
+		FollowerID: viewer.ID,
+		FolloweeID: target.ID,
+		Status:     status,
+		CreatedAt:  now,
+	}
+	if status == models.FollowAccepted {
+		follow.AcceptedAt = &now
+	}
+	if _, err := usersDB.Collection(followsCollection).InsertOne(ctx, follow); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// A concurrent request got there first
+			c.JSON(http.StatusOK, gin.H{"username": target.Username, "status": status})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow user"})
+		return
+	}
+
+	if status == models.FollowAccepted {
+		backfillFeed(ctx, viewer.ID, target.ID)
+		go sendNotification(target.ID.Hex(), "new_follower", fmt.Sprintf("%s started following you", publicName(viewer)))
+	} else {
+		go sendNotification(target.ID.Hex(), "follow_request", fmt.Sprintf("%s asked to follow you", publicName(viewer)))
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"username": target.Username, "status": status})
+}
+
+// UnfollowUser stops following a user, or withdraws a follow request
+func UnfollowUser(c *gin.Context) {
+	viewer, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	target, ok := socialTarget(c)
+	if !ok {
+		return
+	}
+
+	result, err := usersDB.Collection(followsCollection).DeleteOne(c.Request.Context(),
+		bson.M{"follower_id": viewer.ID, "followee_id": target.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unfollow user"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not following this user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unfollowed " + target.Username})
+}
+
+// listFollows pages through follows matching filter, newest first, as cards of the
+// users found under userField. Older pages are fetched with before=<next_before>.
+func listFollows(c *gin.Context, filter bson.M, userField, key string) {
+	if before := c.Query("before"); before != "" {
+		objID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before id"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": objID}
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	ctx := c.Request.Context()
+	cursor, err := usersDB.Collection(followsCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var follows []models.Follow
+	if err := cursor.All(ctx, &follows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(follows))
+	for _, f := range follows {
+		if userField == "follower_id" {
+			ids = append(ids, f.FollowerID)
+		} else {
+			ids = append(ids, f.FolloweeID)
+		}
+	}
+	users := map[primitive.ObjectID]*models.User{}
+	if len(ids) > 0 {
+		cursor, err = usersDB.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		var found []models.User
+		if err := cursor.All(ctx, &found); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		for i := range found {
+			users[found[i].ID] = &found[i]
+		}
+	}
+
+	cards := []gin.H{}
+	for i, f := range follows {
+		user := users[ids[i]]
+		if user == nil || user.DeletionScheduledFor != nil {
+			continue
+		}
+		card := userCard(user)
+		card["since"] = f.CreatedAt
+		if f.AcceptedAt != nil {
+			card["since"] = *f.AcceptedAt
+		}
+		cards = append(cards, card)
+	}
+
+	response := gin.H{key: cards}
+	if int64(len(follows)) == limit {
+		response["next_before"] = follows[len(follows)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// socialListTarget loads the user whose followers or following are listed, if the
+// viewer may see them
+func socialListTarget(c *gin.Context) (*models.User, bool) {
+	viewer, ok := currentUser(c)
+	if !ok {
+		return nil, false
+	}
+	target, ok := visibleTarget(c, viewer)
+	if !ok {
+		return nil, false
+	}
+
+	follow, err := findFollow(c.Request.Context(), viewer.ID, target.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	if !canSeeProfile(viewer, target, follow) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This profile is only visible to its followers"})
+		return nil, false
+	}
+	return target, true
+}
+
+// GetFollowers lists who follows a user
+func GetFollowers(c *gin.Context) {
+	target, ok := socialListTarget(c)
+	if !ok {
+		return
+	}
+	listFollows(c, bson.M{"followee_id": target.ID, "status": models.FollowAccepted}, "follower_id", "followers")
+}
+
+// GetFollowing lists whom a user follows
+func GetFollowing(c *gin.Context) {
+	target, ok := socialListTarget(c)
+	if !ok {
+		return
+	}
+	listFollows(c, bson.M{"follower_id": target.ID, "status": models.FollowAccepted}, "followee_id", "following")
+}
+
+// GetFollowRequests lists who asked to follow the user's followers-only profile
+func GetFollowRequests(c *gin.Context) {
+	objID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	listFollows(c, bson.M{"followee_id": objID, "status": models.FollowPending}, "follower_id", "requests")
+}
+
+// AcceptFollowRequest lets a user who asked follow the profile
+func AcceptFollowRequest(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	follower, ok := socialTarget(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	result, err := usersDB.Collection(followsCollection).UpdateOne(ctx,
+		bson.M{"follower_id": follower.ID, "followee_id": user.ID, "status": models.FollowPending},
+		bson.M{"$set": bson.M{"status": models.FollowAccepted, "accepted_at": time.Now()}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept request"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No follow request from this user"})
+		return
+	}
+
+	backfillFeed(ctx, follower.ID, user.ID)
+	go sendNotification(follower.ID.Hex(), "follow_accepted", fmt.Sprintf("%s accepted your follow request", publicName(user)))
+
+	c.JSON(http.StatusOK, gin.H{"username": follower.Username, "status": models.FollowAccepted})
+}
+
+// DeclineFollowRequest turns down a follow request; the user is not told
+func DeclineFollowRequest(c *gin.Context) {
+	removeFollower(c, bson.M{"status": models.FollowPending}, "No follow request from this user")
+}
+
+// RemoveFollower stops a user from following the profile
+func RemoveFollower(c *gin.Context) {
+	removeFollower(c, bson.M{"status": models.FollowAccepted}, "This user doesn't follow you")
+}
+
+func removeFollower(c *gin.Context, filter bson.M, notFound string) {
+	follower, ok := socialTarget(c)
+	if !ok {
+		return
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	filter["follower_id"] = follower.ID
+	filter["followee_id"] = objID
+
+	result, err := usersDB.Collection(followsCollection).DeleteOne(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Removed " + follower.Username})
+}
+
+// BlockUser hides two users from each other and removes follows both ways
+func BlockUser(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	target, ok := socialTarget(c)
+	if !ok {
+		return
+	}
+	if target.ID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't block yourself"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	_, err := usersDB.Collection(blocksCollection).InsertOne(ctx, models.Block{
+		BlockerID: user.ID,
+		BlockedID: target.ID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+		return
+	}
+
+	_, err = usersDB.Collection(followsCollection).DeleteMany(ctx, bson.M{
+		"$or": []bson.M{
+			{"follower_id": user.ID, "followee_id": target.ID},
+			{"follower_id": target.ID, "followee_id": user.ID},
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove follows"})
+		return
+	}
+
+	logSecurityEvent(c, user, "success", "user_blocked", fmt.Sprintf("User %s blocked %s", user.Username, target.Username))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Blocked " + target.Username})
+}
+
+// UnblockUser lifts a block; earlier follows are not restored
+func UnblockUser(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	target, ok := socialTarget(c)
+	if !ok {
+		return
+	}
+
+	result, err := usersDB.Collection(blocksCollection).DeleteOne(c.Request.Context(),
+		bson.M{"blocker_id": user.ID, "blocked_id": target.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This user is not blocked"})
+		return
+	}
+
+	logSecurityEvent(c, user, "success", "user_unblocked", fmt.Sprintf("User %s unblocked %s", user.Username, target.Username))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unblocked " + target.Username})
+}
+
+// GetBlockedUsers lists the users the current user blocked
+func GetBlockedUsers(c *gin.Context) {
+	objID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	ctx := c.Request.Context()
+
+	cursor, err := usersDB.Collection(blocksCollection).Find(ctx, bson.M{"blocker_id": objID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var blocks []models.Block
+	if err := cursor.All(ctx, &blocks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	blocked := []gin.H{}
+	for _, block := range blocks {
+		var user models.User
+		if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": block.BlockedID}).Decode(&user); err != nil {
+			continue
+		}
+		card := userCard(&user)
+		card["blocked_at"] = block.CreatedAt
+		blocked = append(blocked, card)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocked": blocked})
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+// Failed logins are counted per IP, per account and per (IP, account) pair:
+//
+//   - a pair that keeps failing backs off exponentially, which stops one client guessing
+//     one password without affecting anyone else
+//   - an account failing from many IPs needs a CAPTCHA and then backs off, but only for
+//     networks the user hasn't logged in from, so an attack can't lock the owner out
+//   - an IP failing across many accounts needs a CAPTCHA and is blocked past a hard limit
+const (
+	pairBackoffAfter     = 3
+	accountCaptchaAfter  = 5
+	accountBackoffAfter  = 10
+	ipCaptchaAfter       = 10
+	loginFailureWindow   = time.Hour
+	accountFailureWindow = 24 * time.Hour
+
+	// Wrong codes allowed per temp token, and per user across temp tokens
+	otpMaxAttempts     = 5
+	otpUserMaxAttempts = 10
+	otpFailureWindow   = 15 * time.Minute
+)
+
+var (
+	ipHourlyLimit   = 100
+	loginMaxBackoff = 15 * time.Minute
+)
+
+// InitLoginThrottle reads LOGIN_IP_HOURLY_LIMIT and LOGIN_MAX_BACKOFF
+func InitLoginThrottle() {
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_IP_HOURLY_LIMIT")); err == nil && n > 0 {
+		ipHourlyLimit = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_MAX_BACKOFF")); err == nil && d > 0 {
+		loginMaxBackoff = d
+	}
+}
+
+func throttleUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+func ipFailuresKey(ip string) string {
+	return "login_fail:ip:" + ip
+}
+func pairFailuresKey(ip, username string) string {
+	return "login_fail:pair:" + ip + ":" + throttleUsername(username)
+}
+func accountFailuresKey(username string) string {
+	return "login_fail:user:" + throttleUsername(username)
+}
+func pairBlockKey(ip, username string) string {
+	return "login_block:pair:" + ip + ":" + throttleUsername(username)
+}
+func accountBlockKey(username string) string {
+	return "login_block:user:" + throttleUsername(username)
+}
+
+// loginBackoff doubles from one second with every failure past the free ones
+func loginBackoff(failures, free int) time.Duration {
+	n := failures - free
+	if n <= 0 {
+		return 0
+	}
+	if n > 20 {
+		return loginMaxBackoff
+	}
+	delay := time.Second << (n - 1)
+	if delay > loginMaxBackoff {
+		delay = loginMaxBackoff
+	}
+	return delay
+}
+
+// loginThrottle is the state of the counters for one login attempt
+type loginThrottle struct {
+	ip       string
+	username string
+	// The user has logged in from this network before, so account-wide limits don't apply
+	knownNetwork bool
+
+	ipFailures      int64
+	accountFailures int64
+}
+
+// knownLoginNetwork reports whether the user has successfully logged in from the
+// network of ip before
+func knownLoginNetwork(ctx context.Context, user *models.User, ip string) bool {
+	profile, err := loadLoginProfile(ctx, user.ID)
+	if err != nil || profile == nil {
+		return false
+	}
+	prefix := utils.NetworkPrefix(ip)
+	for _, network := range profile.Networks {
+		if network.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// loadLoginThrottle reads the counters. It returns how long the client has to wait
+// before trying again, or 0.
+func loadLoginThrottle(ctx context.Context, t *loginThrottle) (time.Duration, error) {
+	pipe := redisClient.Pipeline()
+	ipFailures := pipe.Get(ctx, ipFailuresKey(t.ip))
+	accountFailures := pipe.Get(ctx, accountFailuresKey(t.username))
+	pairBlock := pipe.PTTL(ctx, pairBlockKey(t.ip, t.username))
+	accountBlock := pipe.PTTL(ctx, accountBlockKey(t.username))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	t.ipFailures, _ = ipFailures.Int64()
+	t.accountFailures, _ = accountFailures.Int64()
+
+	if t.ipFailures >= int64(ipHourlyLimit) {
+		ttl, err := redisClient.PTTL(ctx, ipFailuresKey(t.ip)).Result()
+		if err != nil || ttl <= 0 {
+			ttl = loginFailureWindow
+		}
+		return ttl, nil
+	}
+	if wait := pairBlock.Val(); wait > 0 {
+		return wait, nil
+	}
+	if wait := accountBlock.Val(); wait > 0 && !t.knownNetwork {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// captchaRequired tells whether the attempt has to come with a solved CAPTCHA
+func (t *loginThrottle) captchaRequired() bool {
+	if !captchaEnabled() {
+		return false
+	}
+	return t.ipFailures >= ipCaptchaAfter || (!t.knownNetwork && t.accountFailures >= accountCaptchaAfter)
+}
+
+// recordLoginFailure counts a failed attempt and starts the backoff of the pair and the
+// account once they are past their free attempts
+func recordLoginFailure(ctx context.Context, t *loginThrottle) error {
+	pipe := redisClient.TxPipeline()
+	ipFailures := pipe.Incr(ctx, ipFailuresKey(t.ip))
+	pipe.Expire(ctx, ipFailuresKey(t.ip), loginFailureWindow)
+	pairFailures := pipe.Incr(ctx, pairFailuresKey(t.ip, t.username))
+	pipe.Expire(ctx, pairFailuresKey(t.ip, t.username), loginFailureWindow)
+	accountFailures := pipe.Incr(ctx, accountFailuresKey(t.username))
+	pipe.Expire(ctx, accountFailuresKey(t.username), accountFailureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	t.ipFailures = ipFailures.Val()
+	t.accountFailures = accountFailures.Val()
+
+	if delay := loginBackoff(int(pairFailures.Val()), pairBackoffAfter); delay > 0 {
+		redisClient.Set(ctx, pairBlockKey(t.ip, t.username), 1, delay)
+	}
+	if delay := loginBackoff(int(t.accountFailures), accountBackoffAfter); delay > 0 {
+		redisClient.Set(ctx, accountBlockKey(t.username), 1, delay)
+	}
+	return nil
+}
+
+// clearLoginFailures forgets the failures of an account once its owner gets in; the
+// IP counter stays since the same client may be attacking other accounts
+func clearLoginFailures(ctx context.Context, t *loginThrottle) {
+	redisClient.Del(ctx,
+		pairFailuresKey(t.ip, t.username), pairBlockKey(t.ip, t.username),
+		accountFailuresKey(t.username), accountBlockKey(t.username))
+}
+
+func respondThrottled(c *gin.Context, wait time.Duration) {
+	seconds := int(wait.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts. Please try again later.",
+		"retry_after": seconds,
+	})
+}
+
+// deleteKeys removes every key matching pattern
+func deleteKeys(ctx context.Context, pattern string) error {
+	iter := redisClient.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if err := redisClient.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// GetLoginThrottle shows the failure counters and blocks for an IP and/or username
+func GetLoginThrottle(c *gin.Context) {
+	ip := c.Query("ip")
+	username := c.Query("username")
+	if ip == "" && username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ip or username is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	counter := func(key string) int64 {
+		n, _ := redisClient.Get(ctx, key).Int64()
+		return n
+	}
+	remaining := func(key string) int {
+		ttl, _ := redisClient.TTL(ctx, key).Result()
+		if ttl < 0 {
+			return 0
+		}
+		return int(ttl.Seconds())
+	}
+
+	response := gin.H{}
+	if ip != "" {
+		failures := counter(ipFailuresKey(ip))
+		response["ip"] = gin.H{
+			"failures":            failures,
+			"limit":               ipHourlyLimit,
+			"blocked":             failures >= int64(ipHourlyLimit),
+			"captcha_required":    failures >= ipCaptchaAfter,
+			"rate_limit_requests": counter("rate_limit:" + ip),
+		}
+	}
+	if username != "" {
+		failures := counter(accountFailuresKey(username))
+		response["account"] = gin.H{
+			"failures":         failures,
+			"blocked_for":      remaining(accountBlockKey(username)),
+			"captcha_required": failures >= accountCaptchaAfter,
+		}
+	}
+	if ip != "" && username != "" {
+		response["pair"] = gin.H{
+			"failures":    counter(pairFailuresKey(ip, username)),
+			"blocked_for": remaining(pairBlockKey(ip, username)),
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UnlockUser clears the login throttling of an account on every IP, together with
+// pending OTP failures and any old-style account lock
+func UnlockUser(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	username := throttleUsername(user.Username)
+	err = redisClient.Del(ctx, accountFailuresKey(username), accountBlockKey(username), otpUserFailuresKey(user.ID.Hex())).Err()
+	if err == nil {
+		err = deleteKeys(ctx, "login_fail:pair:*:"+username)
+	}
+	if err == nil {
+		err = deleteKeys(ctx, "login_block:pair:*:"+username)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"failed_login_attempts": 0, "locked_until": time.Time{}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "unlock_account", fmt.Sprintf("%s unlocked account %s", c.GetString("username"), user.Username))
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
+
+// UnlockIP clears the login throttling and request rate limit of an IP address
+func UnlockIP(c *gin.Context) {
+	parsed := net.ParseIP(c.Param("ip"))
+	if parsed == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+		return
+	}
+	ip := parsed.String()
+
+	ctx := c.Request.Context()
+	err := redisClient.Del(ctx, ipFailuresKey(ip), "rate_limit:"+ip).Err()
+	if err == nil {
+		err = deleteKeys(ctx, "login_fail:pair:"+ip+":*")
+	}
+	if err == nil {
+		err = deleteKeys(ctx, "login_block:pair:"+ip+":*")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock IP"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "unlock_ip", fmt.Sprintf("%s unlocked IP %s", c.GetString("username"), ip))
+	c.JSON(http.StatusOK, gin.H{"message": "IP unlocked"})
+}
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "SpotifyClone"
+}
+
+// currentUser loads the authenticated user
+func currentUser(c *gin.Context) (*models.User, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(c.Request.Context(), bson.M{"_id": objID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// verifyTOTP validates a code for the user's active secret. The matched time step is
+// recorded atomically, so the same code (or an older one) can't be replayed.
+func verifyTOTP(ctx context.Context, user *models.User, code string) (bool, error) {
+	if !user.TOTPEnabled || user.TOTPSecret == "" {
+		return false, nil
+	}
+
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	res, err := usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "totp_last_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// useRecoveryCode consumes a recovery code; each one works exactly once
+func useRecoveryCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	hash := utils.HashRecoveryCode(code)
+	res, err := usersDB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// newRecoveryCodes generates a fresh set of codes, returning plaintext for the user and hashes for storage
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// GetTwoFactorStatus shows which second factor is active, where codes can be sent and
+// how many recovery codes are left
+func GetTwoFactorStatus(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"method":                   user.SecondFactor(),
+		"totp_enabled":             user.TOTPEnabled,
+		"phone":                    user.Phone,
+		"phone_verified":           user.HasVerifiedPhone(),
+		"channels":                 availableOTPChannels(c.Request.Context(), user),
+		"recovery_codes_remaining": len(user.RecoveryCodes),
+	})
+}
+
+// SetupTOTP starts enrollment: a new pending secret is returned as text, otpauth URI and QR PNG.
+// It only becomes active after ConfirmTOTP.
+func SetupTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"totp_pending_secret": secret,
+			"updated_at":          time.Now(),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start TOTP setup"})
+		return
+	}
+
+	uri := utils.TOTPURI(totpIssuer(), user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "totp_setup", fmt.Sprintf("User %s started TOTP enrollment", user.Username))
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// GetTOTPQRCode returns the pending enrollment secret as a QR PNG
+func GetTOTPQRCode(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPPendingSecret == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No TOTP setup in progress"})
+		return
+	}
+
+	png, err := qrcode.Encode(utils.TOTPURI(totpIssuer(), user.Email, user.TOTPPendingSecret), qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// ConfirmTOTP activates the pending secret once the user proves their app produces valid codes
+func ConfirmTOTP(c *gin.Context) {
+	var req models.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPPendingSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No TOTP setup in progress"})
+		return
+	}
+
+	step, valid := utils.ValidateTOTP(user.TOTPPendingSecret, req.Code, time.Now())
+	if !valid {
+		logSecurityEvent(c, nil, "failed", "totp_confirm", fmt.Sprintf("User %s invalid TOTP code", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"totp_secret":       user.TOTPPendingSecret,
+			"totp_enabled":      true,
+			"totp_last_step":    step,
+			"two_factor_method": models.TwoFactorTOTP,
+			"recovery_codes":    hashes,
+			"updated_at":        time.Now(),
+		},
+		"$unset": bson.M{"totp_pending_secret": ""},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable TOTP"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "totp_enabled", fmt.Sprintf("User %s enabled TOTP", user.Username))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Authenticator app enabled. Store these recovery codes somewhere safe, they will not be shown again.",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP turns off the authenticator app and falls back to email OTP
+func DisableTOTP(c *gin.Context) {
+	var req models.DisableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authenticator app is not enabled"})
+		return
+	}
+
+	if match, _ := utils.VerifyPassword(user.PasswordHash, req.Password); !match {
+		logSecurityEvent(c, nil, "failed", "totp_disable", fmt.Sprintf("User %s invalid password", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	valid, err := verifyTOTP(ctx, user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		logSecurityEvent(c, nil, "failed", "totp_disable", fmt.Sprintf("User %s invalid TOTP code", user.Username))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"totp_enabled":      false,
+			"totp_last_step":    0,
+			"two_factor_method": models.TwoFactorEmail,
+			"updated_at":        time.Now(),
+		},
+		"$unset": bson.M{"totp_secret": "", "totp_pending_secret": "", "recovery_codes": ""},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable TOTP"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "totp_disabled", fmt.Sprintf("User %s disabled TOTP", user.Username))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Authenticator app disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, invalidating the old ones
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req models.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	valid, err := verifyTOTP(ctx, user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	_, err = usersDB.Collection("users").UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"recovery_codes": hashes, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save recovery codes"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "recovery_codes_regenerated", fmt.Sprintf("User %s regenerated recovery codes", user.Username))
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// SetTwoFactorMethod lets the user pick how the second factor reaches them: email or SMS
+// codes, push approval or their authenticator app. Push falls back to email at login
+// when the user isn't signed in anywhere else.
+func SetTwoFactorMethod(c *gin.Context) {
+	var req models.TwoFactorMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Method must be email, totp, sms or push"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if req.Method == models.TwoFactorTOTP && !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set up an authenticator app first"})
+		return
+	}
+	if req.Method == models.TwoFactorSMS && !user.HasVerifiedPhone() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verify a phone number first"})
+		return
+	}
+
+	_, err := usersDB.Collection("users").UpdateOne(c.Request.Context(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"two_factor_method": req.Method, "updated_at": time.Now()},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update second factor"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "two_factor_method", fmt.Sprintf("User %s switched second factor to %s", user.Username, req.Method))
+
+	c.JSON(http.StatusOK, gin.H{"method": req.Method})
+}
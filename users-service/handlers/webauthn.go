@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"example.com/users-service/models"
+	"example.com/users-service/utils"
+)
+
+const webAuthnSessionTTL = 5 * time.Minute
+
+// Attestation formats we accept at registration
+var allowedAttestationFormats = map[string]bool{
+	"none":   true,
+	"packed": true,
+}
+
+var (
+	errUnsupportedAttestation = errors.New("unsupported attestation format")
+	errSignCountRegression    = errors.New("sign count regression")
+)
+
+var webAuthn *webauthn.WebAuthn
+
+// InitWebAuthn configures the relying party from WEBAUTHN_RP_ID / WEBAUTHN_RP_ORIGINS
+func InitWebAuthn() {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+
+	origins := os.Getenv("WEBAUTHN_RP_ORIGINS")
+	if origins == "" {
+		origins = os.Getenv("FRONTEND_URL")
+	}
+	if origins == "" {
+		origins = "http://localhost:4200"
+	}
+
+	attestation := protocol.ConveyancePreference(os.Getenv("WEBAUTHN_ATTESTATION"))
+	if attestation == "" {
+		attestation = protocol.PreferNoAttestation
+	}
+
+	var err error
+	webAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:                  rpID,
+		RPDisplayName:         totpIssuer(),
+		RPOrigins:             strings.Split(origins, ","),
+		AttestationPreference: attestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure WebAuthn: %v", err)
+	}
+}
+
+// EnsureWebAuthnIndexes creates indexes for credential lookup
+func EnsureWebAuthnIndexes(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "credential_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("unique_credential_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("user_id_idx"),
+		},
+	}
+
+	if _, err := db.Collection("webauthn_credentials").Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	log.Println("MongoDB indexes for webauthn_credentials ensured")
+}
+
+// webAuthnUser adapts a user and their stored credentials to webauthn.User.
+// The user handle is the ObjectID, which lets discoverable logins find the account.
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte          { return u.user.ID[:] }
+func (u *webAuthnUser) WebAuthnName() string        { return u.user.Username }
+func (u *webAuthnUser) WebAuthnDisplayName() string { return u.user.FirstName + " " + u.user.LastName }
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		creds[i] = c.Credential
+	}
+	return creds
+}
+
+func loadWebAuthnUser(ctx context.Context, user *models.User) (*webAuthnUser, error) {
+	cursor, err := usersDB.Collection("webauthn_credentials").Find(ctx, bson.M{"user_id": user.ID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var creds []models.WebAuthnCredential
+	if err := cursor.All(ctx, &creds); err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user: user, credentials: creds}, nil
+}
+
+func encodeCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func saveWebAuthnSession(ctx context.Context, key string, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return redisClient.Set(ctx, key, data, webAuthnSessionTTL).Err()
+}
+
+// takeWebAuthnSession loads and deletes a ceremony session so each challenge is used once
+func takeWebAuthnSession(ctx context.Context, key string) (*webauthn.SessionData, error) {
+	data, err := redisClient.GetDel(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// BeginWebAuthnRegistration returns credential creation options for navigator.credentials.create()
+func BeginWebAuthnRegistration(c *gin.Context) {
+	var req models.WebAuthnRegisterRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	waUser, err := loadWebAuthnUser(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Don't let the same authenticator register twice
+	creation, session, err := webAuthn.BeginRegistration(waUser,
+		webauthn.WithExclusions(webauthn.Credentials(waUser.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start registration"})
+		return
+	}
+
+	if err := saveWebAuthnSession(ctx, "webauthn_reg:"+user.ID.Hex(), session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+		return
+	}
+	redisClient.Set(ctx, "webauthn_reg_name:"+user.ID.Hex(), utils.SanitizeString(req.Name), webAuthnSessionTTL)
+
+	c.JSON(http.StatusOK, creation)
+}
+
+// FinishWebAuthnRegistration verifies the attestation and stores the new credential
+func FinishWebAuthnRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	session, err := takeWebAuthnSession(ctx, "webauthn_reg:"+user.ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No registration in progress"})
+		return
+	}
+
+	waUser, err := loadWebAuthnUser(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	credential, err := verifyWebAuthnRegistration(waUser, session, c.Request)
+	if errors.Is(err, errUnsupportedAttestation) {
+		logSecurityEvent(c, nil, "failed", "webauthn_register", fmt.Sprintf("User %s %v", user.Username, err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported attestation format"})
+		return
+	}
+	if err != nil {
+		logSecurityEvent(c, nil, "failed", "webauthn_register", fmt.Sprintf("User %s attestation rejected: %v", user.Username, err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Credential verification failed"})
+		return
+	}
+
+	name, _ := redisClient.GetDel(ctx, "webauthn_reg_name:"+user.ID.Hex()).Result()
+	if name == "" {
+		name = "Passkey"
+	}
+
+	record := models.WebAuthnCredential{
+		ID:           primitive.NewObjectID(),
+		UserID:       user.ID,
+		CredentialID: encodeCredentialID(credential.ID),
+		Name:         name,
+		Credential:   *credential,
+		CreatedAt:    time.Now(),
+	}
+	if _, err := usersDB.Collection("webauthn_credentials").InsertOne(ctx, record); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Credential already registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save credential"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "webauthn_register", fmt.Sprintf("User %s registered passkey %s", user.Username, record.CredentialID))
+
+	c.JSON(http.StatusCreated, record)
+}
+
+// verifyWebAuthnRegistration checks the attestation against the registration session
+// and only accepts the formats we support
+func verifyWebAuthnRegistration(waUser *webAuthnUser, session *webauthn.SessionData, r *http.Request) (*webauthn.Credential, error) {
+	credential, err := webAuthn.FinishRegistration(waUser, *session, r)
+	if err != nil {
+		return nil, err
+	}
+	if !allowedAttestationFormats[credential.AttestationType] {
+		return nil, fmt.Errorf("%w %q", errUnsupportedAttestation, credential.AttestationType)
+	}
+	return credential, nil
+}
+
+// BeginWebAuthnLogin starts a passkey login. With a username the user's credentials are
+// listed; without one the authenticator picks a discoverable credential.
+func BeginWebAuthnLogin(c *gin.Context) {
+	var req models.WebAuthnLoginRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		err       error
+	)
+
+	if req.Username != "" {
+		var user models.User
+		if err := usersDB.Collection("users").FindOne(ctx, bson.M{"username": utils.SanitizeString(req.Username)}).Decode(&user); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No passkeys registered"})
+			return
+		}
+		waUser, err := loadWebAuthnUser(ctx, &user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if len(waUser.credentials) == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No passkeys registered"})
+			return
+		}
+		assertion, session, err = webAuthn.BeginLogin(waUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+	} else {
+		assertion, session, err = webAuthn.BeginDiscoverableLogin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+	}
+
+	sessionID, err := utils.GenerateVerificationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	if err := saveWebAuthnSession(ctx, "webauthn_login:"+sessionID, session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"options":    assertion,
+	})
+}
+
+// FinishWebAuthnLogin verifies the assertion and logs the user in, skipping password and OTP
+func FinishWebAuthnLogin(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	session, err := takeWebAuthnSession(ctx, "webauthn_login:"+c.Query("session_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login session expired, please try again"})
+		return
+	}
+
+	dbFailed := false
+	waUser, credential, err := verifyWebAuthnLogin(session, c.Request, func(handle []byte) (*webAuthnUser, error) {
+		user, err := findUserByHandle(ctx, handle)
+		if err != nil {
+			return nil, err
+		}
+		waUser, err := loadWebAuthnUser(ctx, user)
+		dbFailed = err != nil
+		return waUser, err
+	})
+	if errors.Is(err, errSignCountRegression) {
+		logSecurityEvent(c, waUser.user, "failed", "webauthn_login", fmt.Sprintf("User %s sign count regression on %s", waUser.user.Username, encodeCredentialID(credential.ID)))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "This passkey can no longer be used. Please remove it and register it again."})
+		return
+	}
+	if dbFailed {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err != nil {
+		logSecurityEvent(c, nil, "failed", "webauthn_login", fmt.Sprintf("Assertion rejected: %v", err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	user := waUser.user
+	credentialID := encodeCredentialID(credential.ID)
+
+	if time.Now().Before(user.LockedUntil) {
+		logSecurityEvent(c, user, "failed", "webauthn_login", fmt.Sprintf("User %s account locked", user.Username))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is temporarily locked due to multiple failed login attempts"})
+		return
+	}
+	if user.PendingDeletion() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is being deleted"})
+		return
+	}
+	if !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email before logging in"})
+		return
+	}
+
+	// Store the new sign count; the filter makes concurrent logins with the same counter lose
+	res, err := usersDB.Collection("webauthn_credentials").UpdateOne(ctx,
+		bson.M{
+			"credential_id": credentialID,
+			"user_id":       user.ID,
+			"$or": bson.A{
+				bson.M{"credential.authenticator.signcount": bson.M{"$lt": credential.Authenticator.SignCount}},
+				bson.M{"credential.authenticator.signcount": 0},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"credential.authenticator": credential.Authenticator,
+			"credential.flags":         credential.Flags,
+			"last_used_at":             time.Now(),
+		}},
+	)
+	if err != nil || res.MatchedCount == 0 {
+		logSecurityEvent(c, user, "failed", "webauthn_login", fmt.Sprintf("User %s stale sign count on %s", user.Username, credentialID))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	logSecurityEvent(c, user, "success", "webauthn_login", fmt.Sprintf("User %s used passkey %s", user.Username, credentialID))
+
+	// Passkeys can't be phished, so an unusual login is only recorded and alerted on
+	respondWithToken(c, user, assessLogin(ctx, c, user))
+}
+
+// verifyWebAuthnLogin checks the assertion against the login session. userByHandle loads
+// the account: the session's user for a username login, otherwise the one named by the
+// discoverable credential. A counter that didn't increase means the authenticator may
+// have been cloned; the user and credential are still returned so it can be logged.
+func verifyWebAuthnLogin(session *webauthn.SessionData, r *http.Request, userByHandle func(handle []byte) (*webAuthnUser, error)) (*webAuthnUser, *webauthn.Credential, error) {
+	var (
+		waUser     *webAuthnUser
+		credential *webauthn.Credential
+		err        error
+	)
+
+	if len(session.UserID) > 0 {
+		if waUser, err = userByHandle(session.UserID); err != nil {
+			return nil, nil, err
+		}
+		credential, err = webAuthn.FinishLogin(waUser, *session, r)
+	} else {
+		var found webauthn.User
+		found, credential, err = webAuthn.FinishPasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			return userByHandle(userHandle)
+		}, *session, r)
+		if err == nil {
+			waUser = found.(*webAuthnUser)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if credential.Authenticator.CloneWarning {
+		return waUser, credential, errSignCountRegression
+	}
+	return waUser, credential, nil
+}
+
+func findUserByHandle(ctx context.Context, handle []byte) (*models.User, error) {
+	if len(handle) != len(primitive.ObjectID{}) {
+		return nil, fmt.Errorf("invalid user handle")
+	}
+	var objID primitive.ObjectID
+	copy(objID[:], handle)
+
+	var user models.User
+	if err := usersDB.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetWebAuthnCredentials lists the user's registered passkeys
+func GetWebAuthnCredentials(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	waUser, err := loadWebAuthnUser(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	out := make([]gin.H, 0, len(waUser.credentials))
+	for _, cred := range waUser.credentials {
+		out = append(out, gin.H{
+			"id":               cred.ID.Hex(),
+			"name":             cred.Name,
+			"attestation_type": cred.Credential.AttestationType,
+			"transports":       cred.Credential.Transport,
+			"backed_up":        cred.Credential.Flags.BackupState,
+			"created_at":       cred.CreatedAt,
+			"last_used_at":     cred.LastUsedAt,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// DeleteWebAuthnCredential revokes one of the user's passkeys
+func DeleteWebAuthnCredential(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	res, err := usersDB.Collection("webauthn_credentials").DeleteOne(c.Request.Context(), bson.M{"_id": objID, "user_id": user.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete credential"})
+		return
+	}
+	if res.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		return
+	}
+
+	logSecurityEvent(c, nil, "success", "webauthn_revoke", fmt.Sprintf("User %s revoked passkey %s", user.Username, c.Param("id")))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey removed"})
+}
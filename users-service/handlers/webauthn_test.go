@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"example.com/users-service/models"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is an in-memory ES256 authenticator producing the same attestation
+// and assertion responses a browser would post
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+	// Relying party and origin it puts in its responses; a phishing page would differ
+	rpID   string
+	origin string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, id: id, rpID: testRPID, origin: testOrigin}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	return data
+}
+
+// authData builds authenticator data with user present and verified; registration adds
+// the attested credential with its COSE public key
+func (a *softAuthenticator) authData(t *testing.T, attested bool) []byte {
+	t.Helper()
+	rpHash := sha256.Sum256([]byte(a.rpID))
+
+	flags := byte(0x01 | 0x04)
+	if attested {
+		flags |= 0x40
+	}
+
+	var buf bytes.Buffer
+	buf.Write(rpHash[:])
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, a.signCount)
+
+	if attested {
+		coseKey, err := webauthncbor.Marshal(map[int]any{
+			1:  2,  // kty: EC2
+			3:  -7, // alg: ES256
+			-1: 1,  // crv: P-256
+			-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+			-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(make([]byte, 16)) // AAGUID
+		binary.Write(&buf, binary.BigEndian, uint16(len(a.id)))
+		buf.Write(a.id)
+		buf.Write(coseKey)
+	}
+	return buf.Bytes()
+}
+
+func (a *softAuthenticator) sign(t *testing.T, authData, clientData []byte) []byte {
+	t.Helper()
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// register answers navigator.credentials.create() with the given attestation format;
+// packed uses self attestation, signed with the credential key
+func (a *softAuthenticator) register(t *testing.T, session *webauthn.SessionData, format string) *http.Request {
+	t.Helper()
+	clientData := a.clientData("webauthn.create", session.Challenge)
+	authData := a.authData(t, true)
+
+	attStmt := map[string]any{}
+	if format == "packed" {
+		attStmt = map[string]any{"alg": -7, "sig": a.sign(t, authData, clientData)}
+	}
+	attObj, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      format,
+		"attStmt":  attStmt,
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jsonRequest(t, map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"attestationObject": b64(attObj),
+		},
+	})
+}
+
+// login answers navigator.credentials.get() for the given user handle
+func (a *softAuthenticator) login(t *testing.T, session *webauthn.SessionData, userHandle []byte) *http.Request {
+	t.Helper()
+	clientData := a.clientData("webauthn.get", session.Challenge)
+	authData := a.authData(t, false)
+
+	return jsonRequest(t, map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(a.sign(t, authData, clientData)),
+			"userHandle":        b64(userHandle),
+		},
+	})
+}
+
+func jsonRequest(t *testing.T, body any) *http.Request {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func setupWebAuthn(t *testing.T) *webAuthnUser {
+	t.Helper()
+	t.Setenv("WEBAUTHN_RP_ID", testRPID)
+	t.Setenv("WEBAUTHN_RP_ORIGINS", testOrigin)
+	InitWebAuthn()
+
+	return &webAuthnUser{user: &models.User{
+		ID:        primitive.NewObjectID(),
+		Username:  "passkey_user",
+		FirstName: "Pass",
+		LastName:  "Key",
+	}}
+}
+
+// registerCredential runs a registration ceremony and stores the credential on the user
+func registerCredential(t *testing.T, waUser *webAuthnUser, auth *softAuthenticator, format string) *webauthn.Credential {
+	t.Helper()
+	_, session, err := webAuthn.BeginRegistration(waUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := verifyWebAuthnRegistration(waUser, session, auth.register(t, session, format))
+	if err != nil {
+		t.Fatalf("registration with %s attestation: %v", format, err)
+	}
+	waUser.credentials = append(waUser.credentials, models.WebAuthnCredential{
+		UserID:       waUser.user.ID,
+		CredentialID: encodeCredentialID(credential.ID),
+		Credential:   *credential,
+	})
+	return credential
+}
+
+func loginWith(t *testing.T, waUser *webAuthnUser, auth *softAuthenticator, discoverable bool) (*webauthn.Credential, error) {
+	t.Helper()
+	var (
+		session *webauthn.SessionData
+		err     error
+	)
+	if discoverable {
+		_, session, err = webAuthn.BeginDiscoverableLogin()
+	} else {
+		_, session, err = webAuthn.BeginLogin(waUser)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, credential, err := verifyWebAuthnLogin(session, auth.login(t, session, waUser.WebAuthnID()), func(handle []byte) (*webAuthnUser, error) {
+		if !bytes.Equal(handle, waUser.WebAuthnID()) {
+			return nil, errors.New("unknown user handle")
+		}
+		return waUser, nil
+	})
+	return credential, err
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		t.Run(format, func(t *testing.T) {
+			waUser := setupWebAuthn(t)
+			auth := newSoftAuthenticator(t)
+
+			credential := registerCredential(t, waUser, auth, format)
+			if credential.AttestationType != format {
+				t.Errorf("attestation type = %q, want %q", credential.AttestationType, format)
+			}
+			if !bytes.Equal(credential.ID, auth.id) {
+				t.Error("credential ID doesn't match the authenticator")
+			}
+		})
+	}
+}
+
+func TestWebAuthnRegistrationRejectsUnsupportedFormat(t *testing.T) {
+	waUser := setupWebAuthn(t)
+	auth := newSoftAuthenticator(t)
+
+	delete(allowedAttestationFormats, "packed")
+	t.Cleanup(func() { allowedAttestationFormats["packed"] = true })
+
+	_, session, err := webAuthn.BeginRegistration(waUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyWebAuthnRegistration(waUser, session, auth.register(t, session, "packed"))
+	if !errors.Is(err, errUnsupportedAttestation) {
+		t.Fatalf("err = %v, want %v", err, errUnsupportedAttestation)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsWrongRelyingParty(t *testing.T) {
+	tests := map[string]func(*softAuthenticator){
+		"origin": func(a *softAuthenticator) { a.origin = "https://examp1e.com" },
+		"rp id":  func(a *softAuthenticator) { a.rpID = "examp1e.com" },
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			waUser := setupWebAuthn(t)
+			auth := newSoftAuthenticator(t)
+			tamper(auth)
+
+			_, session, err := webAuthn.BeginRegistration(waUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := verifyWebAuthnRegistration(waUser, session, auth.register(t, session, "none")); err == nil {
+				t.Fatal("registration succeeded for the wrong relying party")
+			}
+		})
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	for name, discoverable := range map[string]bool{"username": false, "discoverable": true} {
+		t.Run(name, func(t *testing.T) {
+			waUser := setupWebAuthn(t)
+			auth := newSoftAuthenticator(t)
+			registerCredential(t, waUser, auth, "packed")
+
+			auth.signCount = 1
+			credential, err := loginWith(t, waUser, auth, discoverable)
+			if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			if credential.Authenticator.SignCount != 1 {
+				t.Errorf("sign count = %d, want 1", credential.Authenticator.SignCount)
+			}
+		})
+	}
+}
+
+func TestWebAuthnLoginRejectsSignCountRegression(t *testing.T) {
+	waUser := setupWebAuthn(t)
+	auth := newSoftAuthenticator(t)
+	auth.signCount = 5
+	registerCredential(t, waUser, auth, "none")
+
+	// A clone replaying an older counter
+	auth.signCount = 3
+	if _, err := loginWith(t, waUser, auth, false); !errors.Is(err, errSignCountRegression) {
+		t.Fatalf("err = %v, want %v", err, errSignCountRegression)
+	}
+
+	// The same counter again is a regression too
+	auth.signCount = 5
+	if _, err := loginWith(t, waUser, auth, false); !errors.Is(err, errSignCountRegression) {
+		t.Fatalf("err = %v, want %v", err, errSignCountRegression)
+	}
+
+	auth.signCount = 6
+	if _, err := loginWith(t, waUser, auth, false); err != nil {
+		t.Fatalf("login with an increased counter: %v", err)
+	}
+}
+
+func TestWebAuthnLoginRejectsWrongRelyingParty(t *testing.T) {
+	tests := map[string]func(*softAuthenticator){
+		"origin": func(a *softAuthenticator) { a.origin = "https://examp1e.com" },
+		"rp id":  func(a *softAuthenticator) { a.rpID = "examp1e.com" },
+	}
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			waUser := setupWebAuthn(t)
+			auth := newSoftAuthenticator(t)
+			registerCredential(t, waUser, auth, "none")
+
+			tamper(auth)
+			auth.signCount = 1
+			if _, err := loginWith(t, waUser, auth, false); err == nil {
+				t.Fatal("login succeeded for the wrong relying party")
+			}
+		})
+	}
+}
+
+func TestWebAuthnLoginRejectsReplayedChallenge(t *testing.T) {
+	waUser := setupWebAuthn(t)
+	auth := newSoftAuthenticator(t)
+	registerCredential(t, waUser, auth, "none")
+
+	_, session, err := webAuthn.BeginLogin(waUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := webAuthn.BeginLogin(waUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An assertion for one challenge can't finish another login
+	auth.signCount = 1
+	lookup := func([]byte) (*webAuthnUser, error) { return waUser, nil }
+	if _, _, err := verifyWebAuthnLogin(other, auth.login(t, session, waUser.WebAuthnID()), lookup); err == nil {
+		t.Fatal("login succeeded with another session's challenge")
+	}
+}
@@ -0,0 +1,148 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Every template is a pair of files per locale: <name>.txt, which also defines the
+// "subject" template, and <name>.html, rendered inside layout.html
+//
+//go:embed templates
+var templateFS embed.FS
+
+// DefaultLocale is used when the user has no preference or a template is missing
+const DefaultLocale = "en"
+
+// Locales lists the supported languages
+var Locales = []string{"en", "sr"}
+
+type localizedTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Label of the call-to-action button in layout.html
+var buttonLabels = map[string]string{
+	"en": "Open link",
+	"sr": "Otvori link",
+}
+
+// templates[name][locale]
+var templates = map[string]map[string]*localizedTemplate{}
+
+var templateFuncs = map[string]interface{}{
+	"date": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+}
+
+func init() {
+	if err := loadTemplates(); err != nil {
+		panic(fmt.Sprintf("mail: %v", err))
+	}
+}
+
+func loadTemplates() error {
+	for _, locale := range Locales {
+		files, err := fs.Glob(templateFS, path.Join("templates", locale, "*.txt"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(path.Base(file), ".txt")
+
+			text, err := texttemplate.New(path.Base(file)).Funcs(templateFuncs).Option("missingkey=error").ParseFS(templateFS, file)
+			if err != nil {
+				return err
+			}
+			if text.Lookup("subject") == nil {
+				return fmt.Errorf("%s has no subject", file)
+			}
+			html, err := htmltemplate.New("layout.html").Funcs(templateFuncs).Option("missingkey=error").
+				ParseFS(templateFS, "templates/layout.html", path.Join("templates", locale, name+".html"))
+			if err != nil {
+				return err
+			}
+
+			if templates[name] == nil {
+				templates[name] = map[string]*localizedTemplate{}
+			}
+			templates[name][locale] = &localizedTemplate{text: text, html: html}
+		}
+	}
+
+	for name, byLocale := range templates {
+		if byLocale[DefaultLocale] == nil {
+			return fmt.Errorf("template %s is missing in the default locale", name)
+		}
+	}
+	return nil
+}
+
+// NormalizeLocale maps a language tag such as "sr-Latn-RS" or "EN" to a supported
+// locale, or "" if it isn't supported
+func NormalizeLocale(tag string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	for _, locale := range Locales {
+		if lang == locale {
+			return locale
+		}
+	}
+	return ""
+}
+
+// LocaleFromAcceptLanguage returns the first supported language of an Accept-Language
+// header, or ""
+func LocaleFromAcceptLanguage(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if locale := NormalizeLocale(tag); locale != "" {
+			return locale
+		}
+	}
+	return ""
+}
+
+// Render renders a named template in the given locale, falling back to DefaultLocale
+func Render(name, locale string, data map[string]interface{}) (*Message, error) {
+	byLocale, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+	tmpl := byLocale[locale]
+	if tmpl == nil {
+		locale = DefaultLocale
+		tmpl = byLocale[locale]
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+
+	htmlData := make(map[string]interface{}, len(data)+2)
+	for k, v := range data {
+		htmlData[k] = v
+	}
+	htmlData["Subject"] = strings.TrimSpace(subject.String())
+	htmlData["ButtonLabel"] = buttonLabels[locale]
+	if err := tmpl.html.Execute(&html, htmlData); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
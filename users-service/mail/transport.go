@@ -0,0 +1,213 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Transport delivers rendered messages
+type Transport interface {
+	Name() string
+	Send(ctx context.Context, m *Message) error
+}
+
+// IsPermanent reports whether retrying can't help, e.g. the server rejected the recipient
+func IsPermanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
+
+// SMTP connection security
+const (
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+	SecurityNone     = "none"
+)
+
+// SMTPTransport sends through an SMTP relay. With SecurityStartTLS the connection is
+// upgraded before authenticating and a server without STARTTLS is refused.
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Security string
+}
+
+func (t *SMTPTransport) Name() string { return "smtp" }
+
+func (t *SMTPTransport) Send(ctx context.Context, m *Message) error {
+	from, err := netmail.ParseAddress(t.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", t.From, err)
+	}
+	raw, err := m.Bytes(t.From)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(t.Host, t.Port)
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	tlsConfig := &tls.Config{ServerName: t.Host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	if t.Security == SecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if t.Security == SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if t.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// FileTransport writes every message as an .eml file, a mailbox stand-in for
+// development and tests
+type FileTransport struct {
+	Dir  string
+	From string
+}
+
+func (t *FileTransport) Name() string { return "file" }
+
+func (t *FileTransport) Send(ctx context.Context, m *Message) error {
+	raw, err := m.Bytes(t.From)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), m.ID)
+	return os.WriteFile(filepath.Join(t.Dir, name), raw, 0644)
+}
+
+// LogTransport only logs that a message would have been sent. The body (OTP codes,
+// links) is printed to stdout only when ShowBody is set, never to the log file.
+type LogTransport struct {
+	ShowBody bool
+}
+
+func (t *LogTransport) Name() string { return "log" }
+
+func (t *LogTransport) Send(ctx context.Context, m *Message) error {
+	log.Printf("📧 MOCK EMAIL to=%s subject=%s (body_len=%d)", m.To, m.Subject, len(m.Text))
+	if t.ShowBody {
+		fmt.Printf("📧 MOCK EMAIL to=%s subject=%s\nBODY:\n%s\n-------------------\n", m.To, m.Subject, m.Text)
+	}
+	return nil
+}
+
+// TransportFromEnv picks the transport from EMAIL_TRANSPORT (smtp, file or log). Without
+// it MOCK_EMAIL=true logs bodies, a complete SMTP config uses SMTP and anything else
+// falls back to the log transport.
+func TransportFromEnv() (Transport, error) {
+	from := os.Getenv("EMAIL_FROM")
+	if from == "" {
+		from = os.Getenv("EMAIL_USER")
+	}
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+
+	smtpTransport := &SMTPTransport{
+		Host:     os.Getenv("EMAIL_HOST"),
+		Port:     os.Getenv("EMAIL_PORT"),
+		Username: os.Getenv("EMAIL_USER"),
+		Password: os.Getenv("EMAIL_PASS"),
+		From:     from,
+		Security: os.Getenv("EMAIL_SMTP_SECURITY"),
+	}
+	if smtpTransport.Security == "" {
+		smtpTransport.Security = SecurityStartTLS
+		if smtpTransport.Port == "465" {
+			smtpTransport.Security = SecurityTLS
+		}
+	}
+
+	switch os.Getenv("EMAIL_TRANSPORT") {
+	case "smtp":
+		if smtpTransport.Host == "" || smtpTransport.Port == "" {
+			return nil, errors.New("EMAIL_HOST and EMAIL_PORT are required for the smtp transport")
+		}
+		switch smtpTransport.Security {
+		case SecurityStartTLS, SecurityTLS, SecurityNone:
+		default:
+			return nil, fmt.Errorf("unknown EMAIL_SMTP_SECURITY %q", smtpTransport.Security)
+		}
+		return smtpTransport, nil
+	case "file":
+		dir := os.Getenv("EMAIL_FILE_DIR")
+		if dir == "" {
+			dir = "data/mailbox"
+		}
+		return &FileTransport{Dir: dir, From: from}, nil
+	case "log":
+		return &LogTransport{ShowBody: os.Getenv("MOCK_EMAIL") == "true"}, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_TRANSPORT %q", os.Getenv("EMAIL_TRANSPORT"))
+	}
+
+	if os.Getenv("MOCK_EMAIL") == "true" {
+		return &LogTransport{ShowBody: true}, nil
+	}
+	if smtpTransport.Host != "" && smtpTransport.Port != "" && smtpTransport.Username != "" && smtpTransport.Password != "" {
+		return smtpTransport, nil
+	}
+	log.Println("SMTP not configured, emails are only logged")
+	return &LogTransport{}, nil
+}
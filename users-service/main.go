@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"example.com/users-service/config"
@@ -53,9 +54,12 @@ func main() {
 
 	// Initialize password expiry config
 	config.InitPasswordConfig()
+	utils.InitPasswordHashing()
+	utils.InitPwnedPasswords()
+	utils.InitGeoIP()
 	log.Printf("Password expiry configured: max age = %s", config.GetPasswordMaxAgeString())
 
-	// Start log rotation goroutine
+	// Rotation happens as the files grow; this also removes expired rotated files
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour)
 		defer ticker.Stop()
@@ -114,12 +118,52 @@ func main() {
 	// Setup router
 	router := gin.Default()
 
+	// Client IPs feed rate limits and login risk, so X-Forwarded-For is only
+	// believed when it comes from the gateway
+	router.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// Dodaj tracing middleware
 	router.Use(tracing.TracingMiddleware(serviceName))
 
 	// Initialize handlers
 	handlers.InitHandlers(usersDB, redisClient)
 	handlers.EnsureUserIndexes(usersDB)
+	handlers.EnsureWebAuthnIndexes(usersDB)
+	handlers.InitWebAuthn()
+	handlers.EnsureSigningKeyIndexes(usersDB)
+	handlers.InitSigningKeys()
+	handlers.EnsureRoleAuditIndexes(usersDB)
+	handlers.EnsureOAuthIndexes(usersDB)
+	handlers.EnsureAccountDeletionIndexes(usersDB)
+	handlers.InitAccountDeletion()
+	handlers.EnsureDataExportIndexes(usersDB)
+	handlers.InitDataExport()
+	handlers.InitEmailChange()
+	handlers.EnsureEmailOutboxIndexes(usersDB)
+	handlers.InitEmail()
+	handlers.EnsureLoginProfileIndexes(usersDB)
+	handlers.InitLoginRisk()
+	handlers.InitLoginThrottle()
+	handlers.InitCaptcha()
+	handlers.InitOTPChannels()
+	handlers.EnsureSecurityEventIndexes(usersDB)
+	handlers.InitSecurityEvents()
+	handlers.EnsureSocialIndexes(usersDB)
+	handlers.BootstrapAdmins()
+
+	keyRotationCtx, stopKeyRotation := context.WithCancel(context.Background())
+	defer stopKeyRotation()
+	handlers.StartKeyRotation(keyRotationCtx)
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	handlers.StartDeletionWorker(workersCtx)
+	handlers.StartExportWorker(workersCtx)
+	handlers.StartEmailWorker(workersCtx)
+	handlers.StartSecurityEventWriter(workersCtx)
 
 	// Initialize middleware
 	middleware.InitAuthMiddleware(redisClient)
@@ -194,6 +238,18 @@ func main() {
 	log.Println("Server exited")
 }
 
+// trustedProxies reads TRUSTED_PROXIES, comma-separated gateway IPs or CIDRs.
+// Unset means no proxy is trusted and the peer address is the client IP.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		frontendURL := os.Getenv("FRONTEND_URL")
@@ -202,7 +258,7 @@ func corsMiddleware() gin.HandlerFunc {
 		}
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Device-Token, accept, origin, Cache-Control, X-Requested-With")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 
 		if c.Request.Method == "OPTIONS" {
@@ -21,7 +21,7 @@ func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			utils.LogSecurityEvent("failed", "auth", c.ClientIP(), "Missing Authorization header")
+			utils.LogRequestSecurityEvent(c, "failed", "auth", "Missing Authorization header")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
@@ -29,7 +29,7 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			utils.LogSecurityEvent("failed", "auth", c.ClientIP(), "Invalid Authorization header format")
+			utils.LogRequestSecurityEvent(c, "failed", "auth", "Invalid Authorization header format")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
 			c.Abort()
 			return
@@ -38,12 +38,20 @@ func AuthMiddleware() gin.HandlerFunc {
 		token := parts[1]
 		claims, err := utils.ValidateJWT(token)
 		if err != nil {
-			utils.LogSecurityEvent("failed", "auth", c.ClientIP(), "Invalid/expired JWT")
+			utils.LogRequestSecurityEvent(c, "failed", "auth", "Invalid/expired JWT")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
+		// Third-party tokens only work on the OAuth resource routes
+		if claims.ClientID != "" {
+			utils.LogRequestSecurityEvent(c, "failed", "auth", "OAuth token used on first-party route")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Third-party access tokens can't be used here"})
+			c.Abort()
+			return
+		}
+
 		// Check blacklist (logout)
 		if redisClient != nil && claims.ID != "" && claims.ExpiresAt != nil {
 			ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
@@ -52,11 +60,22 @@ func AuthMiddleware() gin.HandlerFunc {
 			key := "bl:" + claims.ID
 			exists, err := redisClient.Exists(ctx, key).Result()
 			if err == nil && exists > 0 {
-				utils.LogSecurityEvent("failed", "auth", c.ClientIP(), "Blacklisted token used")
+				utils.LogRequestSecurityEvent(c, "failed", "auth", "Blacklisted token used")
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
 				c.Abort()
 				return
 			}
+
+			// Tokens bound to a session die with it (logout elsewhere, reuse detection)
+			if claims.SessionID != "" {
+				exists, err := redisClient.Exists(ctx, "session:"+claims.SessionID).Result()
+				if err == nil && exists == 0 {
+					utils.LogRequestSecurityEvent(c, "failed", "auth", "Token of revoked session used")
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked"})
+					c.Abort()
+					return
+				}
+			}
 		}
 
 		// Store claims for handlers
@@ -64,17 +83,63 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Permissions)
+
+		c.Next()
+	}
+}
+
+// OAuthMiddleware authenticates a third-party access token that grants scope
+func OAuthMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		claims, err := utils.ValidateJWT(token)
+		if err != nil || claims.ClientID == "" {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			c.Abort()
+			return
+		}
 
+		if redisClient != nil {
+			exists, err := redisClient.Exists(c.Request.Context(), "oauth_revoked:"+claims.ID).Result()
+			if err == nil && exists > 0 {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+				c.Abort()
+				return
+			}
+		}
+
+		granted := false
+		for _, s := range strings.Fields(claims.Scope) {
+			if s == scope {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+			c.Abort()
+			return
+		}
+
+		c.Set("claims", claims)
+		c.Set("user_id", claims.UserID)
+		c.Set("username", claims.Username)
+		c.Set("client_id", claims.ClientID)
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission allows the request only if the token grants perm
+func RequirePermission(perm string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role != "admin" {
-			utils.LogSecurityEvent("failed", "authz", c.ClientIP(), "Admin access denied")
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		claimsAny, exists := c.Get("claims")
+		if !exists || !claimsAny.(*utils.Claims).HasPermission(perm) {
+			utils.LogRequestSecurityEvent(c, "failed", "authz", "Missing permission "+perm)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission required: " + perm})
 			c.Abort()
 			return
 		}
@@ -30,7 +30,7 @@ func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
 		count, err := redisClient.Incr(ctx, key).Result()
 		if err != nil {
 			// Redis error, fail open but log
-			utils.LogSecurityEvent("error", "rate_limit", ip, "Redis error")
+			utils.LogRequestSecurityEvent(c, "error", "rate_limit", "Redis error")
 			c.Next()
 			return
 		}
@@ -41,7 +41,7 @@ func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
 		}
 
 		if count > int64(limit) {
-			utils.LogSecurityEvent("blocked", "rate_limit_exceeded", ip, "Too many requests")
+			utils.LogRequestSecurityEvent(c, "blocked", "rate_limit_exceeded", "Too many requests")
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "Too many requests. Please try again later.",
 			})
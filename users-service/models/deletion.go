@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Account deletion lifecycle: pending during the grace period, in_progress once the
+// worker starts purging, completed when every service confirmed
+const (
+	DeletionPending    = "pending"
+	DeletionInProgress = "in_progress"
+	DeletionCompleted  = "completed"
+	DeletionCancelled  = "cancelled"
+)
+
+// DeletionStep tracks the purge in one service so failed steps can be retried alone
+type DeletionStep struct {
+	Done          bool       `json:"done" bson:"done"`
+	Attempts      int        `json:"attempts" bson:"attempts"`
+	LastError     string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" bson:"next_attempt_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// AccountDeletion is a scheduled account deletion and its per-service progress
+type AccountDeletion struct {
+	ID           primitive.ObjectID       `json:"-" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID       `json:"user_id" bson:"user_id"`
+	Username     string                   `json:"username,omitempty" bson:"username,omitempty"`
+	Status       string                   `json:"status" bson:"status"`
+	RequestedAt  time.Time                `json:"requested_at" bson:"requested_at"`
+	ScheduledFor time.Time                `json:"scheduled_for" bson:"scheduled_for"`
+	CompletedAt  *time.Time               `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	Steps        map[string]*DeletionStep `json:"steps" bson:"steps"`
+	// Lease held by the worker processing this deletion
+	LockedUntil time.Time `json:"-" bson:"locked_until"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outbox message lifecycle; expired messages were not delivered while still useful
+const (
+	EmailQueued  = "queued"
+	EmailSending = "sending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+	EmailExpired = "expired"
+)
+
+// EmailMessage is a rendered email in the outbox. Bodies are dropped once the message
+// is delivered since they contain codes and links.
+type EmailMessage struct {
+	ID            primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	UserID        *primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	To            string              `json:"to" bson:"to"`
+	Template      string              `json:"template" bson:"template"`
+	Locale        string              `json:"locale" bson:"locale"`
+	Subject       string              `json:"subject" bson:"subject"`
+	TextBody      string              `json:"-" bson:"text_body,omitempty"`
+	HTMLBody      string              `json:"-" bson:"html_body,omitempty"`
+	Status        string              `json:"status" bson:"status"`
+	Transport     string              `json:"transport,omitempty" bson:"transport,omitempty"`
+	Attempts      int                 `json:"attempts" bson:"attempts"`
+	LastError     string              `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt     time.Time           `json:"created_at" bson:"created_at"`
+	NextAttemptAt time.Time           `json:"next_attempt_at" bson:"next_attempt_at"`
+	SentAt        *time.Time          `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+	// Codes and short-lived links are pointless after this, so they are not retried past it
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LockedUntil time.Time  `json:"-" bson:"locked_until"`
+}
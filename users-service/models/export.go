@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Data export lifecycle; ready exports turn expired once the download link lapses
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+	ExportExpired    = "expired"
+)
+
+// DataExport is a "download my data" request and the ZIP archive it produced
+type DataExport struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `json:"-" bson:"user_id"`
+	Status        string             `json:"status" bson:"status"`
+	RequestedAt   time.Time          `json:"requested_at" bson:"requested_at"`
+	CompletedAt   *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	ExpiresAt     *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	Size          int64              `json:"size,omitempty" bson:"size,omitempty"`
+	Attempts      int                `json:"-" bson:"attempts"`
+	LastError     string             `json:"-" bson:"last_error,omitempty"`
+	NextAttemptAt time.Time          `json:"-" bson:"next_attempt_at"`
+	// Archive in GridFS and the hash of the secret in the download link
+	FileID      primitive.ObjectID `json:"-" bson:"file_id,omitempty"`
+	TokenHash   string             `json:"-" bson:"token_hash,omitempty"`
+	LockedUntil time.Time          `json:"-" bson:"locked_until"`
+}
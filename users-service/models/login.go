@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reasons a login is flagged as suspicious
+const (
+	RiskNewDevice        = "new_device"
+	RiskNewNetwork       = "new_network"
+	RiskImpossibleTravel = "impossible_travel"
+	RiskUnusualHour      = "unusual_hour"
+)
+
+// KnownDevice is a device the user has logged in from; ID is a hash of the device key
+type KnownDevice struct {
+	ID        string    `json:"id" bson:"id"`
+	Label     string    `json:"label" bson:"label"`
+	LastIP    string    `json:"last_ip" bson:"last_ip"`
+	Location  string    `json:"location,omitempty" bson:"location,omitempty"`
+	FirstSeen time.Time `json:"first_seen" bson:"first_seen"`
+	LastSeen  time.Time `json:"last_seen" bson:"last_seen"`
+}
+
+// KnownNetwork is an IP range (/24 or /48) the user has logged in from
+type KnownNetwork struct {
+	Prefix    string    `json:"prefix" bson:"prefix"`
+	FirstSeen time.Time `json:"first_seen" bson:"first_seen"`
+	LastSeen  time.Time `json:"last_seen" bson:"last_seen"`
+}
+
+// LoginPlace is where and when a login happened
+type LoginPlace struct {
+	At        time.Time `json:"at" bson:"at"`
+	IP        string    `json:"ip" bson:"ip"`
+	Location  string    `json:"location,omitempty" bson:"location,omitempty"`
+	Located   bool      `json:"located" bson:"located"`
+	Latitude  float64   `json:"latitude,omitempty" bson:"latitude,omitempty"`
+	Longitude float64   `json:"longitude,omitempty" bson:"longitude,omitempty"`
+}
+
+// LoginProfile is what normal logins of a user look like
+type LoginProfile struct {
+	ID       primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID   primitive.ObjectID `json:"-" bson:"user_id"`
+	Devices  []KnownDevice      `json:"devices" bson:"devices"`
+	Networks []KnownNetwork     `json:"networks" bson:"networks"`
+	// Successful logins per UTC hour of the day
+	HourCounts []int       `json:"hour_counts" bson:"hour_counts"`
+	Logins     int         `json:"logins" bson:"logins"`
+	LastLogin  *LoginPlace `json:"last_login,omitempty" bson:"last_login,omitempty"`
+}
+
+// LoginRisk is the assessment of one login attempt, kept with the pending login until
+// it completes
+type LoginRisk struct {
+	Score    int        `json:"score"`
+	Reasons  []string   `json:"reasons,omitempty"`
+	DeviceID string     `json:"device_id"`
+	Device   string     `json:"device"`
+	Network  string     `json:"network"`
+	Place    LoginPlace `json:"place"`
+	// StepUp requires an emailed code with the login details before tokens are issued
+	StepUp        bool `json:"step_up"`
+	StepUpPending bool `json:"step_up_pending,omitempty"`
+}
+
+// Outcomes of a push login approval
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+)
+
+// LoginApproval is a pending login waiting to be approved from a signed-in device
+type LoginApproval struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Status    string     `json:"status"`
+	Device    string     `json:"device"`
+	Place     LoginPlace `json:"place"`
+	Reasons   []string   `json:"reasons,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
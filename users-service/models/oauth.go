@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scopes third-party apps can request; descriptions are shown on the consent screen
+var OAuthScopes = map[string]string{
+	"profile:read":         "Read your username, name and email",
+	"library:read":         "See your liked songs, saved albums and followed artists",
+	"library:write":        "Add to and remove from your library",
+	"playback:read":        "See what you are playing and your queue",
+	"playback:control":     "Control playback and your queue on your devices",
+	"ratings:read":         "See your song ratings",
+	"ratings:write":        "Rate songs on your behalf",
+	"recommendations:read": "See your personal recommendations",
+	"catalog:read":         "Browse the public music catalog",
+}
+
+// Scopes that make sense without a user, allowed for the client credentials grant
+var OAuthClientScopes = map[string]bool{
+	"catalog:read": true,
+}
+
+// OAuthClient is a registered third-party application
+type OAuthClient struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	ClientID     string             `json:"client_id" bson:"client_id"`
+	SecretHash   string             `json:"-" bson:"secret_hash,omitempty"`
+	Name         string             `json:"name" bson:"name"`
+	OwnerID      primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	RedirectURIs []string           `json:"redirect_uris" bson:"redirect_uris"`
+	Scopes       []string           `json:"scopes" bson:"scopes"`
+	// Public clients (mobile, SPA) can't keep a secret and must use PKCE
+	Confidential bool      `json:"confidential" bson:"confidential"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+}
+
+// OAuthConsent remembers which scopes a user approved for a client
+type OAuthConsent struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"-" bson:"user_id"`
+	ClientID  string             `json:"client_id" bson:"client_id"`
+	Scopes    []string           `json:"scopes" bson:"scopes"`
+	GrantedAt time.Time          `json:"granted_at" bson:"granted_at"`
+}
+
+type RegisterOAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required,min=3,max=64"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required,min=1,dive,url"`
+	Scopes       []string `json:"scopes" binding:"required,min=1"`
+	Confidential bool     `json:"confidential"`
+}
+
+// AuthorizeRequest carries the authorization request parameters from the consent screen
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type" form:"response_type" binding:"required"`
+	ClientID            string `json:"client_id" form:"client_id" binding:"required"`
+	RedirectURI         string `json:"redirect_uri" form:"redirect_uri" binding:"required"`
+	Scope               string `json:"scope" form:"scope"`
+	State               string `json:"state" form:"state"`
+	CodeChallenge       string `json:"code_challenge" form:"code_challenge" binding:"required"`
+	CodeChallengeMethod string `json:"code_challenge_method" form:"code_challenge_method" binding:"required"`
+	Approve             bool   `json:"approve"`
+}
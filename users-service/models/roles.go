@@ -0,0 +1,92 @@
+package models
+
+type Role string
+
+const (
+	RoleAdmin   Role = "admin"
+	RoleCurator Role = "curator"
+	RoleSupport Role = "support"
+	RoleArtist  Role = "artist"
+	RoleRegular Role = "regular"
+	RoleUnauth  Role = "unauth"
+)
+
+// Fine-grained permissions carried in the access token and checked by every service
+const (
+	PermCatalogWrite  = "catalog:write"
+	PermCatalogDelete = "catalog:delete"
+	PermArtistProfile = "artist:profile"
+	PermUsersRead     = "users:read"
+	PermUsersManage   = "users:manage"
+	PermRolesManage   = "roles:manage"
+	PermAuditRead     = "audit:read"
+)
+
+// RolePermissions maps each role to what it may do; regular users need no extra permissions
+var RolePermissions = map[Role][]string{
+	RoleAdmin: {
+		PermCatalogWrite, PermCatalogDelete, PermArtistProfile,
+		PermUsersRead, PermUsersManage, PermRolesManage, PermAuditRead,
+	},
+	RoleCurator: {PermCatalogWrite, PermCatalogDelete},
+	RoleSupport: {PermUsersRead, PermUsersManage, PermAuditRead},
+	RoleArtist:  {PermArtistProfile},
+	RoleRegular: {},
+}
+
+// Role precedence, highest first, used to pick the primary role
+var rolePrecedence = []Role{RoleAdmin, RoleCurator, RoleSupport, RoleArtist, RoleRegular}
+
+func IsValidRole(role Role) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// EffectiveRoles merges granted roles with the legacy single role field;
+// every account is at least a regular user
+func (u *User) EffectiveRoles() []Role {
+	has := map[Role]bool{RoleRegular: true}
+	for _, r := range u.Roles {
+		has[r] = true
+	}
+	if u.Role != "" {
+		has[u.Role] = true
+	}
+
+	roles := make([]Role, 0, len(has))
+	for _, r := range rolePrecedence {
+		if has[r] {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+// PrimaryRole is the highest role the user holds
+func (u *User) PrimaryRole() Role {
+	return u.EffectiveRoles()[0]
+}
+
+// Permissions is the union of the permissions of all the user's roles
+func (u *User) Permissions() []string {
+	seen := map[string]bool{}
+	perms := []string{}
+	for _, r := range u.EffectiveRoles() {
+		for _, p := range RolePermissions[r] {
+			if !seen[p] {
+				seen[p] = true
+				perms = append(perms, p)
+			}
+		}
+	}
+	return perms
+}
+
+func (u *User) HasRole(role Role) bool {
+	for _, r := range u.EffectiveRoles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SecurityEvent is one structured record of the security log. Type is the outcome
+// (success, failed, warning, alert, ...), Action what was attempted.
+type SecurityEvent struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Time      time.Time          `json:"time" bson:"time"`
+	Type      string             `json:"type" bson:"type"`
+	Action    string             `json:"action" bson:"action"`
+	ActorID   string             `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Actor     string             `json:"actor,omitempty" bson:"actor,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	TraceID   string             `json:"trace_id,omitempty" bson:"trace_id,omitempty"`
+	Details   string             `json:"details,omitempty" bson:"details,omitempty"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Who can see a user's profile and follow them
+const (
+	// Only the user; nobody can follow them
+	ProfilePrivate = "private"
+	// Followers the user accepted; following takes a request
+	ProfileFollowers = "followers"
+	// Every signed-in user; follows are accepted right away
+	ProfilePublic = "public"
+)
+
+const (
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+)
+
+// Follow is one user following another; on a followers-only profile it stays pending
+// until the followee accepts it
+type Follow struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	FollowerID primitive.ObjectID `json:"follower_id" bson:"follower_id"`
+	FolloweeID primitive.ObjectID `json:"followee_id" bson:"followee_id"`
+	Status     string             `json:"status" bson:"status"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	AcceptedAt *time.Time         `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+}
+
+// Block hides two users from each other and ends any follow between them
+type Block struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	BlockerID primitive.ObjectID `json:"blocker_id" bson:"blocker_id"`
+	BlockedID primitive.ObjectID `json:"blocked_id" bson:"blocked_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// SocialProfileRequest updates the public profile; omitted fields are left as they are
+type SocialProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=50"`
+	AvatarURL   *string `json:"avatar_url" binding:"omitempty,max=512"`
+	Visibility  string  `json:"profile_visibility" binding:"omitempty,oneof=private followers public"`
+}
+
+// Activity shown in the feeds of a user's followers
+const (
+	ActivityRating       = "rating"
+	ActivityArtistFollow = "artist_follow"
+)
+
+// ActivityTypes lists every activity a user can choose to share
+var ActivityTypes = []string{ActivityRating, ActivityArtistFollow}
+
+// Activity is something a user did in another service, fanned out to their followers' feeds
+type Activity struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	Type     string `json:"type"`
+	TargetID string `json:"target_id"`
+	// Name of the target when the service knows it, e.g. the artist
+	Name      string    `json:"name,omitempty"`
+	Rating    int       `json:"rating,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ActivityRequest is posted by ratings-service and subscriptions-service
+type ActivityRequest struct {
+	UserID    string    `json:"user_id" binding:"required"`
+	Type      string    `json:"type" binding:"required,oneof=rating artist_follow"`
+	TargetID  string    `json:"target_id" binding:"required,max=64"`
+	Name      string    `json:"name" binding:"max=200"`
+	Rating    int       `json:"rating" binding:"omitempty,min=1,max=5"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -3,15 +3,17 @@ package models
 import (
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type Role string
-
+// Second factor used after the password on login
 const (
-	RoleAdmin   Role = "admin"
-	RoleRegular Role = "regular"
-	RoleUnauth  Role = "unauth"
+	TwoFactorEmail = "email"
+	TwoFactorTOTP  = "totp"
+	TwoFactorSMS   = "sms"
+	// Push approval: the login is approved from a device where the user is signed in
+	TwoFactorPush = "push"
 )
 
 type User struct {
@@ -21,10 +23,26 @@ type User struct {
 	PasswordHash string             `json:"-" bson:"password_hash"`
 	FirstName    string             `json:"first_name" bson:"first_name"`
 	LastName     string             `json:"last_name" bson:"last_name"`
-	Role         Role               `json:"role" bson:"role"`
+	// Role is the primary (highest) role, kept for clients that only know one role
+	Role  Role   `json:"role" bson:"role"`
+	Roles []Role `json:"roles,omitempty" bson:"roles,omitempty"`
 
 	EmailVerified bool `json:"email_verified" bson:"email_verified"`
 
+	// Phone number in E.164 format, only set once the user entered a code sent to it
+	Phone         string `json:"phone,omitempty" bson:"phone,omitempty"`
+	PhoneVerified bool   `json:"phone_verified" bson:"phone_verified"`
+
+	// Preferred language for emails (en, sr); empty means the service default
+	Locale string `json:"locale,omitempty" bson:"locale,omitempty"`
+
+	// --- public profile, shown to other users according to ProfileVisibility ---
+	DisplayName       string `json:"display_name,omitempty" bson:"display_name,omitempty"`
+	AvatarURL         string `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	ProfileVisibility string `json:"profile_visibility,omitempty" bson:"profile_visibility,omitempty"`
+	// Activity types kept out of followers' feeds; everything is shared by default
+	HiddenActivity []string `json:"hidden_activity,omitempty" bson:"hidden_activity,omitempty"`
+
 	// --- separated tokens (IMPORTANT) ---
 	EmailVerificationToken    string    `json:"-" bson:"email_verification_token"`
 	EmailVerificationTokenExp time.Time `json:"-" bson:"email_verification_token_exp"`
@@ -35,12 +53,77 @@ type User struct {
 	MagicLinkToken    string    `json:"-" bson:"magic_link_token"`
 	MagicLinkTokenExp time.Time `json:"-" bson:"magic_link_token_exp"`
 
+	// --- email change: the new address has to be verified, the old one can revert ---
+	PendingEmail        string    `json:"pending_email,omitempty" bson:"pending_email,omitempty"`
+	EmailChangeToken    string    `json:"-" bson:"email_change_token,omitempty"`
+	EmailChangeTokenExp time.Time `json:"-" bson:"email_change_token_exp,omitempty"`
+	PreviousEmail       string    `json:"-" bson:"previous_email,omitempty"`
+	EmailRevertToken    string    `json:"-" bson:"email_revert_token,omitempty"`
+	EmailRevertTokenExp time.Time `json:"-" bson:"email_revert_token_exp,omitempty"`
+
 	PasswordChangedAt   time.Time `json:"password_changed_at" bson:"password_changed_at"`
 	CreatedAt           time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt           time.Time `json:"updated_at" bson:"updated_at"`
 	FailedLoginAttempts int       `json:"-" bson:"failed_login_attempts"`
 	LastFailedLogin     time.Time `json:"-" bson:"last_failed_login"`
 	LockedUntil         time.Time `json:"-" bson:"locked_until"`
+
+	// Hashes of the most recent passwords, newest first, to prevent reuse
+	PasswordHistory []string `json:"-" bson:"password_history,omitempty"`
+
+	// --- two-factor authentication ---
+	TwoFactorMethod   string   `json:"two_factor_method,omitempty" bson:"two_factor_method,omitempty"`
+	TOTPEnabled       bool     `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret        string   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"-" bson:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64    `json:"-" bson:"totp_last_step"`
+	RecoveryCodes     []string `json:"-" bson:"recovery_codes,omitempty"`
+
+	// Set while the account is scheduled for deletion; cleared if the user cancels
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for,omitempty" bson:"deletion_scheduled_for,omitempty"`
+}
+
+// PendingDeletion reports whether the grace period is over and the account is being purged
+func (u *User) PendingDeletion() bool {
+	return u.DeletionScheduledFor != nil && !time.Now().Before(*u.DeletionScheduledFor)
+}
+
+// SecondFactor returns the method the user has chosen, defaulting to email OTP when
+// the chosen one isn't set up
+func (u *User) SecondFactor() string {
+	switch {
+	case u.TwoFactorMethod == TwoFactorTOTP && u.TOTPEnabled:
+		return TwoFactorTOTP
+	case u.TwoFactorMethod == TwoFactorSMS && u.HasVerifiedPhone():
+		return TwoFactorSMS
+	case u.TwoFactorMethod == TwoFactorPush:
+		return TwoFactorPush
+	}
+	return TwoFactorEmail
+}
+
+// Visibility returns who can see the user's profile; profiles are private until the
+// user opts in
+func (u *User) Visibility() string {
+	if u.ProfileVisibility == "" {
+		return ProfilePrivate
+	}
+	return u.ProfileVisibility
+}
+
+// SharesActivity reports whether activity of this type goes to the user's followers
+func (u *User) SharesActivity(activityType string) bool {
+	for _, hidden := range u.HiddenActivity {
+		if hidden == activityType {
+			return false
+		}
+	}
+	return true
+}
+
+// HasVerifiedPhone reports whether codes can be sent to the user by SMS
+func (u *User) HasVerifiedPhone() bool {
+	return u.Phone != "" && u.PhoneVerified
 }
 
 type RegisterRequest struct {
@@ -50,16 +133,62 @@ type RegisterRequest struct {
 	PasswordConfirm string `json:"password_confirm" binding:"required,eqfield=Password"`
 	FirstName       string `json:"first_name" binding:"required,min=2,max=50"`
 	LastName        string `json:"last_name" binding:"required,min=2,max=50"`
+	Locale          string `json:"locale" binding:"omitempty,oneof=en sr"`
+	// Token of whichever CAPTCHA provider is configured; the name predates the others
+	RecaptchaToken string `json:"recaptcha_token"`
 }
 
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	// Only checked once failed attempts make a CAPTCHA necessary
+	RecaptchaToken string `json:"recaptcha_token"`
 }
 
 type VerifyOTPRequest struct {
+	TempToken    string `json:"temp_token" binding:"required"`
+	OTPCode      string `json:"otp_code" binding:"required_without=RecoveryCode,omitempty,len=6"`
+	RecoveryCode string `json:"recovery_code" binding:"omitempty,max=20"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required,len=6"`
+}
+
+type DisableTOTPRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required,len=6"`
+}
+
+type TwoFactorMethodRequest struct {
+	Method string `json:"method" binding:"required,oneof=email totp sms push"`
+}
+
+// ResendOTPRequest asks for a new login code, optionally over another channel
+type ResendOTPRequest struct {
+	TempToken string `json:"temp_token" binding:"required"`
+	Method    string `json:"method" binding:"omitempty,oneof=email sms push"`
+}
+
+type VerifyPushRequest struct {
 	TempToken string `json:"temp_token" binding:"required"`
-	OTPCode   string `json:"otp_code" binding:"required,len=6"`
+}
+
+type PhoneRequest struct {
+	Phone    string `json:"phone" binding:"required,max=32"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RemovePhoneRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type PhoneCodeRequest struct {
+	Code string `json:"code" binding:"required,len=6"`
+}
+
+type LoginApprovalRequest struct {
+	Approve *bool `json:"approve" binding:"required"`
 }
 
 type VerifyEmailRequest struct {
@@ -70,13 +199,19 @@ type MagicLinkRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"current_password" binding:"required"`
 	NewPassword     string `json:"new_password" binding:"required,min=8"`
 }
 
 type ResetPasswordRequest struct {
-	Email string `json:"email" binding:"required,email"`
+	Email          string `json:"email" binding:"required,email"`
+	RecaptchaToken string `json:"recaptcha_token"`
 }
 
 type ResetPasswordConfirmRequest struct {
@@ -87,4 +222,71 @@ type ResetPasswordConfirmRequest struct {
 type UpdateProfileRequest struct {
 	FirstName string `json:"first_name" binding:"required,min=2,max=50"`
 	LastName  string `json:"last_name" binding:"required,min=2,max=50"`
+	Locale    string `json:"locale" binding:"omitempty,oneof=en sr"`
+}
+
+// WebAuthnCredential is a registered passkey or security key
+type WebAuthnCredential struct {
+	ID           primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID  `json:"-" bson:"user_id"`
+	CredentialID string              `json:"credential_id" bson:"credential_id"`
+	Name         string              `json:"name" bson:"name"`
+	Credential   webauthn.Credential `json:"-" bson:"credential"`
+	CreatedAt    time.Time           `json:"created_at" bson:"created_at"`
+	LastUsedAt   time.Time           `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
+
+type WebAuthnRegisterRequest struct {
+	Name string `json:"name" binding:"max=64"`
+}
+
+type WebAuthnLoginRequest struct {
+	Username string `json:"username"`
+}
+
+// Session is one logged-in device, stored in Redis; its refresh tokens form a single
+// rotation family and only their hashes are kept
+type Session struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	Device         string    `json:"device"`
+	UserAgent      string    `json:"user_agent"`
+	IP             string    `json:"ip"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastSeen       time.Time `json:"last_seen"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	RefreshHash    string    `json:"refresh_hash"`
+	PreviousHashes []string  `json:"previous_hashes,omitempty"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// SigningKey is a stored JWT signing key; one key is created per rotation period
+type SigningKey struct {
+	KID        string    `bson:"kid"`
+	Algorithm  string    `bson:"alg"`
+	PrivateKey string    `bson:"private_key"`
+	Period     int64     `bson:"period"`
+	CreatedAt  time.Time `bson:"created_at"`
+	ActiveFrom time.Time `bson:"active_from"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+}
+
+type GrantRoleRequest struct {
+	Role Role `json:"role" binding:"required"`
+}
+
+// RoleAuditEntry records who granted or revoked a role, and when
+type RoleAuditEntry struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Action         string             `json:"action" bson:"action"`
+	Role           Role               `json:"role" bson:"role"`
+	TargetID       primitive.ObjectID `json:"target_id" bson:"target_id"`
+	TargetUsername string             `json:"target_username" bson:"target_username"`
+	ActorID        string             `json:"actor_id" bson:"actor_id"`
+	ActorUsername  string             `json:"actor_username" bson:"actor_username"`
+	IP             string             `json:"ip,omitempty" bson:"ip,omitempty"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
 }
@@ -5,6 +5,7 @@ import (
 
 	"example.com/users-service/handlers"
 	"example.com/users-service/middleware"
+	"example.com/users-service/models"
 	"github.com/gin-gonic/gin"
 )
 
@@ -20,11 +21,33 @@ func setupRoutes(router *gin.Engine) {
 		api.POST("/login", handlers.Login)
 
 		api.POST("/verify-otp", handlers.VerifyOTP)
+		api.POST("/verify-otp/resend", handlers.ResendOTP)
+		api.POST("/verify-push", handlers.VerifyPush)
 		api.GET("/verify-email", handlers.VerifyEmail)
+		api.GET("/verify-email-change", handlers.VerifyEmailChange)
+		api.GET("/revert-email-change", handlers.RevertEmailChange)
 		api.POST("/magic-link", handlers.RequestMagicLink)
 		api.GET("/magic-login", handlers.MagicLogin)
 		api.POST("/reset-password", handlers.ResetPassword)
 		api.POST("/reset-password/confirm", handlers.ResetPasswordConfirm)
+		api.POST("/token/refresh", handlers.RefreshToken)
+		api.GET("/captcha/config", handlers.GetCaptchaConfig)
+
+		// Data export download - the link from the notification is the credential
+		api.GET("/profile/export/download", handlers.DownloadDataExport)
+
+		// OAuth2 for third-party apps
+		api.POST("/oauth/token", handlers.OAuthToken)
+		api.POST("/oauth/introspect", handlers.IntrospectOAuthToken)
+		api.POST("/oauth/revoke", handlers.RevokeOAuthToken)
+		api.GET("/oauth/userinfo", middleware.OAuthMiddleware("profile:read"), handlers.OAuthUserInfo)
+
+		// Internal route - revocation check for the api-gateway (not exposed through the gateway)
+		api.GET("/oauth/revoked/:jti", handlers.OAuthTokenRevoked)
+
+		// Passkey login
+		api.POST("/webauthn/login/begin", handlers.BeginWebAuthnLogin)
+		api.POST("/webauthn/login/finish", handlers.FinishWebAuthnLogin)
 
 		// Protected routes
 		protected := api.Group("/")
@@ -34,10 +57,98 @@ func setupRoutes(router *gin.Engine) {
 			protected.GET("/profile", handlers.GetProfile)
 			protected.PUT("/profile", handlers.UpdateProfile)
 			protected.DELETE("/profile", handlers.DeleteAccount)
+			protected.POST("/profile/email", handlers.RequestEmailChange)
+			protected.DELETE("/profile/email", handlers.CancelEmailChange)
+			protected.GET("/profile/deletion", handlers.GetAccountDeletion)
+			protected.POST("/profile/deletion/cancel", handlers.CancelAccountDeletion)
+			protected.POST("/profile/export", handlers.RequestDataExport)
+			protected.GET("/profile/export", handlers.GetDataExport)
 			protected.POST("/logout", handlers.Logout)
+
+			// Sessions
+			protected.GET("/sessions", handlers.GetSessions)
+			protected.GET("/profile/devices", handlers.GetKnownDevices)
+			protected.DELETE("/profile/devices/:id", handlers.ForgetKnownDevice)
+			protected.GET("/profile/security-activity", handlers.GetSecurityActivity)
+			protected.DELETE("/sessions", handlers.RevokeAllSessions)
+			protected.DELETE("/sessions/:id", handlers.RevokeSession)
+
+			// Two-factor authentication
+			protected.GET("/2fa", handlers.GetTwoFactorStatus)
+			protected.PUT("/2fa/method", handlers.SetTwoFactorMethod)
+			protected.POST("/2fa/totp/setup", handlers.SetupTOTP)
+			protected.GET("/2fa/totp/qr", handlers.GetTOTPQRCode)
+			protected.POST("/2fa/totp/confirm", handlers.ConfirmTOTP)
+			protected.POST("/2fa/totp/disable", handlers.DisableTOTP)
+			protected.POST("/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+
+			// Phone number and push approvals for the second factor
+			protected.POST("/profile/phone", handlers.RequestPhoneVerification)
+			protected.POST("/profile/phone/verify", handlers.VerifyPhone)
+			protected.DELETE("/profile/phone", handlers.RemovePhone)
+			protected.GET("/profile/login-approvals", handlers.GetLoginApprovals)
+			protected.POST("/profile/login-approvals/:id", handlers.AnswerLoginApproval)
+
+			// Public profiles, follows and blocks
+			protected.PUT("/profile/social", handlers.UpdateSocialProfile)
+			protected.GET("/profile/follow-requests", handlers.GetFollowRequests)
+			protected.POST("/profile/follow-requests/:username", handlers.AcceptFollowRequest)
+			protected.DELETE("/profile/follow-requests/:username", handlers.DeclineFollowRequest)
+			protected.DELETE("/profile/followers/:username", handlers.RemoveFollower)
+			protected.GET("/profile/blocks", handlers.GetBlockedUsers)
+			protected.GET("/users/:username", handlers.GetUserProfile)
+			protected.GET("/users/:username/followers", handlers.GetFollowers)
+			protected.GET("/users/:username/following", handlers.GetFollowing)
+			protected.POST("/users/:username/follow", handlers.FollowUser)
+			protected.DELETE("/users/:username/follow", handlers.UnfollowUser)
+			protected.POST("/users/:username/block", handlers.BlockUser)
+			protected.DELETE("/users/:username/block", handlers.UnblockUser)
+
+			// Activity feed of the people the user follows
+			protected.GET("/feed", handlers.GetFeed)
+			protected.GET("/profile/activity-sharing", handlers.GetActivitySharing)
+			protected.PUT("/profile/activity-sharing", handlers.UpdateActivitySharing)
+
+			// Passkeys
+			protected.POST("/webauthn/register/begin", handlers.BeginWebAuthnRegistration)
+			protected.POST("/webauthn/register/finish", handlers.FinishWebAuthnRegistration)
+			protected.GET("/webauthn/credentials", handlers.GetWebAuthnCredentials)
+			protected.DELETE("/webauthn/credentials/:id", handlers.DeleteWebAuthnCredential)
+
+			// OAuth consent and developer apps
+			protected.GET("/oauth/authorize", handlers.GetAuthorize)
+			protected.POST("/oauth/authorize", handlers.PostAuthorize)
+			protected.GET("/oauth/consents", handlers.GetOAuthConsents)
+			protected.DELETE("/oauth/consents/:client_id", handlers.RevokeOAuthConsent)
+			protected.POST("/oauth/clients", handlers.RegisterOAuthClient)
+			protected.GET("/oauth/clients", handlers.GetOAuthClients)
+			protected.DELETE("/oauth/clients/:client_id", handlers.DeleteOAuthClient)
+
+			// Role management
+			protected.GET("/admin/roles", middleware.RequirePermission(models.PermUsersRead), handlers.GetRoles)
+			protected.GET("/admin/roles/audit", middleware.RequirePermission(models.PermAuditRead), handlers.GetRoleAudit)
+			protected.GET("/admin/users/:id/roles", middleware.RequirePermission(models.PermUsersRead), handlers.GetUserRoles)
+			protected.POST("/admin/users/:id/roles", middleware.RequirePermission(models.PermRolesManage), handlers.GrantRole)
+			protected.DELETE("/admin/users/:id/roles/:role", middleware.RequirePermission(models.PermRolesManage), handlers.RevokeRole)
+			protected.GET("/admin/deletions", middleware.RequirePermission(models.PermUsersManage), handlers.GetAccountDeletions)
+			protected.GET("/admin/login-throttle", middleware.RequirePermission(models.PermUsersRead), handlers.GetLoginThrottle)
+			protected.POST("/admin/users/:id/unlock", middleware.RequirePermission(models.PermUsersManage), handlers.UnlockUser)
+			protected.POST("/admin/ips/:ip/unlock", middleware.RequirePermission(models.PermUsersManage), handlers.UnlockIP)
+			protected.GET("/admin/emails", middleware.RequirePermission(models.PermAuditRead), handlers.GetEmailOutbox)
+			protected.GET("/admin/security-events", middleware.RequirePermission(models.PermAuditRead), handlers.GetSecurityEvents)
+			protected.GET("/admin/emails/:id", middleware.RequirePermission(models.PermAuditRead), handlers.GetEmailMessage)
+			protected.POST("/admin/emails/:id/retry", middleware.RequirePermission(models.PermUsersManage), handlers.RetryEmailMessage)
 		}
 	}
 
+	// Public JWT signing keys for the other services
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
+
+	// Internal route - activity from ratings-service and subscriptions-service for the
+	// feeds. Authenticated by the service token and not rate limited per IP, since every
+	// call comes from the same few services (not exposed through the gateway).
+	router.POST("/api/v1/activity", middleware.ServiceAuth(), handlers.RecordActivity)
+
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok", "service": "users-service"})
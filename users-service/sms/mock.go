@@ -0,0 +1,158 @@
+package sms
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogSender only logs that a message would have been sent. The body (OTP codes) is
+// printed to stdout only when ShowBody is set, never to the log file.
+type LogSender struct {
+	ShowBody bool
+}
+
+func (s *LogSender) Name() string { return ProviderLog }
+
+func (s *LogSender) Send(ctx context.Context, to, body string) error {
+	log.Printf("📱 MOCK SMS to=%s (body_len=%d)", Mask(to), len(body))
+	if s.ShowBody {
+		fmt.Printf("📱 MOCK SMS to=%s\n%s\n-------------------\n", to, body)
+	}
+	return nil
+}
+
+// MockMessage is a message accepted by MockServer, in the shape the Messages API
+// returns it
+type MockMessage struct {
+	SID         string    `json:"sid"`
+	AccountSID  string    `json:"account_sid"`
+	To          string    `json:"to"`
+	From        string    `json:"from"`
+	Body        string    `json:"body"`
+	Status      string    `json:"status"`
+	DateCreated time.Time `json:"date_created"`
+}
+
+// MockServer implements the part of the Twilio Messages API users-service uses, keeping
+// messages in memory. With AccountSID and AuthToken set, other credentials are rejected
+// like Twilio does. Numbers ending in 0000 fail as unreachable, to exercise errors.
+//
+//	POST /2010-04-01/Accounts/{sid}/Messages.json   send
+//	GET  /2010-04-01/Accounts/{sid}/Messages.json   list, newest first (?To= filters)
+type MockServer struct {
+	AccountSID string
+	AuthToken  string
+
+	mu       sync.Mutex
+	messages []MockMessage
+}
+
+// Messages returns the accepted messages, oldest first
+func (s *MockServer) Messages() []MockMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MockMessage(nil), s.messages...)
+}
+
+func (s *MockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/2010-04-01/Accounts/")
+	accountSID, ok2 := strings.CutSuffix(rest, "/Messages.json")
+	if !ok || !ok2 || accountSID == "" || strings.Contains(accountSID, "/") {
+		writeMockError(w, http.StatusNotFound, 20404, "The requested resource was not found")
+		return
+	}
+
+	user, password, hasAuth := r.BasicAuth()
+	if !hasAuth || user != accountSID ||
+		s.AccountSID != "" && (accountSID != s.AccountSID || password != s.AuthToken) {
+		writeMockError(w, http.StatusUnauthorized, 20003, "Authentication Error - invalid username")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.create(w, r, accountSID)
+	case http.MethodGet:
+		s.list(w, r)
+	default:
+		writeMockError(w, http.StatusMethodNotAllowed, 20004, "Method not allowed")
+	}
+}
+
+func (s *MockServer) create(w http.ResponseWriter, r *http.Request, accountSID string) {
+	if err := r.ParseForm(); err != nil {
+		writeMockError(w, http.StatusBadRequest, 21100, "Invalid form")
+		return
+	}
+	to := r.PostForm.Get("To")
+	from := r.PostForm.Get("From")
+	if from == "" {
+		from = r.PostForm.Get("MessagingServiceSid")
+	}
+
+	switch {
+	case to == "" || r.PostForm.Get("Body") == "":
+		writeMockError(w, http.StatusBadRequest, 21604, "A 'To' phone number and a 'Body' are required")
+		return
+	case from == "":
+		writeMockError(w, http.StatusBadRequest, 21603, "A 'From' phone number is required")
+		return
+	case !e164Pattern.MatchString(to):
+		writeMockError(w, http.StatusBadRequest, 21211, fmt.Sprintf("The 'To' number %s is not a valid phone number", to))
+		return
+	case strings.HasSuffix(to, "0000"):
+		writeMockError(w, http.StatusBadRequest, 21612, fmt.Sprintf("The 'To' phone number: %s, is not currently reachable", to))
+		return
+	}
+
+	sid := make([]byte, 16)
+	rand.Read(sid)
+	message := MockMessage{
+		SID:         "SM" + hex.EncodeToString(sid),
+		AccountSID:  accountSID,
+		To:          to,
+		From:        from,
+		Body:        r.PostForm.Get("Body"),
+		Status:      "queued",
+		DateCreated: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	s.messages = append(s.messages, message)
+	s.mu.Unlock()
+
+	log.Printf("📱 SMS to=%s from=%s\n%s", message.To, message.From, message.Body)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+func (s *MockServer) list(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("To")
+	all := s.Messages()
+
+	messages := []MockMessage{}
+	for i := len(all) - 1; i >= 0; i-- {
+		if to == "" || all[i].To == to {
+			messages = append(messages, all[i])
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"messages": messages})
+}
+
+func writeMockError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Status: status, Code: code, Message: message})
+}
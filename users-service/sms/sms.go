@@ -0,0 +1,95 @@
+// Package sms sends text messages through a Twilio-compatible HTTP API, or only logs
+// them, chosen by configuration.
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Sender delivers a text message to a phone number in E.164 format
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, to, body string) error
+}
+
+// ErrInvalidNumber is returned for phone numbers that can't be brought into E.164 format
+var ErrInvalidNumber = errors.New("invalid phone number")
+
+// E.164: a plus, a country code that doesn't start with 0 and at most 15 digits in total
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// NormalizeE164 brings a phone number written with spaces, dashes, dots or parentheses,
+// or with a 00 international prefix, into E.164 format, e.g. "+381 (64) 123-4567" becomes
+// "+381641234567". Numbers without a country code are rejected rather than guessed.
+func NormalizeE164(number string) (string, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '\t':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(number))
+
+	if strings.HasPrefix(cleaned, "00") {
+		cleaned = "+" + cleaned[2:]
+	}
+	if !e164Pattern.MatchString(cleaned) {
+		return "", ErrInvalidNumber
+	}
+	return cleaned, nil
+}
+
+// Mask hides all but the last digits of a number, for showing where a code was sent
+func Mask(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+	return strings.Repeat("•", len(number)-4) + number[len(number)-4:]
+}
+
+// Provider names for SMS_PROVIDER
+const (
+	ProviderTwilio = "twilio"
+	ProviderLog    = "log"
+)
+
+// FromEnv configures the sender from SMS_PROVIDER. The twilio provider reads
+// TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and SMS_FROM (a number or a messaging service
+// SID starting with MG), and SMS_API_URL to point it at another Twilio-compatible API
+// such as cmd/sms-mock. The log provider only logs messages; MOCK_SMS=true prints their
+// bodies to stdout.
+//
+// Without SMS_PROVIDER a complete Twilio config uses Twilio and anything else logs.
+func FromEnv() (Sender, error) {
+	twilio := &Twilio{
+		AccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+		AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+		From:       os.Getenv("SMS_FROM"),
+		BaseURL:    os.Getenv("SMS_API_URL"),
+	}
+
+	switch os.Getenv("SMS_PROVIDER") {
+	case ProviderTwilio:
+		if twilio.AccountSID == "" || twilio.AuthToken == "" || twilio.From == "" {
+			return nil, errors.New("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and SMS_FROM are required for the twilio provider")
+		}
+		return twilio, nil
+	case ProviderLog:
+		return &LogSender{ShowBody: os.Getenv("MOCK_SMS") == "true"}, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", os.Getenv("SMS_PROVIDER"))
+	}
+
+	if twilio.AccountSID != "" && twilio.AuthToken != "" && twilio.From != "" {
+		return twilio, nil
+	}
+	log.Println("SMS not configured, text messages are only logged")
+	return &LogSender{ShowBody: os.Getenv("MOCK_SMS") == "true"}, nil
+}
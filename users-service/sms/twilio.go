@@ -0,0 +1,84 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TwilioAPIURL is the production API; SMS_API_URL overrides it
+const TwilioAPIURL = "https://api.twilio.com"
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// APIError is an error answer of the messages API
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("sms api error %d (status %d): %s", e.Code, e.Status, e.Message)
+}
+
+// IsPermanent reports whether retrying can't help, e.g. the number can't receive SMS
+func IsPermanent(err error) bool {
+	var apiErr *APIError
+	return errors.Is(err, ErrInvalidNumber) ||
+		errors.As(err, &apiErr) && apiErr.Status >= 400 && apiErr.Status < 500 && apiErr.Status != http.StatusTooManyRequests
+}
+
+// Twilio sends through the Twilio Messages API, or any API speaking the same protocol
+type Twilio struct {
+	AccountSID string
+	AuthToken  string
+	// A sender number, or a messaging service SID (MG...)
+	From    string
+	BaseURL string
+}
+
+func (t *Twilio) Name() string { return ProviderTwilio }
+
+func (t *Twilio) Send(ctx context.Context, to, body string) error {
+	form := url.Values{"To": {to}, "Body": {body}}
+	if strings.HasPrefix(t.From, "MG") {
+		form.Set("MessagingServiceSid", t.From)
+	} else {
+		form.Set("From", t.From)
+	}
+
+	base := t.BaseURL
+	if base == "" {
+		base = TwilioAPIURL
+	}
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimRight(base, "/"), url.PathEscape(t.AccountSID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if json.NewDecoder(resp.Body).Decode(apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		apiErr.Status = resp.StatusCode
+		return apiErr
+	}
+	return nil
+}
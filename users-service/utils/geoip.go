@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GeoLocation is where an IP address is registered, as precise as the database allows
+type GeoLocation struct {
+	Country   string
+	Region    string
+	City      string
+	Latitude  float64
+	Longitude float64
+}
+
+// String is a short human readable place, e.g. "Novi Sad, RS"
+func (g *GeoLocation) String() string {
+	if g.City != "" {
+		return g.City + ", " + g.Country
+	}
+	return g.Country
+}
+
+type geoRange struct {
+	start, end netip.Addr
+	location   *GeoLocation
+}
+
+// Ranges sorted by start address; nil when no database is loaded
+var geoRanges []geoRange
+
+// InitGeoIP loads the database from GEOIP_DB (default data/geoip/dbip-city-lite.csv.gz).
+// The file is the DB-IP "IP to City Lite" CSV, optionally gzipped:
+//
+//	ip_start,ip_end,continent,country,region,city,latitude,longitude
+//
+// Without the file location-based checks are simply skipped.
+func InitGeoIP() {
+	path := os.Getenv("GEOIP_DB")
+	if path == "" {
+		path = "data/geoip/dbip-city-lite.csv.gz"
+	}
+
+	ranges, err := loadGeoIP(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("GeoIP database not found at %s, location checks disabled", path)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load GeoIP database %s: %v", path, err)
+		return
+	}
+	geoRanges = ranges
+	log.Printf("GeoIP database loaded: %d ranges", len(ranges))
+}
+
+func loadGeoIP(path string) ([]geoRange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	// Neighbouring ranges usually share a place, so locations are shared too
+	places := map[string]*GeoLocation{}
+	var ranges []geoRange
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 8 {
+			return nil, fmt.Errorf("line %d: expected 8 columns, got %d", line, len(record))
+		}
+
+		start, err1 := netip.ParseAddr(record[0])
+		end, err2 := netip.ParseAddr(record[1])
+		if err1 != nil || err2 != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: invalid address range", line)
+		}
+		lat, _ := strconv.ParseFloat(record[6], 64)
+		lon, _ := strconv.ParseFloat(record[7], 64)
+
+		key := strings.Join(record[3:8], "|")
+		location, ok := places[key]
+		if !ok {
+			location = &GeoLocation{Country: record[3], Region: record[4], City: record[5], Latitude: lat, Longitude: lon}
+			places[key] = location
+		}
+		ranges = append(ranges, geoRange{start: start.Unmap(), end: end.Unmap(), location: location})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	return ranges, nil
+}
+
+// LookupIP finds where an address is located. Private and unknown addresses, or a
+// missing database, return nil.
+func LookupIP(ip string) *GeoLocation {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || len(geoRanges) == 0 {
+		return nil
+	}
+	addr = addr.Unmap()
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return nil
+	}
+
+	// First range starting after the address; the one before it may contain it
+	i := sort.Search(len(geoRanges), func(i int) bool { return addr.Less(geoRanges[i].start) })
+	if i == 0 {
+		return nil
+	}
+	r := geoRanges[i-1]
+	if addr.BitLen() != r.start.BitLen() || r.end.Less(addr) {
+		return nil
+	}
+	return r.location
+}
+
+// DistanceKm is the great-circle distance between two points
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// NetworkPrefix groups an address with its neighbours (/24 for IPv4, /48 for IPv6),
+// which usually belong to the same provider and location
+func NetworkPrefix(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
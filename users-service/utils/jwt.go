@@ -10,12 +10,12 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("default-secret-key-change-in-production")
+// Access tokens are short-lived, sessions are kept alive with refresh tokens
+var AccessTokenTTL = 15 * time.Minute
 
 func init() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret != "" {
-		jwtSecret = []byte(secret)
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && ttl > 0 {
+		AccessTokenTTL = ttl
 	}
 }
 
@@ -31,38 +31,102 @@ type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// Roles and Permissions are what the other services authorize against
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// SessionID ties the token to a refresh-token session so revoking it cuts access too
+	SessionID string `json:"sid,omitempty"`
+	// ClientID and Scope are set on tokens issued to third-party apps through OAuth
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(userID, username, role string) (string, error) {
+func GenerateJWT(userID, username, role string, roles, permissions []string, sessionID string) (string, error) {
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+
 	jti, err := generateJTI()
 	if err != nil {
 		return "", err
 	}
 
 	claims := Claims{
+		UserID:      userID,
+		Username:    username,
+		Role:        role,
+		Roles:       roles,
+		Permissions: permissions,
+		SessionID:   sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti, // IMPORTANT for logout blacklist
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "users-service",
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.Private)
+}
+
+// GenerateOAuthToken issues an access token for a third-party client. It carries only
+// the granted scope, never the user's roles or permissions. userID is empty for the
+// client credentials grant, where the client acts on its own behalf.
+func GenerateOAuthToken(userID, username, clientID, scope string, ttl time.Duration) (string, *Claims, error) {
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	jti, err := generateJTI()
+	if err != nil {
+		return "", nil, err
+	}
+
+	subject := userID
+	if subject == "" {
+		subject = "client:" + clientID
+	}
+
+	claims := &Claims{
 		UserID:   userID,
 		Username: username,
-		Role:     role,
+		ClientID: clientID,
+		Scope:    scope,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ID:        jti, // IMPORTANT for logout blacklist
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "users-service",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.KID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
 func ValidateJWT(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := verificationKey(kid)
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		if token.Method.Alg() != key.Algorithm {
 			return nil, errors.New("unexpected signing method")
 		}
-		return jwtSecret, nil
-	})
+		return key.Private.Public(), nil
+	}, jwt.WithValidMethods([]string{AlgEdDSA, AlgRS256}), jwt.WithIssuer("users-service"))
 	if err != nil {
 		return nil, err
 	}
@@ -73,3 +137,13 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 	}
 	return nil, errors.New("invalid token")
 }
+
+// HasPermission reports whether the token grants perm
+func (c *Claims) HasPermission(perm string) bool {
+	for _, p := range c.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Supported token signing algorithms
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+// SigningKey is one key of the rotation set. A key is published in the JWKS as soon
+// as it is loaded, signs tokens from ActiveFrom on, and is kept for verification
+// until ExpiresAt, when no token it signed can still be valid.
+type SigningKey struct {
+	KID        string
+	Algorithm  string
+	Private    crypto.Signer
+	ActiveFrom time.Time
+	ExpiresAt  time.Time
+}
+
+var (
+	keysMu      sync.RWMutex
+	signingKeys []*SigningKey
+)
+
+// SetSigningKeys replaces the loaded key set
+func SetSigningKeys(keys []*SigningKey) {
+	sorted := make([]*SigningKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ActiveFrom.Before(sorted[j].ActiveFrom) })
+
+	keysMu.Lock()
+	signingKeys = sorted
+	keysMu.Unlock()
+}
+
+// currentSigningKey returns the newest key that is already active
+func currentSigningKey() (*SigningKey, error) {
+	now := time.Now()
+
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	for i := len(signingKeys) - 1; i >= 0; i-- {
+		key := signingKeys[i]
+		if !key.ActiveFrom.After(now) && key.ExpiresAt.After(now) {
+			return key, nil
+		}
+	}
+	return nil, errors.New("no active signing key")
+}
+
+func verificationKey(kid string) (*SigningKey, bool) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	for _, key := range signingKeys {
+		if key.KID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// GenerateSigningKey creates a fresh private key for the given algorithm
+func GenerateSigningKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case AlgEdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// KeyID derives a stable kid from a hash of the public key
+func KeyID(key crypto.Signer) string {
+	der, _ := x509.MarshalPKIXPublicKey(key.Public())
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// MarshalPrivateKey encodes a private key as PKCS#8 PEM for storage
+func MarshalPrivateKey(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParsePrivateKey decodes a PKCS#8 PEM private key
+func ParsePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+// JWK is the public part of a signing key as published in the JWKS
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// PublicJWKS lists every loaded key, including ones not yet active or already
+// retired from signing, so verifiers always know the key behind a valid token
+func PublicJWKS() []JWK {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	jwks := make([]JWK, 0, len(signingKeys))
+	for _, key := range signingKeys {
+		jwk := JWK{Kid: key.KID, Use: "sig", Alg: key.Algorithm}
+		switch pub := key.Private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -1,77 +1,285 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"example.com/users-service/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var logFile *os.File
+const logDir = "logs"
+
+var (
+	// Security events as JSON lines; everything else from the log package goes to
+	// the service log so the security log stays machine readable
+	securityLog *rotatingFile
+	serviceLog  *rotatingFile
 
-// InitLogger initializes the log file
+	// Receives every event after it is written to the file, e.g. to store it in MongoDB
+	securityEventSink func(models.SecurityEvent)
+)
+
+// InitLogger opens the log files. They are rotated once they reach LOG_MAX_SIZE_MB
+// (default 10), and rotated files are kept for LOG_MAX_AGE_DAYS (default 30), at most
+// LOG_MAX_BACKUPS (default 10) of them.
 func InitLogger() error {
-	logDir := "logs"
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return err
 	}
 
-	logPath := filepath.Join(logDir, "security.log")
-	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	maxSize := int64(envInt("LOG_MAX_SIZE_MB", 10)) * 1024 * 1024
+	maxAge := time.Duration(envInt("LOG_MAX_AGE_DAYS", 30)) * 24 * time.Hour
+	maxBackups := envInt("LOG_MAX_BACKUPS", 10)
+
+	var err error
+	securityLog, err = openRotatingFile(filepath.Join(logDir, "security.log"), maxSize, maxAge, maxBackups)
 	if err != nil {
 		return err
 	}
+	serviceLog, err = openRotatingFile(filepath.Join(logDir, "service.log"), maxSize, maxAge, maxBackups)
+	if err != nil {
+		securityLog.Close()
+		return err
+	}
 
-	logFile = file
-	log.SetOutput(file)
+	log.SetOutput(serviceLog)
 	return nil
 }
 
-// CloseLogger closes the log file
+// CloseLogger closes the log files
 func CloseLogger() {
-	if logFile != nil {
-		logFile.Close()
+	if securityLog != nil {
+		securityLog.Close()
+	}
+	if serviceLog != nil {
+		serviceLog.Close()
 	}
 }
 
-// LogSecurityEvent logs security-related events
-func LogSecurityEvent(eventType, action, ip, details string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	logMessage := fmt.Sprintf("[%s] TYPE=%s ACTION=%s IP=%s DETAILS=%s\n",
-		timestamp, eventType, action, ip, details)
+// SetSecurityEventSink registers where events go besides the log file
+func SetSecurityEventSink(sink func(models.SecurityEvent)) {
+	securityEventSink = sink
+}
+
+// RecordSecurityEvent writes a structured security event to the log file, stdout and
+// the registered sink
+func RecordSecurityEvent(event models.SecurityEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	// The same ID is used in the file and the store, and follows the order of events
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode security event %s: %v", event.Action, err)
+		return
+	}
+	line = append(line, '\n')
 
-	// Log to file
-	if logFile != nil {
-		logFile.WriteString(logMessage)
+	if securityLog != nil {
+		securityLog.Write(line)
 	}
 
 	// Also log to stdout for Docker logs
-	fmt.Print(logMessage)
+	os.Stdout.Write(line)
+
+	if securityEventSink != nil {
+		securityEventSink(event)
+	}
 }
 
-// RotateLogs rotates log files if they exceed size limit (10MB)
+// LogSecurityEvent logs security-related events that don't belong to a request
+func LogSecurityEvent(eventType, action, ip, details string) {
+	RecordSecurityEvent(models.SecurityEvent{
+		Type:    eventType,
+		Action:  action,
+		IP:      ip,
+		Details: details,
+	})
+}
+
+// RequestSecurityEvent describes an event of the current request: client IP, user agent,
+// trace ID and, on authenticated routes, the user making it
+func RequestSecurityEvent(c *gin.Context, eventType, action, details string) models.SecurityEvent {
+	return models.SecurityEvent{
+		Type:      eventType,
+		Action:    action,
+		ActorID:   c.GetString("user_id"),
+		Actor:     c.GetString("username"),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		TraceID:   TraceID(c.Request.Context()),
+		Details:   details,
+	}
+}
+
+// LogRequestSecurityEvent logs a security event of the current request
+func LogRequestSecurityEvent(c *gin.Context, eventType, action, details string) {
+	RecordSecurityEvent(RequestSecurityEvent(c, eventType, action, details))
+}
+
+// TraceID returns the ID of the trace the context belongs to, or "" outside of one
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// RotateLogs rotates log files over the size limit and removes rotated files that are
+// too old or too many
 func RotateLogs() {
-	logPath := "logs/security.log"
-	info, err := os.Stat(logPath)
+	for _, file := range []*rotatingFile{securityLog, serviceLog} {
+		if file == nil {
+			continue
+		}
+		if err := file.Rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %v\n", file.path, err)
+		}
+	}
+}
+
+// rotatingFile is an append-only log file that is renamed to <name>-<timestamp>.log
+// once it grows past maxSize
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	size       int64
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
 	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
 
-	// If file size > 10MB, rotate
-	if info.Size() > 10*1024*1024 {
-		timestamp := time.Now().Format("20060102-150405")
-		newPath := fmt.Sprintf("logs/security-%s.log", timestamp)
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-		// Close current file
-		if logFile != nil {
-			logFile.Close()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %v\n", r.path, err)
+			if r.file == nil {
+				return 0, err
+			}
 		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file if it is over the size limit and prunes old rotated files
+func (r *rotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if r.size > r.maxSize {
+		return r.rotate()
+	}
+	return r.prune()
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
 
-		// Rename old file
-		os.Rename(logPath, newPath)
+	// Millisecond timestamps keep the names unique and sortable
+	rotated := fmt.Sprintf("%s-%s.log", strings.TrimSuffix(r.path, ".log"), time.Now().Format("20060102-150405.000"))
+	renameErr := os.Rename(r.path, rotated)
+
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	return r.prune()
+}
+
+// prune removes rotated files older than maxAge and all but the newest maxBackups
+func (r *rotatingFile) prune() error {
+	files, err := filepath.Glob(strings.TrimSuffix(r.path, ".log") + "-*.log")
+	if err != nil {
+		return err
+	}
+	// Timestamps in the names sort chronologically; newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	cutoff := time.Now().Add(-r.maxAge)
+	for i, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if i >= r.maxBackups || (r.maxAge > 0 && info.ModTime().Before(cutoff)) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
 
-		// Create new file
-		InitLogger()
+func envInt(name string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+		return value
 	}
+	return def
 }
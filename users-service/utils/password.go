@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2idParams are the cost parameters of new password hashes
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Defaults follow the OWASP recommendation for Argon2id
+var passwordParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var errInvalidHash = errors.New("invalid password hash")
+
+// InitPasswordHashing reads the Argon2id cost from ARGON2_MEMORY_KIB,
+// ARGON2_ITERATIONS and ARGON2_PARALLELISM. Raising them makes existing hashes
+// get rehashed on the next login.
+func InitPasswordHashing() {
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY_KIB"), 10, 32); err == nil && v >= 8*1024 {
+		passwordParams.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_ITERATIONS"), 10, 32); err == nil && v >= 1 {
+		passwordParams.Iterations = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8); err == nil && v >= 1 {
+		passwordParams.Parallelism = uint8(v)
+	}
+}
+
+// HashPassword hashes a password with Argon2id in the PHC string format
+// ($argon2id$v=19$m=...,t=...,p=...$salt$hash)
+func HashPassword(password string) (string, error) {
+	p := passwordParams
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword checks a password against an Argon2id or legacy bcrypt hash.
+// needsRehash is true when the password matched but the hash is bcrypt or uses
+// weaker parameters than configured.
+func VerifyPassword(hash, password string) (match bool, needsRehash bool) {
+	if strings.HasPrefix(hash, "$2") {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false, false
+		}
+		return true, true
+	}
+
+	p, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return false, false
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return false, false
+	}
+
+	current := passwordParams
+	needsRehash = p.Memory < current.Memory || p.Iterations < current.Iterations ||
+		p.Parallelism != current.Parallelism || p.KeyLength < current.KeyLength
+	return true, needsRehash
+}
+
+func decodeArgon2idHash(hash string) (Argon2idParams, []byte, []byte, error) {
+	var p Argon2idParams
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Breached passwords are stored like the Have I Been Pwned range API: the SHA-1 of
+// the password is split into a 5 character prefix, which names the file, and a 35
+// character suffix. Each file holds sorted "SUFFIX:COUNT" lines, so a lookup reads a
+// single small file and the corpus never has to be loaded into memory.
+const (
+	PwnedPrefixLength = 5
+	PwnedSuffixLength = 35
+)
+
+var (
+	pwnedDir         = "data/pwned"
+	pwnedMinCount    = 1
+	pwnedMissingOnce sync.Once
+)
+
+// InitPwnedPasswords reads PWNED_PASSWORDS_DIR and PWNED_MIN_COUNT (how many breaches
+// make a password unacceptable)
+func InitPwnedPasswords() {
+	if dir := os.Getenv("PWNED_PASSWORDS_DIR"); dir != "" {
+		pwnedDir = dir
+	}
+	if n, err := strconv.Atoi(os.Getenv("PWNED_MIN_COUNT")); err == nil && n > 0 {
+		pwnedMinCount = n
+	}
+}
+
+// PwnedRangePath is the range file holding hashes with the given prefix
+func PwnedRangePath(dir, prefix string) string {
+	return filepath.Join(dir, strings.ToUpper(prefix)+".txt")
+}
+
+// SplitPwnedHash returns the uppercase SHA-1 of a password split into range prefix and suffix
+func SplitPwnedHash(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash[:PwnedPrefixLength], hash[PwnedPrefixLength:]
+}
+
+// ParsePwnedLine parses a "SUFFIX:COUNT" range line; a missing count means 1
+func ParsePwnedLine(line string) (string, int, error) {
+	suffix, countStr, hasCount := strings.Cut(strings.TrimSpace(line), ":")
+	count := 1
+	if hasCount {
+		n, err := strconv.Atoi(strings.TrimSpace(countStr))
+		if err != nil || n < 0 {
+			return "", 0, fmt.Errorf("invalid count in %q", line)
+		}
+		count = n
+	}
+	return strings.ToUpper(suffix), count, nil
+}
+
+// ReadPwnedRange loads one range file; a missing file is an empty range
+func ReadPwnedRange(path string) (map[string]int, error) {
+	entries := map[string]int{}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		suffix, count, err := ParsePwnedLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		entries[suffix] = count
+	}
+	return entries, scanner.Err()
+}
+
+// WritePwnedRange replaces a range file with the given entries, sorted by suffix.
+// The file is written next to the old one and renamed so readers never see it half done.
+func WritePwnedRange(path string, entries map[string]int) error {
+	suffixes := make([]string, 0, len(entries))
+	for suffix := range entries {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".range-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, suffix := range suffixes {
+		fmt.Fprintf(w, "%s:%d\r\n", suffix, entries[suffix])
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PasswordBreachCount returns how often the password appears in the local breach
+// corpus. Range files are sorted, so the scan stops as soon as it passes the suffix.
+func PasswordBreachCount(password string) (int, error) {
+	if _, err := os.Stat(pwnedDir); err != nil {
+		pwnedMissingOnce.Do(func() {
+			log.Printf("Breached password corpus not found at %s, check disabled", pwnedDir)
+		})
+		return 0, nil
+	}
+
+	prefix, suffix := SplitPwnedHash(password)
+	file, err := os.Open(PwnedRangePath(pwnedDir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < PwnedSuffixLength {
+			continue
+		}
+		switch cmp := strings.Compare(strings.ToUpper(line[:PwnedSuffixLength]), suffix); {
+		case cmp == 0:
+			_, count, err := ParsePwnedLine(line)
+			return count, err
+		case cmp > 0:
+			return 0, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// IsPasswordBreached reports whether the password appeared in at least PWNED_MIN_COUNT
+// breaches, and how many. Lookup errors are logged and treated as not breached so a
+// broken corpus doesn't block every signup.
+func IsPasswordBreached(password string) (bool, int) {
+	count, err := PasswordBreachCount(password)
+	if err != nil {
+		log.Printf("Breached password lookup failed: %v", err)
+		return false, 0
+	}
+	return count >= pwnedMinCount, count
+}